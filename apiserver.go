@@ -19,7 +19,10 @@ package main
 import (
 	"camus/archiver"
 	"camus/cnf"
+	"camus/history"
 	"camus/indexer"
+	"camus/integrity"
+	"camus/quality"
 	"context"
 	"fmt"
 	"net/http"
@@ -32,11 +35,14 @@ import (
 )
 
 type apiServer struct {
-	server          *http.Server
-	conf            *cnf.Conf
-	arch            *archiver.ArchKeeper
-	fulltextService *indexer.Service
-	rdb             *archiver.RedisAdapter
+	server           *http.Server
+	conf             *cnf.Conf
+	arch             *archiver.ArchKeeper
+	fulltextService  *indexer.Service
+	rdb              *archiver.RedisAdapter
+	qualityService   *quality.Service
+	integrityService *integrity.Service
+	historyGC        *history.GarbageCollector
 }
 
 func (api *apiServer) Start(ctx context.Context) {
@@ -54,6 +60,7 @@ func (api *apiServer) Start(ctx context.Context) {
 	archHandler := Actions{ArchKeeper: api.arch}
 
 	engine.GET("/overview", archHandler.Overview)
+	engine.GET("/largest-records", archHandler.LargestRecords)
 	engine.GET("/record/:id", archHandler.GetRecord)
 	engine.GET("/validate/:id", archHandler.Validate)
 	engine.POST("/fix/:id", archHandler.Fix)
@@ -63,9 +70,30 @@ func (api *apiServer) Start(ctx context.Context) {
 	engine.GET("/query-history/build", indexerHandler.IndexLatestRecords)
 	engine.GET("/query-history/rec2doc", indexerHandler.RecordToDoc)
 	engine.GET("/query-history/index-info", indexerHandler.IndexInfo)
+	engine.POST("/query-history/subcorpus/:subcId", indexerHandler.SubcorpusChanged)
 	engine.POST("/user-query-history/:userId", indexerHandler.Search)
 	engine.POST("/user-query-history/:userId/:queryId/:created", indexerHandler.Update)
 	engine.DELETE("/user-query-history/:userId/:queryId/:created", indexerHandler.Delete)
+	engine.GET("/user-query-history-trash/:userId", indexerHandler.Trash)
+	engine.POST("/user-query-history-trash/:userId/:queryId/:created/restore", indexerHandler.Restore)
+
+	historyHandler := history.NewActions(api.historyGC)
+	engine.GET("/query-history/gc", historyHandler.GCState)
+	engine.POST("/query-history/gc/pause", historyHandler.PauseGC)
+	engine.POST("/query-history/gc/resume", historyHandler.ResumeGC)
+	engine.POST("/query-history/gc/run", historyHandler.RunGC)
+
+	if api.integrityService != nil {
+		integrityHandler := integrity.NewActions(api.integrityService)
+		engine.GET("/integrity/status", integrityHandler.Status)
+		engine.GET("/integrity/reports", integrityHandler.Reports)
+	}
+
+	if api.qualityService != nil {
+		qualityHandler := quality.NewActions(api.qualityService)
+		engine.GET("/data-quality/reports", qualityHandler.Reports)
+		engine.POST("/data-quality/run", qualityHandler.Run)
+	}
 
 	api.server = &http.Server{
 		Handler:      engine,
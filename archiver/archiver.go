@@ -19,8 +19,10 @@ package archiver
 import (
 	"camus/cncdb"
 	"camus/reporting"
+	"camus/webhook"
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -44,6 +46,7 @@ import (
 // is reasonably large.
 type ArchKeeper struct {
 	redis       *RedisAdapter
+	queue       IQueue
 	dbArch      cncdb.IConcArchOps
 	reporting   reporting.IReporting
 	conf        *Conf
@@ -51,6 +54,10 @@ type ArchKeeper struct {
 	tz          *time.Location
 	stats       reporting.OpStats
 	recsToIndex chan<- cncdb.HistoryRecord
+	notifier    webhook.INotifier
+
+	sizeStats     reporting.RecordSizeStats
+	sizeStatsLock sync.Mutex
 }
 
 // Start starts the ArchKeeper service
@@ -64,7 +71,11 @@ func (job *ArchKeeper) Start(ctx context.Context) {
 				log.Info().Msg("about to close ArchKeeper")
 				return
 			case <-ticker.C:
-				if err := job.performCheck(); err != nil {
+				// Note: there is no deadline for the whole check as the fetched
+				// items are already removed from the queue (in case of Redis)
+				// and must be processed. A slow check just makes the ticker
+				// drop ticks.
+				if err := job.performCheck(ctx); err != nil {
 					log.Error().Err(err).Msg("Failed to archive query persistence items")
 				}
 			}
@@ -76,6 +87,9 @@ func (job *ArchKeeper) Start(ctx context.Context) {
 func (job *ArchKeeper) Stop(ctx context.Context) error {
 	log.Warn().Msg("stopping ArchKeeper task")
 	close(job.recsToIndex)
+	if err := job.queue.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to close ArchKeeper queue")
+	}
 	if err := job.dedup.OnClose(); err != nil {
 		return fmt.Errorf("failed to stop ArchKeeper properly: %w", err)
 	}
@@ -90,8 +104,8 @@ func (job *ArchKeeper) StoreToDisk() error {
 
 // Reset clears current operations data stored in RAM
 // and initializes itself according to the configuration.
-func (job *ArchKeeper) Reset() error {
-	return job.dedup.Reset()
+func (job *ArchKeeper) Reset(ctx context.Context) error {
+	return job.dedup.Reset(ctx)
 }
 
 // GetStats returns statistics related to ArchKeeper operations.
@@ -100,24 +114,159 @@ func (job *ArchKeeper) GetStats() reporting.OpStats {
 	return job.stats
 }
 
-func (job *ArchKeeper) LoadRecordsByID(concID string) ([]cncdb.ArchRecord, error) {
-	return job.dbArch.LoadRecordsByID(concID)
+// GetRecordSizeStats returns data size statistics of all
+// the archive records processed since Camus started.
+func (job *ArchKeeper) GetRecordSizeStats() reporting.RecordSizeStats {
+	job.sizeStatsLock.Lock()
+	defer job.sizeStatsLock.Unlock()
+	ans := make(reporting.RecordSizeStats)
+	ans.UpdateBy(job.sizeStats)
+	return ans
+}
+
+func (job *ArchKeeper) LoadRecordsByID(ctx context.Context, concID string) ([]cncdb.ArchRecord, error) {
+	return job.dbArch.LoadRecordsByID(ctx, concID)
+}
+
+// queueOpTimeout limits operations finishing processing of a queue
+// item (ack, reject, storing to the failed queue)
+const queueOpTimeout = 10 * time.Second
+
+// queueOpContext creates a context for operations finishing processing
+// of a queue item. They must run even if the processing itself has been
+// interrupted (e.g. by a shutdown), otherwise the item would be lost.
+func queueOpContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), queueOpTimeout)
+}
+
+// maxCreationTimeSkew specifies how far in the future
+// a record creation time can be to be still accepted
+const maxCreationTimeSkew = 5 * time.Minute
+
+// resolveCreationTime determines the time a record was created by KonText.
+// The record payload is preferred, then the queue item. In case none
+// of them provides a usable value, the ingestion time is returned.
+func resolveCreationTime(item queueRecord, rec cncdb.ArchRecord, ingested time.Time) time.Time {
+	candidates := make([]time.Time, 0, 2)
+	if t, ok := cncdb.DataCreationTime(rec.Data); ok {
+		candidates = append(candidates, t)
+	}
+	if item.Created > 0 {
+		candidates = append(candidates, time.Unix(item.Created, 0))
+	}
+	for _, t := range candidates {
+		if t.After(ingested.Add(maxCreationTimeSkew)) {
+			log.Warn().
+				Str("recordId", rec.ID).
+				Time("created", t).
+				Msg("record creation time is in the future, ignoring")
+			continue
+		}
+		return t.In(ingested.Location())
+	}
+	return ingested
+}
+
+// failedQueueRecord prepares a queue record for storing to the failed
+// items queue. For archive items, the creation time is preserved
+// so it survives a possible replay.
+func failedQueueRecord(item queueRecord, rec *cncdb.ArchRecord) queueRecord {
+	if rec != nil && !item.IsHistory() && item.Created == 0 && !rec.Created.IsZero() {
+		item.Created = rec.Created.Unix()
+	}
+	return item
+}
+
+// handleFailedItem rejects a queue item. In case the queue
+// is not going to deliver the item again, it is stored to the
+// failed items queue.
+func (job *ArchKeeper) handleFailedItem(ctx context.Context, item queueItem, rec *cncdb.ArchRecord) {
+	ctx, cancel := queueOpContext(ctx)
+	defer cancel()
+	redeliver, err := item.reject(ctx)
+	if err != nil {
+		log.Error().Err(err).Str("recordId", item.Key).Msg("failed to reject queue item")
+	}
+	if redeliver {
+		log.Warn().Str("recordId", item.Key).Msg("queue item will be redelivered")
+		return
+	}
+	if err := job.redis.AddError(ctx, job.conf.FailedQueueKey, failedQueueRecord(item.queueRecord, rec), rec); err != nil {
+		log.Error().Err(err).Msg("failed to insert error key")
+	}
+}
+
+// handleRejectedItem stores an item which cannot be processed (regardless
+// of how many times it is delivered) to the failed items queue.
+func (job *ArchKeeper) handleRejectedItem(ctx context.Context, item queueItem, rec *cncdb.ArchRecord) {
+	ctx, cancel := queueOpContext(ctx)
+	defer cancel()
+	if err := job.redis.AddError(ctx, job.conf.FailedQueueKey, failedQueueRecord(item.queueRecord, rec), rec); err != nil {
+		log.Error().Err(err).Msg("failed to insert error key")
+	}
+	if err := item.ack(ctx); err != nil {
+		log.Error().Err(err).Str("recordId", item.Key).Msg("failed to acknowledge queue item")
+	}
+}
+
+// applySizePolicy updates size statistics and applies configured policy
+// in case the record is oversized. The returned value specifies whether
+// the record can be archived.
+func (job *ArchKeeper) applySizePolicy(rec *cncdb.ArchRecord, sizeStats reporting.RecordSizeStats) bool {
+	size := len(rec.Data)
+	oversized := job.conf.MaxRecordSize > 0 && size > job.conf.MaxRecordSize
+	supertype := string(cncdb.DataSupertype(rec.Data))
+	if supertype == "" {
+		supertype = "other"
+	}
+	sizeStats.Add(supertype, size, oversized)
+	if !oversized {
+		return true
+	}
+	switch job.conf.OversizedPolicy {
+	case OversizedPolicyCompress:
+		// the database layer compresses the data on insert
+		log.Warn().
+			Str("recordId", rec.ID).
+			Int("size", size).
+			Msg("oversized record will be stored compressed")
+		return true
+	case OversizedPolicyTruncate:
+		data, err := cncdb.TruncateDerivedFields(rec.Data)
+		if err != nil {
+			log.Error().Err(err).Str("recordId", rec.ID).Msg("failed to truncate oversized record")
+			return false
+		}
+		log.Warn().
+			Str("recordId", rec.ID).
+			Int("size", size).
+			Int("truncatedSize", len(data)).
+			Msg("truncated oversized record")
+		if len(data) > job.conf.MaxRecordSize {
+			return false
+		}
+		rec.Data = data
+		return true
+	default:
+		log.Warn().
+			Str("recordId", rec.ID).
+			Int("size", size).
+			Msg("rejecting oversized record")
+		return false
+	}
 }
 
 // handleImplicitReq returns true if everything was ok, otherwise
 // false. Possible problems are logged.
 func (job *ArchKeeper) handleImplicitReq(
-	rec cncdb.ArchRecord, item queueRecord, currStats *reporting.OpStats) bool {
+	ctx context.Context, rec cncdb.ArchRecord, item queueItem, currStats *reporting.OpStats) bool {
 
-	match, err := job.dedup.TestAndSolve(rec)
+	match, err := job.dedup.TestAndSolve(ctx, rec)
 	if err != nil {
 		log.Error().
 			Err(err).
 			Str("recordId", item.Key).
 			Msg("failed to insert record, skipping")
-		if err := job.redis.AddError(job.conf.FailedQueueKey, item, &rec); err != nil {
-			log.Error().Err(err).Msg("failed to insert error key")
-		}
 		currStats.NumErrors++
 		return false
 	}
@@ -126,82 +275,93 @@ func (job *ArchKeeper) handleImplicitReq(
 			Str("recordId", item.Key).
 			Msg("record already archived, data merged")
 		currStats.NumMerged++
+		job.notifier.Notify(ctx, webhook.Event{Type: webhook.EventMerged, ConcID: rec.ID})
 		return true
 	}
-	if err := job.dbArch.InsertRecord(rec); err != nil {
+	if err := job.dbArch.InsertRecord(ctx, rec); err != nil {
 		log.Error().
 			Err(err).
 			Str("recordId", item.Key).
 			Msg("failed to insert record, skipping")
-		if err := job.redis.AddError(job.conf.FailedQueueKey, item, &rec); err != nil {
-			log.Error().Err(err).Msg("failed to insert error key")
-		}
+		currStats.NumErrors++
+		return false
 	}
+	job.notifier.Notify(ctx, webhook.Event{Type: webhook.EventArchived, ConcID: rec.ID})
 	job.dedup.Add(rec.ID)
 	currStats.NumInserted++
-	return false
+	return true
 }
 
+// handleExplicitReq returns true if everything was ok, otherwise
+// false. Possible problems are logged.
 func (job *ArchKeeper) handleExplicitReq(
-	rec cncdb.ArchRecord, item queueRecord, currStats *reporting.OpStats) {
-	exists, err := job.dbArch.ContainsRecord(rec.ID)
+	ctx context.Context, rec cncdb.ArchRecord, item queueItem, currStats *reporting.OpStats) bool {
+	exists, err := job.dbArch.ContainsRecord(ctx, rec.ID)
 	if err != nil {
 		currStats.NumErrors++
 		log.Error().
 			Err(err).
 			Str("recordId", item.Key).
 			Msg("failed to test record existence, skipping")
+		return false
 	}
 	if !exists {
-		err := job.dbArch.InsertRecord(rec)
+		err := job.dbArch.InsertRecord(ctx, rec)
 		if err != nil {
 			currStats.NumErrors++
 			log.Error().
 				Err(err).
 				Str("recordId", item.Key).
 				Msg("failed to insert record, skipping")
-
-		} else {
-			currStats.NumInserted++
+			return false
 		}
+		currStats.NumInserted++
+		job.notifier.Notify(ctx, webhook.Event{Type: webhook.EventPinned, ConcID: rec.ID})
 		job.dedup.Add(rec.ID)
 	}
+	return true
 }
 
-func (job *ArchKeeper) performCheck() error {
-	items, err := job.redis.NextNArchItems(job.conf.QueueKey, int64(job.conf.CheckIntervalChunk))
+func (job *ArchKeeper) performCheck(ctx context.Context) error {
+	items, err := job.queue.NextNItems(ctx, job.conf.CheckIntervalChunk)
 	log.Debug().
 		AnErr("error", err).
 		Int("itemsToProcess", len(items)).
 		Msg("doing regular check")
-	if err != nil {
+	if err != nil && len(items) == 0 {
 		return fmt.Errorf("failed to fetch next queued chunk: %w", err)
 	}
 	var currStats reporting.OpStats
+	currSizeStats := make(reporting.RecordSizeStats)
 	var numFetched int
 	for _, item := range items {
 		currStats.NumFetched++
-		rec, err := job.redis.GetConcRecord(item.KeyCode())
+		rec, err := job.redis.GetConcRecord(ctx, item.KeyCode())
 		if err != nil {
 			log.Error().
 				Err(err).
 				Str("recordId", item.Key).
 				Msg("failed to get record from Redis, skipping")
-			if err := job.redis.AddError(job.conf.FailedQueueKey, item, nil); err != nil {
-				log.Error().Err(err).Msg("failed to insert error key")
-			}
+			job.handleFailedItem(ctx, item, nil)
 			currStats.NumErrors++
 			continue
 		}
-		rec.Created = time.Now().In(job.tz)
+		rec.Ingested = time.Now().In(job.tz)
+		rec.Created = resolveCreationTime(item.queueRecord, rec, rec.Ingested)
 
+		ok := true
 		switch item.Type {
 		case QRTypeArchive, "":
+			if !job.applySizePolicy(&rec, currSizeStats) {
+				job.handleRejectedItem(ctx, item, &rec)
+				currStats.NumErrors++
+				continue
+			}
 			if item.Explicit {
-				job.handleExplicitReq(rec, item, &currStats)
+				ok = job.handleExplicitReq(ctx, rec, item, &currStats)
 
 			} else {
-				job.handleImplicitReq(rec, item, &currStats)
+				ok = job.handleImplicitReq(ctx, rec, item, &currStats)
 			}
 		case QRTypeHistory:
 			job.recsToIndex <- cncdb.HistoryRecord{
@@ -212,6 +372,15 @@ func (job *ArchKeeper) performCheck() error {
 				Rec:     &rec,
 			}
 		}
+		if !ok {
+			job.handleFailedItem(ctx, item, &rec)
+			continue
+		}
+		ackCtx, cancel := queueOpContext(ctx)
+		if err := item.ack(ackCtx); err != nil {
+			log.Error().Err(err).Str("recordId", item.Key).Msg("failed to acknowledge queue item")
+		}
+		cancel()
 	}
 	if currStats.ShowsActivity() {
 		log.Info().
@@ -223,29 +392,63 @@ func (job *ArchKeeper) performCheck() error {
 	}
 	job.reporting.WriteOperationsStatus(currStats)
 	job.stats.UpdateBy(currStats)
+	if len(currSizeStats) > 0 {
+		job.reporting.WriteRecordSizeStats(currSizeStats)
+		job.sizeStatsLock.Lock()
+		if job.sizeStats == nil {
+			job.sizeStats = make(reporting.RecordSizeStats)
+		}
+		job.sizeStats.UpdateBy(currSizeStats)
+		job.sizeStatsLock.Unlock()
+	}
 	return nil
 }
 
 func (job *ArchKeeper) DeduplicateInArchive(
-	curr []cncdb.ArchRecord, rec cncdb.ArchRecord) (cncdb.ArchRecord, error) {
-	return job.dbArch.DeduplicateInArchive(curr, rec)
+	ctx context.Context, curr []cncdb.ArchRecord, rec cncdb.ArchRecord) (cncdb.MergeResult, error) {
+	ans, err := job.dbArch.DeduplicateInArchive(ctx, curr, rec)
+	if err != nil {
+		return ans, err
+	}
+	job.notifier.Notify(
+		ctx,
+		webhook.Event{
+			Type:    webhook.EventMerged,
+			ConcID:  rec.ID,
+			Details: MergeEventDetails(ans),
+		},
+	)
+	return ans, nil
+}
+
+// MergeEventDetails creates details of the webhook.EventMerged event
+func MergeEventDetails(res cncdb.MergeResult) map[string]any {
+	ans := map[string]any{"numVariants": res.NumVariants}
+	if res.HasConflicts() {
+		ans["conflicts"] = res.Conflicts
+	}
+	return ans
 }
 
 func NewArchKeeper(
 	redis *RedisAdapter,
+	queue IQueue,
 	concArchDb cncdb.IConcArchOps,
 	dedup *Deduplicator,
 	recsToIndex chan<- cncdb.HistoryRecord,
 	reporting reporting.IReporting,
+	notifier webhook.INotifier,
 	tz *time.Location,
 	conf *Conf,
 ) *ArchKeeper {
 	return &ArchKeeper{
 		redis:       redis,
+		queue:       queue,
 		dbArch:      concArchDb,
 		dedup:       dedup,
 		recsToIndex: recsToIndex,
 		reporting:   reporting,
+		notifier:    notifier,
 		tz:          tz,
 		conf:        conf,
 	}
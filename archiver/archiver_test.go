@@ -0,0 +1,50 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"camus/cncdb"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveCreationTime(t *testing.T) {
+	ingested := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	fromItem := time.Date(2024, 5, 10, 9, 0, 0, 0, time.UTC)
+	fromData := time.Date(2024, 5, 10, 8, 30, 0, 0, time.UTC)
+
+	rec := cncdb.ArchRecord{ID: "abc", Data: `{"q":["aword,foo"]}`}
+	assert.Equal(t, ingested, resolveCreationTime(queueRecord{}, rec, ingested))
+	assert.Equal(t, fromItem, resolveCreationTime(queueRecord{Created: fromItem.Unix()}, rec, ingested))
+
+	rec.Data = `{"q":["aword,foo"],"created":"2024-05-10T08:30:00Z"}`
+	assert.Equal(t, fromData, resolveCreationTime(queueRecord{Created: fromItem.Unix()}, rec, ingested))
+
+	rec.Data = `{"q":["aword,foo"],"created":1893456000}` // 2030
+	assert.Equal(t, fromItem, resolveCreationTime(queueRecord{Created: fromItem.Unix()}, rec, ingested))
+}
+
+func TestFailedQueueRecordKeepsCreationTime(t *testing.T) {
+	created := time.Date(2024, 5, 10, 9, 0, 0, 0, time.UTC)
+	item := failedQueueRecord(queueRecord{Key: "abc"}, &cncdb.ArchRecord{ID: "abc", Created: created})
+	assert.Equal(t, created.Unix(), item.Created)
+
+	item = failedQueueRecord(queueRecord{Key: "abc", Type: QRTypeHistory, Created: 100}, &cncdb.ArchRecord{Created: created})
+	assert.Equal(t, int64(100), item.Created)
+}
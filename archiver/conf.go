@@ -17,6 +17,7 @@
 package archiver
 
 import (
+	"camus/cncdb"
 	"camus/util"
 	"fmt"
 	"time"
@@ -26,6 +27,11 @@ import (
 
 const (
 	dfltPreloadLastNItems = 500
+	dfltPreloadPageSize   = 1000
+
+	OversizedPolicyReject   = "reject"
+	OversizedPolicyCompress = "compress"
+	OversizedPolicyTruncate = "truncate"
 )
 
 type Conf struct {
@@ -57,9 +63,42 @@ type Conf struct {
 	// avoid them to save disk space and make database more responsive.
 	PreloadLastNItems int `json:"preloadLastNItems"`
 
+	// PreloadDays, if set, makes Camus preload IDs of all the items created
+	// within the specified number of recent days (instead of PreloadLastNItems).
+	// IDs are streamed from the database in pages of PreloadPageSize items
+	// in the background so record ingestion is not blocked.
+	PreloadDays int `json:"preloadDays"`
+
+	PreloadPageSize int `json:"preloadPageSize"`
+
+	// PreloadFromQueryHistory specifies whether the preload should also
+	// add IDs from the query history (within the PreloadDays window).
+	// This is mostly useful in case the archive itself contains many
+	// old items repeatedly used via the query history.
+	PreloadFromQueryHistory bool `json:"preloadFromQueryHistory"`
+
+	// QueueBackend specifies where Camus reads queued records from.
+	// Supported values are "redis" (default; a list specified by QueueKey)
+	// and "nats" (a JetStream stream specified in the NATS section).
+	QueueBackend string    `json:"queueBackend"`
+	NATS         *NATSConf `json:"nats"`
+
 	QueueKey         string `json:"queueKey"`
 	FailedQueueKey   string `json:"failedQueueKey"`
 	FailedRecordsKey string `json:"failedRecordsKey"`
+
+	// MaxRecordSize specifies max. size (in bytes) of a record's data.
+	// Zero means "no limit". What happens with oversized records is
+	// specified by OversizedPolicy.
+	MaxRecordSize int `json:"maxRecordSize"`
+
+	// OversizedPolicy can be one of:
+	// * "reject" - the record is moved to the failed queue
+	// * "compress" - the record is stored compressed (note that
+	//   KonText cannot read such records directly)
+	// * "truncate" - data KonText can recreate are removed from
+	//   the record; if the record is still too large, it is rejected
+	OversizedPolicy string `json:"oversizedPolicy"`
 }
 
 func (conf *Conf) CheckInterval() time.Duration {
@@ -86,15 +125,58 @@ func (conf *Conf) ValidateAndDefaults() error {
 		conf.CheckIntervalSecs = tmp
 	}
 
-	if conf.PreloadLastNItems == 0 {
-		conf.PreloadLastNItems = dfltPreloadLastNItems
-		log.Warn().
-			Int("value", conf.PreloadLastNItems).
-			Msg("value `archiver.preloadLastNItems` not set, using default")
+	if conf.PreloadDays < 0 {
+		return fmt.Errorf("invalid `archiver.preloadDays` value: %d", conf.PreloadDays)
+	}
+	if conf.PreloadDays > 0 {
+		if conf.PreloadPageSize <= 0 {
+			conf.PreloadPageSize = dfltPreloadPageSize
+			log.Warn().
+				Int("value", conf.PreloadPageSize).
+				Msg("value `archiver.preloadPageSize` not set, using default")
+		}
+
+	} else {
+		if conf.PreloadFromQueryHistory {
+			return fmt.Errorf("`archiver.preloadFromQueryHistory` requires `archiver.preloadDays`")
+		}
+		if conf.PreloadLastNItems == 0 {
+			conf.PreloadLastNItems = dfltPreloadLastNItems
+			log.Warn().
+				Int("value", conf.PreloadLastNItems).
+				Msg("value `archiver.preloadLastNItems` not set, using default")
+		}
+		if conf.PreloadLastNItems > cncdb.MaxRecentRecords {
+			return fmt.Errorf(
+				"`archiver.preloadLastNItems` cannot be greater than %d (use `archiver.preloadDays` instead)",
+				cncdb.MaxRecentRecords,
+			)
+		}
 	}
 
-	if conf.QueueKey == "" {
-		return fmt.Errorf("missing configuration: `archiver.queueKey`")
+	switch conf.QueueBackend {
+	case "":
+		conf.QueueBackend = QueueBackendRedis
+		log.Warn().
+			Str("value", conf.QueueBackend).
+			Msg("missing configuration `archiver.queueBackend` - using default")
+		fallthrough
+	case QueueBackendRedis:
+		if conf.QueueKey == "" {
+			return fmt.Errorf("missing configuration: `archiver.queueKey`")
+		}
+	case QueueBackendNATS:
+		if err := conf.NATS.ValidateAndDefaults(conf.CheckIntervalSecs); err != nil {
+			return err
+		}
+		if conf.FailedQueueKey == "" {
+			conf.FailedQueueKey = conf.NATS.Durable + "_failed"
+			log.Warn().
+				Str("value", conf.FailedQueueKey).
+				Msg("missing configuration `archiver.failedQueueKey` - using default")
+		}
+	default:
+		return fmt.Errorf("invalid `archiver.queueBackend` value: %s", conf.QueueBackend)
 	}
 	if conf.FailedQueueKey == "" {
 		conf.FailedQueueKey = conf.QueueKey + "_failed"
@@ -106,5 +188,21 @@ func (conf *Conf) ValidateAndDefaults() error {
 		return fmt.Errorf("missing configuration: `archiver.failedRecordsKey`")
 	}
 
+	if conf.MaxRecordSize < 0 {
+		return fmt.Errorf("invalid `archiver.maxRecordSize` value: %d", conf.MaxRecordSize)
+	}
+	switch conf.OversizedPolicy {
+	case "":
+		if conf.MaxRecordSize > 0 {
+			conf.OversizedPolicy = OversizedPolicyReject
+			log.Warn().
+				Str("value", conf.OversizedPolicy).
+				Msg("missing configuration `archiver.oversizedPolicy` - using default")
+		}
+	case OversizedPolicyReject, OversizedPolicyCompress, OversizedPolicyTruncate:
+	default:
+		return fmt.Errorf("invalid `archiver.oversizedPolicy` value: %s", conf.OversizedPolicy)
+	}
+
 	return nil
 }
@@ -18,6 +18,7 @@ package archiver
 
 import (
 	"camus/cncdb"
+	"context"
 	"fmt"
 	"os"
 	"sync"
@@ -37,8 +38,15 @@ type Deduplicator struct {
 	knownIDs      *bloom.BloomFilter
 	knownIDsMutex *sync.RWMutex
 	concDB        cncdb.IConcArchOps
+	qHistDB       cncdb.IQHistArchOps
 	tz            *time.Location
 	conf          *Conf
+
+	// preloadCancel and preloadDone control a running
+	// background preload (see Reset)
+	preloadCancel context.CancelFunc
+	preloadDone   chan struct{}
+	preloadMutex  sync.Mutex
 }
 
 func (dd *Deduplicator) StoreToDisk() error {
@@ -57,6 +65,7 @@ func (dd *Deduplicator) StoreToDisk() error {
 }
 
 func (dd *Deduplicator) OnClose() error {
+	dd.stopPreload()
 	return dd.StoreToDisk()
 }
 
@@ -81,31 +90,178 @@ func (dd *Deduplicator) Add(concID string) {
 	dd.knownIDs.AddString(concID)
 }
 
-func (dd *Deduplicator) Reset() error {
-	log.Warn().Msg("performing deduplicator reset")
+// addAll adds multiple IDs while acquiring the lock just once
+func (dd *Deduplicator) addAll(concIDs []string) {
 	dd.knownIDsMutex.Lock()
 	defer dd.knownIDsMutex.Unlock()
+	for _, id := range concIDs {
+		dd.knownIDs.AddString(id)
+	}
+}
+
+// Reset clears the deduplicator and preloads recent items. With
+// `preloadDays` configured, the preload runs in the background
+// (i.e. the deduplicator is usable, even if less effective, right
+// after Reset returns) and a possible error is only logged.
+func (dd *Deduplicator) Reset(ctx context.Context) error {
+	log.Warn().Msg("performing deduplicator reset")
+	dd.stopPreload()
+	dd.knownIDsMutex.Lock()
 	dd.knownIDs.ClearAll()
+	dd.knownIDsMutex.Unlock()
+	if dd.conf.PreloadDays > 0 {
+		dd.startPreload(ctx)
+		return nil
+	}
 	if dd.conf.PreloadLastNItems > 0 {
-		return dd.preloadLastNItems()
+		return dd.preloadLastNItems(ctx)
 	}
 	return nil
 }
 
-func (dd *Deduplicator) preloadLastNItems() error {
-	items, err := dd.concDB.LoadRecentNRecords(dd.conf.PreloadLastNItems)
+func (dd *Deduplicator) preloadLastNItems(ctx context.Context) error {
+	items, err := dd.concDB.LoadRecentNRecords(ctx, dd.conf.PreloadLastNItems)
 	if err != nil {
 		return fmt.Errorf("deduplicator failed to preload last N items: %w", err)
 	}
-	for _, item := range items {
-		dd.knownIDs.AddString(item.ID) // Note: cannot use own dd.Add here as it won't get a lock
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
 	}
+	dd.addAll(ids)
 	log.Debug().
-		Int("numItems", dd.conf.PreloadLastNItems).
+		Int("numItems", len(ids)).
 		Msg("preloaded items for better deduplication")
 	return nil
 }
 
+// startPreload runs preloadTimeWindow in the background. The preload
+// is not bound to the (typically request) context it was started with.
+// It can be stopped via stopPreload.
+func (dd *Deduplicator) startPreload(ctx context.Context) {
+	preloadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	dd.preloadMutex.Lock()
+	dd.preloadCancel = cancel
+	dd.preloadDone = done
+	dd.preloadMutex.Unlock()
+	go func() {
+		defer close(done)
+		defer cancel()
+		if err := dd.preloadTimeWindow(preloadCtx); err != nil {
+			log.Error().Err(err).Msg("deduplicator preload failed")
+		}
+	}()
+}
+
+// stopPreload cancels a running background preload (if any)
+// and waits for it to finish
+func (dd *Deduplicator) stopPreload() {
+	dd.preloadMutex.Lock()
+	cancel, done := dd.preloadCancel, dd.preloadDone
+	dd.preloadCancel, dd.preloadDone = nil, nil
+	dd.preloadMutex.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// preloadTimeWindow adds IDs of all the items created within
+// the last `preloadDays`. The IDs are loaded in pages so the
+// deduplicator is locked only for a short time per page.
+func (dd *Deduplicator) preloadTimeWindow(ctx context.Context) error {
+	t0 := time.Now()
+	fromDate := t0.In(dd.tz).AddDate(0, 0, -dd.conf.PreloadDays)
+	log.Info().
+		Int("preloadDays", dd.conf.PreloadDays).
+		Time("fromDate", fromDate).
+		Msg("starting deduplicator preload")
+	numArch, err := dd.preloadArchiveIDs(ctx, fromDate)
+	if err != nil {
+		return fmt.Errorf("deduplicator failed to preload archive items: %w", err)
+	}
+	var numHist int
+	if dd.conf.PreloadFromQueryHistory && dd.qHistDB != nil {
+		numHist, err = dd.preloadQueryHistoryIDs(ctx, fromDate)
+		if err != nil {
+			return fmt.Errorf("deduplicator failed to preload query history items: %w", err)
+		}
+	}
+	log.Info().
+		Int("numArchiveItems", numArch).
+		Int("numQueryHistoryItems", numHist).
+		Dur("duration", time.Since(t0)).
+		Msg("finished deduplicator preload")
+	if numArch+numHist > bloomFilterNumBits {
+		log.Warn().
+			Int("numItems", numArch+numHist).
+			Int("estimatedCapacity", bloomFilterNumBits).
+			Msg("preloaded items exceed deduplicator capacity, expect more false positives")
+	}
+	return nil
+}
+
+func (dd *Deduplicator) preloadArchiveIDs(ctx context.Context, fromDate time.Time) (int, error) {
+	var total int
+	lastCreated := fromDate
+	var lastID string
+	for {
+		items, err := dd.concDB.LoadRecordIDs(ctx, lastCreated, lastID, dd.conf.PreloadPageSize)
+		if err != nil {
+			return total, err
+		}
+		if len(items) == 0 {
+			return total, nil
+		}
+		ids := make([]string, len(items))
+		for i, item := range items {
+			ids[i] = item.ID
+		}
+		dd.addAll(ids)
+		total += len(items)
+		lastCreated = items[len(items)-1].Created
+		lastID = items[len(items)-1].ID
+		log.Info().
+			Int("numItems", total).
+			Time("lastCreated", lastCreated).
+			Msg("deduplicator preload progress (archive)")
+		if len(items) < dd.conf.PreloadPageSize {
+			return total, nil
+		}
+	}
+}
+
+func (dd *Deduplicator) preloadQueryHistoryIDs(ctx context.Context, fromDate time.Time) (int, error) {
+	var total int
+	// records of different users may share both creation time and query ID
+	// so the user ID must be a part of the paging key
+	last := cncdb.HistoryRecord{Created: fromDate.Unix(), UserID: -1}
+	for {
+		items, err := dd.qHistDB.LoadHistoryRecords(ctx, last, dd.conf.PreloadPageSize)
+		if err != nil {
+			return total, err
+		}
+		if len(items) == 0 {
+			return total, nil
+		}
+		ids := make([]string, len(items))
+		for i, item := range items {
+			ids[i] = item.QueryID
+		}
+		dd.addAll(ids)
+		total += len(items)
+		last = items[len(items)-1]
+		log.Info().
+			Int("numItems", total).
+			Time("lastCreated", time.Unix(last.Created, 0)).
+			Msg("deduplicator preload progress (query history)")
+		if len(items) < dd.conf.PreloadPageSize {
+			return total, nil
+		}
+	}
+}
+
 func (dd *Deduplicator) TestRecord(concID string) bool {
 	dd.knownIDsMutex.RLock()
 	defer dd.knownIDsMutex.RUnlock()
@@ -118,11 +274,11 @@ func (dd *Deduplicator) TestRecord(concID string) bool {
 // The "recently used" means that we keep track of recently stored IDs and test
 // for them only. I.e. we do not perform full search in query persistence db
 // for each and every concID we want to store.
-func (dd *Deduplicator) TestAndSolve(newRec cncdb.ArchRecord) (bool, error) {
+func (dd *Deduplicator) TestAndSolve(ctx context.Context, newRec cncdb.ArchRecord) (bool, error) {
 	if !dd.TestRecord(newRec.ID) {
 		return false, nil
 	}
-	recs, err := dd.concDB.LoadRecordsByID(newRec.ID)
+	recs, err := dd.concDB.LoadRecordsByID(ctx, newRec.ID)
 	if err != nil {
 		return false, fmt.Errorf("failed to deduplicate id %s: %w", newRec.ID, err)
 	}
@@ -136,44 +292,27 @@ func (dd *Deduplicator) TestAndSolve(newRec cncdb.ArchRecord) (bool, error) {
 		Str("concId", newRec.ID).
 		Int("numVariants", len(recs)).
 		Msg("found archived record")
-	queryTest := make(map[string][]cncdb.ArchRecord)
-	for _, rec := range recs {
-		_, ok := queryTest[rec.Data]
-		if !ok {
-			queryTest[rec.Data] = make([]cncdb.ArchRecord, 0, 10)
-		}
-		queryTest[rec.Data] = append(queryTest[rec.Data], rec)
-	}
-	var bestRecKey string
-	var largestEntry int
-	for k, v := range queryTest {
-		if len(v) > largestEntry {
-			largestEntry = len(v)
-			bestRecKey = k
-		}
-	}
-	if len(queryTest) > 1 {
-		for k, v := range queryTest {
-			if k == bestRecKey {
-				continue
-			}
-			log.Error().
-				Str("concId", newRec.ID).
-				Str("query", v[0].Data).
-				Msg("Conc. persistence consistency error")
-		}
-	}
-	_, err = dd.concDB.DeduplicateInArchive(queryTest[bestRecKey], newRec)
+	// possible inconsistencies between variants are handled (and reported)
+	// by the merge policy of the database adapter
+	_, err = dd.concDB.DeduplicateInArchive(ctx, recs, newRec)
 	return true, err
 }
 
+// NewDeduplicator creates a new deduplicator. The qHistDB argument
+// is used only for preloading (see Conf.PreloadFromQueryHistory)
+// and can be nil.
 func NewDeduplicator(
-	concDB cncdb.IConcArchOps, conf *Conf, loc *time.Location) (*Deduplicator, error) {
+	concDB cncdb.IConcArchOps,
+	qHistDB cncdb.IQHistArchOps,
+	conf *Conf,
+	loc *time.Location,
+) (*Deduplicator, error) {
 	filter := bloom.NewWithEstimates(bloomFilterNumBits, bloomFilterProbCollision)
 	d := &Deduplicator{
 		tz:            loc,
 		knownIDs:      filter,
 		concDB:        concDB,
+		qHistDB:       qHistDB,
 		conf:          conf,
 		knownIDsMutex: &sync.RWMutex{},
 	}
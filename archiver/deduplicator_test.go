@@ -0,0 +1,126 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"camus/cncdb"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pagedConcArch struct {
+	cncdb.DummyConcArchSQL
+	items    []cncdb.RecordID
+	numPages int
+}
+
+func (db *pagedConcArch) LoadRecordIDs(
+	ctx context.Context, fromCreated time.Time, fromID string, limit int) ([]cncdb.RecordID, error) {
+	db.numPages++
+	ans := make([]cncdb.RecordID, 0, limit)
+	for _, item := range db.items {
+		if item.Created.After(fromCreated) || item.Created.Equal(fromCreated) && item.ID > fromID {
+			ans = append(ans, item)
+		}
+		if len(ans) == limit {
+			break
+		}
+	}
+	return ans, nil
+}
+
+type pagedQHist struct {
+	cncdb.DummyQHistSQL
+	items []cncdb.HistoryRecord
+}
+
+func (db *pagedQHist) LoadHistoryRecords(
+	ctx context.Context, from cncdb.HistoryRecord, limit int) ([]cncdb.HistoryRecord, error) {
+	ans := make([]cncdb.HistoryRecord, 0, limit)
+	for _, item := range db.items {
+		if item.Created > from.Created || item.Created == from.Created &&
+			(item.UserID > from.UserID || item.UserID == from.UserID && item.QueryID > from.QueryID) {
+			ans = append(ans, item)
+		}
+		if len(ans) == limit {
+			break
+		}
+	}
+	return ans, nil
+}
+
+func TestDeduplicatorPreloadTimeWindow(t *testing.T) {
+	now := time.Now()
+	db := &pagedConcArch{}
+	for i := 0; i < 25; i++ {
+		// three items share the same creation time to test paging
+		db.items = append(db.items, cncdb.RecordID{
+			ID:      fmt.Sprintf("rec%02d", i),
+			Created: now.Add(time.Duration(i/3-10) * time.Hour),
+		})
+	}
+	conf := &Conf{
+		DDStateFilePath: filepath.Join(t.TempDir(), "dedup.bin"),
+		PreloadDays:     1,
+		PreloadPageSize: 4,
+	}
+	dd, err := NewDeduplicator(db, nil, conf, time.UTC)
+	assert.NoError(t, err)
+	dd.Add("old")
+
+	assert.NoError(t, dd.Reset(context.Background()))
+	dd.preloadMutex.Lock()
+	done := dd.preloadDone
+	dd.preloadMutex.Unlock()
+	<-done
+	assert.False(t, dd.TestRecord("old"))
+	for _, item := range db.items {
+		assert.True(t, dd.TestRecord(item.ID))
+	}
+	assert.Equal(t, 7, db.numPages)
+}
+
+func TestDeduplicatorPreloadQueryHistory(t *testing.T) {
+	created := time.Now().Add(-time.Hour).Unix()
+	qHist := &pagedQHist{}
+	for i := 0; i < 12; i++ {
+		// different users share both the creation time and query IDs
+		qHist.items = append(qHist.items, cncdb.HistoryRecord{
+			QueryID: fmt.Sprintf("q%02d", i%4),
+			UserID:  i / 4,
+			Created: created,
+		})
+	}
+	qHist.items = append(qHist.items, cncdb.HistoryRecord{QueryID: "last", UserID: 3, Created: created})
+	conf := &Conf{
+		DDStateFilePath:         filepath.Join(t.TempDir(), "dedup.bin"),
+		PreloadDays:             1,
+		PreloadPageSize:         2,
+		PreloadFromQueryHistory: true,
+	}
+	dd, err := NewDeduplicator(&pagedConcArch{}, qHist, conf, time.UTC)
+	assert.NoError(t, err)
+	total, err := dd.preloadQueryHistoryIDs(context.Background(), time.Now().Add(-24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, len(qHist.items), total)
+	assert.True(t, dd.TestRecord("last"))
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+// JetStreamQueue consumes archive and history records from
+// a NATS JetStream stream using a durable pull consumer.
+// Each record must be explicitly acknowledged. Rejected records
+// are redelivered until `maxDeliver` is reached.
+type JetStreamQueue struct {
+	conf     *NATSConf
+	conn     *nats.Conn
+	consumer jetstream.Consumer
+}
+
+func (q *JetStreamQueue) wrapMsg(msg jetstream.Msg) (queueItem, error) {
+	rec, err := decodeQueueRecord(string(msg.Data()))
+	if err != nil {
+		return queueItem{}, err
+	}
+	return queueItem{
+		queueRecord: rec,
+		ack: func(ctx context.Context) error {
+			return msg.Ack()
+		},
+		reject: func(ctx context.Context) (bool, error) {
+			meta, err := msg.Metadata()
+			if err != nil {
+				return false, fmt.Errorf("failed to reject message: %w", err)
+			}
+			if meta.NumDelivered >= uint64(q.conf.MaxDeliver) {
+				return false, msg.Term()
+			}
+			return true, msg.Nak()
+		},
+	}, nil
+}
+
+func (q *JetStreamQueue) NextNItems(ctx context.Context, n int) ([]queueItem, error) {
+	batch, err := q.consumer.FetchNoWait(n)
+	if err != nil {
+		return []queueItem{}, fmt.Errorf("failed to fetch items from stream: %w", err)
+	}
+	ans := make([]queueItem, 0, n)
+	for msg := range batch.Messages() {
+		item, err := q.wrapMsg(msg)
+		if err != nil {
+			// such a message cannot be fixed by redelivery
+			log.Error().Err(err).Msg("invalid queue message, terminating")
+			if err := msg.Term(); err != nil {
+				log.Error().Err(err).Msg("failed to terminate invalid message")
+			}
+			continue
+		}
+		ans = append(ans, item)
+	}
+	if batch.Error() != nil {
+		return ans, fmt.Errorf("failed to fetch items from stream: %w", batch.Error())
+	}
+	return ans, nil
+}
+
+func (q *JetStreamQueue) Close() error {
+	return q.conn.Drain()
+}
+
+// NewJetStreamQueue connects to a NATS server, makes sure the configured
+// stream exists and creates (or updates) Camus' durable consumer.
+func NewJetStreamQueue(ctx context.Context, conf *NATSConf) (*JetStreamQueue, error) {
+	conn, err := nats.Connect(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+	_, err = js.Stream(ctx, conf.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		log.Warn().
+			Str("stream", conf.Stream).
+			Str("subject", conf.Subject).
+			Msg("JetStream stream not found, creating a new one")
+		_, err = js.CreateStream(
+			ctx,
+			jetstream.StreamConfig{
+				Name:     conf.Stream,
+				Subjects: []string{conf.Subject},
+			},
+		)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to initialize stream %s: %w", conf.Stream, err)
+	}
+	consumer, err := js.CreateOrUpdateConsumer(
+		ctx,
+		conf.Stream,
+		jetstream.ConsumerConfig{
+			Durable:       conf.Durable,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       conf.AckWait(),
+			MaxDeliver:    conf.MaxDeliver,
+			FilterSubject: conf.Subject,
+		},
+	)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create consumer %s: %w", conf.Durable, err)
+	}
+	return &JetStreamQueue{
+		conf:     conf,
+		conn:     conn,
+		consumer: consumer,
+	}, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func runEmbeddedNATS(t *testing.T) *server.Server {
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+func fetchAtLeast(t *testing.T, q *JetStreamQueue, n int) []queueItem {
+	ans := make([]queueItem, 0, n)
+	for i := 0; i < 50 && len(ans) < n; i++ {
+		items, err := q.NextNItems(context.Background(), 10)
+		assert.NoError(t, err)
+		ans = append(ans, items...)
+		if len(ans) < n {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	return ans
+}
+
+func TestJetStreamQueueAckAndRedelivery(t *testing.T) {
+	srv := runEmbeddedNATS(t)
+	conf := &NATSConf{
+		URL:         srv.ClientURL(),
+		Stream:      "CAMUS",
+		Subject:     "camus.queue",
+		Durable:     "camus-test",
+		MaxDeliver:  2,
+		AckWaitSecs: 30,
+	}
+	q, err := NewJetStreamQueue(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	pub, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	js, err := pub.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = js.Publish(conf.Subject, []byte(`{"type": "archive", "key": "concordance:a1"}`))
+	assert.NoError(t, err)
+	_, err = js.Publish(conf.Subject, []byte(`b2`))
+	assert.NoError(t, err)
+
+	items := fetchAtLeast(t, q, 2)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "a1", items[0].KeyCode())
+	assert.Equal(t, QRTypeArchive, items[0].Type)
+	assert.Equal(t, "b2", items[1].KeyCode())
+
+	assert.NoError(t, items[0].ack(context.Background()))
+	redeliver, err := items[1].reject(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, redeliver)
+
+	items = fetchAtLeast(t, q, 1)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "b2", items[0].KeyCode())
+	redeliver, err = items[0].reject(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, redeliver)
+
+	time.Sleep(100 * time.Millisecond)
+	items, err = q.NextNItems(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Len(t, items, 0)
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltNATSDurable    = "camus"
+	dfltNATSMaxDeliver = 5
+)
+
+// NATSConf configures a JetStream-based queue of archive
+// and history records.
+type NATSConf struct {
+	URL string `json:"url"`
+
+	// Stream is a name of a JetStream stream the records are
+	// published to. In case the stream does not exist, Camus creates it.
+	Stream string `json:"stream"`
+
+	// Subject is a subject KonText publishes queue records to
+	Subject string `json:"subject"`
+
+	// Durable is a name of Camus' durable consumer
+	Durable string `json:"durable"`
+
+	// MaxDeliver specifies how many times a record is delivered
+	// before it is considered failed and moved to the failed queue.
+	MaxDeliver int `json:"maxDeliver"`
+
+	// AckWaitSecs specifies how long the server waits for an
+	// acknowledgement before it redelivers a record.
+	AckWaitSecs int `json:"ackWaitSecs"`
+}
+
+func (conf *NATSConf) AckWait() time.Duration {
+	return time.Duration(conf.AckWaitSecs) * time.Second
+}
+
+func (conf *NATSConf) ValidateAndDefaults(checkIntervalSecs int) error {
+	if conf == nil {
+		return fmt.Errorf("missing `archiver.nats` section")
+	}
+	if conf.URL == "" {
+		return fmt.Errorf("missing configuration: `archiver.nats.url`")
+	}
+	if conf.Stream == "" {
+		return fmt.Errorf("missing configuration: `archiver.nats.stream`")
+	}
+	if conf.Subject == "" {
+		return fmt.Errorf("missing configuration: `archiver.nats.subject`")
+	}
+	if conf.Durable == "" {
+		conf.Durable = dfltNATSDurable
+		log.Warn().
+			Str("value", conf.Durable).
+			Msg("missing configuration `archiver.nats.durable` - using default")
+	}
+	if conf.MaxDeliver == 0 {
+		conf.MaxDeliver = dfltNATSMaxDeliver
+		log.Warn().
+			Int("value", conf.MaxDeliver).
+			Msg("missing configuration `archiver.nats.maxDeliver` - using default")
+	}
+	if conf.AckWaitSecs == 0 {
+		conf.AckWaitSecs = 2 * checkIntervalSecs
+		log.Warn().
+			Int("value", conf.AckWaitSecs).
+			Msg("missing configuration `archiver.nats.ackWaitSecs` - using double of `archiver.checkIntervalSecs`")
+	}
+	if conf.AckWaitSecs <= checkIntervalSecs {
+		return fmt.Errorf("`archiver.nats.ackWaitSecs` must be greater than `archiver.checkIntervalSecs`")
+	}
+	return nil
+}
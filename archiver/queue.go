@@ -0,0 +1,85 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"context"
+)
+
+const (
+	QueueBackendRedis = "redis"
+	QueueBackendNATS  = "nats"
+)
+
+// queueItem is a queue record along with functions
+// for confirming/rejecting its processing. For queues
+// without explicit acknowledgement support, both functions
+// are no-op.
+type queueItem struct {
+	queueRecord
+	ack func(ctx context.Context) error
+
+	// reject marks the item as failed. The returned value
+	// tells whether the item will be delivered again. If not,
+	// it is up to the caller to store the item somewhere.
+	reject func(ctx context.Context) (bool, error)
+}
+
+// IQueue represents a source of archive and history
+// records to be processed by ArchKeeper.
+type IQueue interface {
+	NextNItems(ctx context.Context, n int) ([]queueItem, error)
+	Close() error
+}
+
+// -------------------------
+
+func noAck(ctx context.Context) error {
+	return nil
+}
+
+func noRedelivery(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+// RedisQueue consumes items from a Redis list.
+// Items are removed from the list once fetched so there is
+// no redelivery of failed items.
+type RedisQueue struct {
+	redis    *RedisAdapter
+	queueKey string
+}
+
+func (q *RedisQueue) NextNItems(ctx context.Context, n int) ([]queueItem, error) {
+	recs, err := q.redis.NextNArchItems(ctx, q.queueKey, int64(n))
+	if err != nil {
+		return []queueItem{}, err
+	}
+	ans := make([]queueItem, len(recs))
+	for i, rec := range recs {
+		ans[i] = queueItem{queueRecord: rec, ack: noAck, reject: noRedelivery}
+	}
+	return ans, nil
+}
+
+func (q *RedisQueue) Close() error {
+	return nil
+}
+
+func NewRedisQueue(redis *RedisAdapter, queueKey string) *RedisQueue {
+	return &RedisQueue{redis: redis, queueKey: queueKey}
+}
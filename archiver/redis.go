@@ -41,10 +41,16 @@ type queueRecord struct {
 	// query persistence data
 	Explicit bool `json:"explicit"`
 
+	// Created is a UNIX timestamp of the original operation. For history
+	// items, it is the time the query was run. For archive items, it is
+	// optional and it specifies when the record was created by KonText
+	// (it is also filled in by Camus when storing failed items so that
+	// a replayed item keeps its original creation time).
+	Created int64 `json:"created"`
+
 	// query history data
-	UserID  int    `json:"user_id"`
-	Created int64  `json:"created"`
-	Name    string `json:"name"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
 }
 
 func (qr queueRecord) IsArchive() bool {
@@ -55,6 +61,19 @@ func (qr queueRecord) IsHistory() bool {
 	return qr.Type == "history"
 }
 
+// decodeQueueRecord decodes a raw queue item which can be either
+// a JSON-encoded queueRecord or (legacy format) just a record key.
+func decodeQueueRecord(raw string) (queueRecord, error) {
+	if !strings.Contains(raw, `"key"`) {
+		return queueRecord{Key: raw}, nil
+	}
+	var v queueRecord
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return queueRecord{}, fmt.Errorf("failed to decode queue item `%s`: %w", raw, err)
+	}
+	return v, nil
+}
+
 func (qr queueRecord) KeyCode() string {
 	if strings.HasPrefix(qr.Key, "concordance:") {
 		return strings.Split(qr.Key, "concordance:")[1]
@@ -62,35 +81,47 @@ func (qr queueRecord) KeyCode() string {
 	return qr.Key
 }
 
+// RedisAdapter provides Redis operations needed by Camus.
+// All the methods accept a context so callers can control
+// cancellation and deadlines of individual operations.
+// Each method uses one of the three connections (Camus state,
+// concordance records, queues) - see RedisConf. In case some of
+// the connections are not configured, the state connection is shared.
 type RedisAdapter struct {
-	conf  *RedisConf
+	conf *RedisConf
+
+	// redis is a connection for Camus internal state
 	redis *redis.Client
-	ctx   context.Context
+
+	// records is a connection for KonText concordance records
+	records *redis.Client
+
+	// queues is a connection for the archive queue and other lists
+	// used as queues
+	queues *redis.Client
 }
 
 func (rd *RedisAdapter) String() string {
+	status := "active"
 	if rd.redis == nil {
-		return fmt.Sprintf(
-			"RedisAdapter (inactive), address %s:%d, db %d",
-			rd.conf.Host, rd.conf.Port, rd.conf.DB,
-		)
+		status = "inactive"
 	}
 	return fmt.Sprintf(
-		"RedisAdapter (active) address %s:%d, db %d",
-		rd.conf.Host, rd.conf.Port, rd.conf.DB,
+		"RedisAdapter (%s), state: %s, records: %s, queues: %s",
+		status, rd.conf.RedisConnConf, rd.conf.RecordsConn(), rd.conf.QueuesConn(),
 	)
 }
 
-func (rd *RedisAdapter) Type(k string) (string, error) {
-	cmd := rd.redis.Type(rd.ctx, k)
+func (rd *RedisAdapter) Type(ctx context.Context, k string) (string, error) {
+	cmd := rd.redis.Type(ctx, k)
 	if cmd.Err() != nil {
 		return "", fmt.Errorf("failed to determine type of %s: %w", k, cmd.Err())
 	}
 	return cmd.Val(), nil
 }
 
-func (rd *RedisAdapter) Get(k string) (string, error) {
-	cmd := rd.redis.Get(rd.ctx, k)
+func (rd *RedisAdapter) Get(ctx context.Context, k string) (string, error) {
+	cmd := rd.redis.Get(ctx, k)
 	if cmd.Err() == redis.Nil {
 		return "", nil
 	}
@@ -100,44 +131,44 @@ func (rd *RedisAdapter) Get(k string) (string, error) {
 	return cmd.Val(), nil
 }
 
-func (rd *RedisAdapter) Set(k string, v any) error {
-	cmd := rd.redis.Set(rd.ctx, k, v, 0)
+func (rd *RedisAdapter) Set(ctx context.Context, k string, v any) error {
+	cmd := rd.redis.Set(ctx, k, v, 0)
 	if cmd.Err() != nil {
 		return fmt.Errorf("failed to set Redis item %s: %w", k, cmd.Err())
 	}
 	return nil
 }
 
-func (rd *RedisAdapter) Exists(key string) (bool, error) {
-	cmd := rd.redis.Exists(rd.ctx, key)
+func (rd *RedisAdapter) Exists(ctx context.Context, key string) (bool, error) {
+	cmd := rd.redis.Exists(ctx, key)
 	if cmd.Err() != nil {
 		return false, fmt.Errorf("failed to test key %s: %w", key, cmd.Err())
 	}
 	return cmd.Val() > 0, nil
 }
 
-func (rd *RedisAdapter) TriggerChan(chname, value string) error {
-	return rd.redis.Publish(rd.ctx, chname, value).Err()
+func (rd *RedisAdapter) TriggerChan(ctx context.Context, chname, value string) error {
+	return rd.queues.Publish(ctx, chname, value).Err()
 }
 
-func (rd *RedisAdapter) UintZAdd(key string, v int) error {
+func (rd *RedisAdapter) UintZAdd(ctx context.Context, key string, v int) error {
 	if v < 0 {
 		panic("UintZAdd - cannot add numbers < 0")
 	}
-	cmd := rd.redis.ZAdd(rd.ctx, key, redis.Z{Score: float64(v), Member: v})
+	cmd := rd.redis.ZAdd(ctx, key, redis.Z{Score: float64(v), Member: v})
 	return cmd.Err()
 }
 
-func (rd *RedisAdapter) ZCard(key string) (int, error) {
-	cmd := rd.redis.ZCard(rd.ctx, key)
+func (rd *RedisAdapter) ZCard(ctx context.Context, key string) (int, error) {
+	cmd := rd.redis.ZCard(ctx, key)
 	return int(cmd.Val()), cmd.Err()
 }
 
 // IntZRemLowest removes and returns an element with lowest score from ZSET
 // returns true if the record was found and removed, otherwise false
 // (i.e. not finding the record is not an error)
-func (rd *RedisAdapter) UintZRemLowest(key string) (int, error) {
-	cmd := rd.redis.ZRange(rd.ctx, key, 0, 0)
+func (rd *RedisAdapter) UintZRemLowest(ctx context.Context, key string) (int, error) {
+	cmd := rd.redis.ZRange(ctx, key, 0, 0)
 	if cmd.Err() == redis.Nil {
 		return -1, nil
 
@@ -154,7 +185,7 @@ func (rd *RedisAdapter) UintZRemLowest(key string) (int, error) {
 	if err != nil {
 		return 0, fmt.Errorf("IntZRemLowest failed - item is not an integer")
 	}
-	cmd2 := rd.redis.ZRem(rd.ctx, key, vToRem)
+	cmd2 := rd.redis.ZRem(ctx, key, vToRem)
 	if cmd2.Err() != nil {
 		err = fmt.Errorf("IntZRemLowest failed: %w", cmd2.Err())
 	}
@@ -162,28 +193,123 @@ func (rd *RedisAdapter) UintZRemLowest(key string) (int, error) {
 }
 
 // ChannelSubscribe subscribe to a Redis channel with a specified name.
-func (rd *RedisAdapter) ChannelSubscribe(name string) <-chan *redis.Message {
-	sub := rd.redis.Subscribe(rd.ctx, name)
+func (rd *RedisAdapter) ChannelSubscribe(ctx context.Context, name string) <-chan *redis.Message {
+	sub := rd.queues.Subscribe(ctx, name)
 	return sub.Channel()
 }
 
 // NextQueueItem fetches an item from the beginning of a Redis list
 // (i.e. LPOP is used in the background and RPUSH is expected to be
 // used to add new items on the other side).
-func (rd *RedisAdapter) NextQueueItem(queue string) (string, error) {
-	lpopCmd := rd.redis.LPop(rd.ctx, queue)
+// In case the queue is empty, an empty string is returned.
+func (rd *RedisAdapter) NextQueueItem(ctx context.Context, queue string) (string, error) {
+	lpopCmd := rd.queues.LPop(ctx, queue)
+	if lpopCmd.Err() == redis.Nil {
+		return "", nil
+	}
 	if lpopCmd.Err() != nil {
 		return "", lpopCmd.Err()
 	}
 	return lpopCmd.Val(), nil
 }
 
-func (rd *RedisAdapter) NextNArchItems(queueKey string, n int64) ([]queueRecord, error) {
+// RPush appends a value to the end of a Redis list
+func (rd *RedisAdapter) RPush(ctx context.Context, key string, value string) error {
+	cmd := rd.queues.RPush(ctx, key, value)
+	if cmd.Err() != nil {
+		return fmt.Errorf("failed to push to list %s: %w", key, cmd.Err())
+	}
+	return nil
+}
+
+// LMove atomically moves the first item of the src list to the end
+// of the dst list and returns the item. In case the src list is empty,
+// an empty string is returned.
+func (rd *RedisAdapter) LMove(ctx context.Context, src, dst string) (string, error) {
+	cmd := rd.queues.LMove(ctx, src, dst, "LEFT", "RIGHT")
+	if cmd.Err() == redis.Nil {
+		return "", nil
+	}
+	if cmd.Err() != nil {
+		return "", fmt.Errorf("failed to move item from list %s to %s: %w", src, dst, cmd.Err())
+	}
+	return cmd.Val(), nil
+}
+
+// LRem removes the first occurrence of a value from a Redis list
+func (rd *RedisAdapter) LRem(ctx context.Context, key string, value string) error {
+	cmd := rd.queues.LRem(ctx, key, 1, value)
+	if cmd.Err() != nil {
+		return fmt.Errorf("failed to remove item from list %s: %w", key, cmd.Err())
+	}
+	return nil
+}
+
+// ZAddWithScore adds a value to a Redis sorted set
+func (rd *RedisAdapter) ZAddWithScore(ctx context.Context, key string, score float64, value string) error {
+	cmd := rd.queues.ZAdd(ctx, key, redis.Z{Score: score, Member: value})
+	if cmd.Err() != nil {
+		return fmt.Errorf("failed to add item to sorted set %s: %w", key, cmd.Err())
+	}
+	return nil
+}
+
+// ZRangeToScore returns all the values of a Redis sorted set
+// with score lower than or equal to maxScore
+func (rd *RedisAdapter) ZRangeToScore(ctx context.Context, key string, maxScore float64) ([]string, error) {
+	cmd := rd.queues.ZRangeByScore(
+		ctx, key, &redis.ZRangeBy{Min: "-inf", Max: strconv.FormatFloat(maxScore, 'f', -1, 64)})
+	if cmd.Err() != nil {
+		return []string{}, fmt.Errorf("failed to get items of sorted set %s: %w", key, cmd.Err())
+	}
+	return cmd.Val(), nil
+}
+
+// ZRem removes a value from a Redis sorted set
+func (rd *RedisAdapter) ZRem(ctx context.Context, key string, value string) error {
+	cmd := rd.queues.ZRem(ctx, key, value)
+	if cmd.Err() != nil {
+		return fmt.Errorf("failed to remove item from sorted set %s: %w", key, cmd.Err())
+	}
+	return nil
+}
+
+// LLen returns length of a Redis list. For nonexistent keys, 0 is returned.
+func (rd *RedisAdapter) LLen(ctx context.Context, key string) (int, error) {
+	cmd := rd.queues.LLen(ctx, key)
+	if cmd.Err() != nil {
+		return 0, fmt.Errorf("failed to get length of list %s: %w", key, cmd.Err())
+	}
+	return int(cmd.Val()), nil
+}
+
+// RPushCapped appends a value to the end of a Redis list and removes
+// the oldest items so the list contains at most maxLen items.
+func (rd *RedisAdapter) RPushCapped(ctx context.Context, key string, value string, maxLen int) error {
+	ppl := rd.redis.TxPipeline()
+	ppl.RPush(ctx, key, value)
+	ppl.LTrim(ctx, key, int64(-maxLen), -1)
+	if _, err := ppl.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to push to list %s: %w", key, err)
+	}
+	return nil
+}
+
+// LastN returns up to n last items of a Redis list (in the list order)
+func (rd *RedisAdapter) LastN(ctx context.Context, key string, n int) ([]string, error) {
+	cmd := rd.redis.LRange(ctx, key, int64(-n), -1)
+	if cmd.Err() != nil {
+		return []string{}, fmt.Errorf("failed to get items of list %s: %w", key, cmd.Err())
+	}
+	return cmd.Val(), nil
+}
+
+func (rd *RedisAdapter) NextNArchItems(ctx context.Context, queueKey string, n int64) ([]queueRecord, error) {
 	ans := make([]queueRecord, 0, n)
-	ppl := rd.redis.Pipeline()
-	lrangeCmd := ppl.LRange(rd.ctx, queueKey, -n, -1)
-	ppl.LTrim(rd.ctx, queueKey, 0, -n-1)
-	_, err := ppl.Exec(rd.ctx)
+	ppl := rd.queues.Pipeline()
+	lrangeCmd := ppl.LRange(ctx, queueKey, -n, -1)
+	ppl.LTrim(ctx, queueKey, 0, -n-1)
+	_, err := ppl.Exec(ctx)
 	if err != nil {
 		return []queueRecord{}, fmt.Errorf("failed to get items from queue: %w", err)
 	}
@@ -192,32 +318,26 @@ func (rd *RedisAdapter) NextNArchItems(queueKey string, n int64) ([]queueRecord,
 		return []queueRecord{}, fmt.Errorf("failed to get items from queue: %w", err)
 	}
 	for i := len(items) - 1; i >= 0; i-- {
-		if strings.Contains(items[i], `"key"`) {
-			var v queueRecord
-			err := json.Unmarshal([]byte(items[i]), &v)
-			if err != nil {
-				return []queueRecord{}, fmt.Errorf("failed to decode queue item `%s`: %w", items[i], err)
-			}
-			ans = append(ans, v)
-
-		} else {
-			ans = append(ans, queueRecord{Key: items[i]})
+		v, err := decodeQueueRecord(items[i])
+		if err != nil {
+			return []queueRecord{}, err
 		}
+		ans = append(ans, v)
 	}
 	return ans, nil
 }
 
-func (rd *RedisAdapter) AddError(errQueue string, item queueRecord, rec *cncdb.ArchRecord) error {
+func (rd *RedisAdapter) AddError(ctx context.Context, errQueue string, item queueRecord, rec *cncdb.ArchRecord) error {
 	itemJSON, err := json.Marshal(item)
 	if err != nil {
 		return fmt.Errorf("failed to add error record %s: %w", item.Key, err)
 	}
-	cmd := rd.redis.LPush(rd.ctx, errQueue, string(itemJSON))
+	cmd := rd.queues.LPush(ctx, errQueue, string(itemJSON))
 	if cmd.Err() != nil {
 		return fmt.Errorf("failed to insert error key %s: %w", item.Key, cmd.Err())
 	}
 	if rec != nil {
-		cmd = rd.redis.HSet(rd.ctx, errQueue, item.Key, rec.Data)
+		cmd = rd.queues.HSet(ctx, errQueue, item.Key, rec.Data)
 		if cmd.Err() != nil {
 			return fmt.Errorf("failed to insert error record %s: %w", item.Key, cmd.Err())
 		}
@@ -232,8 +352,8 @@ func (rd *RedisAdapter) mkKey(id string) string {
 // GetConcRecord returns a concordance/wlist/pquery/kwords records
 // with a specified ID. In case no such record is found, ErrRecordNotFound
 // is returned.
-func (rd *RedisAdapter) GetConcRecord(id string) (cncdb.ArchRecord, error) {
-	ans := rd.redis.Get(rd.ctx, rd.mkKey(id))
+func (rd *RedisAdapter) GetConcRecord(ctx context.Context, id string) (cncdb.ArchRecord, error) {
+	ans := rd.records.Get(ctx, rd.mkKey(id))
 	if ans.Err() == redis.Nil {
 		return cncdb.ArchRecord{}, cncdb.ErrRecordNotFound
 	}
@@ -246,15 +366,54 @@ func (rd *RedisAdapter) GetConcRecord(id string) (cncdb.ArchRecord, error) {
 	}, nil
 }
 
-func NewRedisAdapter(ctx context.Context, conf *RedisConf) *RedisAdapter {
+// ScanConcRecordIDs returns a chunk of IDs of concordance records stored
+// in Redis along with a cursor for the next call. The scan starts with
+// cursor 0 and it is finished once the returned cursor is 0 again.
+func (rd *RedisAdapter) ScanConcRecordIDs(ctx context.Context, cursor uint64, count int64) ([]string, uint64, error) {
+	keys, next, err := rd.records.Scan(ctx, cursor, rd.mkKey("*"), count).Result()
+	if err != nil {
+		return []string{}, 0, fmt.Errorf("failed to scan concordance records: %w", err)
+	}
+	ans := make([]string, len(keys))
+	for i, k := range keys {
+		ans[i] = strings.TrimPrefix(k, rd.mkKey(""))
+	}
+	return ans, next, nil
+}
+
+// UpdateConcRecord replaces data of a concordance record stored in Redis
+// while keeping its expiration time.
+func (rd *RedisAdapter) UpdateConcRecord(ctx context.Context, id, data string) error {
+	err := rd.records.SetArgs(ctx, rd.mkKey(id), data, redis.SetArgs{KeepTTL: true}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update concordance record %s: %w", id, err)
+	}
+	return nil
+}
+
+func newRedisClient(conf RedisConnConf) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", conf.Host, conf.Port),
+		Password: conf.Password,
+		DB:       conf.DB,
+	})
+}
+
+func NewRedisAdapter(conf *RedisConf) *RedisAdapter {
 	ans := &RedisAdapter{
-		conf: conf,
-		redis: redis.NewClient(&redis.Options{
-			Addr:     fmt.Sprintf("%s:%d", conf.Host, conf.Port),
-			Password: conf.Password,
-			DB:       conf.DB,
-		}),
-		ctx: ctx,
+		conf:  conf,
+		redis: newRedisClient(conf.RedisConnConf),
+	}
+	ans.records = ans.redis
+	if conf.RecordsConn() != conf.RedisConnConf {
+		ans.records = newRedisClient(conf.RecordsConn())
+	}
+	ans.queues = ans.redis
+	if conf.QueuesConn() != conf.RedisConnConf {
+		ans.queues = newRedisClient(conf.QueuesConn())
+	}
+	if conf.QueuesConn() == conf.RecordsConn() {
+		ans.queues = ans.records
 	}
 	return ans
 }
@@ -0,0 +1,53 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisConnectionsRouting(t *testing.T) {
+	conf := &RedisConf{
+		RedisConnConf: RedisConnConf{Host: "localhost", Port: 6379, DB: 1, Password: "secret"},
+		Records:       &RedisConnConf{Host: "kontext-redis", DB: 2},
+	}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(
+		t,
+		RedisConnConf{Host: "kontext-redis", Port: 6379, DB: 2, Password: "secret"},
+		conf.RecordsConn(),
+	)
+	assert.Equal(t, conf.RedisConnConf, conf.QueuesConn())
+
+	rd := NewRedisAdapter(conf)
+	assert.NotSame(t, rd.redis, rd.records)
+	assert.Same(t, rd.redis, rd.queues)
+
+	conf.Queues = &RedisConnConf{}
+	assert.Error(t, conf.ValidateAndDefaults())
+
+	// a separate instance typically uses DB 0
+	conf.Queues = &RedisConnConf{Host: "kontext-redis"}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(
+		t,
+		RedisConnConf{Host: "kontext-redis", Port: 6379, DB: 0, Password: "secret"},
+		conf.QueuesConn(),
+	)
+}
@@ -20,16 +20,82 @@ import (
 	"fmt"
 )
 
-type RedisConf struct {
+// RedisConnConf specifies a connection to a Redis database
+type RedisConnConf struct {
 	Host     string `json:"host"`
 	Port     int    `json:"port"`
 	DB       int    `json:"db"`
 	Password string `json:"password"`
 }
 
+func (conf RedisConnConf) String() string {
+	return fmt.Sprintf("%s:%d, db %d", conf.Host, conf.Port, conf.DB)
+}
+
+// RedisConf configures Redis connections. The main connection
+// is used for Camus internal state (processing status, caches, reports).
+// In case KonText keeps concordance records and/or the archive queue
+// in a different Redis instance (or database), it can be configured
+// via `records` and `queues`. Missing host, port and password of these
+// connections are inherited from the main connection. A missing `db` means
+// DB 0 which is accepted only for a different Redis instance (as the main
+// connection never uses DB 0).
+type RedisConf struct {
+	RedisConnConf
+
+	// Records specifies a connection used for reading (and rewriting)
+	// KonText's `concordance:*` records
+	Records *RedisConnConf `json:"records"`
+
+	// Queues specifies a connection used for the archive queue,
+	// the failed items queue and the webhook outbox
+	Queues *RedisConnConf `json:"queues"`
+}
+
+// RecordsConn returns a connection configuration for concordance records
+func (conf *RedisConf) RecordsConn() RedisConnConf {
+	if conf.Records == nil {
+		return conf.RedisConnConf
+	}
+	return *conf.Records
+}
+
+// QueuesConn returns a connection configuration for queues
+func (conf *RedisConf) QueuesConn() RedisConnConf {
+	if conf.Queues == nil {
+		return conf.RedisConnConf
+	}
+	return *conf.Queues
+}
+
+func (conf *RedisConf) inheritConn(sub *RedisConnConf, name string) error {
+	if sub == nil {
+		return nil
+	}
+	if sub.Host == "" {
+		sub.Host = conf.Host
+	}
+	if sub.Port == 0 {
+		sub.Port = conf.Port
+	}
+	if sub.Password == "" {
+		sub.Password = conf.Password
+	}
+	if sub.DB == 0 && sub.Host == conf.Host && sub.Port == conf.Port {
+		return fmt.Errorf("missing Redis configuration: `%s.db`", name)
+	}
+	return nil
+}
+
 func (conf *RedisConf) ValidateAndDefaults() error {
 	if conf.DB == 0 {
 		return fmt.Errorf("missing Redis configuration: `db`")
 	}
+	if err := conf.inheritConn(conf.Records, "records"); err != nil {
+		return err
+	}
+	if err := conf.inheritConn(conf.Queues, "queues"); err != nil {
+		return err
+	}
 	return nil
 }
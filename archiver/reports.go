@@ -0,0 +1,75 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// cappedList is a list keeping only a limited number of latest items
+// (see RedisAdapter.RPushCapped)
+type cappedList interface {
+	RPushCapped(ctx context.Context, key string, value string, maxLen int) error
+	LastN(ctx context.Context, key string, n int) ([]string, error)
+}
+
+// ReportList stores JSON-encoded reports of periodic jobs in a Redis list
+// while keeping only a configured number of the latest ones.
+type ReportList[T any] struct {
+	list     cappedList
+	key      string
+	maxItems int
+}
+
+// Store appends a report to the list
+func (rl *ReportList[T]) Store(ctx context.Context, report T) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to store report to %s: %w", rl.key, err)
+	}
+	if err := rl.list.RPushCapped(ctx, rl.key, string(data), rl.maxItems); err != nil {
+		return fmt.Errorf("failed to store report to %s: %w", rl.key, err)
+	}
+	return nil
+}
+
+// Latest returns up to `num` latest reports, the newest first
+func (rl *ReportList[T]) Latest(ctx context.Context, num int) ([]T, error) {
+	items, err := rl.list.LastN(ctx, rl.key, num)
+	if err != nil {
+		return []T{}, fmt.Errorf("failed to load reports from %s: %w", rl.key, err)
+	}
+	ans := make([]T, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		var report T
+		if err := json.Unmarshal([]byte(items[i]), &report); err != nil {
+			return []T{}, fmt.Errorf("failed to load reports from %s: %w", rl.key, err)
+		}
+		ans = append(ans, report)
+	}
+	return ans, nil
+}
+
+func NewReportList[T any](rdb *RedisAdapter, key string, maxItems int) *ReportList[T] {
+	return &ReportList[T]{
+		list:     rdb,
+		key:      key,
+		maxItems: maxItems,
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memCappedList struct {
+	items []string
+}
+
+func (l *memCappedList) RPushCapped(ctx context.Context, key string, value string, maxLen int) error {
+	l.items = append(l.items, value)
+	if len(l.items) > maxLen {
+		l.items = l.items[len(l.items)-maxLen:]
+	}
+	return nil
+}
+
+func (l *memCappedList) LastN(ctx context.Context, key string, n int) ([]string, error) {
+	return l.items[max(0, len(l.items)-n):], nil
+}
+
+func TestReportList(t *testing.T) {
+	type report struct {
+		Num int `json:"num"`
+	}
+	rl := &ReportList[report]{list: &memCappedList{}, key: "reports", maxItems: 3}
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, rl.Store(context.Background(), report{Num: i}))
+	}
+	latest, err := rl.Latest(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []report{{Num: 5}, {Num: 4}}, latest)
+
+	latest, err = rl.Latest(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []report{{Num: 5}, {Num: 4}, {Num: 3}}, latest)
+}
@@ -18,6 +18,7 @@ package archiver
 
 import (
 	"camus/cncdb"
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -37,18 +38,18 @@ type YearsStats struct {
 	LastUpdate time.Time      `json:"lastUpdate"`
 }
 
-func (job *ArchKeeper) YearsStats(forceReload bool) (YearsStats, error) {
+func (job *ArchKeeper) YearsStats(ctx context.Context, forceReload bool) (YearsStats, error) {
 	var cached string
 	var err error
 	var ans YearsStats
 	if !forceReload {
-		cached, err = job.redis.Get(yearStatsCacheKey)
+		cached, err = job.redis.Get(ctx, yearStatsCacheKey)
 		if err != nil {
 			return ans, fmt.Errorf("failed to get cached years stats: %w", err)
 		}
 	}
 	if cached == "" {
-		data, err := job.dbArch.GetArchSizesByYears(forceReload)
+		data, err := job.dbArch.GetArchSizesByYears(ctx, forceReload)
 		if err == cncdb.ErrTooDemandingQuery {
 			return ans, nil
 
@@ -64,7 +65,7 @@ func (job *ArchKeeper) YearsStats(forceReload bool) (YearsStats, error) {
 		if err != nil {
 			return ans, fmt.Errorf("failed to marshal recent years stats data: %w", err)
 		}
-		if err := job.redis.Set(yearStatsCacheKey, jsonData); err != nil {
+		if err := job.redis.Set(ctx, yearStatsCacheKey, jsonData); err != nil {
 			return ans, fmt.Errorf("failed to store recent years stats to cache: %w", err)
 		}
 
@@ -75,3 +76,14 @@ func (job *ArchKeeper) YearsStats(forceReload bool) (YearsStats, error) {
 	}
 	return ans, nil
 }
+
+// LargestRecords returns the largest archived records created since fromDate.
+// Without forceReload, the underlying query is performed only at night.
+func (job *ArchKeeper) LargestRecords(
+	ctx context.Context, fromDate time.Time, limit int, forceReload bool) ([]cncdb.RecordSize, error) {
+	ans, err := job.dbArch.GetLargestRecords(ctx, fromDate, limit, forceReload)
+	if err != nil {
+		return ans, fmt.Errorf("failed to load largest records: %w", err)
+	}
+	return ans, nil
+}
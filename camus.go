@@ -23,8 +23,14 @@ import (
 	"camus/cnf"
 	"camus/history"
 	"camus/indexer"
+	"camus/integrity"
+	"camus/migration"
+	"camus/quality"
 	"camus/reporting"
+	"camus/webhook"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -58,28 +64,125 @@ type service interface {
 
 func createArchiver(
 	db cncdb.IConcArchOps,
+	qHistDB cncdb.IQHistArchOps,
 	rdb *archiver.RedisAdapter,
 	recsToIndex chan<- cncdb.HistoryRecord,
 	reporting reporting.IReporting,
+	notifier webhook.INotifier,
 	conf *cnf.Conf,
 ) *archiver.ArchKeeper {
-	dedup, err := archiver.NewDeduplicator(db, conf.Archiver, conf.TimezoneLocation())
+	dedup, err := archiver.NewDeduplicator(db, qHistDB, conf.Archiver, conf.TimezoneLocation())
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to initialize deduplicator")
 		os.Exit(1)
 		return nil
 	}
+	var queue archiver.IQueue
+	switch conf.Archiver.QueueBackend {
+	case archiver.QueueBackendNATS:
+		queue, err = archiver.NewJetStreamQueue(context.Background(), conf.Archiver.NATS)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to initialize NATS queue")
+			os.Exit(1)
+			return nil
+		}
+	default:
+		queue = archiver.NewRedisQueue(rdb, conf.Archiver.QueueKey)
+	}
 	return archiver.NewArchKeeper(
 		rdb,
+		queue,
 		db,
 		dedup,
 		recsToIndex,
 		reporting,
+		notifier,
 		conf.TimezoneLocation(),
 		conf.Archiver,
 	)
 }
 
+// configureConcArchOps enables all the configured data handling features
+func configureConcArchOps(concArchOps *cncdb.MySQLConcArch, conf *cnf.Conf) {
+	if conf.Archiver.OversizedPolicy == archiver.OversizedPolicyCompress {
+		concArchOps.EnableCompression(conf.Archiver.MaxRecordSize)
+	}
+	if conf.MySQL.ContentAddressedData {
+		concArchOps.EnableContentAddressing()
+	}
+	if conf.MySQL.IngestionTimeColumn {
+		concArchOps.EnableIngestionTime()
+	}
+	if conf.MySQL.ChecksumColumn {
+		concArchOps.EnableChecksums()
+	}
+	if conf.MySQL.RetiredColumn {
+		concArchOps.EnableRetiredColumn()
+	}
+	concArchOps.SetMergePolicy(conf.MySQL.MergePolicy)
+	if conf.MySQL.Encryption != nil {
+		if err := concArchOps.EnableEncryption(conf.MySQL.Encryption); err != nil {
+			log.Error().Err(err).Msg("Failed to initialize data encryption")
+			os.Exit(1)
+		}
+	}
+}
+
+// createMySQLOps creates archive and query history operations
+// with all the configured data handling features enabled
+func createMySQLOps(db *sql.DB, conf *cnf.Conf) (*cncdb.MySQLConcArch, *cncdb.MySQLQueryHist) {
+	concArchOps, qHistOps := cncdb.NewMySQLOps(db, conf.TimezoneLocation())
+	configureConcArchOps(concArchOps, conf)
+	if conf.MySQL.Replica != nil {
+		replicaDB, err := cncdb.DBOpen(conf.MySQL.Replica.DB)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open replica SQL database")
+			os.Exit(1)
+			return nil, nil
+		}
+		concArchOps.SetReplica(replicaDB, conf.MySQL.Replica)
+	}
+	if conf.MySQL.WriteBudget != nil {
+		writeBudget := cncdb.NewWriteBudget(conf.MySQL.WriteBudget, conf.TimezoneLocation())
+		concArchOps.SetWriteBudget(writeBudget)
+		qHistOps.SetWriteBudget(writeBudget)
+	}
+	return concArchOps, qHistOps
+}
+
+// createLiveMySQLOps creates database operations for actions modifying
+// the archive - i.e. with writes mirrored to the secondary database
+// in case the mirroring is configured.
+func createLiveMySQLOps(db *sql.DB, conf *cnf.Conf) (cncdb.IConcArchOps, cncdb.IQHistArchOps) {
+	concArchOps, qHistOps := createMySQLOps(db, conf)
+	if conf.MySQL.Mirror != nil {
+		return createMirroredOps(conf, concArchOps, qHistOps)
+	}
+	return concArchOps, qHistOps
+}
+
+// createMirroredOps wraps primary database operations so all the writes
+// are mirrored to the configured secondary database
+func createMirroredOps(
+	conf *cnf.Conf,
+	concArchOps cncdb.IConcArchOps,
+	qHistOps cncdb.IQHistArchOps,
+) (*cncdb.MirroredConcArch, *cncdb.MirroredQueryHist) {
+	db, err := cncdb.DBOpen(conf.MySQL.Mirror.DB)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to open secondary SQL database")
+		os.Exit(1)
+		return nil, nil
+	}
+	log.Info().
+		Bool("shadowReads", conf.MySQL.Mirror.ShadowReads).
+		Msgf("mirroring writes to database %s@%s", conf.MySQL.Mirror.DB.Name, conf.MySQL.Mirror.DB.Host)
+	secConcArchOps, secQHistOps := cncdb.NewMySQLOps(db, conf.TimezoneLocation())
+	configureConcArchOps(secConcArchOps, conf)
+	return cncdb.NewMirroredOps(
+		concArchOps, qHistOps, secConcArchOps, secQHistOps, conf.MySQL.Mirror.ShadowReads)
+}
+
 func cleanVersionInfo(v string) string {
 	return strings.TrimLeft(strings.Trim(v, "'"), "v")
 }
@@ -95,6 +198,12 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage:\n\t%s [options] start [config.json]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\t%s [options] init-query-history [config.json]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\t%s [options] gc-query-history [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] migrate-data-blobs [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] retire-corpus [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] rename-corpus [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] migrate-attrs [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] rotate-data-keys [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] mirror-backfill [config.json]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\t%s [options] version\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
@@ -117,6 +226,40 @@ func main() {
 	initChunkSize2 := gcQueryHistoryCmd.Int("chunk-size", 100, "How many items to process per run (can be run mulitple times while preserving proc. state)")
 	logToConsole2 := gcQueryHistoryCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
 
+	migrateBlobsCmd := flag.NewFlagSet("migrate-data-blobs", flag.ExitOnError)
+	migrateChunkSize := migrateBlobsCmd.Int("chunk-size", 500, "How many records to migrate per transaction (can be run multiple times while preserving proc. state)")
+	removeOrphanBlobs := migrateBlobsCmd.Bool("remove-orphans", false, "Instead of migrating records, remove blobs not referenced by any record")
+	logToConsole3 := migrateBlobsCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+
+	retireCorpusCmd := flag.NewFlagSet("retire-corpus", flag.ExitOnError)
+	retiredCorpus := retireCorpusCmd.String("corpus", "", "ID of the withdrawn corpus")
+	retireChunkSize := retireCorpusCmd.Int("chunk-size", 1000, "How many archive records to load at once")
+	logToConsole4 := retireCorpusCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+
+	renameCorpusCmd := flag.NewFlagSet("rename-corpus", flag.ExitOnError)
+	renameFrom := renameCorpusCmd.String("from", "", "Original corpus ID")
+	renameTo := renameCorpusCmd.String("to", "", "New corpus ID")
+	renameAuditFile := renameCorpusCmd.String("audit-file", "", "A file to write changed records to (JSON Lines, appended)")
+	renameChunkSize := renameCorpusCmd.Int("chunk-size", 1000, "How many archive records to load at once")
+	renameDryRun := renameCorpusCmd.Bool("dry-run", false, "If set, then no changes are written (except for the audit file)")
+	logToConsole5 := renameCorpusCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+
+	migrateAttrsCmd := flag.NewFlagSet("migrate-attrs", flag.ExitOnError)
+	attrRulesFile := migrateAttrsCmd.String("rules", "", "A JSON file with attribute renames and value mappings")
+	attrsAuditFile := migrateAttrsCmd.String("audit-file", "", "A file to write changed records to (JSON Lines, appended)")
+	attrsChunkSize := migrateAttrsCmd.Int("chunk-size", 1000, "How many archive records to load at once")
+	attrsDryRun := migrateAttrsCmd.Bool("dry-run", false, "If set, then no changes are written (except for the audit file)")
+	logToConsole6 := migrateAttrsCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+
+	rotateKeysCmd := flag.NewFlagSet("rotate-data-keys", flag.ExitOnError)
+	rotateChunkSize := rotateKeysCmd.Int("chunk-size", 500, "How many records to re-encrypt per chunk (can be run multiple times while preserving proc. state)")
+	logToConsole7 := rotateKeysCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+
+	mirrorBackfillCmd := flag.NewFlagSet("mirror-backfill", flag.ExitOnError)
+	backfillFrom := mirrorBackfillCmd.String("from", "", "Process records created since the date (YYYY-MM-DD); if omitted, the whole archive is processed")
+	backfillChunkSize := mirrorBackfillCmd.Int("chunk-size", 500, "How many records to load at once")
+	logToConsole8 := mirrorBackfillCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+
 	versionCmd := flag.NewFlagSet("version", flag.ExitOnError)
 	versionCmd.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Camus - get version information\n\n")
@@ -156,6 +299,74 @@ func main() {
 		}
 		logging.SetupLogging(conf.Logging)
 		cnf.ValidateAndDefaults(conf)
+	case "migrate-data-blobs":
+		migrateBlobsCmd.Parse(os.Args[2:])
+		conf = cnf.LoadConfig(migrateBlobsCmd.Arg(0))
+		if *logToConsole3 {
+			conf.Logging.Path = ""
+		}
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
+	case "retire-corpus":
+		retireCorpusCmd.Parse(os.Args[2:])
+		if *retiredCorpus == "" {
+			fmt.Fprintf(os.Stderr, "missing -corpus argument\n")
+			os.Exit(1)
+		}
+		conf = cnf.LoadConfig(retireCorpusCmd.Arg(0))
+		if *logToConsole4 {
+			conf.Logging.Path = ""
+		}
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
+	case "rename-corpus":
+		renameCorpusCmd.Parse(os.Args[2:])
+		if *renameFrom == "" || *renameTo == "" || *renameAuditFile == "" {
+			fmt.Fprintf(os.Stderr, "arguments -from, -to and -audit-file are required\n")
+			os.Exit(1)
+		}
+		conf = cnf.LoadConfig(renameCorpusCmd.Arg(0))
+		if *logToConsole5 {
+			conf.Logging.Path = ""
+		}
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
+	case "migrate-attrs":
+		migrateAttrsCmd.Parse(os.Args[2:])
+		if *attrRulesFile == "" || *attrsAuditFile == "" {
+			fmt.Fprintf(os.Stderr, "arguments -rules and -audit-file are required\n")
+			os.Exit(1)
+		}
+		conf = cnf.LoadConfig(migrateAttrsCmd.Arg(0))
+		if *logToConsole6 {
+			conf.Logging.Path = ""
+		}
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
+	case "rotate-data-keys":
+		rotateKeysCmd.Parse(os.Args[2:])
+		conf = cnf.LoadConfig(rotateKeysCmd.Arg(0))
+		if *logToConsole7 {
+			conf.Logging.Path = ""
+		}
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
+		if conf.MySQL.Encryption == nil {
+			fmt.Fprintf(os.Stderr, "data encryption is not configured\n")
+			os.Exit(1)
+		}
+	case "mirror-backfill":
+		mirrorBackfillCmd.Parse(os.Args[2:])
+		conf = cnf.LoadConfig(mirrorBackfillCmd.Arg(0))
+		if *logToConsole8 {
+			conf.Logging.Path = ""
+		}
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
+		if conf.MySQL.Mirror == nil {
+			fmt.Fprintf(os.Stderr, "database mirroring is not configured\n")
+			os.Exit(1)
+		}
 	default:
 		flag.Usage()
 		fmt.Fprintf(
@@ -177,7 +388,7 @@ func main() {
 		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 		defer stop()
 
-		rdb := archiver.NewRedisAdapter(ctx, conf.Redis)
+		rdb := archiver.NewRedisAdapter(conf.Redis)
 
 		var reportingService reporting.IReporting
 		if conf.Reporting.Host != "" {
@@ -196,18 +407,40 @@ func main() {
 			reportingService = &reporting.DummyWriter{}
 		}
 
+		var notifier webhook.INotifier
+		if conf.Webhooks != nil && len(conf.Webhooks.Endpoints) > 0 {
+			notifier = webhook.NewDispatcher(conf.Webhooks, rdb, conf.TimezoneLocation())
+
+		} else {
+			notifier = &webhook.DummyNotifier{}
+		}
+		// in dry-run modes, the respective writes do not happen
+		// so there is nothing to notify about
+		archNotifier, cleanerNotifier := notifier, notifier
+		if *dryRun {
+			archNotifier = &webhook.DummyNotifier{}
+		}
+		if *dryRunCleaner {
+			cleanerNotifier = &webhook.DummyNotifier{}
+		}
+
 		// ---------- prepare db operations providers for services  ---------------------------
 
 		var dbArchOps cncdb.IConcArchOps
 		var dbQHistOps cncdb.IQHistArchOps
 
-		dbArchOpsRaw, dbQHistOpsRaw := cncdb.NewMySQLOps(ctx, db, conf.TimezoneLocation())
+		dbArchOpsRaw, dbQHistOpsRaw := createMySQLOps(db, conf)
+		var dbArchOpsLive cncdb.IConcArchOps = dbArchOpsRaw
+		var dbQHistOpsLive cncdb.IQHistArchOps = dbQHistOpsRaw
+		if conf.MySQL.Mirror != nil {
+			dbArchOpsLive, dbQHistOpsLive = createMirroredOps(conf, dbArchOpsRaw, dbQHistOpsRaw)
+		}
 		if *dryRun {
 			dbArchOps, dbQHistOps = cncdb.NewMySQLDryRun(dbArchOpsRaw, dbQHistOpsRaw)
 
 		} else {
-			dbArchOps = dbArchOpsRaw
-			dbQHistOps = dbQHistOpsRaw
+			dbArchOps = dbArchOpsLive
+			dbQHistOps = dbQHistOpsLive
 		}
 
 		// archive cleaner service:
@@ -217,7 +450,7 @@ func main() {
 			archCleanerDbOps, _ = cncdb.NewMySQLDryRun(dbArchOpsRaw, dbQHistOpsRaw)
 
 		} else {
-			archCleanerDbOps = dbArchOpsRaw
+			archCleanerDbOps = dbArchOpsLive
 		}
 
 		// -------
@@ -226,10 +459,10 @@ func main() {
 
 		// conc. archiver service:
 
-		arch := createArchiver(dbArchOps, rdb, recsToIndex, reportingService, conf)
+		arch := createArchiver(dbArchOps, dbQHistOps, rdb, recsToIndex, reportingService, archNotifier, conf)
 
 		cln := cleaner.NewService(
-			archCleanerDbOps, rdb, reportingService, conf.Cleaner, conf.TimezoneLocation())
+			archCleanerDbOps, rdb, reportingService, cleanerNotifier, conf.Cleaner, conf.TimezoneLocation())
 
 		// query history fulltext service:
 
@@ -242,11 +475,25 @@ func main() {
 
 		fulltext := indexer.NewService(conf.Indexer, ftIndexer, rdb)
 
-		as := &apiServer{
-			arch:            arch,
-			conf:            conf,
-			fulltextService: fulltext,
-			rdb:             rdb,
+		// archive data quality reports (optional)
+
+		var qualityService *quality.Service
+		if conf.DataQuality != nil {
+			qualityService = quality.NewService(
+				conf.DataQuality, archCleanerDbOps, rdb, reportingService, conf.TimezoneLocation())
+		}
+
+		// archive records integrity verification (optional)
+
+		var integrityService *integrity.Service
+		if conf.Integrity != nil {
+			integrityConf := *conf.Integrity
+			if *dryRun && integrityConf.FillMissing {
+				log.Warn().Msg("dry run mode - integrity verification will not fill in missing checksums")
+				integrityConf.FillMissing = false
+			}
+			integrityService = integrity.NewService(
+				&integrityConf, dbArchOpsRaw, rdb, conf.TimezoneLocation())
 		}
 
 		// query history garbage collector service
@@ -259,9 +506,27 @@ func main() {
 			conf.Indexer,
 		)
 
+		as := &apiServer{
+			arch:             arch,
+			conf:             conf,
+			fulltextService:  fulltext,
+			rdb:              rdb,
+			qualityService:   qualityService,
+			integrityService: integrityService,
+			historyGC:        qHistGC,
+		}
+
 		// -------
 
-		services := []service{ftIndexer, arch, cln, fulltext, as, reportingService, qHistGC}
+		services := []service{ftIndexer, arch, cln, fulltext}
+		if qualityService != nil {
+			// must be started before the API server (see quality.Service.RunCheckInBackground)
+			services = append(services, qualityService)
+		}
+		if integrityService != nil {
+			services = append(services, integrityService)
+		}
+		services = append(services, as, reportingService, notifier, qHistGC)
 		for _, m := range services {
 			m.Start(ctx)
 		}
@@ -304,11 +569,11 @@ func main() {
 			return
 		}
 		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
-		dbConcArchOps, dbQHistOps := cncdb.NewMySQLOps(ctx, db, conf.TimezoneLocation())
+		dbConcArchOps, dbQHistOps := createMySQLOps(db, conf)
 		exec := history.NewDataInitializer(
 			dbConcArchOps,
 			dbQHistOps,
-			archiver.NewRedisAdapter(ctx, conf.Redis),
+			archiver.NewRedisAdapter(conf.Redis),
 		)
 		exec.Run(ctx, conf, *initChunkSize)
 	case "gc-query-history": // aka garbage-collect-query-history
@@ -322,8 +587,8 @@ func main() {
 		}
 		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
 
-		rdb := archiver.NewRedisAdapter(ctx, conf.Redis)
-		dbConcArchOps, dbQHistOps := cncdb.NewMySQLOps(ctx, db, conf.TimezoneLocation())
+		rdb := archiver.NewRedisAdapter(conf.Redis)
+		dbConcArchOps, dbQHistOps := createLiveMySQLOps(db, conf)
 
 		recsToIndex := make(chan cncdb.HistoryRecord)
 		ftIndexer, err := indexer.NewIndexer(conf.Indexer, dbConcArchOps, dbQHistOps, rdb, recsToIndex)
@@ -343,6 +608,231 @@ func main() {
 		exec.RunAdHoc(ctx, dbConcArchOps, conf, *initChunkSize2)
 		close(recsToIndex)
 
+	case "migrate-data-blobs":
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		db, err := cncdb.DBOpen(conf.MySQL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open SQL database")
+			os.Exit(1)
+			return
+		}
+		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
+		dbConcArchOps, _ := createMySQLOps(db, conf)
+		if *removeOrphanBlobs {
+			numRemoved, err := dbConcArchOps.RemoveOrphanBlobs(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to remove orphan blobs")
+				os.Exit(1)
+				return
+			}
+			log.Info().Int64("numRemoved", numRemoved).Msg("removed orphan blobs")
+			return
+		}
+		if err := dbConcArchOps.RunBlobMigration(ctx, *migrateChunkSize); err != nil {
+			log.Error().Err(err).Msg("Failed to migrate records to data blobs")
+			os.Exit(1)
+			return
+		}
+
+	case "retire-corpus":
+		if !conf.MySQL.RetiredColumn {
+			log.Error().Msg("Corpus retirement requires the mysql.retiredColumn to be enabled")
+			os.Exit(1)
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		db, err := cncdb.DBOpen(conf.MySQL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open SQL database")
+			os.Exit(1)
+			return
+		}
+		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
+
+		rdb := archiver.NewRedisAdapter(conf.Redis)
+		dbConcArchOps, dbQHistOps := createLiveMySQLOps(db, conf)
+
+		recsToIndex := make(chan cncdb.HistoryRecord)
+		ftIndexer, err := indexer.NewIndexerOrDie(conf.Indexer, dbConcArchOps, dbQHistOps, rdb, recsToIndex)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to initialize index")
+			os.Exit(1)
+			return
+		}
+		stats, err := history.NewCorpusRetirement(dbConcArchOps, ftIndexer).Run(ctx, *retiredCorpus, *retireChunkSize)
+		close(recsToIndex)
+		if err != nil {
+			log.Error().Err(err).Any("stats", stats).Msg("Failed to retire corpus")
+			os.Exit(1)
+			return
+		}
+		log.Info().Any("stats", stats).Msg("corpus retired")
+		out, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(out))
+
+	case "rename-corpus":
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		db, err := cncdb.DBOpen(conf.MySQL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open SQL database")
+			os.Exit(1)
+			return
+		}
+		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
+
+		rdb := archiver.NewRedisAdapter(conf.Redis)
+		var dbConcArchOps cncdb.IConcArchOps
+		var dbQHistOps cncdb.IQHistArchOps
+		if *renameDryRun {
+			dbArchOpsRaw, dbQHistOpsRaw := createMySQLOps(db, conf)
+			dbConcArchOps, _ = cncdb.NewMySQLDryRun(dbArchOpsRaw, dbQHistOpsRaw)
+			dbQHistOps = dbQHistOpsRaw
+
+		} else {
+			dbConcArchOps, dbQHistOps = createLiveMySQLOps(db, conf)
+		}
+
+		recsToIndex := make(chan cncdb.HistoryRecord)
+		ftIndexer, err := indexer.NewIndexerOrDie(conf.Indexer, dbConcArchOps, dbQHistOps, rdb, recsToIndex)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to initialize index")
+			os.Exit(1)
+			return
+		}
+		auditLog, err := migration.OpenAuditLog(*renameAuditFile)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open audit log")
+			os.Exit(1)
+			return
+		}
+		defer auditLog.Close()
+		job := migration.NewCorpusRename(
+			dbConcArchOps, rdb, ftIndexer, auditLog, *renameDryRun, conf.TimezoneLocation())
+		stats, err := job.Run(ctx, *renameFrom, *renameTo, *renameChunkSize)
+		close(recsToIndex)
+		if err != nil {
+			log.Error().Err(err).Any("stats", stats).Msg("Failed to rename corpus")
+			os.Exit(1)
+			return
+		}
+		log.Info().Any("stats", stats).Msg("corpus renamed")
+		out, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(out))
+
+	case "migrate-attrs":
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		rules, err := migration.LoadAttrMigrationRules(*attrRulesFile)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to load attribute migration rules")
+			os.Exit(1)
+			return
+		}
+		db, err := cncdb.DBOpen(conf.MySQL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open SQL database")
+			os.Exit(1)
+			return
+		}
+		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
+
+		rdb := archiver.NewRedisAdapter(conf.Redis)
+		var dbConcArchOps cncdb.IConcArchOps
+		var dbQHistOps cncdb.IQHistArchOps
+		if *attrsDryRun {
+			dbArchOpsRaw, dbQHistOpsRaw := createMySQLOps(db, conf)
+			dbConcArchOps, _ = cncdb.NewMySQLDryRun(dbArchOpsRaw, dbQHistOpsRaw)
+			dbQHistOps = dbQHistOpsRaw
+
+		} else {
+			dbConcArchOps, dbQHistOps = createLiveMySQLOps(db, conf)
+		}
+
+		recsToIndex := make(chan cncdb.HistoryRecord)
+		ftIndexer, err := indexer.NewIndexerOrDie(conf.Indexer, dbConcArchOps, dbQHistOps, rdb, recsToIndex)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to initialize index")
+			os.Exit(1)
+			return
+		}
+		auditLog, err := migration.OpenAuditLog(*attrsAuditFile)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open audit log")
+			os.Exit(1)
+			return
+		}
+		defer auditLog.Close()
+		job := migration.NewAttrMigration(
+			dbConcArchOps, rdb, ftIndexer, auditLog, *attrsDryRun, conf.TimezoneLocation())
+		stats, err := job.Run(ctx, rules, *attrsChunkSize)
+		close(recsToIndex)
+		if err != nil {
+			log.Error().Err(err).Any("stats", stats).Msg("Failed to migrate attributes")
+			os.Exit(1)
+			return
+		}
+		log.Info().Any("stats", stats).Msg("attributes migrated")
+		out, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(out))
+
+	case "rotate-data-keys":
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		db, err := cncdb.DBOpen(conf.MySQL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open SQL database")
+			os.Exit(1)
+			return
+		}
+		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
+		dbConcArchOps, _ := createMySQLOps(db, conf)
+		if err := dbConcArchOps.RunKeyRotation(ctx, *rotateChunkSize); err != nil {
+			log.Error().Err(err).Msg("Failed to re-encrypt records")
+			os.Exit(1)
+			return
+		}
+
+	case "mirror-backfill":
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		var fromDate time.Time
+		if *backfillFrom != "" {
+			var err error
+			fromDate, err = time.ParseInLocation("2006-01-02", *backfillFrom, conf.TimezoneLocation())
+			if err != nil {
+				log.Error().Err(err).Msg("Invalid -from date")
+				os.Exit(1)
+				return
+			}
+		}
+		db, err := cncdb.DBOpen(conf.MySQL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open SQL database")
+			os.Exit(1)
+			return
+		}
+		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
+		dbConcArchOps, dbQHistOps := createMySQLOps(db, conf)
+		mirroredOps, mirroredQHistOps := createMirroredOps(conf, dbConcArchOps, dbQHistOps)
+		stats, err := mirroredOps.Backfill(ctx, fromDate, *backfillChunkSize)
+		if err != nil {
+			log.Error().Err(err).Any("stats", stats).Msg("Failed to backfill secondary database")
+			os.Exit(1)
+			return
+		}
+		qhStats, err := mirroredQHistOps.Backfill(ctx, fromDate, *backfillChunkSize)
+		if err != nil {
+			log.Error().Err(err).Any("stats", qhStats).Msg("Failed to backfill secondary query history")
+			os.Exit(1)
+			return
+		}
+		out, _ := json.MarshalIndent(
+			map[string]cncdb.MirrorBackfillStats{"archive": stats, "queryHistory": qhStats}, "", "  ")
+		fmt.Println(string(out))
+
 	default:
 		log.Fatal().Msgf("Unknown action %s", action)
 	}
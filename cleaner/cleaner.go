@@ -20,6 +20,7 @@ import (
 	"camus/archiver"
 	"camus/cncdb"
 	"camus/reporting"
+	"camus/webhook"
 	"context"
 	"fmt"
 	"time"
@@ -39,6 +40,7 @@ type Service struct {
 	tz             *time.Location
 	cleanupRunning bool
 	reporting      reporting.IReporting
+	notifier       webhook.INotifier
 }
 
 func (job *Service) Start(ctx context.Context) {
@@ -58,10 +60,12 @@ func (job *Service) Start(ctx context.Context) {
 					if cncdb.TimeIsAtNight(t) {
 						numProc = job.conf.NumProcessItemsPerTickNight
 					}
-					err := job.performCleanup(numProc)
+					tickCtx, cancel := context.WithTimeout(ctx, job.conf.CheckInterval())
+					err := job.performCleanup(tickCtx, numProc)
 					if err != nil {
 						log.Error().Err(err).Msg("failed to perform cleanup")
 					}
+					cancel()
 				}
 			}
 		}
@@ -73,14 +77,14 @@ func (job *Service) Stop(ctx context.Context) error {
 	return nil
 }
 
-func (job *Service) performCleanup(itemsToProc int) error {
+func (job *Service) performCleanup(ctx context.Context, itemsToProc int) error {
 	job.cleanupRunning = true
 	defer func() { job.cleanupRunning = false }()
 	t0 := time.Now()
 
 	birthLimit := time.Now().In(job.tz).Add(-job.conf.MinAgeUnvisited())
 	var stats reporting.CleanupStats
-	lastDateRaw, err := job.rdb.Get(job.conf.StatusKey)
+	lastDateRaw, err := job.rdb.Get(ctx, job.conf.StatusKey)
 	if err != nil {
 		return fmt.Errorf("failed to fetch last check date from Redis (key %s): %w", job.conf.StatusKey, err)
 	}
@@ -95,7 +99,7 @@ func (job *Service) performCleanup(itemsToProc int) error {
 		Time("lastCheck", lastDate).
 		Int("itemsToLoad", itemsToProc).
 		Msg("preparing for archive cleanup")
-	items, err := job.db.LoadRecordsFromDate(lastDate, itemsToProc)
+	items, err := job.db.LoadRecordsFromDate(ctx, lastDate, itemsToProc)
 	if err != nil {
 		return fmt.Errorf("failed to load requested items for cleanup from database: %w", err)
 	}
@@ -109,20 +113,20 @@ func (job *Service) performCleanup(itemsToProc int) error {
 			continue // already resolved duplicity
 		}
 		visitedIDs.Add(item.ID)
-		if item.Permanent == 1 {
-			continue
+		if item.Permanent > 0 {
+			continue // pinned records are never removed
 		}
 		stats.NumFetched++
-		variants, err := job.db.LoadRecordsByID(item.ID)
+		variants, err := job.db.LoadRecordsByID(ctx, item.ID)
 		if err != nil {
 			log.Warn().
 				Err(err).
-				Str("recordId", variants[0].ID).
+				Str("recordId", item.ID).
 				Msg("failed to load variants for, setting err flag and skipping")
-			if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
+			if err := job.db.UpdateRecordStatus(ctx, item.ID, -1); err != nil {
 				log.Error().
 					Err(err).
-					Str("recordId", variants[0].ID).
+					Str("recordId", item.ID).
 					Msg("failed to set error status")
 			}
 			stats.NumErrors++
@@ -135,7 +139,7 @@ func (job *Service) performCleanup(itemsToProc int) error {
 				Err(err).
 				Str("recordId", variants[0].ID).
 				Msg("archive record variants failed to validate, setting err flag and skipping")
-			if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
+			if err := job.db.UpdateRecordStatus(ctx, variants[0].ID, -1); err != nil {
 				log.Error().
 					Err(err).
 					Str("recordId", variants[0].ID).
@@ -146,13 +150,13 @@ func (job *Service) performCleanup(itemsToProc int) error {
 		}
 
 		if len(variants) > 1 {
-			mergedItem, err := job.db.DeduplicateInArchive(variants, variants[0])
+			merged, err := job.db.DeduplicateInArchive(ctx, variants, variants[0])
 			if err != nil {
 				log.Warn().
 					Err(err).
 					Str("recordId", variants[0].ID).
 					Msg("failed to deduplicate items in database, setting err flag and skipping")
-				if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
+				if err := job.db.UpdateRecordStatus(ctx, variants[0].ID, -1); err != nil {
 					log.Error().
 						Err(err).
 						Str("recordId", variants[0].ID).
@@ -162,13 +166,22 @@ func (job *Service) performCleanup(itemsToProc int) error {
 				continue
 			}
 			stats.NumMerged++
+			mergedItem := merged.Record
+			job.notifier.Notify(
+				ctx,
+				webhook.Event{
+					Type:    webhook.EventMerged,
+					ConcID:  mergedItem.ID,
+					Details: archiver.MergeEventDetails(merged),
+				},
+			)
 			if mergedItem.NumAccess == 0 && mergedItem.Created.Before(birthLimit) {
 				log.Debug().
 					Str("recordId", mergedItem.ID).
 					Time("limitBirth", birthLimit).
 					Msg("record will be removed due to no access and high age")
-				if err := job.db.RemoveRecordsByID(variants[0].ID); err != nil {
-					if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
+				if err := job.db.RemoveRecordsByID(ctx, variants[0].ID); err != nil {
+					if err := job.db.UpdateRecordStatus(ctx, variants[0].ID, -1); err != nil {
 						log.Error().
 							Err(err).
 							Str("recordId", variants[0].ID).
@@ -178,6 +191,7 @@ func (job *Service) performCleanup(itemsToProc int) error {
 					continue
 				}
 				stats.NumDeleted++
+				job.notifier.Notify(ctx, webhook.Event{Type: webhook.EventDeleted, ConcID: variants[0].ID})
 			}
 
 		} else {
@@ -186,8 +200,8 @@ func (job *Service) performCleanup(itemsToProc int) error {
 					Str("recordId", variants[0].ID).
 					Time("limitBirth", birthLimit).
 					Msg("record will be removed due to no access and high age")
-				if err := job.db.RemoveRecordsByID(variants[0].ID); err != nil {
-					if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
+				if err := job.db.RemoveRecordsByID(ctx, variants[0].ID); err != nil {
+					if err := job.db.UpdateRecordStatus(ctx, variants[0].ID, -1); err != nil {
 						log.Error().
 							Err(err).
 							Str("recordId", variants[0].ID).
@@ -197,10 +211,11 @@ func (job *Service) performCleanup(itemsToProc int) error {
 					continue
 				}
 				stats.NumDeleted++
+				job.notifier.Notify(ctx, webhook.Event{Type: webhook.EventDeleted, ConcID: variants[0].ID})
 			}
 		}
 	}
-	job.rdb.Set(job.conf.StatusKey, items[len(items)-1].Created.Format(dtFormat))
+	job.rdb.Set(ctx, job.conf.StatusKey, items[len(items)-1].Created.Format(dtFormat))
 	log.Info().
 		Any("stats", stats).
 		Float64("procTime", time.Since(t0).Seconds()).
@@ -213,6 +228,7 @@ func NewService(
 	db cncdb.IConcArchOps,
 	rdb *archiver.RedisAdapter,
 	reporting reporting.IReporting,
+	notifier webhook.INotifier,
 	conf Conf,
 	tz *time.Location,
 ) *Service {
@@ -221,6 +237,7 @@ func NewService(
 		db:        db,
 		rdb:       rdb,
 		reporting: reporting,
+		notifier:  notifier,
 		tz:        tz,
 	}
 }
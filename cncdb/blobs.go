@@ -0,0 +1,315 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Content-addressed storage of archive record data.
+//
+// In this mode, the `data` column of `kontext_conc_persistence` contains
+// just a reference to a row in the `camus_data_blobs` table which is
+// shared by all the records with the same data. Please note that KonText
+// is not able to read such records directly.
+//
+// The blob table and the column referring to it must be created manually:
+//
+// CREATE TABLE camus_data_blobs (
+//   hash CHAR(64) NOT NULL,
+//   data LONGTEXT NOT NULL,
+//   created DATETIME NOT NULL,
+//   PRIMARY KEY (hash)
+// );
+//
+// ALTER TABLE kontext_conc_persistence ADD COLUMN data_hash CHAR(64) NULL,
+//   ADD INDEX data_hash_idx (data_hash);
+//
+// Blobs are never removed along with records referencing them. Use the
+// `migrate-data-blobs -remove-orphans` action to clean up unused blobs.
+
+const (
+	blobRefPrefix = "camus-blob:"
+
+	// maxBlobsPerQuery limits number of blobs loaded by a single
+	// `IN (...)` query
+	maxBlobsPerQuery = 500
+
+	// minOrphanBlobAge specifies how long an unreferenced blob is kept.
+	// This prevents removal of blobs whose referencing records have not
+	// been committed yet (see storeBlob).
+	minOrphanBlobAge = 24 * time.Hour
+)
+
+// IsBlobRef tests whether stored record data represent a reference
+// to a content-addressed blob.
+func IsBlobRef(data string) bool {
+	return strings.HasPrefix(data, blobRefPrefix)
+}
+
+// DataHash returns a content address of record data
+func DataHash(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func blobRef(hash string) string {
+	return blobRefPrefix + hash
+}
+
+func blobRefHash(data string) string {
+	return strings.TrimPrefix(data, blobRefPrefix)
+}
+
+// EnableContentAddressing makes the archive store record data
+// in the content-addressed blob table. Reading blob-stored data
+// is always supported, regardless of this setting.
+func (ops *MySQLConcArch) EnableContentAddressing() {
+	ops.contentAddressed = true
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// storeBlob stores data (if not already present) and returns
+// a reference to be stored to the archive table. For an already
+// stored blob, its creation time is updated so it cannot be removed
+// as an orphan before the new reference is stored (see RemoveOrphanBlobs).
+func (ops *MySQLConcArch) storeBlob(ctx context.Context, ex execer, data string) (string, error) {
+	hash := DataHash(data)
+	_, err := ex.ExecContext(
+		ctx,
+		"INSERT INTO camus_data_blobs (hash, data, created) VALUES (?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE created = VALUES(created)",
+		hash, data, time.Now().In(ops.tz),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to store data blob %s: %w", hash, err)
+	}
+	return blobRef(hash), nil
+}
+
+func (ops *MySQLConcArch) loadBlobs(ctx context.Context, hashes []string) (map[string]string, error) {
+	ans := make(map[string]string, len(hashes))
+	for i := 0; i < len(hashes); i += maxBlobsPerQuery {
+		chunk := hashes[i:min(i+maxBlobsPerQuery, len(hashes))]
+		args := make([]any, len(chunk))
+		for j, h := range chunk {
+			args[j] = h
+		}
+		rows, err := ops.db.QueryContext(
+			ctx,
+			"SELECT hash, data FROM camus_data_blobs "+
+				"WHERE hash IN (?"+strings.Repeat(", ?", len(chunk)-1)+")",
+			args...,
+		)
+		if err != nil {
+			return map[string]string{}, fmt.Errorf("failed to load data blobs: %w", err)
+		}
+		for rows.Next() {
+			var hash, data string
+			if err := rows.Scan(&hash, &data); err != nil {
+				rows.Close()
+				return map[string]string{}, fmt.Errorf("failed to load data blobs: %w", err)
+			}
+			ans[hash] = data
+		}
+		rows.Close()
+	}
+	return ans, nil
+}
+
+// resolveData replaces stored forms of records' data (blob references,
+// compressed and encrypted data) with the original data.
+func (ops *MySQLConcArch) resolveData(ctx context.Context, recs []ArchRecord) error {
+	if err := ops.resolveStoredData(ctx, recs); err != nil {
+		return err
+	}
+	return ops.decryptRecords(recs)
+}
+
+// decryptRecords decrypts data of records with already resolved
+// stored data (see resolveStoredData)
+func (ops *MySQLConcArch) decryptRecords(recs []ArchRecord) error {
+	for i, rec := range recs {
+		var err error
+		recs[i].Data, err = ops.decryptData(rec.Data)
+		if err != nil {
+			return fmt.Errorf("failed to load record %s: %w", rec.ID, err)
+		}
+	}
+	return nil
+}
+
+// resolveStoredData replaces blob references and compressed data
+// with the respective (possibly encrypted) data.
+func (ops *MySQLConcArch) resolveStoredData(ctx context.Context, recs []ArchRecord) error {
+	hashes := make([]string, 0, len(recs))
+	for _, rec := range recs {
+		if IsBlobRef(rec.Data) {
+			hashes = append(hashes, blobRefHash(rec.Data))
+		}
+	}
+	var blobs map[string]string
+	if len(hashes) > 0 {
+		var err error
+		blobs, err = ops.loadBlobs(ctx, hashes)
+		if err != nil {
+			return err
+		}
+	}
+	for i, rec := range recs {
+		if IsBlobRef(rec.Data) {
+			data, ok := blobs[blobRefHash(rec.Data)]
+			if !ok {
+				return fmt.Errorf("missing data blob %s for record %s", blobRefHash(rec.Data), rec.ID)
+			}
+			recs[i].Data = data
+		}
+		var err error
+		recs[i].Data, err = DecompressData(recs[i].Data)
+		if err != nil {
+			return fmt.Errorf("failed to load record %s: %w", rec.ID, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDataToBlobs moves inline data of up to `maxItems` records
+// created since `fromDate` to the content-addressed blob table.
+// The function returns creation time of the last processed record
+// which can be used as `fromDate` for the next chunk. Already migrated
+// records are skipped. If no record is left, zero time is returned.
+func (ops *MySQLConcArch) MigrateDataToBlobs(
+	ctx context.Context, fromDate time.Time, maxItems int) (time.Time, int, error) {
+	rows, err := ops.db.QueryContext(
+		ctx,
+		"SELECT id, data, created FROM kontext_conc_persistence "+
+			"WHERE created >= ? AND data NOT LIKE ? "+
+			"ORDER BY created LIMIT ?",
+		fromDate, blobRefPrefix+"%", maxItems,
+	)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("failed to migrate data to blobs: %w", err)
+	}
+	recs := make([]ArchRecord, 0, maxItems)
+	for rows.Next() {
+		var rec ArchRecord
+		if err := rows.Scan(&rec.ID, &rec.Data, &rec.Created); err != nil {
+			rows.Close()
+			return time.Time{}, 0, fmt.Errorf("failed to migrate data to blobs: %w", err)
+		}
+		recs = append(recs, rec)
+	}
+	rows.Close()
+	if len(recs) == 0 {
+		return time.Time{}, 0, nil
+	}
+	// we wait for the whole chunk so the transaction is not kept
+	// open while being throttled
+	if err := ops.writeBudget.Wait(ctx, len(recs)); err != nil {
+		return time.Time{}, 0, fmt.Errorf("failed to migrate data to blobs: %w", err)
+	}
+	tx, err := ops.NewTransaction(ctx)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("failed to migrate data to blobs: %w", err)
+	}
+	for _, rec := range recs {
+		ref, err := ops.storeBlob(ctx, tx, rec.Data)
+		if err != nil {
+			tx.Rollback()
+			return time.Time{}, 0, fmt.Errorf("failed to migrate data to blobs: %w", err)
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE kontext_conc_persistence SET data = ?, data_hash = ? WHERE id = ? AND created = ?",
+			ref, blobRefHash(ref), rec.ID, rec.Created,
+		)
+		if err != nil {
+			tx.Rollback()
+			return time.Time{}, 0, fmt.Errorf("failed to migrate data to blobs: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, 0, fmt.Errorf("failed to migrate data to blobs: %w", err)
+	}
+	return recs[len(recs)-1].Created, len(recs), nil
+}
+
+// RemoveOrphanBlobs removes blobs not referenced by any archive record.
+// Recently stored (or reused) blobs are kept (see minOrphanBlobAge).
+// This is a demanding operation which should be run only occasionally.
+func (ops *MySQLConcArch) RemoveOrphanBlobs(ctx context.Context) (int64, error) {
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return 0, fmt.Errorf("failed to remove orphan blobs: %w", err)
+	}
+	res, err := ops.db.ExecContext(
+		ctx,
+		"DELETE b FROM camus_data_blobs AS b "+
+			"LEFT JOIN kontext_conc_persistence AS p ON p.data_hash = b.hash "+
+			"WHERE p.id IS NULL AND b.created < ?",
+		time.Now().In(ops.tz).Add(-minOrphanBlobAge),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove orphan blobs: %w", err)
+	}
+	aff, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove orphan blobs: %w", err)
+	}
+	return aff, nil
+}
+
+// RunBlobMigration migrates all the archive records to the content-addressed
+// storage, processing `chunkSize` records per transaction. The migration
+// can be interrupted and run again at any time.
+func (ops *MySQLConcArch) RunBlobMigration(ctx context.Context, chunkSize int) error {
+	var fromDate time.Time
+	var total int
+	for {
+		select {
+		case <-ctx.Done():
+			log.Warn().Int("numMigrated", total).Msg("blob migration interrupted")
+			return nil
+		default:
+		}
+		lastCreated, num, err := ops.MigrateDataToBlobs(ctx, fromDate, chunkSize)
+		if err != nil {
+			return err
+		}
+		if num == 0 {
+			break
+		}
+		total += num
+		fromDate = lastCreated
+		log.Info().
+			Int("numMigrated", total).
+			Time("lastCreated", lastCreated).
+			Msg("migrated chunk of records to data blobs")
+	}
+	log.Info().Int("numMigrated", total).Msg("blob migration finished")
+	return nil
+}
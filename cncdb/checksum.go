@@ -0,0 +1,209 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Payload checksums allow for detection of silent data corruption
+// and manual edits of archived records. A checksum is calculated from
+// record's payload - i.e. from the data as stored but with blob references
+// resolved and with compression removed (encrypted data stay encrypted,
+// including data compressed before their encryption - see encryption.go).
+// This means that migration to blobs or compression does not invalidate
+// checksums while any data update (including key rotation) recalculates them.
+//
+// The archive table must contain the `data_checksum` column:
+//
+// ALTER TABLE kontext_conc_persistence ADD COLUMN data_checksum CHAR(64) NULL;
+
+// ChecksumMismatch describes an archived record whose payload does not
+// match its stored checksum
+type ChecksumMismatch struct {
+	ID       string    `json:"id"`
+	Created  time.Time `json:"created"`
+	Stored   string    `json:"stored"`
+	Computed string    `json:"computed"`
+
+	// Error describes a problem which prevented the payload
+	// from being obtained at all (e.g. broken compressed data)
+	Error string `json:"error,omitempty"`
+}
+
+// ChecksumVerification is a result of verifying a chunk of records
+// (see MySQLConcArch.VerifyChecksums)
+type ChecksumVerification struct {
+
+	// LastCreated and LastID identify the last processed record
+	// and should be used as a starting point for the next chunk
+	LastCreated time.Time `json:"lastCreated"`
+	LastID      string    `json:"lastId"`
+
+	NumChecked int `json:"numChecked"`
+
+	// NumMissing is a number of records without a checksum
+	NumMissing int `json:"numMissing"`
+
+	// NumFilled is a number of records with a newly calculated checksum
+	NumFilled int `json:"numFilled"`
+
+	Mismatches []ChecksumMismatch `json:"mismatches"`
+}
+
+// PayloadChecksum calculates a checksum of a record payload
+func PayloadChecksum(payload string) string {
+	sum := sha256.Sum256([]byte(payload))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnableChecksums makes the archive store checksums of records'
+// payloads and verify them when loading records by ID
+// (see DBConf.ChecksumColumn).
+func (ops *MySQLConcArch) EnableChecksums() {
+	ops.checksums = true
+}
+
+// findChecksumMismatches compares payloads of records (with already
+// resolved stored data - see resolveStoredData) with their checksums.
+// Records without a checksum are skipped.
+func findChecksumMismatches(recs []ArchRecord, checksums []sql.NullString) []ChecksumMismatch {
+	ans := make([]ChecksumMismatch, 0, 2)
+	for i, rec := range recs {
+		if !checksums[i].Valid {
+			continue
+		}
+		computed := PayloadChecksum(rec.Data)
+		if computed != checksums[i].String {
+			ans = append(ans, ChecksumMismatch{
+				ID:       rec.ID,
+				Created:  rec.Created,
+				Stored:   checksums[i].String,
+				Computed: computed,
+			})
+		}
+	}
+	return ans
+}
+
+func logChecksumMismatches(mismatches []ChecksumMismatch) {
+	for _, m := range mismatches {
+		log.Error().
+			Str("recordId", m.ID).
+			Time("created", m.Created).
+			Str("storedChecksum", m.Stored).
+			Str("computedChecksum", m.Computed).
+			Str("error", m.Error).
+			Msg("archived record payload does not match its checksum")
+	}
+}
+
+// VerifyChecksums verifies payload checksums of up to `maxItems` records
+// following the record identified by `fromCreated` and `fromID` (use zero
+// values to start from the beginning). With fillMissing, checksums are
+// calculated and stored for records without them.
+func (ops *MySQLConcArch) VerifyChecksums(
+	ctx context.Context,
+	fromCreated time.Time,
+	fromID string,
+	maxItems int,
+	fillMissing bool,
+) (ChecksumVerification, error) {
+	ans := ChecksumVerification{Mismatches: []ChecksumMismatch{}}
+	if !ops.checksums {
+		return ans, fmt.Errorf("failed to verify checksums: checksums not enabled")
+	}
+	// for filling in missing checksums, we need the most recent data
+	rows, err := ops.readDB(ctx, !fillMissing).QueryContext(
+		ctx,
+		"SELECT id, data, created, data_checksum FROM kontext_conc_persistence "+
+			"WHERE created > ? OR (created = ? AND id > ?) "+
+			"ORDER BY created, id LIMIT ?",
+		fromCreated, fromCreated, fromID, maxItems,
+	)
+	if err != nil {
+		return ans, fmt.Errorf("failed to verify checksums: %w", err)
+	}
+	recs := make([]ArchRecord, 0, maxItems)
+	checksums := make([]sql.NullString, 0, maxItems)
+	for rows.Next() {
+		var rec ArchRecord
+		var checksum sql.NullString
+		if err := rows.Scan(&rec.ID, &rec.Data, &rec.Created, &checksum); err != nil {
+			rows.Close()
+			return ans, fmt.Errorf("failed to verify checksums: %w", err)
+		}
+		recs = append(recs, rec)
+		checksums = append(checksums, checksum)
+	}
+	rows.Close()
+	if len(recs) == 0 {
+		return ans, nil
+	}
+	ans.LastCreated = recs[len(recs)-1].Created
+	ans.LastID = recs[len(recs)-1].ID
+	ans.NumChecked = len(recs)
+	unresolvable := make(map[int]bool)
+	if err := ops.resolveStoredData(ctx, recs); err != nil {
+		// a single broken record must not stop the verification
+		// so we try to resolve records one by one
+		for i := range recs {
+			if err := ops.resolveStoredData(ctx, recs[i:i+1]); err != nil {
+				unresolvable[i] = true
+				ans.Mismatches = append(ans.Mismatches, ChecksumMismatch{
+					ID:      recs[i].ID,
+					Created: recs[i].Created,
+					Stored:  checksums[i].String,
+					Error:   err.Error(),
+				})
+				checksums[i] = sql.NullString{} // not to be compared below
+			}
+		}
+	}
+	ans.Mismatches = append(ans.Mismatches, findChecksumMismatches(recs, checksums)...)
+	logChecksumMismatches(ans.Mismatches)
+	for i, rec := range recs {
+		if checksums[i].Valid || unresolvable[i] {
+			continue
+		}
+		ans.NumMissing++
+		if !fillMissing {
+			continue
+		}
+		if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+			return ans, fmt.Errorf("failed to fill in checksum of %s: %w", rec.ID, err)
+		}
+		_, err := ops.db.ExecContext(
+			ctx,
+			"UPDATE kontext_conc_persistence SET data_checksum = ? "+
+				"WHERE id = ? AND created = ? AND data_checksum IS NULL",
+			PayloadChecksum(rec.Data), rec.ID, rec.Created,
+		)
+		if err != nil {
+			return ans, fmt.Errorf("failed to fill in checksum of %s: %w", rec.ID, err)
+		}
+		ans.NumFilled++
+	}
+	return ans, nil
+}
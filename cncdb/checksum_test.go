@@ -0,0 +1,42 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindChecksumMismatches(t *testing.T) {
+	recs := []ArchRecord{
+		{ID: "a", Data: `{"q": ["aword,foo"]}`},
+		{ID: "b", Data: `{"q": ["aword,bar"]}`},
+		{ID: "c", Data: `{"q": ["aword,baz"]}`},
+	}
+	checksums := []sql.NullString{
+		{String: PayloadChecksum(`{"q": ["aword,foo"]}`), Valid: true},
+		{String: PayloadChecksum(`{"q": ["aword,edited"]}`), Valid: true},
+		{},
+	}
+	mismatches := findChecksumMismatches(recs, checksums)
+	if assert.Len(t, mismatches, 1) {
+		assert.Equal(t, "b", mismatches[0].ID)
+		assert.Equal(t, PayloadChecksum(recs[1].Data), mismatches[0].Computed)
+	}
+}
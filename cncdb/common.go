@@ -17,12 +17,14 @@
 package cncdb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
 )
 
 var (
@@ -33,28 +35,6 @@ func TimeIsAtNight(t time.Time) bool {
 	return t.Hour() >= 22 || t.Hour() <= 5
 }
 
-func MergeRecords(recs []ArchRecord, newRec ArchRecord, tz *time.Location) ArchRecord {
-	if len(recs) == 0 {
-		panic("cannot merge empty slice of ArchRecords")
-	}
-	ans := newRec
-	ans.NumAccess++
-	ans.LastAccess = time.Now().In(tz)
-	for _, rec := range recs {
-		ans.NumAccess += rec.NumAccess
-		if rec.Created.Before(ans.Created) && !rec.Created.IsZero() {
-			ans.Created = rec.Created
-		}
-		if rec.LastAccess.After(ans.LastAccess) {
-			ans.LastAccess = rec.LastAccess
-		}
-		if rec.Permanent > ans.Permanent {
-			ans.Permanent = rec.Permanent
-		}
-	}
-	return ans
-}
-
 func ValidateQueryInstances(variants []ArchRecord) error {
 	if len(variants) < 2 {
 		return nil
@@ -78,3 +58,35 @@ func ValidateQueryInstances(variants []ArchRecord) error {
 	}
 	return nil
 }
+
+// ScanArchive goes through all the archive records, ordered by creation
+// time and ID, chunk by chunk and calls fn for each of them. The records
+// are read from the primary database so fn can safely rewrite them.
+func ScanArchive(ctx context.Context, db IConcArchOps, chunkSize int, fn func(rec ArchRecord)) error {
+	var from RecordID
+	var numScanned int
+	for {
+		items, err := db.LoadRecordsAfter(ctx, from, chunkSize)
+		if err != nil {
+			return fmt.Errorf("failed to scan archive: %w", err)
+		}
+		for _, item := range items {
+			fn(item)
+		}
+		numScanned += len(items)
+		if len(items) < chunkSize {
+			return nil
+		}
+		last := items[len(items)-1]
+		from = RecordID{ID: last.ID, Created: last.Created}
+		log.Info().
+			Time("lastCreated", from.Created).
+			Int("numScanned", numScanned).
+			Msg("scanned chunk of archive records")
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to scan archive: %w", ctx.Err())
+		default:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pagedConcArch struct {
+	DummyConcArchSQL
+	items []ArchRecord
+}
+
+func (db *pagedConcArch) LoadRecordsAfter(
+	ctx context.Context, from RecordID, maxItems int) ([]ArchRecord, error) {
+	ans := make([]ArchRecord, 0, maxItems)
+	for _, item := range db.items {
+		if item.Created.After(from.Created) || item.Created.Equal(from.Created) && item.ID > from.ID {
+			ans = append(ans, item)
+		}
+		if len(ans) == maxItems {
+			break
+		}
+	}
+	return ans, nil
+}
+
+func TestScanArchiveSameCreationTime(t *testing.T) {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	db := &pagedConcArch{}
+	for i := 0; i < 10; i++ {
+		// more records share the creation time than fits in a chunk
+		db.items = append(db.items, ArchRecord{
+			ID:      fmt.Sprintf("rec%02d", i),
+			Created: created.Add(time.Duration(i/7) * time.Second),
+		})
+	}
+	scanned := make([]string, 0, len(db.items))
+	err := ScanArchive(context.Background(), db, 3, func(rec ArchRecord) {
+		scanned = append(scanned, rec.ID)
+	})
+	assert.NoError(t, err)
+	expected := make([]string, len(db.items))
+	for i, item := range db.items {
+		expected[i] = item.ID
+	}
+	assert.Equal(t, expected, scanned)
+}
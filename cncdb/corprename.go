@@ -0,0 +1,128 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// form entries containing a single corpus name
+var corpusNameFields = map[string]bool{
+	"corpname":     true,
+	"maincorp":     true,
+	"ref_corpname": true,
+}
+
+// form entries containing a list of corpora (e.g. aligned ones)
+var corpusListFields = map[string]bool{
+	"corpora": true,
+	"align":   true,
+}
+
+func renameInList(v any, oldID, newID string) bool {
+	items, ok := v.([]any)
+	if !ok {
+		return false
+	}
+	var changed bool
+	for i, item := range items {
+		if s, ok := item.(string); ok && s == oldID {
+			items[i] = newID
+			changed = true
+		}
+	}
+	return changed
+}
+
+// renameInForm replaces the corpus name in known corpus fields
+// and in keys of all the per-corpus entries (curr_queries,
+// curr_query_types etc.)
+func renameInForm(form map[string]any, oldID, newID string) bool {
+	var changed bool
+	for k, v := range form {
+		switch tv := v.(type) {
+		case string:
+			if corpusNameFields[k] && tv == oldID {
+				form[k] = newID
+				changed = true
+			}
+		case []any:
+			if corpusListFields[k] && renameInList(tv, oldID, newID) {
+				changed = true
+			}
+		case map[string]any:
+			if corpVal, ok := tv[oldID]; ok {
+				delete(tv, oldID)
+				tv[newID] = corpVal
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// MarshalData encodes record data the way KonText does (i.e.
+// without escaping of HTML characters which are common in CQL queries)
+func MarshalData(rec map[string]any) (string, error) {
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(rec); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buff.String(), "\n"), nil
+}
+
+// UnmarshalData decodes record data into a generic map while preserving
+// numeric values as they are.
+func UnmarshalData(data string) (map[string]any, error) {
+	var rec map[string]any
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// RenameCorpusInData replaces corpus `oldID` with `newID` in record data,
+// i.e. in the list of corpora (including aligned ones), in keys of per-corpus
+// form entries and in fields referring to a corpus (e.g. `ref_corpname`).
+// The returned bool specifies whether the data have been changed.
+func RenameCorpusInData(data, oldID, newID string) (string, bool, error) {
+	rec, err := UnmarshalData(data)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to rename corpus in data: %w", err)
+	}
+	changed := renameInList(rec["corpora"], oldID, newID)
+	for _, formKey := range []string{"lastop_form", "form"} {
+		if form, ok := rec[formKey].(map[string]any); ok && renameInForm(form, oldID, newID) {
+			changed = true
+		}
+	}
+	if !changed {
+		return data, false, nil
+	}
+	ans, err := MarshalData(rec)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to rename corpus in data: %w", err)
+	}
+	return ans, true, nil
+}
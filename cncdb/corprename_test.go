@@ -0,0 +1,52 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameCorpusInData(t *testing.T) {
+	data := `{"corpora":["syn_v9","intercorp_en"],"id":"foo","lastop_form":{` +
+		`"curr_queries":{"intercorp_en":"[word=\"x\"]","syn_v9":"<s/>[lemma=\"pes\"]"},` +
+		`"curr_query_types":{"intercorp_en":"advanced","syn_v9":"advanced"},"form_type":"query"},` +
+		`"user_id":1234567890123}`
+	ans, changed, err := RenameCorpusInData(data, "syn_v9", "syn_v10")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(
+		t,
+		`{"corpora":["syn_v10","intercorp_en"],"id":"foo","lastop_form":{`+
+			`"curr_queries":{"intercorp_en":"[word=\"x\"]","syn_v10":"<s/>[lemma=\"pes\"]"},`+
+			`"curr_query_types":{"intercorp_en":"advanced","syn_v10":"advanced"},"form_type":"query"},`+
+			`"user_id":1234567890123}`,
+		ans,
+	)
+
+	kwords := `{"corpora":["foo"],"form":{"form_type":"kwords","ref_corpname":"syn_v9"}}`
+	ans, changed, err = RenameCorpusInData(kwords, "syn_v9", "syn_v10")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, `{"corpora":["foo"],"form":{"form_type":"kwords","ref_corpname":"syn_v10"}}`, ans)
+
+	ans, changed, err = RenameCorpusInData(kwords, "syn_v8", "syn_v10")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, kwords, ans)
+}
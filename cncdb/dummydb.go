@@ -17,6 +17,7 @@
 package cncdb
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -25,47 +26,79 @@ import (
 type DummyConcArchSQL struct {
 }
 
-func (dsql *DummyConcArchSQL) NewTransaction() (*sql.Tx, error) {
+func (dsql *DummyConcArchSQL) NewTransaction(ctx context.Context) (*sql.Tx, error) {
 	return nil, nil
 }
 
-func (dsql *DummyConcArchSQL) LoadRecentNRecords(num int) ([]ArchRecord, error) {
+func (dsql *DummyConcArchSQL) LoadRecentNRecords(ctx context.Context, num int) ([]ArchRecord, error) {
 	return []ArchRecord{}, nil
 }
 
-func (dsql *DummyConcArchSQL) LoadRecordsFromDate(fromDate time.Time, maxItems int) ([]ArchRecord, error) {
+func (dsql *DummyConcArchSQL) LoadRecordsFromDate(ctx context.Context, fromDate time.Time, maxItems int) ([]ArchRecord, error) {
 	return []ArchRecord{}, nil
 }
 
-func (dsql *DummyConcArchSQL) ContainsRecord(concID string) (bool, error) {
+func (dsql *DummyConcArchSQL) LoadRecordsAfter(
+	ctx context.Context, from RecordID, maxItems int) ([]ArchRecord, error) {
+	return []ArchRecord{}, nil
+}
+
+func (dsql *DummyConcArchSQL) LoadRecordIDs(
+	ctx context.Context, fromCreated time.Time, fromID string, limit int) ([]RecordID, error) {
+	return []RecordID{}, nil
+}
+
+func (dsql *DummyConcArchSQL) ContainsRecord(ctx context.Context, concID string) (bool, error) {
 	return false, nil
 }
 
-func (dsql *DummyConcArchSQL) LoadRecordsByID(concID string) ([]ArchRecord, error) {
+func (dsql *DummyConcArchSQL) LoadRecordsByID(ctx context.Context, concID string) ([]ArchRecord, error) {
 	return []ArchRecord{}, nil
 }
 
-func (dsql *DummyConcArchSQL) InsertRecord(rec ArchRecord) error {
+func (dsql *DummyConcArchSQL) InsertRecord(ctx context.Context, rec ArchRecord) error {
+	return nil
+}
+
+func (dsql *DummyConcArchSQL) SetRecordRetired(ctx context.Context, id string) error {
+	return nil
+}
+
+func (dsql *DummyConcArchSQL) IsRecordRetired(ctx context.Context, id string) (bool, error) {
+	return false, nil
+}
+
+func (dsql *DummyConcArchSQL) UpdateRecordStatus(ctx context.Context, id string, status int) error {
 	return nil
 }
 
-func (dsql *DummyConcArchSQL) UpdateRecordStatus(id string, status int) error {
+func (dsql *DummyConcArchSQL) UpdateRecordData(ctx context.Context, id string, created time.Time, data string) error {
 	return nil
 }
 
-func (dsql *DummyConcArchSQL) RemoveRecordsByID(concID string) error {
+func (dsql *DummyConcArchSQL) RemoveRecordsByID(ctx context.Context, concID string) error {
 	return nil
 }
 
-func (dsql *DummyConcArchSQL) DeduplicateInArchive(curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
-	return ArchRecord{}, nil
+func (dsql *DummyConcArchSQL) DeduplicateInArchive(ctx context.Context, curr []ArchRecord, rec ArchRecord) (MergeResult, error) {
+	return MergeResult{}, nil
 }
 
-func (dsql *DummyConcArchSQL) GetArchSizesByYears(forceLoad bool) ([][2]int, error) {
+func (dsql *DummyConcArchSQL) GetArchSizesByYears(ctx context.Context, forceLoad bool) ([][2]int, error) {
 	return [][2]int{}, nil
 }
 
-func (dsql *DummyConcArchSQL) GetSubcorpusProps(subcID string) (SubcProps, error) {
+func (dsql *DummyConcArchSQL) GetLargestRecords(
+	ctx context.Context, fromDate time.Time, limit int, forceLoad bool) ([]RecordSize, error) {
+	return []RecordSize{}, nil
+}
+
+func (dsql *DummyConcArchSQL) SampleRecords(
+	ctx context.Context, fromDate, toDate time.Time, num int, forceLoad bool) ([]ArchRecord, error) {
+	return []ArchRecord{}, nil
+}
+
+func (dsql *DummyConcArchSQL) GetSubcorpusProps(ctx context.Context, subcID string) (SubcProps, error) {
 	return SubcProps{}, nil
 }
 
@@ -75,37 +108,70 @@ func (dsql *DummyConcArchSQL) GetSubcorpusProps(subcID string) (SubcProps, error
 type DummyQHistSQL struct {
 }
 
-func (dsql *DummyQHistSQL) GetAllUsersWithSomeRecords() ([]int, error) {
+func (dsql *DummyQHistSQL) NewTransaction(ctx context.Context) (*sql.Tx, error) {
+	return nil, nil
+}
+
+func (dsql *DummyQHistSQL) GetAllUsersWithSomeRecords(ctx context.Context) ([]int, error) {
 	return []int{}, nil
 }
 
-func (dsql *DummyQHistSQL) GetUserRecords(userID int, numItems int) ([]HistoryRecord, error) {
+func (dsql *DummyQHistSQL) GetUserRecords(ctx context.Context, userID int, numItems int) ([]HistoryRecord, error) {
 	return []HistoryRecord{}, nil
 }
 
-func (dsql *DummyQHistSQL) MarkOldRecords(numPreserve int) (int64, error) {
+func (dsql *DummyQHistSQL) MarkOldRecords(ctx context.Context, numPreserve int) (int64, error) {
 	return 0, nil
 }
 
-func (dsql *DummyQHistSQL) LoadRecentNHistory(num int) ([]HistoryRecord, error) {
+func (dsql *DummyQHistSQL) LoadRecentNHistory(ctx context.Context, num int) ([]HistoryRecord, error) {
 	return []HistoryRecord{}, nil
 }
 
-func (dsql *DummyQHistSQL) GarbageCollectRecords(userID int) (int64, error) {
+func (dsql *DummyQHistSQL) GarbageCollectRecords(ctx context.Context, userID int) (int64, error) {
 	return 0, nil
 }
 
-func (dsql *DummyQHistSQL) GetUserGarbageRecords(userID int) ([]HistoryRecord, error) {
+func (dsql *DummyQHistSQL) GetUserGarbageRecords(ctx context.Context, userID int) ([]HistoryRecord, error) {
 	return []HistoryRecord{}, nil
 }
-func (dsql *DummyQHistSQL) RemoveRecord(tx *sql.Tx, created int64, userID int, queryID string) error {
+func (dsql *DummyQHistSQL) RemoveRecord(ctx context.Context, tx *sql.Tx, created int64, userID int, queryID string) error {
 	return nil
 }
 
-func (dsql *DummyQHistSQL) GetPendingDeletionRecords(tx *sql.Tx, maxItems int) ([]HistoryRecord, error) {
+func (dsql *DummyQHistSQL) GetPendingDeletionRecords(ctx context.Context, tx *sql.Tx, maxItems int) ([]HistoryRecord, error) {
+	return []HistoryRecord{}, nil
+}
+
+func (dsql *DummyQHistSQL) TableSize(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (dsql *DummyQHistSQL) LoadHistoryRecords(
+	ctx context.Context, from HistoryRecord, limit int) ([]HistoryRecord, error) {
 	return []HistoryRecord{}, nil
 }
 
-func (dsql *DummyQHistSQL) TableSize() (int64, error) {
+func (dsql *DummyQHistSQL) UpsertHistoryRecords(ctx context.Context, recs []HistoryRecord) error {
+	return nil
+}
+
+func (dsql *DummyQHistSQL) GetPendingDeletionBacklog(ctx context.Context) (PendingDeletionBacklog, error) {
+	return PendingDeletionBacklog{}, nil
+}
+
+func (dsql *DummyQHistSQL) TrashRecords(ctx context.Context, tx *sql.Tx, recs []HistoryRecord, source string) error {
+	return nil
+}
+
+func (dsql *DummyQHistSQL) GetUserTrash(ctx context.Context, userID int, limit int) ([]TrashedHistoryRecord, error) {
+	return []TrashedHistoryRecord{}, nil
+}
+
+func (dsql *DummyQHistSQL) RestoreFromTrash(ctx context.Context, userID int, created int64, queryID string) (HistoryRecord, error) {
+	return HistoryRecord{}, ErrRecordNotFound
+}
+
+func (dsql *DummyQHistSQL) PurgeTrash(ctx context.Context, olderThan time.Time) (int64, error) {
 	return 0, nil
 }
@@ -0,0 +1,558 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Envelope encryption of archive record data.
+//
+// Each value (either the whole record data or a configured field) is
+// encrypted by a random data key (AES-256-GCM) which is itself encrypted
+// by a master key loaded from a local keyfile. Encrypted values have
+// the form `camus-enc:[key ID]:[encrypted data key]:[encrypted value]`.
+// Like blob-stored data, encrypted records cannot be read by KonText.
+//
+// The keyfile is a JSON file with base64-encoded 32-byte keys:
+//
+// {"activeKey": "2024-01", "keys": {"2024-01": "...", "2023-01": "..."}}
+//
+// New values are always encrypted with the active key. Older keys must
+// be kept in the keyfile until all the records are re-encrypted using
+// the `rotate-data-keys` action.
+//
+// Data encrypted as a whole are compressed (if configured) before
+// the encryption. As each value uses a random data key and nonce, two
+// copies of the same data never produce the same encrypted value. This
+// means that content addressing (see blobs.go) cannot deduplicate such
+// records - the blob table then serves just as an external storage.
+// With field encryption, only records with the same encrypted values
+// (typically the same record stored repeatedly) share a blob.
+
+const (
+	encryptedValuePrefix = "camus-enc:"
+	encryptionKeySize    = 32
+)
+
+var (
+	ErrMissingKeyring = errors.New("data are encrypted but no keyring is configured")
+)
+
+type EncryptionConf struct {
+	KeyFilePath string `json:"keyFilePath"`
+
+	// Fields lists dot-separated paths of record data values to be
+	// encrypted (e.g. `lastop_form.curr_queries`). Empty value means
+	// the whole data are encrypted.
+	Fields []string `json:"fields"`
+}
+
+func (conf *EncryptionConf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.KeyFilePath == "" {
+		return fmt.Errorf("missing `keyFilePath` in encryption configuration")
+	}
+	for _, f := range conf.Fields {
+		if f == "" || strings.HasPrefix(f, ".") || strings.HasSuffix(f, ".") {
+			return fmt.Errorf("invalid encrypted field path `%s`", f)
+		}
+	}
+	return nil
+}
+
+// Keyring contains master keys used to encrypt data keys
+type Keyring struct {
+	activeKeyID string
+	keys        map[string][]byte
+}
+
+func (kr *Keyring) ActiveKeyID() string {
+	return kr.activeKeyID
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed value too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// Encrypt encrypts a value using a new data key wrapped by the active key
+func (kr *Keyring) Encrypt(value string) (string, error) {
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("failed to encrypt value: %w", err)
+	}
+	wrappedKey, err := seal(kr.keys[kr.activeKeyID], dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt value: %w", err)
+	}
+	sealed, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt value: %w", err)
+	}
+	return encryptedValuePrefix + kr.activeKeyID + ":" +
+		base64.StdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the original value of an encrypted one
+func (kr *Keyring) Decrypt(value string) (string, error) {
+	items := strings.Split(strings.TrimPrefix(value, encryptedValuePrefix), ":")
+	if len(items) != 3 {
+		return "", fmt.Errorf("failed to decrypt value: invalid format")
+	}
+	masterKey, ok := kr.keys[items[0]]
+	if !ok {
+		return "", fmt.Errorf("failed to decrypt value: unknown key %s", items[0])
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(items[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(items[2])
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	dataKey, err := open(masterKey, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	ans, err := open(dataKey, sealed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(ans), nil
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keyring: %w", err)
+	}
+	var keyFile struct {
+		ActiveKey string            `json:"activeKey"`
+		Keys      map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &keyFile); err != nil {
+		return nil, fmt.Errorf("failed to load keyring: %w", err)
+	}
+	ans := &Keyring{activeKeyID: keyFile.ActiveKey, keys: make(map[string][]byte)}
+	for id, v := range keyFile.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("failed to load keyring: invalid key ID `%s`", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load keyring: invalid key %s: %w", id, err)
+		}
+		if len(key) != encryptionKeySize {
+			return nil, fmt.Errorf(
+				"failed to load keyring: key %s must have %d bytes", id, encryptionKeySize)
+		}
+		ans.keys[id] = key
+	}
+	if _, ok := ans.keys[ans.activeKeyID]; !ok {
+		return nil, fmt.Errorf("failed to load keyring: active key `%s` not found", ans.activeKeyID)
+	}
+	return ans, nil
+}
+
+// ------------------------------
+
+func IsEncryptedValue(v string) bool {
+	return strings.HasPrefix(v, encryptedValuePrefix)
+}
+
+func encryptedValueKeyID(v string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(v, encryptedValuePrefix), ":")
+	return id
+}
+
+// dataEncryptor encrypts record data according to the configuration
+type dataEncryptor struct {
+	keyring *Keyring
+	fields  [][]string
+}
+
+func (de *dataEncryptor) encryptField(rec map[string]any, path []string) error {
+	curr := rec
+	for _, k := range path[:len(path)-1] {
+		next, ok := curr[k].(map[string]any)
+		if !ok {
+			return nil
+		}
+		curr = next
+	}
+	k := path[len(path)-1]
+	v, ok := curr[k]
+	if !ok || v == nil {
+		return nil
+	}
+	if sv, ok := v.(string); ok && IsEncryptedValue(sv) {
+		return nil
+	}
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	encV, err := de.keyring.Encrypt(strings.TrimSuffix(buff.String(), "\n"))
+	if err != nil {
+		return err
+	}
+	curr[k] = encV
+	return nil
+}
+
+// encryptsWholeData tests whether the whole record data are encrypted
+// as a single value (i.e. no fields are configured)
+func (de *dataEncryptor) encryptsWholeData() bool {
+	return len(de.fields) == 0
+}
+
+// needsEncryption tests whether encrypting the data would change them,
+// i.e. whether the data are not encrypted as a whole (in the whole data
+// mode) or whether some of the configured fields exist and are not encrypted
+func (de *dataEncryptor) needsEncryption(data string) (bool, error) {
+	if de.encryptsWholeData() {
+		return !IsEncryptedValue(data), nil
+	}
+	if IsEncryptedValue(data) {
+		// data encrypted as a whole must be switched to field encryption
+		return true, nil
+	}
+	rec, err := UnmarshalData(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to test data encryption: %w", err)
+	}
+	for _, path := range de.fields {
+		var curr any = rec
+		for _, k := range path {
+			m, ok := curr.(map[string]any)
+			if !ok {
+				curr = nil
+				break
+			}
+			curr = m[k]
+		}
+		if curr == nil {
+			continue
+		}
+		if sv, ok := curr.(string); !ok || !IsEncryptedValue(sv) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (de *dataEncryptor) encryptData(data string) (string, error) {
+	if de.encryptsWholeData() {
+		if IsEncryptedValue(data) {
+			return data, nil
+		}
+		return de.keyring.Encrypt(data)
+	}
+	rec, err := UnmarshalData(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	for _, path := range de.fields {
+		if err := de.encryptField(rec, path); err != nil {
+			return "", fmt.Errorf("failed to encrypt data: %w", err)
+		}
+	}
+	return MarshalData(rec)
+}
+
+// walkEncryptedValues calls fn for each encrypted string value found
+// in a decoded JSON value and replaces it with the returned one.
+func walkEncryptedValues(v any, fn func(encV string) (any, error)) (any, error) {
+	switch tv := v.(type) {
+	case string:
+		if IsEncryptedValue(tv) {
+			return fn(tv)
+		}
+	case map[string]any:
+		for k, item := range tv {
+			newItem, err := walkEncryptedValues(item, fn)
+			if err != nil {
+				return nil, err
+			}
+			tv[k] = newItem
+		}
+	case []any:
+		for i, item := range tv {
+			newItem, err := walkEncryptedValues(item, fn)
+			if err != nil {
+				return nil, err
+			}
+			tv[i] = newItem
+		}
+	}
+	return v, nil
+}
+
+// dataKeyIDs returns IDs of all the master keys used to encrypt
+// (parts of) the data
+func dataKeyIDs(data string) ([]string, error) {
+	if IsEncryptedValue(data) {
+		return []string{encryptedValueKeyID(data)}, nil
+	}
+	ans := make([]string, 0, 3)
+	if !strings.Contains(data, encryptedValuePrefix) {
+		return ans, nil
+	}
+	rec, err := UnmarshalData(data)
+	if err != nil {
+		return ans, fmt.Errorf("failed to determine data keys: %w", err)
+	}
+	_, err = walkEncryptedValues(rec, func(encV string) (any, error) {
+		if id := encryptedValueKeyID(encV); !slices.Contains(ans, id) {
+			ans = append(ans, id)
+		}
+		return encV, nil
+	})
+	return ans, err
+}
+
+// DecryptData returns record data with all the encrypted values
+// decrypted. Data without encrypted values are returned as they are.
+// Data compressed before encryption are decompressed too.
+func DecryptData(keyring *Keyring, data string) (string, error) {
+	if !strings.Contains(data, encryptedValuePrefix) {
+		return data, nil
+	}
+	if keyring == nil {
+		return "", ErrMissingKeyring
+	}
+	if IsEncryptedValue(data) {
+		plain, err := keyring.Decrypt(data)
+		if err != nil {
+			return "", err
+		}
+		return DecompressData(plain)
+	}
+	rec, err := UnmarshalData(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt data: %w", err)
+	}
+	_, err = walkEncryptedValues(rec, func(encV string) (any, error) {
+		plain, err := keyring.Decrypt(encV)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(strings.NewReader(plain))
+		dec.UseNumber()
+		var ans any
+		if err := dec.Decode(&ans); err != nil {
+			return nil, err
+		}
+		return ans, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt data: %w", err)
+	}
+	return MarshalData(rec)
+}
+
+// ------------------------------
+
+// EnableEncryption makes the archive store record data (or configured
+// fields) encrypted. Once enabled, encrypted records are decrypted
+// transparently on read.
+func (ops *MySQLConcArch) EnableEncryption(conf *EncryptionConf) error {
+	keyring, err := LoadKeyring(conf.KeyFilePath)
+	if err != nil {
+		return err
+	}
+	ops.encryptor = &dataEncryptor{keyring: keyring, fields: make([][]string, len(conf.Fields))}
+	for i, f := range conf.Fields {
+		ops.encryptor.fields[i] = strings.Split(f, ".")
+	}
+	return nil
+}
+
+func (ops *MySQLConcArch) decryptData(data string) (string, error) {
+	var keyring *Keyring
+	if ops.encryptor != nil {
+		keyring = ops.encryptor.keyring
+	}
+	return DecryptData(keyring, data)
+}
+
+// needsReencryption tests whether data (in the encrypted form) should
+// be encrypted again to use the active key only
+func (ops *MySQLConcArch) needsReencryption(data string) (bool, error) {
+	keyIDs, err := dataKeyIDs(data)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range keyIDs {
+		if id != ops.encryptor.keyring.ActiveKeyID() {
+			return true, nil
+		}
+	}
+	return ops.encryptor.needsEncryption(data)
+}
+
+// recordsToReencrypt returns decrypted data of records (with already resolved
+// stored data - see resolveStoredData) which must be encrypted again
+func (ops *MySQLConcArch) recordsToReencrypt(recs []ArchRecord) ([]ArchRecord, error) {
+	ans := make([]ArchRecord, 0, len(recs))
+	for _, rec := range recs {
+		needs, err := ops.needsReencryption(rec.Data)
+		if err != nil {
+			return []ArchRecord{}, fmt.Errorf("failed to re-encrypt record %s: %w", rec.ID, err)
+		}
+		if !needs {
+			continue
+		}
+		rec.Data, err = ops.decryptData(rec.Data)
+		if err != nil {
+			return []ArchRecord{}, fmt.Errorf("failed to re-encrypt record %s: %w", rec.ID, err)
+		}
+		ans = append(ans, rec)
+	}
+	return ans, nil
+}
+
+// ReencryptData re-encrypts up to `maxItems` records following the record
+// identified by `from` (use zero value to start from the beginning) with
+// the active key. Records not encrypted yet are encrypted too. The function
+// returns the last processed record (to be used as `from` for the next chunk),
+// number of processed and number of re-encrypted records.
+func (ops *MySQLConcArch) ReencryptData(
+	ctx context.Context, from RecordID, maxItems int) (RecordID, int, int, error) {
+	if ops.encryptor == nil {
+		return RecordID{}, 0, 0, fmt.Errorf("failed to re-encrypt data: encryption not enabled")
+	}
+	rows, err := ops.db.QueryContext(
+		ctx,
+		"SELECT id, data, created FROM kontext_conc_persistence "+
+			"WHERE created > ? OR (created = ? AND id > ?) "+
+			"ORDER BY created, id LIMIT ?",
+		from.Created, from.Created, from.ID, maxItems,
+	)
+	if err != nil {
+		return RecordID{}, 0, 0, fmt.Errorf("failed to re-encrypt data: %w", err)
+	}
+	recs := make([]ArchRecord, 0, maxItems)
+	for rows.Next() {
+		var rec ArchRecord
+		if err := rows.Scan(&rec.ID, &rec.Data, &rec.Created); err != nil {
+			rows.Close()
+			return RecordID{}, 0, 0, fmt.Errorf("failed to re-encrypt data: %w", err)
+		}
+		recs = append(recs, rec)
+	}
+	rows.Close()
+	if len(recs) == 0 {
+		return RecordID{}, 0, 0, nil
+	}
+	if err := ops.resolveStoredData(ctx, recs); err != nil {
+		return RecordID{}, 0, 0, fmt.Errorf("failed to re-encrypt data: %w", err)
+	}
+	toReencrypt, err := ops.recordsToReencrypt(recs)
+	if err != nil {
+		return RecordID{}, 0, 0, err
+	}
+	for _, rec := range toReencrypt {
+		if err := ops.UpdateRecordData(ctx, rec.ID, rec.Created, rec.Data); err != nil {
+			return RecordID{}, 0, 0, fmt.Errorf("failed to re-encrypt record %s: %w", rec.ID, err)
+		}
+	}
+	last := recs[len(recs)-1]
+	return RecordID{ID: last.ID, Created: last.Created}, len(recs), len(toReencrypt), nil
+}
+
+// RunKeyRotation re-encrypts all the archive records so they use
+// the active key only. The process can be interrupted and run again
+// at any time.
+func (ops *MySQLConcArch) RunKeyRotation(ctx context.Context, chunkSize int) error {
+	var from RecordID
+	var total, totalReencrypted int
+	for {
+		select {
+		case <-ctx.Done():
+			log.Warn().Int("numReencrypted", totalReencrypted).Msg("key rotation interrupted")
+			return fmt.Errorf("failed to finish key rotation: %w", ctx.Err())
+		default:
+		}
+		last, num, numReencrypted, err := ops.ReencryptData(ctx, from, chunkSize)
+		if err != nil {
+			return err
+		}
+		total += num
+		totalReencrypted += numReencrypted
+		if num < chunkSize {
+			break
+		}
+		from = last
+		log.Info().
+			Int("numProcessed", total).
+			Int("numReencrypted", totalReencrypted).
+			Time("lastCreated", last.Created).
+			Msg("re-encrypted chunk of records")
+	}
+	log.Info().
+		Int("numProcessed", total).
+		Int("numReencrypted", totalReencrypted).
+		Msg("key rotation finished")
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKeyring() *Keyring {
+	return &Keyring{
+		activeKeyID: "k2",
+		keys: map[string][]byte{
+			"k1": []byte(strings.Repeat("a", encryptionKeySize)),
+			"k2": []byte(strings.Repeat("b", encryptionKeySize)),
+		},
+	}
+}
+
+func TestEncryptWholeData(t *testing.T) {
+	enc := &dataEncryptor{keyring: testKeyring()}
+	data := `{"id":"foo","lastop_form":{"form_type":"query","curr_queries":{"syn2020":"[word=\"x\"]"}}}`
+	encrypted, err := enc.encryptData(data)
+	assert.NoError(t, err)
+	assert.True(t, IsEncryptedValue(encrypted))
+	keyIDs, err := dataKeyIDs(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k2"}, keyIDs)
+
+	decrypted, err := DecryptData(enc.keyring, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+
+	_, err = DecryptData(nil, encrypted)
+	assert.ErrorIs(t, err, ErrMissingKeyring)
+}
+
+func TestEncryptFields(t *testing.T) {
+	enc := &dataEncryptor{
+		keyring: testKeyring(),
+		fields:  [][]string{{"lastop_form", "curr_queries"}, {"user_id"}, {"missing", "field"}},
+	}
+	data := `{"id":"foo","lastop_form":{"form_type":"query","curr_queries":{"syn2020":"[word=\"x\"]"}},"user_id":42}`
+	encrypted, err := enc.encryptData(data)
+	assert.NoError(t, err)
+	assert.False(t, IsEncryptedValue(encrypted))
+	assert.NotContains(t, encrypted, "word")
+
+	decrypted, err := DecryptData(enc.keyring, encrypted)
+	assert.NoError(t, err)
+	assert.JSONEq(t, data, decrypted)
+
+	enc.keyring.activeKeyID = "k1"
+	reencrypted, err := enc.encryptData(decrypted)
+	assert.NoError(t, err)
+	keyIDs, err := dataKeyIDs(reencrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k1"}, keyIDs)
+}
+
+func TestEncodeDataCompressesBeforeEncryption(t *testing.T) {
+	ops := &MySQLConcArch{
+		compressAbove: 100,
+		encryptor:     &dataEncryptor{keyring: testKeyring()},
+	}
+	data := `{"id":"foo","q":["` + strings.Repeat("word ", 1000) + `"]}`
+	enc, err := ops.encodeData(context.Background(), data)
+	assert.NoError(t, err)
+	assert.True(t, IsEncryptedValue(enc.stored))
+	assert.Equal(t, enc.payload, enc.stored)
+	assert.Less(t, len(enc.stored), len(data)/2)
+
+	plain, err := ops.encryptor.keyring.Decrypt(enc.stored)
+	assert.NoError(t, err)
+	assert.True(t, IsCompressedData(plain))
+
+	decoded, err := ops.decryptData(enc.stored)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded)
+}
+
+func TestSecondRotationReencryptsNothing(t *testing.T) {
+	ops := &MySQLConcArch{
+		compressAbove: 100,
+		encryptor: &dataEncryptor{
+			keyring: testKeyring(),
+			fields:  [][]string{{"lastop_form", "curr_queries"}},
+		},
+	}
+	ctx := context.Background()
+	compressed, err := CompressData(`{"id":"baz","q":["` + strings.Repeat("word ", 100) + `"]}`)
+	assert.NoError(t, err)
+	stored := map[string]string{
+		"foo": `{"id":"foo","lastop_form":{"form_type":"query","curr_queries":{"syn2020":"[word=\"x\"]"}}}`,
+		"bar": `{"id":"bar","form":{"form_type":"wordlist"}}`,
+		"baz": compressed,
+	}
+	rotate := func() int {
+		recs := make([]ArchRecord, 0, len(stored))
+		for id, data := range stored {
+			recs = append(recs, ArchRecord{ID: id, Data: data})
+		}
+		assert.NoError(t, ops.resolveStoredData(ctx, recs))
+		toReencrypt, err := ops.recordsToReencrypt(recs)
+		assert.NoError(t, err)
+		for _, rec := range toReencrypt {
+			enc, err := ops.encodeData(ctx, rec.Data)
+			assert.NoError(t, err)
+			stored[rec.ID] = enc.stored
+		}
+		return len(toReencrypt)
+	}
+	assert.Equal(t, 1, rotate())
+	assert.Equal(t, 0, rotate())
+
+	ops.encryptor.keyring.activeKeyID = "k1"
+	assert.Equal(t, 1, rotate())
+	assert.Equal(t, 0, rotate())
+
+	ops.encryptor.fields = nil
+	assert.Equal(t, 3, rotate())
+	assert.Equal(t, 0, rotate())
+}
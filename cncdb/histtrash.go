@@ -0,0 +1,202 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Query history trash
+//
+// Deleted query history items (both by users and by the garbage collector)
+// can be moved to a trash table so they can be restored within a retention
+// period (see indexer.Conf.QueryHistoryTrashRetentionDays). The table must
+// be created manually:
+//
+// CREATE TABLE camus_query_history_trash (
+//   user_id INT NOT NULL,
+//   query_id VARCHAR(191) NOT NULL,
+//   created INT NOT NULL,
+//   name VARCHAR(255),
+//   deleted DATETIME NOT NULL,
+//   source VARCHAR(20) NOT NULL,
+//   PRIMARY KEY (user_id, created, query_id),
+//   KEY (deleted)
+// );
+
+const (
+	TrashSourceAPI = "api"
+	TrashSourceGC  = "gc"
+)
+
+// TrashedHistoryRecord is a deleted query history item
+type TrashedHistoryRecord struct {
+	QueryID string    `json:"query_id"`
+	UserID  int       `json:"user_id"`
+	Created int64     `json:"created"`
+	Name    string    `json:"name"`
+	Deleted time.Time `json:"deleted"`
+
+	// Source specifies who deleted the item (see TrashSourceAPI, TrashSourceGC)
+	Source string `json:"source"`
+}
+
+func (tr TrashedHistoryRecord) HistoryRecord() HistoryRecord {
+	return HistoryRecord{
+		QueryID: tr.QueryID,
+		UserID:  tr.UserID,
+		Created: tr.Created,
+		Name:    tr.Name,
+	}
+}
+
+// TrashRecords stores deleted query history records to the trash. In case
+// tx is nil, the operation runs outside of a transaction.
+func (ops *MySQLQueryHist) TrashRecords(
+	ctx context.Context, tx *sql.Tx, recs []HistoryRecord, source string) error {
+	if len(recs) == 0 {
+		return nil
+	}
+	if err := ops.writeBudget.Wait(ctx, len(recs)); err != nil {
+		return fmt.Errorf("failed to move query history items to trash: %w", err)
+	}
+	var ex execer = ops.db
+	if tx != nil {
+		ex = tx
+	}
+	now := time.Now().In(ops.tz)
+	for _, rec := range recs {
+		var name sql.NullString
+		if rec.Name != "" {
+			name = sql.NullString{String: rec.Name, Valid: true}
+		}
+		_, err := ex.ExecContext(
+			ctx,
+			"INSERT INTO camus_query_history_trash "+
+				"(user_id, query_id, created, name, deleted, source) "+
+				"VALUES (?, ?, ?, ?, ?, ?) "+
+				"ON DUPLICATE KEY UPDATE deleted = VALUES(deleted), source = VALUES(source)",
+			rec.UserID, rec.QueryID, rec.Created, name, now, source,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to move query history items to trash: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetUserTrash returns up to `limit` most recently deleted
+// query history items of a user
+func (ops *MySQLQueryHist) GetUserTrash(
+	ctx context.Context, userID int, limit int) ([]TrashedHistoryRecord, error) {
+	rows, err := ops.db.QueryContext(
+		ctx,
+		"SELECT user_id, query_id, created, name, deleted, source "+
+			"FROM camus_query_history_trash "+
+			"WHERE user_id = ? ORDER BY deleted DESC, created DESC LIMIT ?",
+		userID, limit,
+	)
+	if err != nil {
+		return []TrashedHistoryRecord{}, fmt.Errorf("failed to get query history trash: %w", err)
+	}
+	defer rows.Close()
+	ans := make([]TrashedHistoryRecord, 0, limit)
+	for rows.Next() {
+		var item TrashedHistoryRecord
+		var name sql.NullString
+		if err := rows.Scan(
+			&item.UserID, &item.QueryID, &item.Created, &name, &item.Deleted, &item.Source); err != nil {
+			return []TrashedHistoryRecord{}, fmt.Errorf("failed to get query history trash: %w", err)
+		}
+		item.Name = name.String
+		ans = append(ans, item)
+	}
+	return ans, nil
+}
+
+// RestoreFromTrash moves a trashed item back to the query history.
+// In case the item is not in the trash, ErrRecordNotFound is returned.
+func (ops *MySQLQueryHist) RestoreFromTrash(
+	ctx context.Context, userID int, created int64, queryID string) (HistoryRecord, error) {
+	if err := ops.writeBudget.Wait(ctx, 2); err != nil {
+		return HistoryRecord{}, fmt.Errorf("failed to restore query history item: %w", err)
+	}
+	tx, err := ops.NewTransaction(ctx)
+	if err != nil {
+		return HistoryRecord{}, fmt.Errorf("failed to restore query history item: %w", err)
+	}
+	row := tx.QueryRowContext(
+		ctx,
+		"SELECT name FROM camus_query_history_trash "+
+			"WHERE user_id = ? AND created = ? AND query_id = ? FOR UPDATE",
+		userID, created, queryID,
+	)
+	var name sql.NullString
+	if err := row.Scan(&name); err == sql.ErrNoRows {
+		tx.Rollback()
+		return HistoryRecord{}, ErrRecordNotFound
+
+	} else if err != nil {
+		tx.Rollback()
+		return HistoryRecord{}, fmt.Errorf("failed to restore query history item: %w", err)
+	}
+	// the item may still exist in case it was deleted just from the index
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT IGNORE INTO kontext_query_history (user_id, query_id, created, name) "+
+			"VALUES (?, ?, ?, ?)",
+		userID, queryID, created, name,
+	)
+	if err != nil {
+		tx.Rollback()
+		return HistoryRecord{}, fmt.Errorf("failed to restore query history item: %w", err)
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM camus_query_history_trash WHERE user_id = ? AND created = ? AND query_id = ?",
+		userID, created, queryID,
+	)
+	if err != nil {
+		tx.Rollback()
+		return HistoryRecord{}, fmt.Errorf("failed to restore query history item: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return HistoryRecord{}, fmt.Errorf("failed to restore query history item: %w", err)
+	}
+	return HistoryRecord{QueryID: queryID, UserID: userID, Created: created, Name: name.String}, nil
+}
+
+// PurgeTrash permanently removes items deleted before `olderThan`
+func (ops *MySQLQueryHist) PurgeTrash(ctx context.Context, olderThan time.Time) (int64, error) {
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return 0, fmt.Errorf("failed to purge query history trash: %w", err)
+	}
+	res, err := ops.db.ExecContext(
+		ctx,
+		"DELETE FROM camus_query_history_trash WHERE deleted < ?", olderThan)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge query history trash: %w", err)
+	}
+	aff, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge query history trash: %w", err)
+	}
+	return aff, nil
+}
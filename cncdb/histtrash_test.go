@@ -0,0 +1,40 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrashedRecordToHistoryRecord(t *testing.T) {
+	tr := TrashedHistoryRecord{
+		QueryID: "abcd",
+		UserID:  42,
+		Created: 1700000000,
+		Name:    "my query",
+		Deleted: time.Now(),
+		Source:  TrashSourceGC,
+	}
+	hRec := tr.HistoryRecord()
+	assert.Equal(t, "abcd", hRec.QueryID)
+	assert.Equal(t, 42, hRec.UserID)
+	assert.Equal(t, int64(1700000000), hRec.Created)
+	assert.Equal(t, "my query", hRec.Name)
+}
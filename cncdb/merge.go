@@ -0,0 +1,222 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// MergeDataNewest keeps data of the most recently created variant
+	MergeDataNewest MergeDataPolicy = "newest"
+
+	// MergeDataOldest keeps data of the earliest created variant
+	MergeDataOldest MergeDataPolicy = "oldest"
+
+	// MergeDataMajority keeps data shared by most of the variants
+	// (in case of a tie, the newest of them is used)
+	MergeDataMajority MergeDataPolicy = "majority"
+
+	// MergeErrorClear makes a merged record erroneous only if all
+	// the variants are erroneous
+	MergeErrorClear MergeErrorPolicy = "clear"
+
+	// MergeErrorPreserve makes a merged record erroneous if any
+	// of the variants is erroneous (unless some of them is permanent
+	// or retired)
+	MergeErrorPreserve MergeErrorPolicy = "preserve"
+
+	recordStatusError = -1
+)
+
+type MergeDataPolicy string
+
+func (p MergeDataPolicy) Validate() bool {
+	return p == MergeDataNewest || p == MergeDataOldest || p == MergeDataMajority
+}
+
+type MergeErrorPolicy string
+
+func (p MergeErrorPolicy) Validate() bool {
+	return p == MergeErrorClear || p == MergeErrorPreserve
+}
+
+// MergePolicy specifies how variants of the same archived
+// record are combined into a single one.
+type MergePolicy struct {
+	Data        MergeDataPolicy  `json:"data"`
+	ErrorStatus MergeErrorPolicy `json:"errorStatus"`
+
+	// NoAccessBump disables counting of the merge itself
+	// as an access to the record
+	NoAccessBump bool `json:"noAccessBump"`
+}
+
+func (p *MergePolicy) ValidateAndDefaults() error {
+	if p.Data == "" {
+		p.Data = MergeDataNewest
+	}
+	if !p.Data.Validate() {
+		return fmt.Errorf("invalid merge data policy `%s`", p.Data)
+	}
+	if p.ErrorStatus == "" {
+		p.ErrorStatus = MergeErrorClear
+	}
+	if !p.ErrorStatus.Validate() {
+		return fmt.Errorf("invalid merge error status policy `%s`", p.ErrorStatus)
+	}
+	return nil
+}
+
+// MergeConflict describes a record property with
+// different values among merged variants
+type MergeConflict struct {
+	Field string `json:"field"`
+
+	// Values contains distinct values found among the variants.
+	// For `data`, hashes are used instead of the actual data.
+	Values []string `json:"values"`
+	Chosen string   `json:"chosen"`
+}
+
+type MergeResult struct {
+	Record      ArchRecord      `json:"record"`
+	NumVariants int             `json:"numVariants"`
+	Conflicts   []MergeConflict `json:"conflicts"`
+}
+
+func (mr MergeResult) HasConflicts() bool {
+	return len(mr.Conflicts) > 0
+}
+
+// chooseData selects a variant providing data for the merged record
+func chooseData(variants []ArchRecord, policy MergeDataPolicy) ArchRecord {
+	ans := variants[0]
+	switch policy {
+	case MergeDataOldest:
+		for _, v := range variants[1:] {
+			if v.Created.Before(ans.Created) {
+				ans = v
+			}
+		}
+	case MergeDataMajority:
+		counts := make(map[string]int)
+		for _, v := range variants {
+			counts[v.Data]++
+		}
+		for _, v := range variants[1:] {
+			if counts[v.Data] > counts[ans.Data] ||
+				counts[v.Data] == counts[ans.Data] && v.Created.After(ans.Created) {
+				ans = v
+			}
+		}
+	default:
+		for _, v := range variants[1:] {
+			if v.Created.After(ans.Created) {
+				ans = v
+			}
+		}
+	}
+	return ans
+}
+
+func distinctValues(variants []ArchRecord, fn func(v ArchRecord) string) []string {
+	ans := make([]string, 0, len(variants))
+	for _, v := range variants {
+		value := fn(v)
+		if !slices.Contains(ans, value) {
+			ans = append(ans, value)
+		}
+	}
+	return ans
+}
+
+// MergeRecords combines variants of a record (`recs`) with a new
+// variant `newRec` according to the policy. Access counts are summed,
+// the earliest creation and ingestion times and the latest access
+// are used. In case the variants differ in data or status, the result
+// contains respective conflicts.
+func MergeRecords(recs []ArchRecord, newRec ArchRecord, policy MergePolicy, tz *time.Location) MergeResult {
+	if len(recs) == 0 {
+		panic("cannot merge empty slice of ArchRecords")
+	}
+	// newRec is the first variant so it is preferred in case of ties
+	variants := make([]ArchRecord, 0, len(recs)+1)
+	variants = append(variants, newRec)
+	for _, rec := range recs {
+		if rec.Created.Equal(newRec.Created) && rec.Data == newRec.Data && rec.NumAccess == newRec.NumAccess {
+			continue // newRec itself
+		}
+		variants = append(variants, rec)
+	}
+	ans := chooseData(variants, policy.Data)
+	ans.NumAccess = 0
+	ans.Permanent = 0
+	ans.Retired = false
+	var numErrors int
+	for _, v := range variants {
+		ans.NumAccess += v.NumAccess
+		if v.Created.Before(ans.Created) && !v.Created.IsZero() {
+			ans.Created = v.Created
+		}
+		if v.LastAccess.After(ans.LastAccess) {
+			ans.LastAccess = v.LastAccess
+		}
+		if !v.Ingested.IsZero() && (ans.Ingested.IsZero() || v.Ingested.Before(ans.Ingested)) {
+			ans.Ingested = v.Ingested
+		}
+		ans.Retired = ans.Retired || v.Retired
+		if v.Permanent == recordStatusError {
+			numErrors++
+
+		} else if v.Permanent > ans.Permanent {
+			ans.Permanent = v.Permanent
+		}
+	}
+	if ans.Permanent == 0 && numErrors > 0 &&
+		(policy.ErrorStatus == MergeErrorPreserve || numErrors == len(variants)) {
+		ans.Permanent = recordStatusError
+	}
+	if !policy.NoAccessBump {
+		ans.NumAccess++
+		ans.LastAccess = time.Now().In(tz)
+	}
+
+	result := MergeResult{
+		Record:      ans,
+		NumVariants: len(variants),
+		Conflicts:   make([]MergeConflict, 0, 2),
+	}
+	dataValues := distinctValues(variants, func(v ArchRecord) string { return DataHash(v.Data) })
+	if len(dataValues) > 1 {
+		result.Conflicts = append(
+			result.Conflicts,
+			MergeConflict{Field: "data", Values: dataValues, Chosen: DataHash(ans.Data)},
+		)
+	}
+	statusValues := distinctValues(variants, func(v ArchRecord) string { return strconv.Itoa(v.Permanent) })
+	if len(statusValues) > 1 {
+		result.Conflicts = append(
+			result.Conflicts,
+			MergeConflict{Field: "permanent", Values: statusValues, Chosen: strconv.Itoa(ans.Permanent)},
+		)
+	}
+	return result
+}
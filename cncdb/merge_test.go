@@ -0,0 +1,79 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testingVariants() ([]ArchRecord, ArchRecord) {
+	t0 := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	recs := []ArchRecord{
+		{ID: "abc", Data: `{"q":["a"]}`, Created: t0, NumAccess: 2, LastAccess: t0.Add(time.Hour)},
+		{ID: "abc", Data: `{"q":["b"]}`, Created: t0.Add(time.Minute), NumAccess: 1, LastAccess: t0, Permanent: -1},
+	}
+	newRec := ArchRecord{ID: "abc", Data: `{"q":["b"]}`, Created: t0.Add(2 * time.Minute)}
+	return recs, newRec
+}
+
+func TestMergeRecordsDefaultPolicy(t *testing.T) {
+	recs, newRec := testingVariants()
+	var policy MergePolicy
+	assert.NoError(t, policy.ValidateAndDefaults())
+	ans := MergeRecords(recs, newRec, policy, time.UTC)
+	assert.Equal(t, 3, ans.NumVariants)
+	assert.Equal(t, newRec.Data, ans.Record.Data)
+	assert.Equal(t, recs[0].Created, ans.Record.Created)
+	assert.Equal(t, 4, ans.Record.NumAccess)
+	assert.Equal(t, 0, ans.Record.Permanent)
+	assert.Len(t, ans.Conflicts, 2)
+	assert.Equal(t, "data", ans.Conflicts[0].Field)
+	assert.Equal(t, DataHash(newRec.Data), ans.Conflicts[0].Chosen)
+}
+
+func TestMergeRecordsCustomPolicy(t *testing.T) {
+	recs, newRec := testingVariants()
+	policy := MergePolicy{Data: MergeDataOldest, ErrorStatus: MergeErrorPreserve, NoAccessBump: true}
+	ans := MergeRecords(recs, newRec, policy, time.UTC)
+	assert.Equal(t, recs[0].Data, ans.Record.Data)
+	assert.Equal(t, 3, ans.Record.NumAccess)
+	assert.Equal(t, recs[0].LastAccess, ans.Record.LastAccess)
+	assert.Equal(t, -1, ans.Record.Permanent)
+
+	policy.Data = MergeDataMajority
+	ans = MergeRecords(recs, newRec, policy, time.UTC)
+	assert.Equal(t, newRec.Data, ans.Record.Data)
+}
+
+func TestMergeRecordsSkipsNewRecInVariants(t *testing.T) {
+	recs, _ := testingVariants()
+	ans := MergeRecords(recs, recs[0], MergePolicy{NoAccessBump: true}, time.UTC)
+	assert.Equal(t, 2, ans.NumVariants)
+	assert.Equal(t, 3, ans.Record.NumAccess)
+}
+
+func TestMergeRecordsKeepsPinnedRetired(t *testing.T) {
+	recs, newRec := testingVariants()
+	recs[0].Permanent = 1
+	recs[1].Retired = true
+	ans := MergeRecords(recs, newRec, MergePolicy{NoAccessBump: true}, time.UTC)
+	assert.Equal(t, 1, ans.Record.Permanent)
+	assert.True(t, ans.Record.Retired)
+}
@@ -0,0 +1,537 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	shadowReadTimeout = 10 * time.Second
+)
+
+// MirrorConf configures a secondary archive database all the writes
+// are mirrored to (e.g. during a migration to new hardware).
+type MirrorConf struct {
+	DB *DBConf `json:"db"`
+
+	// ShadowReads enables comparing of (cheap) primary reads with
+	// the secondary database. Divergences are logged.
+	ShadowReads bool `json:"shadowReads"`
+}
+
+func (conf *MirrorConf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.DB == nil || conf.DB.Host == "" || conf.DB.Name == "" {
+		return fmt.Errorf("missing secondary database in mirror configuration")
+	}
+	return nil
+}
+
+// MirroredConcArch writes to both the primary and the secondary
+// archive while reading from the primary only. Primary is the
+// authority - i.e. a failed write to the secondary is only logged
+// and the respective record must be fixed via Backfill.
+type MirroredConcArch struct {
+	primary     IConcArchOps
+	secondary   IConcArchOps
+	shadowReads bool
+}
+
+func (m *MirroredConcArch) logSecondaryError(err error, op, concID string) {
+	log.Error().
+		Err(err).
+		Str("operation", op).
+		Str("concId", concID).
+		Msg("failed to mirror write to secondary archive, backfill needed")
+}
+
+func (m *MirroredConcArch) logDivergence(op, concID string, primary, secondary any) {
+	log.Warn().
+		Str("operation", op).
+		Str("concId", concID).
+		Any("primary", primary).
+		Any("secondary", secondary).
+		Msg("secondary archive diverges from primary")
+}
+
+// shadowRead runs fn (a secondary read and comparison) in background
+// so it does not affect response time of the primary read
+func (m *MirroredConcArch) shadowRead(ctx context.Context, fn func(ctx context.Context)) {
+	if !m.shadowReads {
+		return
+	}
+	go func() {
+		sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shadowReadTimeout)
+		defer cancel()
+		fn(sctx)
+	}()
+}
+
+func (m *MirroredConcArch) NewTransaction(ctx context.Context) (*sql.Tx, error) {
+	return m.primary.NewTransaction(ctx)
+}
+
+func (m *MirroredConcArch) LoadRecentNRecords(ctx context.Context, num int) ([]ArchRecord, error) {
+	return m.primary.LoadRecentNRecords(ctx, num)
+}
+
+func (m *MirroredConcArch) LoadRecordsFromDate(ctx context.Context, fromDate time.Time, maxItems int) ([]ArchRecord, error) {
+	return m.primary.LoadRecordsFromDate(ctx, fromDate, maxItems)
+}
+
+func (m *MirroredConcArch) LoadRecordsAfter(
+	ctx context.Context, from RecordID, maxItems int) ([]ArchRecord, error) {
+	return m.primary.LoadRecordsAfter(ctx, from, maxItems)
+}
+
+func (m *MirroredConcArch) ContainsRecord(ctx context.Context, concID string) (bool, error) {
+	ans, err := m.primary.ContainsRecord(ctx, concID)
+	if err != nil {
+		return ans, err
+	}
+	m.shadowRead(ctx, func(ctx context.Context) {
+		sans, err := m.secondary.ContainsRecord(ctx, concID)
+		if err != nil {
+			log.Warn().Err(err).Str("concId", concID).Msg("failed to perform shadow read")
+			return
+		}
+		if sans != ans {
+			m.logDivergence("ContainsRecord", concID, ans, sans)
+		}
+	})
+	return ans, nil
+}
+
+// recordVariantsDiffer compares variants of a record as stored
+// in two archives
+func recordVariantsDiffer(recs1, recs2 []ArchRecord) bool {
+	if len(recs1) != len(recs2) {
+		return true
+	}
+	cmp := func(a, b ArchRecord) int { return a.Created.Compare(b.Created) }
+	recs1 = slices.SortedFunc(slices.Values(recs1), cmp)
+	recs2 = slices.SortedFunc(slices.Values(recs2), cmp)
+	for i := range recs1 {
+		if !recs1[i].Created.Equal(recs2[i].Created) ||
+			recs1[i].Data != recs2[i].Data ||
+			recs1[i].Permanent != recs2[i].Permanent ||
+			recs1[i].Retired != recs2[i].Retired ||
+			recs1[i].NumAccess != recs2[i].NumAccess {
+			return true
+		}
+	}
+	return false
+}
+
+func variantsSummary(recs []ArchRecord) []string {
+	ans := make([]string, len(recs))
+	for i, rec := range recs {
+		ans[i] = fmt.Sprintf(
+			"%s/%s/%d/%t", rec.Created.Format(time.RFC3339), DataHash(rec.Data), rec.Permanent, rec.Retired)
+	}
+	return ans
+}
+
+func (m *MirroredConcArch) LoadRecordsByID(ctx context.Context, concID string) ([]ArchRecord, error) {
+	ans, err := m.primary.LoadRecordsByID(ctx, concID)
+	if err != nil {
+		return ans, err
+	}
+	primaryRecs := slices.Clone(ans)
+	m.shadowRead(ctx, func(ctx context.Context) {
+		srecs, err := m.secondary.LoadRecordsByID(ctx, concID)
+		if err != nil {
+			log.Warn().Err(err).Str("concId", concID).Msg("failed to perform shadow read")
+			return
+		}
+		if recordVariantsDiffer(primaryRecs, srecs) {
+			m.logDivergence(
+				"LoadRecordsByID", concID, variantsSummary(primaryRecs), variantsSummary(srecs))
+		}
+	})
+	return ans, nil
+}
+
+func (m *MirroredConcArch) InsertRecord(ctx context.Context, rec ArchRecord) error {
+	if err := m.primary.InsertRecord(ctx, rec); err != nil {
+		return err
+	}
+	if err := m.secondary.InsertRecord(ctx, rec); err != nil {
+		m.logSecondaryError(err, "InsertRecord", rec.ID)
+	}
+	return nil
+}
+
+func (m *MirroredConcArch) SetRecordRetired(ctx context.Context, id string) error {
+	if err := m.primary.SetRecordRetired(ctx, id); err != nil {
+		return err
+	}
+	if err := m.secondary.SetRecordRetired(ctx, id); err != nil {
+		m.logSecondaryError(err, "SetRecordRetired", id)
+	}
+	return nil
+}
+
+func (m *MirroredConcArch) IsRecordRetired(ctx context.Context, id string) (bool, error) {
+	return m.primary.IsRecordRetired(ctx, id)
+}
+
+func (m *MirroredConcArch) UpdateRecordStatus(ctx context.Context, id string, status int) error {
+	if err := m.primary.UpdateRecordStatus(ctx, id, status); err != nil {
+		return err
+	}
+	if err := m.secondary.UpdateRecordStatus(ctx, id, status); err != nil {
+		m.logSecondaryError(err, "UpdateRecordStatus", id)
+	}
+	return nil
+}
+
+func (m *MirroredConcArch) UpdateRecordData(ctx context.Context, id string, created time.Time, data string) error {
+	if err := m.primary.UpdateRecordData(ctx, id, created, data); err != nil {
+		return err
+	}
+	if err := m.secondary.UpdateRecordData(ctx, id, created, data); err != nil {
+		m.logSecondaryError(err, "UpdateRecordData", id)
+	}
+	return nil
+}
+
+func (m *MirroredConcArch) RemoveRecordsByID(ctx context.Context, concID string) error {
+	if err := m.primary.RemoveRecordsByID(ctx, concID); err != nil {
+		return err
+	}
+	if err := m.secondary.RemoveRecordsByID(ctx, concID); err != nil {
+		m.logSecondaryError(err, "RemoveRecordsByID", concID)
+	}
+	return nil
+}
+
+// replaceInSecondary makes the secondary archive contain exactly
+// the `recs` variants of the record `concID`
+func (m *MirroredConcArch) replaceInSecondary(ctx context.Context, concID string, recs []ArchRecord) error {
+	if err := m.secondary.RemoveRecordsByID(ctx, concID); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := m.secondary.InsertRecord(ctx, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeduplicateInArchive merges the record in the primary archive and
+// stores the very same merged record to the secondary one (i.e. the merge
+// is not performed independently to prevent different results).
+func (m *MirroredConcArch) DeduplicateInArchive(ctx context.Context, curr []ArchRecord, rec ArchRecord) (MergeResult, error) {
+	ans, err := m.primary.DeduplicateInArchive(ctx, curr, rec)
+	if err != nil {
+		return ans, err
+	}
+	if err := m.replaceInSecondary(ctx, rec.ID, []ArchRecord{ans.Record}); err != nil {
+		m.logSecondaryError(err, "DeduplicateInArchive", rec.ID)
+	}
+	return ans, nil
+}
+
+func (m *MirroredConcArch) GetArchSizesByYears(ctx context.Context, forceLoad bool) ([][2]int, error) {
+	return m.primary.GetArchSizesByYears(ctx, forceLoad)
+}
+
+func (m *MirroredConcArch) GetLargestRecords(
+	ctx context.Context, fromDate time.Time, limit int, forceLoad bool) ([]RecordSize, error) {
+	return m.primary.GetLargestRecords(ctx, fromDate, limit, forceLoad)
+}
+
+func (m *MirroredConcArch) SampleRecords(
+	ctx context.Context, fromDate, toDate time.Time, num int, forceLoad bool) ([]ArchRecord, error) {
+	return m.primary.SampleRecords(ctx, fromDate, toDate, num, forceLoad)
+}
+
+func (m *MirroredConcArch) LoadRecordIDs(
+	ctx context.Context, fromCreated time.Time, fromID string, limit int) ([]RecordID, error) {
+	return m.primary.LoadRecordIDs(ctx, fromCreated, fromID, limit)
+}
+
+func (m *MirroredConcArch) GetSubcorpusProps(ctx context.Context, subcID string) (SubcProps, error) {
+	return m.primary.GetSubcorpusProps(ctx, subcID)
+}
+
+// MirrorBackfillStats describes a result of a Backfill run. For query
+// history, only NumChecked and NumFailed are determined.
+type MirrorBackfillStats struct {
+	NumChecked  int `json:"numChecked"`
+	NumMissing  int `json:"numMissing"`
+	NumDiverged int `json:"numDiverged"`
+	NumFailed   int `json:"numFailed"`
+}
+
+// Backfill goes through primary archive records created since fromDate
+// and makes sure the secondary archive contains the same variants.
+// The process can be interrupted and run again at any time.
+func (m *MirroredConcArch) Backfill(
+	ctx context.Context, fromDate time.Time, chunkSize int) (MirrorBackfillStats, error) {
+	var stats MirrorBackfillStats
+	from := RecordID{Created: fromDate}
+	for {
+		select {
+		case <-ctx.Done():
+			log.Warn().Any("stats", stats).Msg("mirror backfill interrupted")
+			return stats, fmt.Errorf("failed to backfill secondary archive: %w", ctx.Err())
+		default:
+		}
+		ids, err := m.primary.LoadRecordIDs(ctx, from.Created, from.ID, chunkSize)
+		if err != nil {
+			return stats, fmt.Errorf("failed to backfill secondary archive: %w", err)
+		}
+		// variants of a record are compared at once so we skip
+		// the other variants within the chunk
+		processed := make(map[string]bool)
+		for _, item := range ids {
+			if processed[item.ID] {
+				continue
+			}
+			processed[item.ID] = true
+			stats.NumChecked++
+			variants, err := m.primary.LoadRecordsByID(ctx, item.ID)
+			if err != nil {
+				return stats, fmt.Errorf("failed to backfill secondary archive: %w", err)
+			}
+			svariants, err := m.secondary.LoadRecordsByID(ctx, item.ID)
+			if err != nil {
+				return stats, fmt.Errorf("failed to backfill secondary archive: %w", err)
+			}
+			if !recordVariantsDiffer(variants, svariants) {
+				continue
+			}
+			if len(svariants) == 0 {
+				stats.NumMissing++
+
+			} else {
+				stats.NumDiverged++
+			}
+			if err := m.replaceInSecondary(ctx, item.ID, variants); err != nil {
+				log.Error().Err(err).Str("concId", item.ID).Msg("failed to backfill record")
+				stats.NumFailed++
+			}
+		}
+		if len(ids) < chunkSize {
+			break
+		}
+		from = ids[len(ids)-1]
+		log.Info().Any("stats", stats).Time("lastCreated", from.Created).Msg("backfilled chunk of records")
+	}
+	log.Info().Any("stats", stats).Msg("mirror backfill finished")
+	return stats, nil
+}
+
+// --------------------------------------------------------------
+
+// MirroredQueryHist writes to both the primary and the secondary
+// query history while reading from the primary only.
+type MirroredQueryHist struct {
+	primary   IQHistArchOps
+	secondary IQHistArchOps
+}
+
+func (m *MirroredQueryHist) logSecondaryError(err error, op string) {
+	log.Error().
+		Err(err).
+		Str("operation", op).
+		Msg("failed to mirror write to secondary query history")
+}
+
+func (m *MirroredQueryHist) NewTransaction(ctx context.Context) (*sql.Tx, error) {
+	return m.primary.NewTransaction(ctx)
+}
+
+func (m *MirroredQueryHist) GetAllUsersWithSomeRecords(ctx context.Context) ([]int, error) {
+	return m.primary.GetAllUsersWithSomeRecords(ctx)
+}
+
+func (m *MirroredQueryHist) GetUserRecords(ctx context.Context, userID int, numItems int) ([]HistoryRecord, error) {
+	return m.primary.GetUserRecords(ctx, userID, numItems)
+}
+
+func (m *MirroredQueryHist) MarkOldRecords(ctx context.Context, numPreserve int) (int64, error) {
+	ans, err := m.primary.MarkOldRecords(ctx, numPreserve)
+	if err != nil {
+		return ans, err
+	}
+	if _, err := m.secondary.MarkOldRecords(ctx, numPreserve); err != nil {
+		m.logSecondaryError(err, "MarkOldRecords")
+	}
+	return ans, nil
+}
+
+func (m *MirroredQueryHist) GarbageCollectRecords(ctx context.Context, userID int) (int64, error) {
+	ans, err := m.primary.GarbageCollectRecords(ctx, userID)
+	if err != nil {
+		return ans, err
+	}
+	if _, err := m.secondary.GarbageCollectRecords(ctx, userID); err != nil {
+		m.logSecondaryError(err, "GarbageCollectRecords")
+	}
+	return ans, nil
+}
+
+func (m *MirroredQueryHist) GetUserGarbageRecords(ctx context.Context, userID int) ([]HistoryRecord, error) {
+	return m.primary.GetUserGarbageRecords(ctx, userID)
+}
+
+// RemoveRecord removes the record from both databases. As the transaction
+// belongs to the primary database, the secondary removal runs without it.
+func (m *MirroredQueryHist) RemoveRecord(ctx context.Context, tx *sql.Tx, created int64, userID int, queryID string) error {
+	if err := m.primary.RemoveRecord(ctx, tx, created, userID, queryID); err != nil {
+		return err
+	}
+	if err := m.secondary.RemoveRecord(ctx, nil, created, userID, queryID); err != nil {
+		m.logSecondaryError(err, "RemoveRecord")
+	}
+	return nil
+}
+
+func (m *MirroredQueryHist) GetPendingDeletionRecords(ctx context.Context, tx *sql.Tx, maxItems int) ([]HistoryRecord, error) {
+	return m.primary.GetPendingDeletionRecords(ctx, tx, maxItems)
+}
+
+func (m *MirroredQueryHist) LoadRecentNHistory(ctx context.Context, num int) ([]HistoryRecord, error) {
+	return m.primary.LoadRecentNHistory(ctx, num)
+}
+
+func (m *MirroredQueryHist) TableSize(ctx context.Context) (int64, error) {
+	return m.primary.TableSize(ctx)
+}
+
+func (m *MirroredQueryHist) LoadHistoryRecords(
+	ctx context.Context, from HistoryRecord, limit int) ([]HistoryRecord, error) {
+	return m.primary.LoadHistoryRecords(ctx, from, limit)
+}
+
+func (m *MirroredQueryHist) UpsertHistoryRecords(ctx context.Context, recs []HistoryRecord) error {
+	if err := m.primary.UpsertHistoryRecords(ctx, recs); err != nil {
+		return err
+	}
+	if err := m.secondary.UpsertHistoryRecords(ctx, recs); err != nil {
+		m.logSecondaryError(err, "UpsertHistoryRecords")
+	}
+	return nil
+}
+
+func (m *MirroredQueryHist) GetPendingDeletionBacklog(ctx context.Context) (PendingDeletionBacklog, error) {
+	return m.primary.GetPendingDeletionBacklog(ctx)
+}
+
+func (m *MirroredQueryHist) TrashRecords(ctx context.Context, tx *sql.Tx, recs []HistoryRecord, source string) error {
+	if err := m.primary.TrashRecords(ctx, tx, recs, source); err != nil {
+		return err
+	}
+	if err := m.secondary.TrashRecords(ctx, nil, recs, source); err != nil {
+		m.logSecondaryError(err, "TrashRecords")
+	}
+	return nil
+}
+
+func (m *MirroredQueryHist) GetUserTrash(ctx context.Context, userID int, limit int) ([]TrashedHistoryRecord, error) {
+	return m.primary.GetUserTrash(ctx, userID, limit)
+}
+
+func (m *MirroredQueryHist) RestoreFromTrash(ctx context.Context, userID int, created int64, queryID string) (HistoryRecord, error) {
+	ans, err := m.primary.RestoreFromTrash(ctx, userID, created, queryID)
+	if err != nil {
+		return ans, err
+	}
+	if _, err := m.secondary.RestoreFromTrash(ctx, userID, created, queryID); err != nil {
+		m.logSecondaryError(err, "RestoreFromTrash")
+	}
+	return ans, nil
+}
+
+func (m *MirroredQueryHist) PurgeTrash(ctx context.Context, olderThan time.Time) (int64, error) {
+	ans, err := m.primary.PurgeTrash(ctx, olderThan)
+	if err != nil {
+		return ans, err
+	}
+	if _, err := m.secondary.PurgeTrash(ctx, olderThan); err != nil {
+		m.logSecondaryError(err, "PurgeTrash")
+	}
+	return ans, nil
+}
+
+// Backfill copies primary query history records created since fromDate
+// to the secondary query history (names of existing records are updated).
+// This is necessary as the history records are inserted by KonText which
+// writes to the primary database only. The process can be interrupted
+// and run again at any time. Records removed from the primary history
+// before mirroring was enabled are not removed from the secondary one.
+func (m *MirroredQueryHist) Backfill(
+	ctx context.Context, fromDate time.Time, chunkSize int) (MirrorBackfillStats, error) {
+	var stats MirrorBackfillStats
+	from := HistoryRecord{Created: fromDate.Unix(), UserID: -1}
+	for {
+		select {
+		case <-ctx.Done():
+			log.Warn().Any("stats", stats).Msg("query history mirror backfill interrupted")
+			return stats, fmt.Errorf("failed to backfill secondary query history: %w", ctx.Err())
+		default:
+		}
+		hRecs, err := m.primary.LoadHistoryRecords(ctx, from, chunkSize)
+		if err != nil {
+			return stats, fmt.Errorf("failed to backfill secondary query history: %w", err)
+		}
+		stats.NumChecked += len(hRecs)
+		if err := m.secondary.UpsertHistoryRecords(ctx, hRecs); err != nil {
+			log.Error().Err(err).Msg("failed to backfill chunk of query history records")
+			stats.NumFailed += len(hRecs)
+		}
+		if len(hRecs) < chunkSize {
+			break
+		}
+		from = hRecs[len(hRecs)-1]
+		log.Info().Any("stats", stats).Int64("lastCreated", from.Created).Msg("backfilled chunk of query history")
+	}
+	log.Info().Any("stats", stats).Msg("query history mirror backfill finished")
+	return stats, nil
+}
+
+// NewMirroredOps creates operations writing to both primary and secondary
+// databases. Reads are always performed on the primary one.
+func NewMirroredOps(
+	primaryArch IConcArchOps,
+	primaryHist IQHistArchOps,
+	secondaryArch IConcArchOps,
+	secondaryHist IQHistArchOps,
+	shadowReads bool,
+) (*MirroredConcArch, *MirroredQueryHist) {
+	return &MirroredConcArch{
+		primary:     primaryArch,
+		secondary:   secondaryArch,
+		shadowReads: shadowReads,
+	}, &MirroredQueryHist{
+		primary:   primaryHist,
+		secondary: secondaryHist,
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingConcArch struct {
+	DummyConcArchSQL
+	numInserts int
+}
+
+func (db *failingConcArch) InsertRecord(ctx context.Context, rec ArchRecord) error {
+	db.numInserts++
+	return errors.New("secondary unavailable")
+}
+
+type memQHist struct {
+	DummyQHistSQL
+	items []HistoryRecord
+}
+
+func (db *memQHist) LoadHistoryRecords(
+	ctx context.Context, from HistoryRecord, limit int) ([]HistoryRecord, error) {
+	ans := make([]HistoryRecord, 0, limit)
+	for _, item := range db.items {
+		if item.Created > from.Created || item.Created == from.Created &&
+			(item.UserID > from.UserID || item.UserID == from.UserID && item.QueryID > from.QueryID) {
+			ans = append(ans, item)
+		}
+		if len(ans) == limit {
+			break
+		}
+	}
+	return ans, nil
+}
+
+func (db *memQHist) UpsertHistoryRecords(ctx context.Context, recs []HistoryRecord) error {
+	db.items = append(db.items, recs...)
+	return nil
+}
+
+func TestRecordVariantsDiffer(t *testing.T) {
+	t1 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	recs1 := []ArchRecord{{ID: "a", Data: "{}", Created: t1}, {ID: "a", Data: "{\"x\":1}", Created: t2}}
+	recs2 := []ArchRecord{recs1[1], recs1[0]}
+	assert.False(t, recordVariantsDiffer(recs1, recs2))
+
+	recs2[0].Permanent = 1
+	assert.True(t, recordVariantsDiffer(recs1, recs2))
+	assert.True(t, recordVariantsDiffer(recs1, recs1[:1]))
+}
+
+func TestMirroredInsertIgnoresSecondaryError(t *testing.T) {
+	secondary := &failingConcArch{}
+	arch, _ := NewMirroredOps(
+		&DummyConcArchSQL{}, nil, secondary, nil, false)
+	assert.NoError(t, arch.InsertRecord(context.Background(), ArchRecord{ID: "a"}))
+	assert.Equal(t, 1, secondary.numInserts)
+}
+
+func TestMirroredQueryHistBackfill(t *testing.T) {
+	primary := &memQHist{}
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		// records of different users share the same creation time and query ID
+		primary.items = append(primary.items, HistoryRecord{
+			QueryID: fmt.Sprintf("q%d", i%2),
+			UserID:  i / 2,
+			Created: created.Unix() + int64(i/4),
+		})
+	}
+	secondary := &memQHist{}
+	_, qHist := NewMirroredOps(nil, primary, nil, secondary, false)
+	stats, err := qHist.Backfill(context.Background(), created, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, stats.NumChecked)
+	assert.ElementsMatch(t, primary.items, secondary.items)
+}
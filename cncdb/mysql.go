@@ -21,6 +21,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -28,7 +29,7 @@ import (
 )
 
 const (
-	maxRecentRecords = 1000
+	MaxRecentRecords = 1000
 )
 
 type DBConf struct {
@@ -38,6 +39,51 @@ type DBConf struct {
 	User     string `json:"user"`
 	Password string `json:"password"`
 	PoolSize int    `json:"poolSize"`
+
+	// ContentAddressedData enables storing of archived records' data
+	// in a content-addressed blob table (see blobs.go). Please note
+	// that records encrypted as a whole are never deduplicated
+	// (see encryption.go).
+	ContentAddressedData bool `json:"contentAddressedData"`
+
+	// MergePolicy specifies how variants of the same record are merged
+	// (used by the archiver's deduplicator, by the cleaner and by the `/fix` action)
+	MergePolicy MergePolicy `json:"mergePolicy"`
+
+	// IngestionTimeColumn enables storing of the time records were
+	// archived by Camus (which may differ from their creation time).
+	// The archive table must contain the `ingested` column:
+	//
+	// ALTER TABLE kontext_conc_persistence ADD COLUMN ingested DATETIME NULL;
+	IngestionTimeColumn bool `json:"ingestionTimeColumn"`
+
+	// RetiredColumn enables storing of the flag marking records referring
+	// to a withdrawn corpus (required by the `retire-corpus` action).
+	// The archive table must contain the `retired` column:
+	//
+	// ALTER TABLE kontext_conc_persistence ADD COLUMN retired TINYINT NOT NULL DEFAULT 0;
+	RetiredColumn bool `json:"retiredColumn"`
+
+	// ChecksumColumn enables storing and verification of checksums
+	// of archived records' payloads (see checksum.go for the required
+	// `data_checksum` column)
+	ChecksumColumn bool `json:"checksumColumn"`
+
+	// WriteBudget limits the total rate of writes to the database
+	// (shared by the archiver, cleaner, history GC etc.)
+	WriteBudget *WriteBudgetConf `json:"writeBudget"`
+
+	// Mirror enables mirroring of all the writes to a secondary
+	// database (see mirror.go)
+	Mirror *MirrorConf `json:"mirror"`
+
+	// Replica configures a read-only database for heavy read
+	// operations (see replica.go)
+	Replica *ReplicaConf `json:"replica"`
+
+	// Encryption enables encryption of archived records' data
+	// (see encryption.go)
+	Encryption *EncryptionConf `json:"encryption"`
 }
 
 func DBOpen(conf *DBConf) (*sql.DB, error) {
@@ -57,11 +103,13 @@ func DBOpen(conf *DBConf) (*sql.DB, error) {
 	return db, nil
 }
 
+// generateRows reads records selected via MySQLConcArch.recordCols
 func generateRows(sqlRows *sql.Rows, expectedSize int) ([]ArchRecord, error) {
 	ans := make([]ArchRecord, 0, expectedSize)
 	for sqlRows.Next() {
 		var item ArchRecord
-		err := sqlRows.Scan(&item.ID, &item.Data, &item.Created, &item.NumAccess, &item.LastAccess, &item.Permanent)
+		err := sqlRows.Scan(
+			&item.ID, &item.Data, &item.Created, &item.NumAccess, &item.LastAccess, &item.Permanent, &item.Retired)
 		if err != nil {
 			return []ArchRecord{}, fmt.Errorf("failed to load recent records: %w", err)
 		}
@@ -73,51 +121,181 @@ func generateRows(sqlRows *sql.Rows, expectedSize int) ([]ArchRecord, error) {
 // -----------------------------------------
 
 type MySQLConcArch struct {
-	db  *sql.DB
-	tz  *time.Location
-	ctx context.Context
+	db *sql.DB
+	tz *time.Location
+
+	// compressAbove specifies data size (in bytes) above which
+	// the data are stored compressed. Zero means "never compress".
+	compressAbove int
+
+	// contentAddressed specifies whether the data are stored in
+	// the content-addressed blob table (see blobs.go)
+	contentAddressed bool
+
+	// ingestionTime specifies whether the `ingested` column
+	// is available (see DBConf.IngestionTimeColumn)
+	ingestionTime bool
+
+	// checksums specifies whether the `data_checksum` column
+	// is available (see DBConf.ChecksumColumn)
+	checksums bool
+
+	// retired specifies whether the `retired` column
+	// is available (see DBConf.RetiredColumn)
+	retired bool
+
+	mergePolicy MergePolicy
+
+	// encryptor is used to encrypt data before storing
+	// (see EnableEncryption)
+	encryptor *dataEncryptor
+
+	// writeBudget limits rate of write operations (see SetWriteBudget)
+	writeBudget *WriteBudget
+
+	// replica is an optional read-only database (see SetReplica)
+	replica *replica
+}
+
+// EnableCompression makes the archive store data larger than
+// minSize in a compressed form. Reading compressed data is always
+// supported, regardless of this setting.
+func (ops *MySQLConcArch) EnableCompression(minSize int) {
+	ops.compressAbove = minSize
+}
+
+// SetMergePolicy specifies how record variants are merged
+// during deduplication (see DeduplicateInArchive)
+func (ops *MySQLConcArch) SetMergePolicy(policy MergePolicy) {
+	ops.mergePolicy = policy
+}
+
+// EnableIngestionTime makes the archive store and load
+// ingestion time of records (see DBConf.IngestionTimeColumn).
+func (ops *MySQLConcArch) EnableIngestionTime() {
+	ops.ingestionTime = true
 }
 
-func (ops *MySQLConcArch) NewTransaction() (*sql.Tx, error) {
-	return ops.db.BeginTx(ops.ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+// EnableRetiredColumn makes the archive store and load
+// the retired flag of records (see DBConf.RetiredColumn).
+func (ops *MySQLConcArch) EnableRetiredColumn() {
+	ops.retired = true
 }
 
-func (ops *MySQLConcArch) LoadRecentNRecords(num int) ([]ArchRecord, error) {
+func (ops *MySQLConcArch) retiredCol() string {
+	if ops.retired {
+		return "retired"
+	}
+	return "0"
+}
+
+// recordCols returns columns to be selected for generateRows
+func (ops *MySQLConcArch) recordCols() string {
+	return "id, data, created, num_access, last_access, permanent, " + ops.retiredCol()
+}
+
+func (ops *MySQLConcArch) NewTransaction(ctx context.Context) (*sql.Tx, error) {
+	return ops.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+}
+
+func (ops *MySQLConcArch) LoadRecentNRecords(ctx context.Context, num int) ([]ArchRecord, error) {
 	// we use helperLimit to help partitioned table with millions of items
 	// to avoid going through all the partitions (or is the query planner
 	// able to determine it from `order by created DESC limit X` ?)
 	helperLimit := time.Now().In(ops.tz).Add(-180 * 24 * time.Hour)
-	if num > maxRecentRecords {
-		panic(fmt.Sprintf("cannot load more than %d records at a time", maxRecentRecords))
+	if num > MaxRecentRecords {
+		return []ArchRecord{}, fmt.Errorf(
+			"failed to load recent records: cannot load more than %d records at a time", MaxRecentRecords)
 	}
-	rows, err := ops.db.QueryContext(
-		ops.ctx,
-		"SELECT id, data, created, num_access, last_access, permanent "+
+	rows, err := ops.readDB(ctx, false).QueryContext(
+		ctx,
+		"SELECT "+ops.recordCols()+" "+
 			"FROM kontext_conc_persistence "+
 			"WHERE created >= ? "+
 			"ORDER BY created DESC LIMIT ?", helperLimit, num)
 	if err != nil {
 		return []ArchRecord{}, fmt.Errorf("failed to load recent records: %w", err)
 	}
-	return generateRows(rows, num)
+	ans, err := generateRows(rows, num)
+	if err != nil {
+		return []ArchRecord{}, err
+	}
+	if err := ops.resolveData(ctx, ans); err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to load recent records: %w", err)
+	}
+	return ans, nil
 }
 
-func (ops *MySQLConcArch) LoadRecordsFromDate(fromDate time.Time, maxItems int) ([]ArchRecord, error) {
-	rows, err := ops.db.QueryContext(
-		ops.ctx,
-		"SELECT id, data, created, num_access, last_access, permanent "+
+func (ops *MySQLConcArch) LoadRecordsFromDate(ctx context.Context, fromDate time.Time, maxItems int) ([]ArchRecord, error) {
+	rows, err := ops.readDB(ctx, true).QueryContext(
+		ctx,
+		"SELECT "+ops.recordCols()+" "+
 			"FROM kontext_conc_persistence "+
 			"WHERE created >= ? "+
 			"ORDER BY created LIMIT ?", fromDate, maxItems)
 	if err != nil {
 		return []ArchRecord{}, fmt.Errorf("failed to load records: %w", err)
 	}
-	return generateRows(rows, maxItems)
+	ans, err := generateRows(rows, maxItems)
+	if err != nil {
+		return []ArchRecord{}, err
+	}
+	if err := ops.resolveData(ctx, ans); err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to load records: %w", err)
+	}
+	return ans, nil
 }
 
-func (ops *MySQLConcArch) ContainsRecord(concID string) (bool, error) {
-	row := ops.db.QueryRowContext(
-		ops.ctx,
+func (ops *MySQLConcArch) LoadRecordsAfter(
+	ctx context.Context, from RecordID, maxItems int) ([]ArchRecord, error) {
+	rows, err := ops.readDB(ctx, false).QueryContext(
+		ctx,
+		"SELECT "+ops.recordCols()+" "+
+			"FROM kontext_conc_persistence "+
+			"WHERE created > ? OR (created = ? AND id > ?) "+
+			"ORDER BY created, id LIMIT ?",
+		from.Created, from.Created, from.ID, maxItems,
+	)
+	if err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to load records: %w", err)
+	}
+	ans, err := generateRows(rows, maxItems)
+	if err != nil {
+		return []ArchRecord{}, err
+	}
+	if err := ops.resolveData(ctx, ans); err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to load records: %w", err)
+	}
+	return ans, nil
+}
+
+func (ops *MySQLConcArch) LoadRecordIDs(
+	ctx context.Context, fromCreated time.Time, fromID string, limit int) ([]RecordID, error) {
+	rows, err := ops.readDB(ctx, true).QueryContext(
+		ctx,
+		"SELECT id, created FROM kontext_conc_persistence "+
+			"WHERE created > ? OR (created = ? AND id > ?) "+
+			"ORDER BY created, id LIMIT ?",
+		fromCreated, fromCreated, fromID, limit,
+	)
+	if err != nil {
+		return []RecordID{}, fmt.Errorf("failed to load record IDs: %w", err)
+	}
+	defer rows.Close()
+	ans := make([]RecordID, 0, limit)
+	for rows.Next() {
+		var item RecordID
+		if err := rows.Scan(&item.ID, &item.Created); err != nil {
+			return []RecordID{}, fmt.Errorf("failed to load record IDs: %w", err)
+		}
+		ans = append(ans, item)
+	}
+	return ans, nil
+}
+
+func (ops *MySQLConcArch) ContainsRecord(ctx context.Context, concID string) (bool, error) {
+	row := ops.readDB(ctx, false).QueryRowContext(
+		ctx,
 		"SELECT COUNT(*) FROM kontext_conc_persistence "+
 			"WHERE id = ? LIMIT 1", concID)
 	if row.Err() != nil {
@@ -128,34 +306,153 @@ func (ops *MySQLConcArch) ContainsRecord(concID string) (bool, error) {
 	return ans, nil
 }
 
-func (ops *MySQLConcArch) LoadRecordsByID(concID string) ([]ArchRecord, error) {
-	rows, err := ops.db.QueryContext(
-		ops.ctx,
-		"SELECT data, created, num_access, last_access, permanent "+
+func (ops *MySQLConcArch) LoadRecordsByID(ctx context.Context, concID string) ([]ArchRecord, error) {
+	ingestedCol := "NULL"
+	if ops.ingestionTime {
+		ingestedCol = "ingested"
+	}
+	checksumCol := "NULL"
+	if ops.checksums {
+		checksumCol = "data_checksum"
+	}
+	rows, err := ops.readDB(ctx, false).QueryContext(
+		ctx,
+		"SELECT data, created, num_access, last_access, permanent, "+ops.retiredCol()+", "+
+			ingestedCol+", "+checksumCol+" "+
 			"FROM kontext_conc_persistence WHERE id = ?", concID)
 	if err != nil {
 		return []ArchRecord{}, fmt.Errorf("failed to get records with id %s: %w", concID, err)
 	}
 	ans := make([]ArchRecord, 0, 10)
+	checksums := make([]sql.NullString, 0, 10)
 	for rows.Next() {
 		item := ArchRecord{ID: concID}
+		var ingested sql.NullTime
+		var checksum sql.NullString
 		err := rows.Scan(
 			&item.Data, &item.Created, &item.NumAccess, &item.LastAccess,
-			&item.Permanent)
+			&item.Permanent, &item.Retired, &ingested, &checksum)
 		if err != nil {
 			return []ArchRecord{}, fmt.Errorf("failed to get records with id %s: %w", concID, err)
 		}
+		item.Ingested = ingested.Time
 		ans = append(ans, item)
+		checksums = append(checksums, checksum)
+	}
+	if err := ops.resolveStoredData(ctx, ans); err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to get records with id %s: %w", concID, err)
+	}
+	// we do not refuse records with a mismatch here as this would
+	// block their processing (e.g. by the cleaner) - they are reported
+	// instead (see also the integrity verification service)
+	logChecksumMismatches(findChecksumMismatches(ans, checksums))
+	if err := ops.decryptRecords(ans); err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to get records with id %s: %w", concID, err)
 	}
 	return ans, nil
 }
 
-func (ops *MySQLConcArch) InsertRecord(rec ArchRecord) error {
-	_, err := ops.db.ExecContext(
-		ops.ctx,
-		"INSERT INTO kontext_conc_persistence (id, data, created, num_access, last_access, permanent) "+
-			"VALUES (?, ?, ?, ?, ?, ?)",
-		rec.ID, rec.Data, rec.Created, rec.NumAccess, rec.LastAccess, rec.Permanent,
+// encodedData is record data in a form to be stored in the archive table
+type encodedData struct {
+	stored string
+
+	// payload is the form the checksum is calculated from (see checksum.go)
+	payload string
+
+	// blobHash is a hash of the blob the stored data refer to
+	// (see EnableContentAddressing)
+	blobHash sql.NullString
+}
+
+// encodeData converts record data into a form to be stored
+// in the archive table (see EnableEncryption, EnableCompression,
+// EnableContentAddressing). Data encrypted as a whole are compressed
+// before encryption as encrypted data cannot be compressed. With field
+// encryption, the resulting JSON is compressed afterwards.
+func (ops *MySQLConcArch) encodeData(ctx context.Context, data string) (encodedData, error) {
+	compress := ops.compressAbove > 0 && len(data) > ops.compressAbove && !IsCompressedData(data)
+	if ops.encryptor != nil && ops.encryptor.encryptsWholeData() {
+		var err error
+		if compress {
+			data, err = CompressData(data)
+			if err != nil {
+				return encodedData{}, err
+			}
+			compress = false
+		}
+		data, err = ops.encryptor.encryptData(data)
+		if err != nil {
+			return encodedData{}, err
+		}
+
+	} else if ops.encryptor != nil {
+		var err error
+		data, err = ops.encryptor.encryptData(data)
+		if err != nil {
+			return encodedData{}, err
+		}
+	}
+	ans := encodedData{payload: data}
+	if compress {
+		var err error
+		data, err = CompressData(data)
+		if err != nil {
+			return encodedData{}, err
+		}
+	}
+	ans.stored = data
+	if ops.contentAddressed && !IsBlobRef(data) {
+		var err error
+		ans.stored, err = ops.storeBlob(ctx, ops.db, data)
+		if err != nil {
+			return encodedData{}, err
+		}
+	}
+	if IsBlobRef(ans.stored) {
+		ans.blobHash = sql.NullString{String: blobRefHash(ans.stored), Valid: true}
+	}
+	return ans, nil
+}
+
+func (ops *MySQLConcArch) InsertRecord(ctx context.Context, rec ArchRecord) error {
+	var err error
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return fmt.Errorf("failed to insert archive record: %w", err)
+	}
+	enc, err := ops.encodeData(ctx, rec.Data)
+	if err != nil {
+		return fmt.Errorf("failed to insert archive record: %w", err)
+	}
+	cols := []string{"id", "data", "created", "num_access", "last_access", "permanent"}
+	args := []any{rec.ID, enc.stored, rec.Created, rec.NumAccess, rec.LastAccess, rec.Permanent}
+	if ops.retired {
+		cols = append(cols, "retired")
+		args = append(args, rec.Retired)
+	}
+	if ops.ingestionTime {
+		var ingested sql.NullTime
+		if !rec.Ingested.IsZero() {
+			ingested = sql.NullTime{Time: rec.Ingested, Valid: true}
+		}
+		cols = append(cols, "ingested")
+		args = append(args, ingested)
+	}
+	if ops.checksums {
+		cols = append(cols, "data_checksum")
+		args = append(args, PayloadChecksum(enc.payload))
+	}
+	if ops.contentAddressed {
+		cols = append(cols, "data_hash")
+		args = append(args, enc.blobHash)
+	}
+	_, err = ops.db.ExecContext(
+		ctx,
+		fmt.Sprintf(
+			"INSERT INTO kontext_conc_persistence (%s) VALUES (%s)",
+			strings.Join(cols, ", "),
+			strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "),
+		),
+		args...,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert archive record: %w", err)
@@ -163,9 +460,44 @@ func (ops *MySQLConcArch) InsertRecord(rec ArchRecord) error {
 	return nil
 }
 
-func (ops *MySQLConcArch) UpdateRecordStatus(id string, status int) error {
+// UpdateRecordData replaces data of a record variant identified
+// by its ID and creation time.
+func (ops *MySQLConcArch) UpdateRecordData(ctx context.Context, id string, created time.Time, data string) error {
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return fmt.Errorf("failed to update data of %s: %w", id, err)
+	}
+	enc, err := ops.encodeData(ctx, data)
+	if err != nil {
+		return fmt.Errorf("failed to update data of %s: %w", id, err)
+	}
+	cols := []string{"data = ?"}
+	args := []any{enc.stored}
+	if ops.checksums {
+		cols = append(cols, "data_checksum = ?")
+		args = append(args, PayloadChecksum(enc.payload))
+	}
+	if ops.contentAddressed {
+		cols = append(cols, "data_hash = ?")
+		args = append(args, enc.blobHash)
+	}
+	args = append(args, id, created)
+	_, err = ops.db.ExecContext(
+		ctx,
+		"UPDATE kontext_conc_persistence SET "+strings.Join(cols, ", ")+" WHERE id = ? AND created = ?",
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update data of %s: %w", id, err)
+	}
+	return nil
+}
+
+func (ops *MySQLConcArch) UpdateRecordStatus(ctx context.Context, id string, status int) error {
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return fmt.Errorf("failed to update status of %s: %w", id, err)
+	}
 	res, err := ops.db.ExecContext(
-		ops.ctx,
+		ctx,
 		"UPDATE kontext_conc_persistence SET permanent = ? WHERE id = ?", status, id)
 	if err != nil {
 		return fmt.Errorf("failed to update status of %s: %w", id, err)
@@ -180,9 +512,44 @@ func (ops *MySQLConcArch) UpdateRecordStatus(id string, status int) error {
 	return nil
 }
 
-func (ops *MySQLConcArch) RemoveRecordsByID(concID string) error {
+// SetRecordRetired marks all the variants of a record as retired
+// (see ArchRecord.Retired)
+func (ops *MySQLConcArch) SetRecordRetired(ctx context.Context, id string) error {
+	if !ops.retired {
+		return fmt.Errorf("failed to retire %s: retired column not enabled", id)
+	}
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return fmt.Errorf("failed to retire %s: %w", id, err)
+	}
 	_, err := ops.db.ExecContext(
-		ops.ctx,
+		ctx,
+		"UPDATE kontext_conc_persistence SET retired = 1 WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to retire %s: %w", id, err)
+	}
+	return nil
+}
+
+func (ops *MySQLConcArch) IsRecordRetired(ctx context.Context, id string) (bool, error) {
+	if !ops.retired {
+		return false, nil
+	}
+	row := ops.readDB(ctx, false).QueryRowContext(
+		ctx,
+		"SELECT COALESCE(MAX(retired), 0) FROM kontext_conc_persistence WHERE id = ?", id)
+	var ans bool
+	if err := row.Scan(&ans); err != nil {
+		return false, fmt.Errorf("failed to test retired status of %s: %w", id, err)
+	}
+	return ans, nil
+}
+
+func (ops *MySQLConcArch) RemoveRecordsByID(ctx context.Context, concID string) error {
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return fmt.Errorf("failed to remove records with id %s: %w", concID, err)
+	}
+	_, err := ops.db.ExecContext(
+		ctx,
 		"DELETE FROM kontext_conc_persistence WHERE id = ?", concID)
 	if err != nil {
 		return fmt.Errorf("failed to remove records with id %s: %w", concID, err)
@@ -190,30 +557,39 @@ func (ops *MySQLConcArch) RemoveRecordsByID(concID string) error {
 	return nil
 }
 
-func (ops *MySQLConcArch) DeduplicateInArchive(curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
-	err := ops.RemoveRecordsByID(rec.ID)
+// DeduplicateInArchive replaces all the variants of a record with a single
+// one created by merging `curr` and `rec` according to the configured
+// merge policy (see SetMergePolicy).
+func (ops *MySQLConcArch) DeduplicateInArchive(ctx context.Context, curr []ArchRecord, rec ArchRecord) (MergeResult, error) {
+	err := ops.RemoveRecordsByID(ctx, rec.ID)
 	if err != nil {
-		return ArchRecord{}, fmt.Errorf("failed to finish deduplication for %s: %w", rec.ID, err)
+		return MergeResult{}, fmt.Errorf("failed to finish deduplication for %s: %w", rec.ID, err)
+	}
+	ans := MergeRecords(curr, rec, ops.mergePolicy, ops.tz)
+	if ans.HasConflicts() {
+		log.Warn().
+			Str("concId", rec.ID).
+			Any("conflicts", ans.Conflicts).
+			Msg("merged record variants differ")
 	}
-	ans := MergeRecords(curr, rec, ops.tz)
-	err = ops.InsertRecord(ans)
+	err = ops.InsertRecord(ctx, ans.Record)
 	if err != nil {
 		log.Error().
 			Err(err).
 			Str("concId", rec.ID).
-			Str("data", ans.Data).
+			Str("data", ans.Record.Data).
 			Msg("failed to insert merged record")
 		return ans, fmt.Errorf("failed to store merged record %s: %w", rec.ID, err)
 	}
 	return ans, nil
 }
 
-func (ops *MySQLConcArch) GetArchSizesByYears(forceLoad bool) ([][2]int, error) {
+func (ops *MySQLConcArch) GetArchSizesByYears(ctx context.Context, forceLoad bool) ([][2]int, error) {
 	if !forceLoad && !TimeIsAtNight(time.Now().In(ops.tz)) {
 		return [][2]int{}, ErrTooDemandingQuery
 	}
-	rows, err := ops.db.QueryContext(
-		ops.ctx,
+	rows, err := ops.readDB(ctx, true).QueryContext(
+		ctx,
 		"SELECT COUNT(*), YEAR(created) AS yc "+
 			"FROM kontext_conc_persistence "+
 			"GROUP BY YEAR(created) ORDER BY yc")
@@ -231,12 +607,119 @@ func (ops *MySQLConcArch) GetArchSizesByYears(forceLoad bool) ([][2]int, error)
 	return ans, nil
 }
 
-func (ops *MySQLConcArch) GetSubcorpusProps(subcID string) (SubcProps, error) {
+// GetLargestRecords returns `limit` largest records created since `fromDate`.
+// The size is measured as stored in the database (i.e. compressed records
+// are reported with their compressed size, blob-stored records with the size
+// of their blob). As the query is quite demanding, it is performed only during
+// night hours unless forceLoad is true.
+func (ops *MySQLConcArch) GetLargestRecords(
+	ctx context.Context, fromDate time.Time, limit int, forceLoad bool) ([]RecordSize, error) {
+	if !forceLoad && !TimeIsAtNight(time.Now().In(ops.tz)) {
+		return []RecordSize{}, ErrTooDemandingQuery
+	}
+	var rows *sql.Rows
+	var err error
+	if ops.contentAddressed {
+		rows, err = ops.readDB(ctx, true).QueryContext(
+			ctx,
+			"SELECT p.id, COALESCE(b.data, p.data), p.created, "+
+				"LENGTH(COALESCE(b.data, p.data)) AS data_size "+
+				"FROM kontext_conc_persistence AS p "+
+				"LEFT JOIN camus_data_blobs AS b ON b.hash = p.data_hash "+
+				"WHERE p.created >= ? "+
+				"ORDER BY data_size DESC LIMIT ?", fromDate, limit)
+
+	} else {
+		rows, err = ops.readDB(ctx, true).QueryContext(
+			ctx,
+			"SELECT id, data, created, LENGTH(data) AS data_size "+
+				"FROM kontext_conc_persistence "+
+				"WHERE created >= ? "+
+				"ORDER BY data_size DESC LIMIT ?", fromDate, limit)
+	}
+	if err != nil {
+		return []RecordSize{}, fmt.Errorf("failed to get largest records: %w", err)
+	}
+	ans := make([]RecordSize, 0, limit)
+	for rows.Next() {
+		var item RecordSize
+		var data string
+		if err := rows.Scan(&item.ID, &data, &item.Created, &item.Size); err != nil {
+			return []RecordSize{}, fmt.Errorf("failed to get largest records: %w", err)
+		}
+		data, err = DecompressData(data)
+		if err != nil {
+			return []RecordSize{}, fmt.Errorf("failed to get largest records: %w", err)
+		}
+		data, err = ops.decryptData(data)
+		if err != nil {
+			return []RecordSize{}, fmt.Errorf("failed to get largest records: %w", err)
+		}
+		item.QuerySupertype = DataSupertype(data)
+		ans = append(ans, item)
+	}
+	return ans, nil
+}
+
+// SampleRecords returns up to `num` randomly selected records created
+// within [fromDate, toDate). As random ordering requires a full scan
+// of the respective range, the query is performed only during night hours
+// unless forceLoad is true.
+func (ops *MySQLConcArch) SampleRecords(
+	ctx context.Context, fromDate, toDate time.Time, num int, forceLoad bool) ([]ArchRecord, error) {
+	if !forceLoad && !TimeIsAtNight(time.Now().In(ops.tz)) {
+		return []ArchRecord{}, ErrTooDemandingQuery
+	}
+	// to avoid sorting whole records, we sample just their keys first
+	// and load the records afterwards
+	rows, err := ops.readDB(ctx, true).QueryContext(
+		ctx,
+		"SELECT id, created FROM kontext_conc_persistence "+
+			"WHERE created >= ? AND created < ? "+
+			"ORDER BY RAND() LIMIT ?", fromDate, toDate, num)
+	if err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to sample records: %w", err)
+	}
+	defer rows.Close()
+	keys := make([]any, 0, 2*num)
+	for rows.Next() {
+		var item RecordID
+		if err := rows.Scan(&item.ID, &item.Created); err != nil {
+			return []ArchRecord{}, fmt.Errorf("failed to sample records: %w", err)
+		}
+		keys = append(keys, item.ID, item.Created)
+	}
+	if err := rows.Err(); err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to sample records: %w", err)
+	}
+	if len(keys) == 0 {
+		return []ArchRecord{}, nil
+	}
+	recRows, err := ops.readDB(ctx, true).QueryContext(
+		ctx,
+		"SELECT "+ops.recordCols()+" "+
+			"FROM kontext_conc_persistence "+
+			"WHERE (id, created) IN ((?, ?)"+strings.Repeat(", (?, ?)", len(keys)/2-1)+")",
+		keys...)
+	if err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to sample records: %w", err)
+	}
+	ans, err := generateRows(recRows, len(keys)/2)
+	if err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to sample records: %w", err)
+	}
+	if err := ops.resolveData(ctx, ans); err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to sample records: %w", err)
+	}
+	return ans, nil
+}
+
+func (ops *MySQLConcArch) GetSubcorpusProps(ctx context.Context, subcID string) (SubcProps, error) {
 	if subcID == "" {
 		return SubcProps{}, nil
 	}
-	row := ops.db.QueryRowContext(
-		ops.ctx,
+	row := ops.readDB(ctx, false).QueryRowContext(
+		ctx,
 		"SELECT name, text_types FROM kontext_subcorpus WHERE id = ?", subcID)
 	var name string
 	var textTypes sql.NullString
@@ -258,18 +741,18 @@ func (ops *MySQLConcArch) GetSubcorpusProps(subcID string) (SubcProps, error) {
 // --------------------------------------------------
 
 type MySQLQueryHist struct {
-	db  *sql.DB
-	tz  *time.Location
-	ctx context.Context
+	db          *sql.DB
+	tz          *time.Location
+	writeBudget *WriteBudget
 }
 
-func (ops *MySQLQueryHist) NewTransaction() (*sql.Tx, error) {
-	return ops.db.BeginTx(ops.ctx, nil)
+func (ops *MySQLQueryHist) NewTransaction(ctx context.Context) (*sql.Tx, error) {
+	return ops.db.BeginTx(ctx, nil)
 }
 
-func (ops *MySQLQueryHist) GetAllUsersWithSomeRecords() ([]int, error) {
+func (ops *MySQLQueryHist) GetAllUsersWithSomeRecords(ctx context.Context) ([]int, error) {
 	rows, err := ops.db.QueryContext(
-		ops.ctx,
+		ctx,
 		"SELECT DISTINCT user_id FROM kontext_query_history ORDER BY user_id",
 	)
 	if err != nil {
@@ -290,12 +773,15 @@ func (ops *MySQLQueryHist) GetAllUsersWithSomeRecords() ([]int, error) {
 // MarkOldRecords takes ordered records for each user and enything above numPreserve
 // is marked for deletion (column `pending_deletion_from`).
 // The method panics in case numPreserve <= 0 (i.e. even zero is forbidden)
-func (ops *MySQLQueryHist) MarkOldRecords(numPreserve int) (int64, error) {
+func (ops *MySQLQueryHist) MarkOldRecords(ctx context.Context, numPreserve int) (int64, error) {
 	if numPreserve <= 0 {
 		panic("cannot MarkOldRecords - numPreserve must be > 0")
 	}
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return -1, fmt.Errorf("failed to mark old query history records: %w", err)
+	}
 	res, err := ops.db.ExecContext(
-		ops.ctx,
+		ctx,
 		"UPDATE kontext_query_history AS qh JOIN "+
 			"( "+
 			"SELECT user_id, created, query_id "+
@@ -322,9 +808,9 @@ func (ops *MySQLQueryHist) MarkOldRecords(numPreserve int) (int64, error) {
 	return aff, nil
 }
 
-func (ops *MySQLQueryHist) GetUserRecords(userID int, numItems int) ([]HistoryRecord, error) {
+func (ops *MySQLQueryHist) GetUserRecords(ctx context.Context, userID int, numItems int) ([]HistoryRecord, error) {
 	rows, err := ops.db.QueryContext(
-		ops.ctx,
+		ctx,
 		"SELECT query_id, created, name FROM ( "+
 			"SELECT * FROM kontext_query_history WHERE user_id = ? AND name IS NOT NULL "+
 			"UNION "+
@@ -350,9 +836,9 @@ func (ops *MySQLQueryHist) GetUserRecords(userID int, numItems int) ([]HistoryRe
 	return ans, nil
 }
 
-func (ops *MySQLQueryHist) GetUserGarbageRecords(userID int) ([]HistoryRecord, error) {
+func (ops *MySQLQueryHist) GetUserGarbageRecords(ctx context.Context, userID int) ([]HistoryRecord, error) {
 	rows, err := ops.db.QueryContext(
-		ops.ctx,
+		ctx,
 		"SELECT user_id, query_id, created, name FROM kontext_query_history "+
 			"WHERE user_id = ? AND created NOT IN "+
 			"(SELECT created FROM "+
@@ -380,9 +866,12 @@ func (ops *MySQLQueryHist) GetUserGarbageRecords(userID int) ([]HistoryRecord, e
 	return ans, nil
 }
 
-func (ops *MySQLQueryHist) GarbageCollectRecords(userID int) (int64, error) {
+func (ops *MySQLQueryHist) GarbageCollectRecords(ctx context.Context, userID int) (int64, error) {
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return 0, fmt.Errorf("failed to garbage collect user query history: %w", err)
+	}
 	res, err := ops.db.ExecContext(
-		ops.ctx,
+		ctx,
 		"DELETE FROM kontext_query_history "+
 			"WHERE user_id = ? AND created NOT IN "+
 			"(SELECT created FROM "+
@@ -403,9 +892,16 @@ func (ops *MySQLQueryHist) GarbageCollectRecords(userID int) (int64, error) {
 	return aff, nil
 }
 
-func (ops *MySQLQueryHist) RemoveRecord(tx *sql.Tx, created int64, userID int, queryID string) error {
-	res, err := ops.db.ExecContext(
-		ops.ctx,
+func (ops *MySQLQueryHist) RemoveRecord(ctx context.Context, tx *sql.Tx, created int64, userID int, queryID string) error {
+	if err := ops.writeBudget.Wait(ctx, 1); err != nil {
+		return fmt.Errorf("failed to delete query history item: %w", err)
+	}
+	var ex execer = ops.db
+	if tx != nil {
+		ex = tx
+	}
+	res, err := ex.ExecContext(
+		ctx,
 		"DELETE FROM kontext_query_history "+
 			"WHERE created = ? AND user_id = ? AND query_id = ? AND name IS NULL ",
 		created, userID, queryID,
@@ -423,17 +919,18 @@ func (ops *MySQLQueryHist) RemoveRecord(tx *sql.Tx, created int64, userID int, q
 	return nil
 }
 
-func (ops *MySQLQueryHist) LoadRecentNHistory(num int) ([]HistoryRecord, error) {
+func (ops *MySQLQueryHist) LoadRecentNHistory(ctx context.Context, num int) ([]HistoryRecord, error) {
 	// we use helperLimit to help partitioned table with millions of items
 	// to avoid going through all the partitions (or is the query planner
 	// able to determine it from `order by created DESC limit X` ?)
 	helperLimit := time.Now().In(ops.tz).Add(-180 * 24 * time.Hour)
-	if num > maxRecentRecords {
-		panic(fmt.Sprintf("cannot load more than %d records at a time", maxRecentRecords))
+	if num > MaxRecentRecords {
+		return []HistoryRecord{}, fmt.Errorf(
+			"failed to get user query history: cannot load more than %d records at a time", MaxRecentRecords)
 	}
 
 	rows, err := ops.db.QueryContext(
-		ops.ctx,
+		ctx,
 		"SELECT user_id, query_id, created, name FROM kontext_query_history "+
 			"WHERE created >= ? "+
 			"ORDER BY created DESC LIMIT ?",
@@ -456,9 +953,9 @@ func (ops *MySQLQueryHist) LoadRecentNHistory(num int) ([]HistoryRecord, error)
 	return ans, nil
 }
 
-func (ops *MySQLQueryHist) GetPendingDeletionRecords(tx *sql.Tx, maxItems int) ([]HistoryRecord, error) {
+func (ops *MySQLQueryHist) GetPendingDeletionRecords(ctx context.Context, tx *sql.Tx, maxItems int) ([]HistoryRecord, error) {
 	rows, err := tx.QueryContext(
-		ops.ctx,
+		ctx,
 		"SELECT user_id, query_id, created, name FROM kontext_query_history "+
 			"WHERE pending_deletion_from IS NOT NULL "+
 			"ORDER BY pending_deletion_from "+
@@ -482,8 +979,79 @@ func (ops *MySQLQueryHist) GetPendingDeletionRecords(tx *sql.Tx, maxItems int) (
 	return ans, nil
 }
 
-func (ops *MySQLQueryHist) TableSize() (int64, error) {
-	rows := ops.db.QueryRow("SELECT COUNT(*) FROM kontext_query_history")
+func (ops *MySQLQueryHist) GetPendingDeletionBacklog(ctx context.Context) (PendingDeletionBacklog, error) {
+	row := ops.db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*), MIN(pending_deletion_from) FROM kontext_query_history "+
+			"WHERE pending_deletion_from IS NOT NULL",
+	)
+	var ans PendingDeletionBacklog
+	var oldest sql.NullTime
+	if err := row.Scan(&ans.NumRecords, &oldest); err != nil {
+		return ans, fmt.Errorf("failed to get pending deletion backlog: %w", err)
+	}
+	if oldest.Valid {
+		ans.Oldest = &oldest.Time
+	}
+	return ans, nil
+}
+
+func (ops *MySQLQueryHist) LoadHistoryRecords(
+	ctx context.Context, from HistoryRecord, limit int) ([]HistoryRecord, error) {
+	rows, err := ops.db.QueryContext(
+		ctx,
+		"SELECT user_id, query_id, created, name FROM kontext_query_history "+
+			"WHERE created > ? OR (created = ? AND (user_id > ? OR (user_id = ? AND query_id > ?))) "+
+			"ORDER BY created, user_id, query_id LIMIT ?",
+		from.Created, from.Created, from.UserID, from.UserID, from.QueryID, limit,
+	)
+	if err != nil {
+		return []HistoryRecord{}, fmt.Errorf("failed to load query history records: %w", err)
+	}
+	defer rows.Close()
+	ans := make([]HistoryRecord, 0, limit)
+	for rows.Next() {
+		var hRec HistoryRecord
+		var name sql.NullString
+		if err := rows.Scan(&hRec.UserID, &hRec.QueryID, &hRec.Created, &name); err != nil {
+			return []HistoryRecord{}, fmt.Errorf("failed to load query history records: %w", err)
+		}
+		hRec.Name = name.String
+		ans = append(ans, hRec)
+	}
+	return ans, nil
+}
+
+func (ops *MySQLQueryHist) UpsertHistoryRecords(ctx context.Context, recs []HistoryRecord) error {
+	if len(recs) == 0 {
+		return nil
+	}
+	args := make([]any, 0, 4*len(recs))
+	for _, hRec := range recs {
+		var name sql.NullString
+		if hRec.Name != "" {
+			name = sql.NullString{String: hRec.Name, Valid: true}
+		}
+		args = append(args, hRec.UserID, hRec.QueryID, hRec.Created, name)
+	}
+	if err := ops.writeBudget.Wait(ctx, len(recs)); err != nil {
+		return fmt.Errorf("failed to upsert query history records: %w", err)
+	}
+	_, err := ops.db.ExecContext(
+		ctx,
+		"INSERT INTO kontext_query_history (user_id, query_id, created, name) "+
+			"VALUES (?, ?, ?, ?)"+strings.Repeat(", (?, ?, ?, ?)", len(recs)-1)+" "+
+			"ON DUPLICATE KEY UPDATE name = VALUES(name)",
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to upsert query history records: %w", err)
+	}
+	return nil
+}
+
+func (ops *MySQLQueryHist) TableSize(ctx context.Context) (int64, error) {
+	rows := ops.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM kontext_query_history")
 	var count int64
 	if err := rows.Scan(&count); err != nil {
 		return 0, fmt.Errorf("failed to get size of the kontext_query_history table: %w", err)
@@ -493,14 +1061,12 @@ func (ops *MySQLQueryHist) TableSize() (int64, error) {
 
 // --------------------------
 
-func NewMySQLOps(ctx context.Context, db *sql.DB, tz *time.Location) (*MySQLConcArch, *MySQLQueryHist) {
+func NewMySQLOps(db *sql.DB, tz *time.Location) (*MySQLConcArch, *MySQLQueryHist) {
 	return &MySQLConcArch{
-			ctx: ctx,
-			db:  db,
-			tz:  tz,
-		}, &MySQLQueryHist{
-			ctx: ctx,
-			db:  db,
-			tz:  tz,
-		}
+		db: db,
+		tz: tz,
+	}, &MySQLQueryHist{
+		db: db,
+		tz: tz,
+	}
 }
@@ -17,6 +17,7 @@
 package cncdb
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -30,52 +31,86 @@ type MySQLConcArchDryRun struct {
 	db *MySQLConcArch
 }
 
-func (db *MySQLConcArchDryRun) NewTransaction() (*sql.Tx, error) {
-	return db.db.NewTransaction()
+func (db *MySQLConcArchDryRun) NewTransaction(ctx context.Context) (*sql.Tx, error) {
+	return db.db.NewTransaction(ctx)
 }
 
-func (db *MySQLConcArchDryRun) LoadRecentNRecords(num int) ([]ArchRecord, error) {
-	return db.db.LoadRecentNRecords(num)
+func (db *MySQLConcArchDryRun) LoadRecentNRecords(ctx context.Context, num int) ([]ArchRecord, error) {
+	return db.db.LoadRecentNRecords(ctx, num)
 }
 
-func (db *MySQLConcArchDryRun) LoadRecordsFromDate(fromDate time.Time, maxItems int) ([]ArchRecord, error) {
-	return db.db.LoadRecordsFromDate(fromDate, maxItems)
+func (db *MySQLConcArchDryRun) LoadRecordsFromDate(ctx context.Context, fromDate time.Time, maxItems int) ([]ArchRecord, error) {
+	return db.db.LoadRecordsFromDate(ctx, fromDate, maxItems)
 }
 
-func (db *MySQLConcArchDryRun) ContainsRecord(concID string) (bool, error) {
-	return db.db.ContainsRecord(concID)
+func (db *MySQLConcArchDryRun) LoadRecordsAfter(
+	ctx context.Context, from RecordID, maxItems int) ([]ArchRecord, error) {
+	return db.db.LoadRecordsAfter(ctx, from, maxItems)
 }
 
-func (db *MySQLConcArchDryRun) LoadRecordsByID(concID string) ([]ArchRecord, error) {
-	return db.db.LoadRecordsByID(concID)
+func (db *MySQLConcArchDryRun) ContainsRecord(ctx context.Context, concID string) (bool, error) {
+	return db.db.ContainsRecord(ctx, concID)
 }
 
-func (db *MySQLConcArchDryRun) InsertRecord(rec ArchRecord) error {
+func (db *MySQLConcArchDryRun) LoadRecordsByID(ctx context.Context, concID string) ([]ArchRecord, error) {
+	return db.db.LoadRecordsByID(ctx, concID)
+}
+
+func (db *MySQLConcArchDryRun) InsertRecord(ctx context.Context, rec ArchRecord) error {
 	log.Info().Msgf("DRY-RUN>>> InsertRecord(ArchRecord{ID: %s})", rec.ID)
 	return nil
 }
 
-func (db *MySQLConcArchDryRun) UpdateRecordStatus(id string, status int) error {
+func (db *MySQLConcArchDryRun) SetRecordRetired(ctx context.Context, id string) error {
+	log.Info().Msgf("DRY-RUN>>> SetRecordRetired(%s)", id)
+	return nil
+}
+
+func (db *MySQLConcArchDryRun) IsRecordRetired(ctx context.Context, id string) (bool, error) {
+	return db.db.IsRecordRetired(ctx, id)
+}
+
+func (db *MySQLConcArchDryRun) UpdateRecordStatus(ctx context.Context, id string, status int) error {
 	log.Info().Msgf("DRY-RUN>>> UpdateRecordStatus(%s, %d)", id, status)
 	return nil
 }
 
-func (db *MySQLConcArchDryRun) RemoveRecordsByID(concID string) error {
+func (db *MySQLConcArchDryRun) UpdateRecordData(ctx context.Context, id string, created time.Time, data string) error {
+	log.Info().Msgf("DRY-RUN>>> UpdateRecordData(%s, %s, ...)", id, created)
+	return nil
+}
+
+func (db *MySQLConcArchDryRun) RemoveRecordsByID(ctx context.Context, concID string) error {
 	log.Info().Msgf("DRY-RUN>>> RemoveRecordsByID(%s)", concID)
 	return nil
 }
 
-func (db *MySQLConcArchDryRun) DeduplicateInArchive(curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
+func (db *MySQLConcArchDryRun) DeduplicateInArchive(ctx context.Context, curr []ArchRecord, rec ArchRecord) (MergeResult, error) {
 	log.Info().Msgf("DRY-RUN>>> DeduplicateInArchive(..., ArchRecord{ID: %s})", rec.ID)
-	return ArchRecord{}, nil
+	return MergeRecords(curr, rec, db.db.mergePolicy, db.db.tz), nil
+}
+
+func (ops *MySQLConcArchDryRun) GetArchSizesByYears(ctx context.Context, forceLoad bool) ([][2]int, error) {
+	return ops.db.GetArchSizesByYears(ctx, forceLoad)
+}
+
+func (ops *MySQLConcArchDryRun) GetLargestRecords(
+	ctx context.Context, fromDate time.Time, limit int, forceLoad bool) ([]RecordSize, error) {
+	return ops.db.GetLargestRecords(ctx, fromDate, limit, forceLoad)
+}
+
+func (ops *MySQLConcArchDryRun) SampleRecords(
+	ctx context.Context, fromDate, toDate time.Time, num int, forceLoad bool) ([]ArchRecord, error) {
+	return ops.db.SampleRecords(ctx, fromDate, toDate, num, forceLoad)
 }
 
-func (ops *MySQLConcArchDryRun) GetArchSizesByYears(forceLoad bool) ([][2]int, error) {
-	return ops.db.GetArchSizesByYears(forceLoad)
+func (ops *MySQLConcArchDryRun) LoadRecordIDs(
+	ctx context.Context, fromCreated time.Time, fromID string, limit int) ([]RecordID, error) {
+	return ops.db.LoadRecordIDs(ctx, fromCreated, fromID, limit)
 }
 
-func (ops *MySQLConcArchDryRun) GetSubcorpusProps(subcID string) (SubcProps, error) {
-	return ops.db.GetSubcorpusProps(subcID)
+func (ops *MySQLConcArchDryRun) GetSubcorpusProps(ctx context.Context, subcID string) (SubcProps, error) {
+	return ops.db.GetSubcorpusProps(ctx, subcID)
 }
 
 // --------------------------------------------------------------
@@ -87,47 +122,80 @@ type MySQLQueryHistDryRun struct {
 	db *MySQLQueryHist
 }
 
-func (ops *MySQLQueryHistDryRun) NewTransaction() (*sql.Tx, error) {
-	return ops.db.NewTransaction()
+func (ops *MySQLQueryHistDryRun) NewTransaction(ctx context.Context) (*sql.Tx, error) {
+	return ops.db.NewTransaction(ctx)
 }
 
-func (ops *MySQLQueryHistDryRun) GetAllUsersWithSomeRecords() ([]int, error) {
-	return ops.db.GetAllUsersWithSomeRecords()
+func (ops *MySQLQueryHistDryRun) GetAllUsersWithSomeRecords(ctx context.Context) ([]int, error) {
+	return ops.db.GetAllUsersWithSomeRecords(ctx)
 }
 
-func (ops *MySQLQueryHistDryRun) GetUserRecords(userID int, numItems int) ([]HistoryRecord, error) {
-	return ops.db.GetUserRecords(userID, numItems)
+func (ops *MySQLQueryHistDryRun) GetUserRecords(ctx context.Context, userID int, numItems int) ([]HistoryRecord, error) {
+	return ops.db.GetUserRecords(ctx, userID, numItems)
 }
 
-func (ops *MySQLQueryHistDryRun) MarkOldRecords(numPreserve int) (int64, error) {
+func (ops *MySQLQueryHistDryRun) MarkOldRecords(ctx context.Context, numPreserve int) (int64, error) {
 	log.Info().Msgf("DRY-RUN>>> MarkOldRecords(%d)", numPreserve)
 	return 0, nil
 }
 
-func (db *MySQLQueryHistDryRun) LoadRecentNHistory(num int) ([]HistoryRecord, error) {
-	return db.db.LoadRecentNHistory(num)
+func (db *MySQLQueryHistDryRun) LoadRecentNHistory(ctx context.Context, num int) ([]HistoryRecord, error) {
+	return db.db.LoadRecentNHistory(ctx, num)
 }
 
-func (db *MySQLQueryHistDryRun) GarbageCollectRecords(userID int) (int64, error) {
+func (db *MySQLQueryHistDryRun) GarbageCollectRecords(ctx context.Context, userID int) (int64, error) {
 	log.Info().Msgf("DRY-RUN>>> GarbageCollectRecords(%d)", userID)
 	return 0, nil
 }
 
-func (db *MySQLQueryHistDryRun) GetUserGarbageRecords(userID int) ([]HistoryRecord, error) {
-	return db.db.GetUserGarbageRecords(userID)
+func (db *MySQLQueryHistDryRun) GetUserGarbageRecords(ctx context.Context, userID int) ([]HistoryRecord, error) {
+	return db.db.GetUserGarbageRecords(ctx, userID)
 }
 
-func (db *MySQLQueryHistDryRun) RemoveRecord(tx *sql.Tx, created int64, userID int, queryID string) error {
+func (db *MySQLQueryHistDryRun) RemoveRecord(ctx context.Context, tx *sql.Tx, created int64, userID int, queryID string) error {
 	log.Info().Msgf("DRY-RUN>>> RemoveRecord(%d, %d, %s)", created, userID, queryID)
 	return nil
 }
 
-func (db *MySQLQueryHistDryRun) GetPendingDeletionRecords(tx *sql.Tx, maxItems int) ([]HistoryRecord, error) {
-	return db.db.GetPendingDeletionRecords(tx, maxItems)
+func (db *MySQLQueryHistDryRun) GetPendingDeletionRecords(ctx context.Context, tx *sql.Tx, maxItems int) ([]HistoryRecord, error) {
+	return db.db.GetPendingDeletionRecords(ctx, tx, maxItems)
 }
 
-func (db *MySQLQueryHistDryRun) TableSize() (int64, error) {
-	return db.db.TableSize()
+func (db *MySQLQueryHistDryRun) TableSize(ctx context.Context) (int64, error) {
+	return db.db.TableSize(ctx)
+}
+
+func (db *MySQLQueryHistDryRun) LoadHistoryRecords(
+	ctx context.Context, from HistoryRecord, limit int) ([]HistoryRecord, error) {
+	return db.db.LoadHistoryRecords(ctx, from, limit)
+}
+
+func (db *MySQLQueryHistDryRun) UpsertHistoryRecords(ctx context.Context, recs []HistoryRecord) error {
+	log.Info().Msgf("DRY-RUN>>> UpsertHistoryRecords(%d records)", len(recs))
+	return nil
+}
+
+func (db *MySQLQueryHistDryRun) GetPendingDeletionBacklog(ctx context.Context) (PendingDeletionBacklog, error) {
+	return db.db.GetPendingDeletionBacklog(ctx)
+}
+
+func (db *MySQLQueryHistDryRun) TrashRecords(ctx context.Context, tx *sql.Tx, recs []HistoryRecord, source string) error {
+	log.Info().Msgf("DRY-RUN>>> TrashRecords(..., [%d items], %s)", len(recs), source)
+	return nil
+}
+
+func (db *MySQLQueryHistDryRun) GetUserTrash(ctx context.Context, userID int, limit int) ([]TrashedHistoryRecord, error) {
+	return db.db.GetUserTrash(ctx, userID, limit)
+}
+
+func (db *MySQLQueryHistDryRun) RestoreFromTrash(ctx context.Context, userID int, created int64, queryID string) (HistoryRecord, error) {
+	log.Info().Msgf("DRY-RUN>>> RestoreFromTrash(%d, %d, %s)", userID, created, queryID)
+	return HistoryRecord{QueryID: queryID, UserID: userID, Created: created}, nil
+}
+
+func (db *MySQLQueryHistDryRun) PurgeTrash(ctx context.Context, olderThan time.Time) (int64, error) {
+	log.Info().Msgf("DRY-RUN>>> PurgeTrash(%s)", olderThan)
+	return 0, nil
 }
 
 func NewMySQLDryRun(opsArch *MySQLConcArch, opsHist *MySQLQueryHist) (*MySQLConcArchDryRun, *MySQLQueryHistDryRun) {
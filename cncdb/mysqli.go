@@ -17,6 +17,7 @@
 package cncdb
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -29,45 +30,105 @@ type SubcProps struct {
 // IConcArchOps is an abstract interface for high level
 // database operations for concordance archive.
 type IConcArchOps interface {
-	NewTransaction() (*sql.Tx, error)
-	LoadRecentNRecords(num int) ([]ArchRecord, error)
-	LoadRecordsFromDate(fromDate time.Time, maxItems int) ([]ArchRecord, error)
-	ContainsRecord(concID string) (bool, error)
-	LoadRecordsByID(concID string) ([]ArchRecord, error)
-	InsertRecord(rec ArchRecord) error
-	UpdateRecordStatus(id string, status int) error
-	RemoveRecordsByID(concID string) error
-	DeduplicateInArchive(curr []ArchRecord, rec ArchRecord) (ArchRecord, error)
+	NewTransaction(ctx context.Context) (*sql.Tx, error)
+	LoadRecentNRecords(ctx context.Context, num int) ([]ArchRecord, error)
+	LoadRecordsFromDate(ctx context.Context, fromDate time.Time, maxItems int) ([]ArchRecord, error)
+
+	// LoadRecordIDs returns up to `limit` IDs of records following the record
+	// identified by `fromCreated` and `fromID` (ordered by creation time and ID).
+	// It is intended for paging through large parts of the archive
+	// without loading records' data.
+	LoadRecordIDs(ctx context.Context, fromCreated time.Time, fromID string, limit int) ([]RecordID, error)
+
+	// LoadRecordsAfter returns up to `maxItems` records following the record
+	// `from` (ordered by creation time and ID). Unlike LoadRecordsFromDate,
+	// the records are read from the primary database (unless replica reads
+	// are allowed via WithReplicaRead) so they can be safely rewritten.
+	LoadRecordsAfter(ctx context.Context, from RecordID, maxItems int) ([]ArchRecord, error)
+	ContainsRecord(ctx context.Context, concID string) (bool, error)
+	LoadRecordsByID(ctx context.Context, concID string) ([]ArchRecord, error)
+	InsertRecord(ctx context.Context, rec ArchRecord) error
+	UpdateRecordStatus(ctx context.Context, id string, status int) error
+
+	// SetRecordRetired marks all the variants of a record as retired
+	// while keeping their status
+	SetRecordRetired(ctx context.Context, id string) error
+
+	// IsRecordRetired tests whether a record is marked as retired
+	// (always false in case the retired flag is not stored)
+	IsRecordRetired(ctx context.Context, id string) (bool, error)
+
+	// UpdateRecordData replaces data of a record variant identified
+	// by its ID and creation time.
+	UpdateRecordData(ctx context.Context, id string, created time.Time, data string) error
+	RemoveRecordsByID(ctx context.Context, concID string) error
+
+	// DeduplicateInArchive replaces all the variants of a record with
+	// a single merged one. The result contains also possible conflicts
+	// between the merged variants.
+	DeduplicateInArchive(ctx context.Context, curr []ArchRecord, rec ArchRecord) (MergeResult, error)
 
 	// GetArchSizesByYears
 	// Without forceReload, the function refuses to perform actual query outside
 	// defined night time.
 	// Returns list of pairs where FIRST item is always YEAR, the SECOND one is COUNT
-	GetArchSizesByYears(forceLoad bool) ([][2]int, error)
+	GetArchSizesByYears(ctx context.Context, forceLoad bool) ([][2]int, error)
+
+	// GetLargestRecords returns largest records (by data size) created since fromDate.
+	// Without forceReload, the function refuses to perform actual query outside
+	// defined night time.
+	GetLargestRecords(ctx context.Context, fromDate time.Time, limit int, forceLoad bool) ([]RecordSize, error)
+
+	// SampleRecords returns up to `num` random records created within [fromDate, toDate).
+	// Without forceReload, the function refuses to perform actual query outside
+	// defined night time.
+	SampleRecords(ctx context.Context, fromDate, toDate time.Time, num int, forceLoad bool) ([]ArchRecord, error)
 
 	// GetSubcorpusProps takes a subcorpus "hash" ID and returns
 	// a corresponding name defined by the author.
 	// The method should accept empty value by responding
 	// with empty value (and without error).
-	GetSubcorpusProps(subcID string) (SubcProps, error)
+	GetSubcorpusProps(ctx context.Context, subcID string) (SubcProps, error)
 }
 
 // IQHistArchOps is an abstract interface for high level
 // database operations for query history (which itself is kind
 // of a "tag" to the concordance archive table)
 type IQHistArchOps interface {
-	NewTransaction() (*sql.Tx, error)
-	GetAllUsersWithSomeRecords() ([]int, error)
+	NewTransaction(ctx context.Context) (*sql.Tx, error)
+	GetAllUsersWithSomeRecords(ctx context.Context) ([]int, error)
+
+	GetUserRecords(ctx context.Context, userID int, numItems int) ([]HistoryRecord, error)
+	MarkOldRecords(ctx context.Context, numPreserve int) (int64, error)
+	GarbageCollectRecords(ctx context.Context, userID int) (int64, error)
+	GetUserGarbageRecords(ctx context.Context, userID int) ([]HistoryRecord, error)
 
-	GetUserRecords(userID int, numItems int) ([]HistoryRecord, error)
-	MarkOldRecords(numPreserve int) (int64, error)
-	GarbageCollectRecords(userID int) (int64, error)
-	GetUserGarbageRecords(userID int) ([]HistoryRecord, error)
-	RemoveRecord(tx *sql.Tx, created int64, userID int, queryID string) error
+	// RemoveRecord deletes an unnamed history item.
+	// The `tx` argument is optional.
+	RemoveRecord(ctx context.Context, tx *sql.Tx, created int64, userID int, queryID string) error
 
 	// GetPendingDeletionRecords should return records with oldest
 	// pending deletion time.
-	GetPendingDeletionRecords(tx *sql.Tx, maxItems int) ([]HistoryRecord, error)
-	LoadRecentNHistory(num int) ([]HistoryRecord, error)
-	TableSize() (int64, error)
+	GetPendingDeletionRecords(ctx context.Context, tx *sql.Tx, maxItems int) ([]HistoryRecord, error)
+	LoadRecentNHistory(ctx context.Context, num int) ([]HistoryRecord, error)
+
+	// LoadHistoryRecords returns up to `limit` records (including names) following
+	// the record `from` (ordered by creation time, user ID and query ID). It is
+	// intended for paging through the query history.
+	LoadHistoryRecords(ctx context.Context, from HistoryRecord, limit int) ([]HistoryRecord, error)
+
+	// UpsertHistoryRecords inserts records or updates names of the existing ones
+	UpsertHistoryRecords(ctx context.Context, recs []HistoryRecord) error
+	TableSize(ctx context.Context) (int64, error)
+
+	// GetPendingDeletionBacklog returns size and age of records
+	// marked for deletion
+	GetPendingDeletionBacklog(ctx context.Context) (PendingDeletionBacklog, error)
+
+	// TrashRecords stores deleted records to the trash (see histtrash.go).
+	// The `tx` argument is optional.
+	TrashRecords(ctx context.Context, tx *sql.Tx, recs []HistoryRecord, source string) error
+	GetUserTrash(ctx context.Context, userID int, limit int) ([]TrashedHistoryRecord, error)
+	RestoreFromTrash(ctx context.Context, userID int, created int64, queryID string) (HistoryRecord, error)
+	PurgeTrash(ctx context.Context, olderThan time.Time) (int64, error)
 }
@@ -18,9 +18,13 @@
 package cncdb
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 const (
@@ -69,12 +73,68 @@ type concForm struct {
 	CurrParsedQueries map[string][]any `json:"curr_parsed_queries"`
 }
 
+// flexInt decodes integer values KonText stores either as numbers
+// or as strings (depending on a version and a form origin). As the values
+// are just optional form properties, an unsupported value is logged
+// and replaced by zero so the whole form can still be decoded.
+type flexInt int
+
+func (v *flexInt) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*v = 0
+		return nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			log.Warn().Err(err).Str("value", string(data)).Msg("unsupported integer form value, using 0")
+			*v = 0
+			return nil
+		}
+		i = int(f)
+	}
+	*v = flexInt(i)
+	return nil
+}
+
+// flexBool decodes boolean values KonText stores either as booleans
+// or as 0/1 numbers (or strings). Like with flexInt, an unsupported
+// value is logged and replaced by false.
+type flexBool bool
+
+func (v *flexBool) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*v = false
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		log.Warn().Err(err).Str("value", string(data)).Msg("unsupported boolean form value, using false")
+		*v = false
+		return nil
+	}
+	*v = flexBool(b)
+	return nil
+}
+
 type wlistForm struct {
 	FormType     string   `json:"form_type"`
 	WLAttr       string   `json:"wlattr"`
 	WLPattern    string   `json:"wlpat"`
 	PFilterWords []string `json:"pfilter_words"`
 	NFilterWords []string `json:"nfilter_words"`
+
+	// WLType is either `simple` or `multilevel`
+	WLType string `json:"wltype"`
+
+	// WLPosAttrs contains attributes of a multilevel word list
+	WLPosAttrs      []string `json:"wlposattrs"`
+	WLMinFreq       flexInt  `json:"wlminfreq"`
+	WLNums          string   `json:"wlnums"`
+	IncludeNonwords flexBool `json:"include_nonwords"`
 }
 
 type kwordsForm struct {
@@ -83,6 +143,12 @@ type kwordsForm struct {
 	RefUsesubcorp string `json:"ref_usesubcorp"`
 	WLAttr        string `json:"wlattr"`
 	WLPattern     string `json:"wlpat"`
+
+	// ScoreType is a keyness score (`logL`, `chi2`, `din`, ...)
+	ScoreType       string   `json:"score_type"`
+	WLMinFreq       flexInt  `json:"wlminfreq"`
+	WLMaxFreq       flexInt  `json:"wlmaxfreq"`
+	IncludeNonwords flexBool `json:"include_nonwords"`
 }
 
 type pqueryForm struct {
@@ -154,6 +220,6 @@ func (qr *UntypedQueryRecord) GetSupertype() (QuerySupertype, error) {
 	return st, nil
 }
 
-func (qr *UntypedQueryRecord) GetSubcorpus(db IConcArchOps) (SubcProps, error) {
-	return db.GetSubcorpusProps(qr.SubcorpusID)
+func (qr *UntypedQueryRecord) GetSubcorpus(ctx context.Context, db IConcArchOps) (SubcProps, error) {
+	return db.GetSubcorpusProps(ctx, qr.SubcorpusID)
 }
@@ -0,0 +1,39 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlexValuesTolerateUnsupportedInput(t *testing.T) {
+	var form wlistForm
+	err := json.Unmarshal(
+		[]byte(`{"form_type":"wlist","wlattr":"word","wlminfreq":"many","include_nonwords":"on"}`), &form)
+	assert.NoError(t, err)
+	assert.Equal(t, "word", form.WLAttr)
+	assert.Equal(t, flexInt(0), form.WLMinFreq)
+	assert.Equal(t, flexBool(false), form.IncludeNonwords)
+
+	err = json.Unmarshal([]byte(`{"wlminfreq":"5","include_nonwords":1}`), &form)
+	assert.NoError(t, err)
+	assert.Equal(t, flexInt(5), form.WLMinFreq)
+	assert.Equal(t, flexBool(true), form.IncludeNonwords)
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// compressedDataPrefix marks records stored by Camus in a compressed
+	// form. Please note that KonText is not able to read such records
+	// directly.
+	compressedDataPrefix = "gzip+base64:"
+)
+
+// derivedDataFields lists paths of record data fields which
+// can be recreated by KonText from other data and thus can be
+// removed from oversized records
+var derivedDataFields = [][]string{
+	{"lastop_form", "curr_parsed_queries"},
+	{"lastop_form", "bib_mapping"},
+}
+
+// RecordSize describes the size of an archived record's data
+type RecordSize struct {
+	ID             string         `json:"id"`
+	Created        time.Time      `json:"created"`
+	Size           int            `json:"size"`
+	QuerySupertype QuerySupertype `json:"querySupertype"`
+}
+
+// DataSupertype determines query supertype of raw record data.
+// For unparseable or unsupported data, QuerySupertypeUnsupported
+// is returned.
+func DataSupertype(data string) QuerySupertype {
+	var rec UntypedQueryRecord
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return QuerySupertypeUnsupported
+	}
+	st, err := rec.GetSupertype()
+	if err != nil {
+		return QuerySupertypeUnsupported
+	}
+	return st
+}
+
+func IsCompressedData(data string) bool {
+	return strings.HasPrefix(data, compressedDataPrefix)
+}
+
+// CompressData compresses record data so it can be stored to
+// the archive. Use DecompressData to obtain the original value.
+func CompressData(data string) (string, error) {
+	var buff bytes.Buffer
+	zw := gzip.NewWriter(&buff)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		return "", fmt.Errorf("failed to compress data: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress data: %w", err)
+	}
+	return compressedDataPrefix + base64.StdEncoding.EncodeToString(buff.Bytes()), nil
+}
+
+// DecompressData returns the original record data in case they are
+// compressed. Otherwise, the data are returned as they are.
+func DecompressData(data string) (string, error) {
+	if !IsCompressedData(data) {
+		return data, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(data, compressedDataPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress data: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress data: %w", err)
+	}
+	ans, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress data: %w", err)
+	}
+	return string(ans), nil
+}
+
+// TruncateDerivedFields removes data fields KonText is able to recreate
+// (see derivedDataFields).
+func TruncateDerivedFields(data string) (string, error) {
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return "", fmt.Errorf("failed to truncate record data: %w", err)
+	}
+	for _, path := range derivedDataFields {
+		curr := rec
+		for i, key := range path {
+			if i == len(path)-1 {
+				delete(curr, key)
+				break
+			}
+			next, ok := curr[key].(map[string]any)
+			if !ok {
+				break
+			}
+			curr = next
+		}
+	}
+	ans, err := json.Marshal(rec)
+	if err != nil {
+		return "", fmt.Errorf("failed to truncate record data: %w", err)
+	}
+	return string(ans), nil
+}
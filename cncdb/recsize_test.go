@@ -0,0 +1,45 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressData(t *testing.T) {
+	data := `{"id": "foo", "lastop_form": {"form_type": "query"}}`
+	compressed, err := CompressData(data)
+	assert.NoError(t, err)
+	assert.True(t, IsCompressedData(compressed))
+	decompressed, err := DecompressData(compressed)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decompressed)
+
+	plain, err := DecompressData(data)
+	assert.NoError(t, err)
+	assert.Equal(t, data, plain)
+}
+
+func TestTruncateDerivedFields(t *testing.T) {
+	data := `{"id":"foo","lastop_form":{"form_type":"query","curr_parsed_queries":{"corp1":[]}}}`
+	ans, err := TruncateDerivedFields(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"foo","lastop_form":{"form_type":"query"}}`, ans)
+	assert.Equal(t, QuerySupertypeConc, DataSupertype(ans))
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltReplicaMaxLagSecs           = 30
+	dfltReplicaLagCheckIntervalSecs = 10
+)
+
+type replicaReadCtxKey struct{}
+
+// ReplicaConf configures a read-only replica of the archive database.
+// Heavy read operations (stats, sampling, cleanup scans) and reads with
+// context marked by WithReplicaRead are performed on the replica as long
+// as its replication lag is acceptable. Otherwise, the primary database
+// is used. Writes are always performed on the primary database.
+type ReplicaConf struct {
+	DB *DBConf `json:"db"`
+
+	// MaxLagSecs specifies max. acceptable replication lag.
+	// Negative value disables lag checking (e.g. in case the database
+	// user is not allowed to read the replication status).
+	MaxLagSecs           int `json:"maxLagSecs"`
+	LagCheckIntervalSecs int `json:"lagCheckIntervalSecs"`
+}
+
+func (conf *ReplicaConf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.DB == nil || conf.DB.Host == "" || conf.DB.Name == "" {
+		return fmt.Errorf("missing replica database in replica configuration")
+	}
+	if conf.MaxLagSecs == 0 {
+		conf.MaxLagSecs = dfltReplicaMaxLagSecs
+		log.Warn().
+			Int("value", conf.MaxLagSecs).
+			Msg("replica maxLagSecs not specified, using default")
+	}
+	if conf.LagCheckIntervalSecs <= 0 {
+		conf.LagCheckIntervalSecs = dfltReplicaLagCheckIntervalSecs
+		log.Warn().
+			Int("value", conf.LagCheckIntervalSecs).
+			Msg("replica lagCheckIntervalSecs not specified, using default")
+	}
+	return nil
+}
+
+// WithReplicaRead marks the context so archive reads performed
+// with it can be routed to the replica database (if configured).
+// Use this only for reads which can tolerate slightly outdated data.
+func WithReplicaRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaReadCtxKey{}, true)
+}
+
+func replicaReadAllowed(ctx context.Context) bool {
+	v, ok := ctx.Value(replicaReadCtxKey{}).(bool)
+	return ok && v
+}
+
+// replica represents a read-only database connection along
+// with (cached) information about its replication lag
+type replica struct {
+	db   *sql.DB
+	conf *ReplicaConf
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	usable    bool
+}
+
+// replicationLag reads replication lag from the replica status.
+// In case the replication is not running, an error is returned.
+func (r *replica) replicationLag(ctx context.Context) (int, error) {
+	rows, err := r.db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		// older versions of MySQL and MariaDB
+		rows, err = r.db.QueryContext(ctx, "SHOW SLAVE STATUS")
+		if err != nil {
+			return -1, fmt.Errorf("failed to get replication status: %w", err)
+		}
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return -1, fmt.Errorf("failed to get replication status: %w", err)
+	}
+	lagCol := slices.IndexFunc(cols, func(c string) bool {
+		return c == "Seconds_Behind_Source" || c == "Seconds_Behind_Master"
+	})
+	if lagCol < 0 {
+		return -1, fmt.Errorf("failed to get replication status: lag column not found")
+	}
+	if !rows.Next() {
+		return -1, fmt.Errorf("failed to get replication status: not a replica")
+	}
+	values := make([]sql.RawBytes, len(cols))
+	scanArgs := make([]any, len(cols))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	if err := rows.Scan(scanArgs...); err != nil {
+		return -1, fmt.Errorf("failed to get replication status: %w", err)
+	}
+	if values[lagCol] == nil {
+		return -1, fmt.Errorf("failed to get replication status: replication not running")
+	}
+	lag, err := strconv.Atoi(string(values[lagCol]))
+	if err != nil {
+		return -1, fmt.Errorf("failed to get replication status: %w", err)
+	}
+	return lag, nil
+}
+
+// isUsable tests whether the replica is not lagging too much.
+// The actual check is performed at most once per configured interval.
+func (r *replica) isUsable(ctx context.Context) bool {
+	if r.conf.MaxLagSecs < 0 {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.lastCheck) < time.Duration(r.conf.LagCheckIntervalSecs)*time.Second {
+		return r.usable
+	}
+	r.lastCheck = time.Now()
+	wasUsable := r.usable
+	lag, err := r.replicationLag(ctx)
+	if err != nil {
+		r.usable = false
+		if wasUsable {
+			log.Warn().Err(err).Msg("replica database not usable, falling back to primary")
+		}
+		return false
+	}
+	r.usable = lag <= r.conf.MaxLagSecs
+	if wasUsable && !r.usable {
+		log.Warn().Int("lagSecs", lag).Msg("replica database lagging, falling back to primary")
+
+	} else if !wasUsable && r.usable {
+		log.Info().Int("lagSecs", lag).Msg("using replica database for reads")
+	}
+	return r.usable
+}
+
+// SetReplica makes the archive perform heavy reads on the replica database
+// (see ReplicaConf).
+func (ops *MySQLConcArch) SetReplica(db *sql.DB, conf *ReplicaConf) {
+	ops.replica = &replica{db: db, conf: conf}
+}
+
+// readDB returns a database to be used for a read operation. The replica
+// is used only if `allowReplica` is true or if the context allows it.
+func (ops *MySQLConcArch) readDB(ctx context.Context, allowReplica bool) *sql.DB {
+	if ops.replica == nil || !allowReplica && !replicaReadAllowed(ctx) {
+		return ops.db
+	}
+	if ops.replica.isUsable(ctx) {
+		return ops.replica.db
+	}
+	return ops.db
+}
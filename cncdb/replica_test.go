@@ -0,0 +1,38 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadDBRouting(t *testing.T) {
+	primary := &sql.DB{}
+	replicaDB := &sql.DB{}
+	ops := &MySQLConcArch{db: primary}
+	ctx := context.Background()
+	assert.Same(t, primary, ops.readDB(ctx, true))
+
+	ops.SetReplica(replicaDB, &ReplicaConf{MaxLagSecs: -1})
+	assert.Same(t, primary, ops.readDB(ctx, false))
+	assert.Same(t, replicaDB, ops.readDB(ctx, true))
+	assert.Same(t, replicaDB, ops.readDB(WithReplicaRead(ctx), false))
+}
@@ -20,6 +20,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,6 +29,25 @@ var (
 	ErrRecordNotFound = errors.New("record not found")
 )
 
+// anyToStrings converts a decoded JSON array of strings
+// (i.e. []any) to []string. Non-string items are skipped.
+func anyToStrings(v any) []string {
+	switch tv := v.(type) {
+	case []string:
+		return tv
+	case []any:
+		ans := make([]string, 0, len(tv))
+		for _, item := range tv {
+			if s, ok := item.(string); ok {
+				ans = append(ans, s)
+			}
+		}
+		return ans
+	default:
+		return []string{}
+	}
+}
+
 type GeneralDataRecord map[string]any
 
 func (rec GeneralDataRecord) GetPrevID() string {
@@ -46,11 +67,7 @@ func (rec GeneralDataRecord) GetCorpora() []string {
 	if !ok {
 		return []string{}
 	}
-	typedV, ok := v.([]string)
-	if !ok {
-		return []string{}
-	}
-	return typedV
+	return anyToStrings(v)
 }
 
 func (rec GeneralDataRecord) GetQuery() []string {
@@ -58,11 +75,7 @@ func (rec GeneralDataRecord) GetQuery() []string {
 	if !ok {
 		return []string{}
 	}
-	typedV, ok := v.([]string)
-	if !ok {
-		return []string{}
-	}
-	return typedV
+	return anyToStrings(v)
 }
 
 // ----------------------------------
@@ -74,6 +87,16 @@ type ArchRecord struct {
 	NumAccess  int
 	LastAccess time.Time
 	Permanent  int
+
+	// Retired marks records referring to a withdrawn corpus. The flag
+	// is stored separately from Permanent so a retired record keeps its
+	// status (see DBConf.RetiredColumn).
+	Retired bool
+
+	// Ingested is the time the record was archived by Camus.
+	// It is zero for records archived before ingestion time
+	// was stored (see DBConf.IngestionTimeColumn).
+	Ingested time.Time
 }
 
 func (rec ArchRecord) FetchData() (GeneralDataRecord, error) {
@@ -85,6 +108,41 @@ func (rec ArchRecord) FetchData() (GeneralDataRecord, error) {
 	return ans, nil
 }
 
+// DataCreationTime returns creation time of a record as stored
+// in its payload by KonText (`created` - either a UNIX timestamp
+// or an RFC3339 string). If not found, false is returned.
+func DataCreationTime(data string) (time.Time, bool) {
+	var rec struct {
+		Created any `json:"created"`
+	}
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return time.Time{}, false
+	}
+	switch tv := rec.Created.(type) {
+	case float64:
+		if tv <= 0 {
+			return time.Time{}, false
+		}
+		sec := int64(tv)
+		return time.Unix(sec, int64((tv-float64(sec))*1e9)), true
+	case string:
+		t, err := time.Parse(time.RFC3339, tv)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// RecordID identifies an archived record variant
+// without its data (see IConcArchOps.LoadRecordIDs)
+type RecordID struct {
+	ID      string
+	Created time.Time
+}
+
 // ----------------------------------
 
 type HistoryRecord struct {
@@ -95,6 +153,37 @@ type HistoryRecord struct {
 	Rec     *ArchRecord
 }
 
+// PendingDeletionBacklog describes query history records
+// marked for deletion but not deleted yet
+type PendingDeletionBacklog struct {
+	NumRecords int64 `json:"numRecords"`
+
+	// Oldest is the oldest `pending_deletion_from` value (if any)
+	Oldest *time.Time `json:"oldest"`
+}
+
 func (qh *HistoryRecord) CreateIndexID() string {
 	return fmt.Sprintf("%d/%d/%s", qh.UserID, qh.Created, qh.QueryID)
 }
+
+// ParseIndexID is an inverse function to HistoryRecord.CreateIndexID.
+// The returned record contains only the identifying attributes.
+func ParseIndexID(id string) (HistoryRecord, error) {
+	items := strings.SplitN(id, "/", 3)
+	if len(items) != 3 {
+		return HistoryRecord{}, fmt.Errorf("invalid index ID %s", id)
+	}
+	userID, err := strconv.Atoi(items[0])
+	if err != nil {
+		return HistoryRecord{}, fmt.Errorf("invalid user ID in index ID %s: %w", id, err)
+	}
+	created, err := strconv.ParseInt(items[1], 10, 64)
+	if err != nil {
+		return HistoryRecord{}, fmt.Errorf("invalid creation time in index ID %s: %w", id, err)
+	}
+	return HistoryRecord{
+		UserID:  userID,
+		Created: created,
+		QueryID: items[2],
+	}, nil
+}
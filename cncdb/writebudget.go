@@ -0,0 +1,154 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	slowWriteWaitThreshold = 5 * time.Second
+)
+
+// WriteBudgetConf limits the rate of write statements (inserts, updates,
+// deletes) Camus performs on the database. As KonText users run the most
+// demanding queries during the day, a separate (typically more generous)
+// budget can be set for night hours (see TimeIsAtNight).
+type WriteBudgetConf struct {
+	DayWritesPerSec   float64 `json:"dayWritesPerSec"`
+	NightWritesPerSec float64 `json:"nightWritesPerSec"`
+
+	// Burst specifies how many writes can be performed at once
+	// after a period of inactivity
+	Burst int `json:"burst"`
+}
+
+func (conf *WriteBudgetConf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.DayWritesPerSec <= 0 {
+		return fmt.Errorf("dayWritesPerSec must be > 0")
+	}
+	if conf.NightWritesPerSec == 0 {
+		conf.NightWritesPerSec = conf.DayWritesPerSec
+		log.Warn().
+			Float64("value", conf.NightWritesPerSec).
+			Msg("nightWritesPerSec not specified, using dayWritesPerSec")
+	}
+	if conf.NightWritesPerSec < 0 {
+		return fmt.Errorf("nightWritesPerSec must be > 0")
+	}
+	if conf.Burst == 0 {
+		conf.Burst = int(math.Ceil(math.Max(conf.DayWritesPerSec, conf.NightWritesPerSec)))
+		log.Warn().
+			Int("value", conf.Burst).
+			Msg("write budget burst not specified, using max. writes per second")
+	}
+	if conf.Burst < 0 {
+		return fmt.Errorf("write budget burst must be > 0")
+	}
+	return nil
+}
+
+// WriteBudget is a token bucket shared by all the database operations
+// providers so the total write rate stays under the configured limit
+// regardless of which component (archiver, cleaner, GC, ...) writes.
+// A nil WriteBudget means "unlimited".
+type WriteBudget struct {
+	conf    *WriteBudgetConf
+	limiter *rate.Limiter
+	tz      *time.Location
+	mu      sync.Mutex
+	atNight bool
+}
+
+// adjustToTime switches between day and night limits if needed
+func (wb *WriteBudget) adjustToTime(t time.Time) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	atNight := TimeIsAtNight(t.In(wb.tz))
+	if atNight == wb.atNight {
+		return
+	}
+	wb.atNight = atNight
+	if atNight {
+		wb.limiter.SetLimitAt(t, rate.Limit(wb.conf.NightWritesPerSec))
+
+	} else {
+		wb.limiter.SetLimitAt(t, rate.Limit(wb.conf.DayWritesPerSec))
+	}
+	log.Info().
+		Bool("atNight", atNight).
+		Float64("writesPerSec", float64(wb.limiter.Limit())).
+		Msg("switched database write budget")
+}
+
+// Wait blocks until `numWrites` writes are allowed by the budget
+// or the context is done.
+func (wb *WriteBudget) Wait(ctx context.Context, numWrites int) error {
+	if wb == nil {
+		return nil
+	}
+	t0 := time.Now()
+	wb.adjustToTime(t0)
+	for numWrites > 0 {
+		n := min(numWrites, wb.conf.Burst)
+		if err := wb.limiter.WaitN(ctx, n); err != nil {
+			return fmt.Errorf("failed to obtain write budget: %w", err)
+		}
+		numWrites -= n
+	}
+	if waited := time.Since(t0); waited > slowWriteWaitThreshold {
+		log.Warn().
+			Float64("waitSecs", waited.Seconds()).
+			Msg("database writes throttled by write budget")
+	}
+	return nil
+}
+
+func NewWriteBudget(conf *WriteBudgetConf, tz *time.Location) *WriteBudget {
+	now := time.Now()
+	ans := &WriteBudget{
+		conf:    conf,
+		tz:      tz,
+		atNight: TimeIsAtNight(now.In(tz)),
+	}
+	limit := conf.DayWritesPerSec
+	if ans.atNight {
+		limit = conf.NightWritesPerSec
+	}
+	ans.limiter = rate.NewLimiter(rate.Limit(limit), conf.Burst)
+	return ans
+}
+
+// SetWriteBudget makes all the write operations wait for the budget
+func (ops *MySQLConcArch) SetWriteBudget(wb *WriteBudget) {
+	ops.writeBudget = wb
+}
+
+// SetWriteBudget makes all the write operations wait for the budget
+func (ops *MySQLQueryHist) SetWriteBudget(wb *WriteBudget) {
+	ops.writeBudget = wb
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBudgetWaitAboveBurst(t *testing.T) {
+	conf := &WriteBudgetConf{DayWritesPerSec: 1000, NightWritesPerSec: 1000, Burst: 10}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	wb := NewWriteBudget(conf, time.UTC)
+	assert.NoError(t, wb.Wait(context.Background(), 25))
+}
+
+func TestWriteBudgetCancelled(t *testing.T) {
+	conf := &WriteBudgetConf{DayWritesPerSec: 0.001, NightWritesPerSec: 0.001, Burst: 1}
+	wb := NewWriteBudget(conf, time.UTC)
+	assert.NoError(t, wb.Wait(context.Background(), 1))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, wb.Wait(ctx, 1))
+}
+
+func TestNilWriteBudget(t *testing.T) {
+	var wb *WriteBudget
+	assert.NoError(t, wb.Wait(context.Background(), 100))
+}
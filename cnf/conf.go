@@ -21,6 +21,9 @@ import (
 	"camus/cleaner"
 	"camus/cncdb"
 	"camus/indexer"
+	"camus/integrity"
+	"camus/quality"
+	"camus/webhook"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -55,6 +58,9 @@ type Conf struct {
 	Indexer                *indexer.Conf       `json:"indexer"`
 	Cleaner                cleaner.Conf        `json:"cleaner"`
 	Reporting              hltscl.PgConf       `json:"reporting"`
+	Webhooks               *webhook.Conf       `json:"webhooks"`
+	DataQuality            *quality.Conf       `json:"dataQuality"`
+	Integrity              *integrity.Conf     `json:"integrity"`
 }
 
 func (conf *Conf) TimezoneLocation() *time.Location {
@@ -104,6 +110,22 @@ func ValidateAndDefaults(conf *Conf) {
 		log.Fatal().Err(err).Msg("invalid time zone")
 	}
 
+	if err := conf.MySQL.MergePolicy.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid database configuration")
+	}
+	if err := conf.MySQL.Encryption.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid database configuration")
+	}
+	if err := conf.MySQL.WriteBudget.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid database configuration")
+	}
+	if err := conf.MySQL.Mirror.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid database configuration")
+	}
+	if err := conf.MySQL.Replica.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid database configuration")
+	}
+
 	if err := conf.Redis.ValidateAndDefaults(); err != nil {
 		log.Fatal().Err(err).Msg("invalid Redis configuration")
 	}
@@ -119,4 +141,19 @@ func ValidateAndDefaults(conf *Conf) {
 	if err := conf.Indexer.ValidateAndDefaults(); err != nil {
 		log.Fatal().Err(err).Msg("invalid indexer configuration")
 	}
+
+	if err := conf.Webhooks.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid webhooks configuration")
+	}
+
+	if err := conf.DataQuality.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid data quality configuration")
+	}
+
+	if conf.Integrity != nil && !conf.MySQL.ChecksumColumn {
+		log.Fatal().Msg("invalid integrity configuration - `db.checksumColumn` must be enabled")
+	}
+	if err := conf.Integrity.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid integrity configuration")
+	}
 }
@@ -19,15 +19,23 @@ package main
 import (
 	"camus/archiver"
 	"camus/cncdb"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/czcorpus/cnc-gokit/uniresp"
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	dfltNumLargestRecords = 20
+	maxNumLargestRecords  = 1000
+)
+
 var (
 	brokenConcRec1 = regexp.MustCompile(`^get concordance:[^:]+:\s*`)
 )
@@ -60,11 +68,12 @@ type Actions struct {
 func (a *Actions) Overview(ctx *gin.Context) {
 	ans := make(map[string]any)
 	ans["archiver"] = a.ArchKeeper.GetStats()
+	ans["recordSizes"] = a.ArchKeeper.GetRecordSizeStats()
 	var forceTotalsReload bool
 	if ctx.Query("forceReload") == "1" {
 		forceTotalsReload = true
 	}
-	totals, err := a.ArchKeeper.YearsStats(forceTotalsReload)
+	totals, err := a.ArchKeeper.YearsStats(ctx.Request.Context(), forceTotalsReload)
 	if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
 		return
@@ -73,8 +82,47 @@ func (a *Actions) Overview(ctx *gin.Context) {
 	uniresp.WriteJSONResponse(ctx.Writer, ans)
 }
 
+// LargestRecords lists the largest archived records created since
+// `fromDate` (YYYY-MM-DD, default is one year ago). As the query is demanding,
+// it is performed only at night unless `forceReload=1` is provided.
+func (a *Actions) LargestRecords(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(dfltNumLargestRecords)))
+	if err != nil || limit <= 0 || limit > maxNumLargestRecords {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid limit (must be between 1 and %d)", maxNumLargestRecords),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	fromDate := time.Now().AddDate(-1, 0, 0)
+	if v := ctx.Query("fromDate"); v != "" {
+		fromDate, err = time.Parse("2006-01-02", v)
+		if err != nil {
+			uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("invalid fromDate: %w", err), http.StatusBadRequest)
+			return
+		}
+	}
+	recs, err := a.ArchKeeper.LargestRecords(
+		ctx.Request.Context(), fromDate, limit, ctx.Query("forceReload") == "1")
+	if errors.Is(err, cncdb.ErrTooDemandingQuery) {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("the query can be performed only at night (or use forceReload=1)"),
+			http.StatusServiceUnavailable,
+		)
+		return
+
+	} else if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"records": recs})
+}
+
 func (a *Actions) GetRecord(ctx *gin.Context) {
-	rec, err := a.ArchKeeper.LoadRecordsByID(ctx.Param("id"))
+	rec, err := a.ArchKeeper.LoadRecordsByID(
+		cncdb.WithReplicaRead(ctx.Request.Context()), ctx.Param("id"))
 	if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError) // TODO
 		return
@@ -85,6 +133,7 @@ func (a *Actions) GetRecord(ctx *gin.Context) {
 func (a *Actions) Validate(ctx *gin.Context) {
 	currID := ctx.Param("id")
 	visitedIDs := make(visitedIds)
+	readCtx := cncdb.WithReplicaRead(ctx.Request.Context())
 	for currID != "" {
 		visitedIDs[currID]++
 		if visitedIDs.containsCycle() {
@@ -94,7 +143,7 @@ func (a *Actions) Validate(ctx *gin.Context) {
 			)
 			return
 		}
-		recs, err := a.ArchKeeper.LoadRecordsByID(currID)
+		recs, err := a.ArchKeeper.LoadRecordsByID(readCtx, currID)
 		if err != nil {
 			uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError) // TODO
 			return
@@ -129,7 +178,7 @@ func (a *Actions) Validate(ctx *gin.Context) {
 }
 
 func (a *Actions) Fix(ctx *gin.Context) {
-	recs, err := a.ArchKeeper.LoadRecordsByID(ctx.Param("id"))
+	recs, err := a.ArchKeeper.LoadRecordsByID(ctx.Request.Context(), ctx.Param("id"))
 	if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError) // TODO
 		return
@@ -139,19 +188,20 @@ func (a *Actions) Fix(ctx *gin.Context) {
 		rec.Data = brokenConcRec1.ReplaceAllString(rec.Data, "")
 		fixedRecs[i] = rec
 	}
-	newRec, err := a.ArchKeeper.DeduplicateInArchive(fixedRecs, fixedRecs[0])
+	merged, err := a.ArchKeeper.DeduplicateInArchive(ctx.Request.Context(), fixedRecs, fixedRecs[0])
 	if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError) // TODO
 		return
 	}
 	ans := make(map[string]any)
 	ans["numInstances"] = len(recs)
-	ans["fixed"] = newRec
+	ans["fixed"] = merged.Record
+	ans["conflicts"] = merged.Conflicts
 	uniresp.WriteJSONResponse(ctx.Writer, ans)
 }
 
 func (a *Actions) DedupReset(ctx *gin.Context) {
-	if err := a.ArchKeeper.Reset(); err != nil {
+	if err := a.ArchKeeper.Reset(ctx.Request.Context()); err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
 		return
 	}
@@ -23,7 +23,11 @@ import (
 	"camus/indexer"
 	"camus/reporting"
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -34,6 +38,53 @@ const (
 	timeWaitAfterDelErrors = 5 * time.Minute
 )
 
+var (
+	ErrGCPaused     = errors.New("query history garbage collector is paused")
+	ErrGCNotRunning = errors.New("query history garbage collector is not running")
+)
+
+// historyIndex describes the part of the fulltext index
+// the garbage collector works with
+type historyIndex interface {
+	Count() (uint64, error)
+	Delete(ctx context.Context, recID string) error
+}
+
+// GCMarkResult describes a result of the latest marking cycle
+// (i.e. marking of old records for deletion)
+type GCMarkResult struct {
+	Finished  time.Time `json:"finished"`
+	Triggered bool      `json:"triggered"`
+	NumMarked int64     `json:"numMarked"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// GCDeletionResult describes a result of the latest deletion cycle
+type GCDeletionResult struct {
+	reporting.QueryHistoryDelStats
+	Finished  time.Time `json:"finished"`
+	Triggered bool      `json:"triggered"`
+}
+
+// GCState is an overview of the garbage collector's state
+type GCState struct {
+	Paused       bool       `json:"paused"`
+	PausedSince  *time.Time `json:"pausedSince,omitempty"`
+	NextMark     time.Time  `json:"nextMark"`
+	NextDeletion time.Time  `json:"nextDeletion"`
+
+	// WaitingAfterErrors is true in case the last deletion cycle
+	// failed and the next one is postponed
+	WaitingAfterErrors bool                         `json:"waitingAfterErrors"`
+	LastMark           *GCMarkResult                `json:"lastMark"`
+	LastDeletion       *GCDeletionResult            `json:"lastDeletion"`
+	Backlog            cncdb.PendingDeletionBacklog `json:"backlog"`
+
+	// EstimatedClearSecs is an estimated time needed to delete all
+	// the records from the backlog. It is not available while paused.
+	EstimatedClearSecs *float64 `json:"estimatedClearSecs"`
+}
+
 type GarbageCollector struct {
 	db            cncdb.IQHistArchOps
 	rdb           *archiver.RedisAdapter
@@ -41,8 +92,24 @@ type GarbageCollector struct {
 	markInterval  time.Duration
 	numPreserve   int
 	maxNumDelete  int
-	indexer       *indexer.Indexer
+	indexer       historyIndex
 	statusWriter  reporting.IReporting
+
+	// trashRetention specifies how long deleted records are kept
+	// in the trash. Zero means the trash is disabled.
+	trashRetention time.Duration
+
+	markTrigger   chan struct{}
+	deleteTrigger chan struct{}
+
+	mu                 sync.Mutex
+	running            bool
+	pausedSince        *time.Time
+	nextMark           time.Time
+	nextDeletion       time.Time
+	waitingAfterErrors bool
+	lastMark           *GCMarkResult
+	lastDeletion       *GCDeletionResult
 }
 
 func (gc *GarbageCollector) Start(ctx context.Context) {
@@ -50,6 +117,13 @@ func (gc *GarbageCollector) Start(ctx context.Context) {
 		Str("rmCheckInterval", gc.checkInterval.String()).
 		Msg("starting history.GarbageCollector task")
 
+	now := time.Now()
+	gc.mu.Lock()
+	gc.running = true
+	gc.nextDeletion = now.Add(gc.checkInterval)
+	gc.nextMark = now.Add(gc.markInterval)
+	gc.mu.Unlock()
+
 	timer := time.NewTimer(gc.checkInterval)
 	markerTimer := time.NewTicker(gc.markInterval)
 	go func() {
@@ -59,72 +133,214 @@ func (gc *GarbageCollector) Start(ctx context.Context) {
 				log.Info().Msg("about to close fulltext Service")
 				return
 			case <-markerTimer.C:
-				gc.createPendingRecords()
-			case <-timer.C:
-				var numErr int
-				indexSize, err := gc.indexer.Count()
-				if err != nil {
-					numErr++
-					log.Error().Err(err).Msg("failed to obtain fulltext index size")
-				}
-
-				tableSize, err := gc.db.TableSize()
-				if err != nil {
-					numErr++
-					log.Error().Err(err).Msg("failed to obtain table kontext_query_history size")
-				}
-
-				delStats := gc.processDeletionPendingRecords()
-				delStats.NumErrors += numErr
-				if delStats.NumErrors == 0 {
-					delStats.IndexSize = int64(indexSize)
-					delStats.SQLTableSize = tableSize
+				gc.mu.Lock()
+				gc.nextMark = time.Now().Add(gc.markInterval)
+				gc.mu.Unlock()
+				if gc.IsPaused() {
+					log.Info().Msg("query history GC paused, skipping marking of old records")
+					continue
 				}
-				gc.statusWriter.WriteQueryHistoryDeletionStatus(delStats)
-
-				if delStats.NumErrors == 0 {
-					timer = time.NewTimer(gc.checkInterval)
+				gc.runMarkCycle(ctx, false)
+			case <-gc.markTrigger:
+				gc.runMarkCycle(ctx, true)
+			case <-timer.C:
+				wait := gc.checkInterval
+				if gc.IsPaused() {
+					log.Info().Msg("query history GC paused, skipping deletion of pending records")
 
 				} else {
-					go func() {
-						log.Error().
-							Msgf(
-								"errors in deleting of pending records - going to wait %01.1f minutes then continue",
-								timeWaitAfterDelErrors.Seconds(),
-							)
-						time.Sleep(timeWaitAfterDelErrors)
-						timer = time.NewTimer(gc.checkInterval)
-					}()
+					wait = gc.runDeletionCycle(ctx, false)
 				}
+				gc.scheduleDeletion(timer, wait)
+			case <-gc.deleteTrigger:
+				gc.scheduleDeletion(timer, gc.runDeletionCycle(ctx, true))
 			}
 		}
 	}()
 }
 
-func (gc *GarbageCollector) createPendingRecords() {
-	numRm, err := gc.db.MarkOldRecords(gc.numPreserve)
+func (gc *GarbageCollector) scheduleDeletion(timer *time.Timer, wait time.Duration) {
+	timer.Reset(wait)
+	gc.mu.Lock()
+	gc.nextDeletion = time.Now().Add(wait)
+	gc.mu.Unlock()
+}
+
+func (gc *GarbageCollector) runMarkCycle(ctx context.Context, triggered bool) {
+	markCtx, cancel := context.WithTimeout(ctx, gc.markInterval)
+	numMarked, err := gc.createPendingRecords(markCtx)
+	cancel()
+	result := &GCMarkResult{Finished: time.Now(), Triggered: triggered, NumMarked: numMarked}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	gc.mu.Lock()
+	gc.lastMark = result
+	gc.mu.Unlock()
+}
+
+// runDeletionCycle deletes the next batch of pending records and returns
+// time to wait before the next cycle
+func (gc *GarbageCollector) runDeletionCycle(ctx context.Context, triggered bool) time.Duration {
+	tickCtx, cancel := context.WithTimeout(ctx, gc.checkInterval)
+	defer cancel()
+	var numErr int
+	indexSize, err := gc.indexer.Count()
+	if err != nil {
+		numErr++
+		log.Error().Err(err).Msg("failed to obtain fulltext index size")
+	}
+
+	tableSize, err := gc.db.TableSize(tickCtx)
+	if err != nil {
+		numErr++
+		log.Error().Err(err).Msg("failed to obtain table kontext_query_history size")
+	}
+
+	delStats := gc.processDeletionPendingRecords(tickCtx)
+	if gc.trashRetention > 0 {
+		numPurged, err := gc.db.PurgeTrash(tickCtx, time.Now().Add(-gc.trashRetention))
+		if err != nil {
+			numErr++
+			log.Error().Err(err).Msg("failed to purge query history trash")
+
+		} else if numPurged > 0 {
+			log.Info().Int64("numPurged", numPurged).Msg("purged old items from query history trash")
+		}
+	}
+	delStats.NumErrors += numErr
+	if delStats.NumErrors == 0 {
+		delStats.IndexSize = int64(indexSize)
+		delStats.SQLTableSize = tableSize
+	}
+	gc.statusWriter.WriteQueryHistoryDeletionStatus(delStats)
+
+	wait := gc.checkInterval
+	if delStats.NumErrors > 0 {
+		log.Error().
+			Msgf(
+				"errors in deleting of pending records - going to wait %01.1f minutes then continue",
+				timeWaitAfterDelErrors.Minutes(),
+			)
+		wait = timeWaitAfterDelErrors
+	}
+	gc.mu.Lock()
+	gc.lastDeletion = &GCDeletionResult{
+		QueryHistoryDelStats: delStats,
+		Finished:             time.Now(),
+		Triggered:            triggered,
+	}
+	gc.waitingAfterErrors = delStats.NumErrors > 0
+	gc.mu.Unlock()
+	return wait
+}
+
+// Pause makes the garbage collector skip all the scheduled cycles
+// until Resume is called. The state is not preserved across restarts.
+func (gc *GarbageCollector) Pause() {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.pausedSince == nil {
+		now := time.Now()
+		gc.pausedSince = &now
+		log.Warn().Msg("query history GC paused")
+	}
+}
+
+func (gc *GarbageCollector) Resume() {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.pausedSince != nil {
+		gc.pausedSince = nil
+		log.Warn().Msg("query history GC resumed")
+	}
+}
+
+func (gc *GarbageCollector) IsPaused() bool {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.pausedSince != nil
+}
+
+func (gc *GarbageCollector) trigger(ch chan struct{}) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if !gc.running {
+		return ErrGCNotRunning
+	}
+	if gc.pausedSince != nil {
+		return ErrGCPaused
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+		// already triggered and waiting for processing
+	}
+	return nil
+}
+
+// TriggerMark makes the garbage collector run the marking
+// cycle immediately
+func (gc *GarbageCollector) TriggerMark() error {
+	return gc.trigger(gc.markTrigger)
+}
+
+// TriggerDeletion makes the garbage collector run the deletion
+// cycle immediately. The next scheduled deletion is then planned
+// relatively to the triggered one.
+func (gc *GarbageCollector) TriggerDeletion() error {
+	return gc.trigger(gc.deleteTrigger)
+}
+
+func (gc *GarbageCollector) State(ctx context.Context) (GCState, error) {
+	gc.mu.Lock()
+	ans := GCState{
+		Paused:             gc.pausedSince != nil,
+		PausedSince:        gc.pausedSince,
+		NextMark:           gc.nextMark,
+		NextDeletion:       gc.nextDeletion,
+		WaitingAfterErrors: gc.waitingAfterErrors,
+		LastMark:           gc.lastMark,
+		LastDeletion:       gc.lastDeletion,
+	}
+	gc.mu.Unlock()
+	var err error
+	ans.Backlog, err = gc.db.GetPendingDeletionBacklog(ctx)
+	if err != nil {
+		return ans, fmt.Errorf("failed to get query history GC state: %w", err)
+	}
+	if !ans.Paused && gc.maxNumDelete > 0 {
+		numCycles := math.Ceil(float64(ans.Backlog.NumRecords) / float64(gc.maxNumDelete))
+		est := numCycles * gc.checkInterval.Seconds()
+		ans.EstimatedClearSecs = &est
+	}
+	return ans, nil
+}
+
+func (gc *GarbageCollector) createPendingRecords(ctx context.Context) (int64, error) {
+	numRm, err := gc.db.MarkOldRecords(ctx, gc.numPreserve)
 	if err != nil {
 		log.Error().
 			Err(err).
 			Msg("failed to mark kontext_query_history records for deletion (will try again)")
-
-	} else {
-		log.Info().
-			Int64("numMarked", numRm).
-			Msg("marked next set of kontext_query_history records for deletion")
+		return 0, err
 	}
+	log.Info().
+		Int64("numMarked", numRm).
+		Msg("marked next set of kontext_query_history records for deletion")
+	return numRm, nil
 }
 
 // processDeletionPendingRecords returns status whether we are allowed
 // to run a new timer to process the next batch of records.
-func (gc *GarbageCollector) processDeletionPendingRecords() reporting.QueryHistoryDelStats {
+func (gc *GarbageCollector) processDeletionPendingRecords(ctx context.Context) reporting.QueryHistoryDelStats {
 	log.Debug().Msg("retrieving next query history data with pending deletion")
-	tx, err := gc.db.NewTransaction()
+	tx, err := gc.db.NewTransaction(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to retrieve next query history data with pending deletion")
 		return reporting.QueryHistoryDelStats{NumErrors: 1}
 	}
-	recs, err := gc.db.GetPendingDeletionRecords(tx, gc.maxNumDelete)
+	recs, err := gc.db.GetPendingDeletionRecords(ctx, tx, gc.maxNumDelete)
 	log.Debug().
 		Int("maxLimit", gc.maxNumDelete).
 		Int("numRecords", len(recs)).
@@ -136,8 +352,17 @@ func (gc *GarbageCollector) processDeletionPendingRecords() reporting.QueryHisto
 		}
 		return reporting.QueryHistoryDelStats{NumErrors: 1}
 	}
+	if gc.trashRetention > 0 {
+		if err := gc.db.TrashRecords(ctx, tx, recs, cncdb.TrashSourceGC); err != nil {
+			log.Error().Err(err).Msg("failed to move query history items to trash")
+			if err := tx.Rollback(); err != nil {
+				log.Error().Err(err).Msg("failed to rollback transaction")
+			}
+			return reporting.QueryHistoryDelStats{NumErrors: 1}
+		}
+	}
 	for _, rec := range recs {
-		if err := gc.db.RemoveRecord(tx, rec.Created, rec.UserID, rec.QueryID); err != nil {
+		if err := gc.db.RemoveRecord(ctx, tx, rec.Created, rec.UserID, rec.QueryID); err != nil {
 			log.Error().
 				Int64("created", rec.Created).
 				Int("userId", rec.UserID).
@@ -149,7 +374,7 @@ func (gc *GarbageCollector) processDeletionPendingRecords() reporting.QueryHisto
 			}
 			return reporting.QueryHistoryDelStats{NumErrors: 1}
 		}
-		if err := gc.indexer.Delete(rec.CreateIndexID()); err != nil {
+		if err := gc.indexer.Delete(ctx, rec.CreateIndexID()); err != nil {
 			log.Error().
 				Int64("created", rec.Created).
 				Int("userId", rec.UserID).
@@ -183,7 +408,7 @@ func (gc *GarbageCollector) RunAdHoc(
 	chunkSize int,
 ) {
 
-	cacheExists, err := gc.rdb.Exists(gcUsersProcSetKey)
+	cacheExists, err := gc.rdb.Exists(ctx, gcUsersProcSetKey)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to garbage collect query history")
 		os.Exit(1)
@@ -191,14 +416,14 @@ func (gc *GarbageCollector) RunAdHoc(
 	}
 	if !cacheExists {
 		log.Info().Msg("processed user IDs not found - will create a new set")
-		users, err := gc.db.GetAllUsersWithSomeRecords()
+		users, err := gc.db.GetAllUsersWithSomeRecords(ctx)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to garbage collect query history")
 			os.Exit(2)
 			return
 		}
 		for _, uid := range users {
-			gc.rdb.UintZAdd(gcUsersProcSetKey, uid)
+			gc.rdb.UintZAdd(ctx, gcUsersProcSetKey, uid)
 		}
 		log.Info().Int("numberOfUsers", len(users)).Msg("added users to process")
 	}
@@ -213,7 +438,7 @@ func (gc *GarbageCollector) RunAdHoc(
 	}
 	log.Info().Int("chunkSize", chunkSize).Msg("processing next chunk of users")
 	for i := 0; i < chunkSize; i++ {
-		nextUserID, err := gc.rdb.UintZRemLowest(gcUsersProcSetKey)
+		nextUserID, err := gc.rdb.UintZRemLowest(ctx, gcUsersProcSetKey)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to garbage collect query history")
 			os.Exit(4)
@@ -224,7 +449,7 @@ func (gc *GarbageCollector) RunAdHoc(
 			break
 		}
 
-		rmFromIndex, err := gc.db.GetUserGarbageRecords(nextUserID)
+		rmFromIndex, err := gc.db.GetUserGarbageRecords(ctx, nextUserID)
 		if err != nil {
 			log.Error().
 				Err(err).
@@ -232,8 +457,17 @@ func (gc *GarbageCollector) RunAdHoc(
 				Msg("failed to garbage-collect queries for a user")
 			continue
 		}
+		if gc.trashRetention > 0 {
+			if err := gc.db.TrashRecords(ctx, nil, rmFromIndex, cncdb.TrashSourceGC); err != nil {
+				log.Error().
+					Err(err).
+					Int("userId", nextUserID).
+					Msg("failed to move queries of a user to trash, skipping")
+				continue
+			}
+		}
 		for _, v := range rmFromIndex {
-			if err := ftIndexer.Delete(v.CreateIndexID()); err != nil {
+			if err := ftIndexer.Delete(ctx, v.CreateIndexID()); err != nil {
 				log.Error().
 					Err(err).
 					Int("userId", nextUserID).
@@ -243,7 +477,7 @@ func (gc *GarbageCollector) RunAdHoc(
 			}
 		}
 
-		numRemoved, err := gc.db.GarbageCollectRecords(nextUserID)
+		numRemoved, err := gc.db.GarbageCollectRecords(ctx, nextUserID)
 		if err != nil {
 			log.Error().
 				Err(err).
@@ -265,7 +499,7 @@ func (gc *GarbageCollector) RunAdHoc(
 		}
 
 	}
-	remainingUsers, err := gc.rdb.ZCard(gcUsersProcSetKey)
+	remainingUsers, err := gc.rdb.ZCard(ctx, gcUsersProcSetKey)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to determine remaining num. of users to process")
 		os.Exit(6)
@@ -285,13 +519,16 @@ func NewGarbageCollector(
 	conf *indexer.Conf,
 ) *GarbageCollector {
 	return &GarbageCollector{
-		db:            db,
-		rdb:           rdb,
-		indexer:       fulltext,
-		statusWriter:  statusWriter,
-		checkInterval: conf.QueryHistoryCleanupIntervalDur(),
-		markInterval:  conf.QueryHistoryMarkPendingIntervalDur(),
-		maxNumDelete:  conf.QueryHistoryMaxNumDeleteAtOnce,
-		numPreserve:   conf.QueryHistoryNumPreserve,
+		db:             db,
+		rdb:            rdb,
+		indexer:        fulltext,
+		statusWriter:   statusWriter,
+		checkInterval:  conf.QueryHistoryCleanupIntervalDur(),
+		markInterval:   conf.QueryHistoryMarkPendingIntervalDur(),
+		maxNumDelete:   conf.QueryHistoryMaxNumDeleteAtOnce,
+		numPreserve:    conf.QueryHistoryNumPreserve,
+		trashRetention: conf.QueryHistoryTrashRetention(),
+		markTrigger:    make(chan struct{}, 1),
+		deleteTrigger:  make(chan struct{}, 1),
 	}
 }
@@ -0,0 +1,207 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"camus/cncdb"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// txTestDB is a minimal SQL driver providing records pending deletion
+// and recording committed write statements as "<verb> <query ID>"
+type txTestDB struct {
+	mu        sync.Mutex
+	pending   []cncdb.HistoryRecord
+	committed []string
+}
+
+func (db *txTestDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return &txTestConn{db: db}, nil
+}
+
+func (db *txTestDB) Driver() driver.Driver {
+	return db
+}
+
+func (db *txTestDB) Open(name string) (driver.Conn, error) {
+	return &txTestConn{db: db}, nil
+}
+
+type txTestConn struct {
+	db   *txTestDB
+	inTx bool
+	tx   []string
+}
+
+func (c *txTestConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements not supported")
+}
+
+func (c *txTestConn) Close() error {
+	return nil
+}
+
+func (c *txTestConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	c.tx = []string{}
+	return c, nil
+}
+
+func (c *txTestConn) Commit() error {
+	c.db.mu.Lock()
+	c.db.committed = append(c.db.committed, c.tx...)
+	c.db.mu.Unlock()
+	c.inTx = false
+	return nil
+}
+
+func (c *txTestConn) Rollback() error {
+	c.inTx = false
+	return nil
+}
+
+func (c *txTestConn) ExecContext(
+	ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	var queryID string
+	for _, arg := range args {
+		if v, ok := arg.Value.(string); ok {
+			queryID = v
+			break
+		}
+	}
+	stmt := fmt.Sprintf("%s %s", strings.Fields(query)[0], queryID)
+	if c.inTx {
+		c.tx = append(c.tx, stmt)
+
+	} else {
+		c.db.mu.Lock()
+		c.db.committed = append(c.db.committed, stmt)
+		c.db.mu.Unlock()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *txTestConn) QueryContext(
+	ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &txTestRows{recs: c.db.pending}, nil
+}
+
+type txTestRows struct {
+	recs []cncdb.HistoryRecord
+}
+
+func (r *txTestRows) Columns() []string {
+	return []string{"user_id", "query_id", "created", "name"}
+}
+
+func (r *txTestRows) Close() error {
+	return nil
+}
+
+func (r *txTestRows) Next(dest []driver.Value) error {
+	if len(r.recs) == 0 {
+		return io.EOF
+	}
+	dest[0] = int64(r.recs[0].UserID)
+	dest[1] = r.recs[0].QueryID
+	dest[2] = r.recs[0].Created
+	dest[3] = nil
+	r.recs = r.recs[1:]
+	return nil
+}
+
+type failingIndex struct {
+	failID string
+}
+
+func (idx *failingIndex) Count() (uint64, error) {
+	return 0, nil
+}
+
+func (idx *failingIndex) Delete(ctx context.Context, recID string) error {
+	if recID == idx.failID {
+		return fmt.Errorf("failed to delete %s", recID)
+	}
+	return nil
+}
+
+func newTxTestGC(recs []cncdb.HistoryRecord, failID string) (*GarbageCollector, *txTestDB) {
+	db := &txTestDB{pending: recs}
+	_, qhist := cncdb.NewMySQLOps(sql.OpenDB(db), time.UTC)
+	return &GarbageCollector{
+		db:             qhist,
+		indexer:        &failingIndex{failID: failID},
+		maxNumDelete:   10,
+		trashRetention: time.Hour,
+	}, db
+}
+
+func TestGCTrigger(t *testing.T) {
+	gc := &GarbageCollector{
+		markTrigger:   make(chan struct{}, 1),
+		deleteTrigger: make(chan struct{}, 1),
+	}
+	assert.ErrorIs(t, gc.TriggerMark(), ErrGCNotRunning)
+
+	gc.running = true
+	gc.Pause()
+	assert.True(t, gc.IsPaused())
+	assert.ErrorIs(t, gc.TriggerDeletion(), ErrGCPaused)
+
+	gc.Resume()
+	assert.NoError(t, gc.TriggerDeletion())
+	// repeated trigger is merged with the pending one
+	assert.NoError(t, gc.TriggerDeletion())
+	assert.Len(t, gc.deleteTrigger, 1)
+}
+
+func TestGCDeletionTrashesRecords(t *testing.T) {
+	recs := []cncdb.HistoryRecord{
+		{UserID: 1, QueryID: "q1", Created: 100},
+		{UserID: 1, QueryID: "q2", Created: 200},
+	}
+	gc, db := newTxTestGC(recs, "")
+	stats := gc.processDeletionPendingRecords(context.Background())
+	assert.Equal(t, 2, stats.NumDeleted)
+	assert.Equal(t, 0, stats.NumErrors)
+	assert.Equal(t, []string{"INSERT q1", "INSERT q2", "DELETE q1", "DELETE q2"}, db.committed)
+}
+
+func TestGCDeletionRollsBackOnIndexError(t *testing.T) {
+	recs := []cncdb.HistoryRecord{
+		{UserID: 1, QueryID: "q1", Created: 100},
+		{UserID: 1, QueryID: "q2", Created: 200},
+	}
+	gc, db := newTxTestGC(recs, recs[1].CreateIndexID())
+	stats := gc.processDeletionPendingRecords(context.Background())
+	assert.Equal(t, 0, stats.NumDeleted)
+	assert.Equal(t, 1, stats.NumErrors)
+	// the already performed deletions must be rolled back along
+	// with the trash rows, otherwise the items would be lost
+	assert.Empty(t, db.committed)
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+type Actions struct {
+	gc *GarbageCollector
+}
+
+// GCState shows the state of the query history garbage collector
+// including the size of the deletion backlog.
+func (a *Actions) GCState(ctx *gin.Context) {
+	state, err := a.gc.State(ctx.Request.Context())
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, state)
+}
+
+func (a *Actions) PauseGC(ctx *gin.Context) {
+	a.gc.Pause()
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"paused": true})
+}
+
+func (a *Actions) ResumeGC(ctx *gin.Context) {
+	a.gc.Resume()
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"paused": false})
+}
+
+// RunGC triggers an immediate GC cycle specified by the `cycle`
+// URL argument (`mark` or `delete`).
+func (a *Actions) RunGC(ctx *gin.Context) {
+	var err error
+	cycle := ctx.Query("cycle")
+	switch cycle {
+	case "mark":
+		err = a.gc.TriggerMark()
+	case "delete":
+		err = a.gc.TriggerDeletion()
+	default:
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid cycle `%s` (must be either `mark` or `delete`)", cycle),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	if errors.Is(err, ErrGCPaused) {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusConflict)
+		return
+
+	} else if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusServiceUnavailable)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"triggered": cycle})
+}
+
+func NewActions(gc *GarbageCollector) *Actions {
+	return &Actions{gc: gc}
+}
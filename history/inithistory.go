@@ -39,10 +39,11 @@ type DataInitializer struct {
 	rdb         *archiver.RedisAdapter
 }
 
-func (di *DataInitializer) processQuery(hRec cncdb.HistoryRecord, ftIndexer *indexer.Indexer) error {
-	rec, err := di.rdb.GetConcRecord(hRec.QueryID)
+func (di *DataInitializer) processQuery(
+	ctx context.Context, hRec cncdb.HistoryRecord, ftIndexer *indexer.Indexer) error {
+	rec, err := di.rdb.GetConcRecord(ctx, hRec.QueryID)
 	if err == cncdb.ErrRecordNotFound {
-		recs, err := di.concArchDb.LoadRecordsByID(hRec.QueryID)
+		recs, err := di.concArchDb.LoadRecordsByID(ctx, hRec.QueryID)
 		if err != nil {
 			return fmt.Errorf("failed to load query %s from MySQL: %w", hRec.QueryID, err)
 		}
@@ -55,7 +56,7 @@ func (di *DataInitializer) processQuery(hRec cncdb.HistoryRecord, ftIndexer *ind
 		return fmt.Errorf("failed to process query %s: %w", hRec.QueryID, err)
 	}
 	hRec.Rec = &rec
-	ok, err := ftIndexer.IndexRecord(&hRec)
+	ok, err := ftIndexer.IndexRecord(ctx, &hRec)
 	if err != nil {
 		return fmt.Errorf("failed to index query %s: %w", hRec.QueryID, err)
 	}
@@ -71,7 +72,7 @@ func (di *DataInitializer) Run(
 	chunkSize int,
 ) {
 	// check for status of possible previous run first
-	keyType, err := di.rdb.Type(usersProcSetKey)
+	keyType, err := di.rdb.Type(ctx, usersProcSetKey)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to init query history")
 		os.Exit(1)
@@ -87,7 +88,7 @@ func (di *DataInitializer) Run(
 
 	var finishedAllChunks bool
 
-	cacheExists, err := di.rdb.Exists(usersProcSetKey)
+	cacheExists, err := di.rdb.Exists(ctx, usersProcSetKey)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to init query history")
 		os.Exit(1)
@@ -95,14 +96,14 @@ func (di *DataInitializer) Run(
 	}
 	if !cacheExists {
 		log.Info().Msg("processed user IDs not found - will create a new set")
-		users, err := di.queryHistDb.GetAllUsersWithSomeRecords()
+		users, err := di.queryHistDb.GetAllUsersWithSomeRecords(ctx)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to init query history")
 			os.Exit(2)
 			return
 		}
 		for _, uid := range users {
-			di.rdb.UintZAdd(usersProcSetKey, uid)
+			di.rdb.UintZAdd(ctx, usersProcSetKey, uid)
 		}
 		log.Info().Int("numberOfUsers", len(users)).Msg("added users to process")
 	}
@@ -117,7 +118,7 @@ func (di *DataInitializer) Run(
 	}
 	log.Info().Int("chunkSize", chunkSize).Msg("processing next chunk of users")
 	for i := 0; i < chunkSize; i++ {
-		nextUserID, err := di.rdb.UintZRemLowest(usersProcSetKey)
+		nextUserID, err := di.rdb.UintZRemLowest(ctx, usersProcSetKey)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to init query history")
 			os.Exit(4)
@@ -127,7 +128,7 @@ func (di *DataInitializer) Run(
 			finishedAllChunks = true
 			break
 		}
-		qIDs, err := di.queryHistDb.GetUserRecords(nextUserID, conf.Indexer.QueryHistoryNumPreserve)
+		qIDs, err := di.queryHistDb.GetUserRecords(ctx, nextUserID, conf.Indexer.QueryHistoryNumPreserve)
 		log.Info().
 			Int("userId", nextUserID).
 			Err(err).
@@ -138,7 +139,7 @@ func (di *DataInitializer) Run(
 			return
 		}
 		for _, hRec := range qIDs {
-			if err := di.processQuery(hRec, ftIndexer); err != nil {
+			if err := di.processQuery(ctx, hRec, ftIndexer); err != nil {
 				log.Error().
 					Err(err).
 					Int("userId", nextUserID).
@@ -159,7 +160,7 @@ func (di *DataInitializer) Run(
 		default:
 		}
 	}
-	remainingUsers, err := di.rdb.ZCard(usersProcSetKey)
+	remainingUsers, err := di.rdb.ZCard(ctx, usersProcSetKey)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to determine remaining num. of users to process")
 		os.Exit(6)
@@ -172,7 +173,7 @@ func (di *DataInitializer) Run(
 	if finishedAllChunks {
 		rec := fmt.Sprintf("finished-%s", time.Now().In(conf.TimezoneLocation()))
 		log.Info().Msgf("no more items - writing '%s' to Redis and ending", rec)
-		if err := di.rdb.Set(usersProcSetKey, rec); err != nil {
+		if err := di.rdb.Set(ctx, usersProcSetKey, rec); err != nil {
 			log.Error().Err(err).Msg("failed to write 'finished' record to Redis")
 			os.Exit(5)
 		}
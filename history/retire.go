@@ -0,0 +1,112 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"camus/cncdb"
+	"camus/indexer"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+)
+
+type RetirementStats struct {
+	CorpusID          string `json:"corpusId"`
+	NumArchScanned    int    `json:"numArchScanned"`
+	NumArchRetired    int    `json:"numArchRetired"`
+	NumArchErrors     int    `json:"numArchErrors"`
+	NumHistoryFound   int    `json:"numHistoryFound"`
+	NumHistoryRetired int    `json:"numHistoryRetired"`
+}
+
+// CorpusRetirement marks archive records and query history
+// documents referring to a withdrawn corpus as retired.
+type CorpusRetirement struct {
+	concArchDb cncdb.IConcArchOps
+	ftIndexer  *indexer.Indexer
+}
+
+func recordUsesCorpus(rec cncdb.ArchRecord, corpusID string) (bool, error) {
+	var data cncdb.UntypedQueryRecord
+	if err := json.Unmarshal([]byte(rec.Data), &data); err != nil {
+		return false, fmt.Errorf("failed to parse record %s: %w", rec.ID, err)
+	}
+	return slices.Contains(data.Corpora, corpusID), nil
+}
+
+// retireArchRecords goes through the whole archive and marks
+// records referring to the corpus.
+func (cr *CorpusRetirement) retireArchRecords(
+	ctx context.Context, corpusID string, chunkSize int, stats *RetirementStats) error {
+	retired := make(map[string]bool)
+	err := cncdb.ScanArchive(ctx, cr.concArchDb, chunkSize, func(item cncdb.ArchRecord) {
+		stats.NumArchScanned++
+		if retired[item.ID] || item.Retired {
+			return
+		}
+		uses, err := recordUsesCorpus(item, corpusID)
+		if err != nil {
+			log.Warn().Err(err).Str("recordId", item.ID).Msg("invalid archive record, skipping")
+			stats.NumArchErrors++
+			return
+		}
+		if !uses {
+			return
+		}
+		if err := cr.concArchDb.SetRecordRetired(ctx, item.ID); err != nil {
+			log.Error().Err(err).Str("recordId", item.ID).Msg("failed to retire archive record")
+			stats.NumArchErrors++
+			return
+		}
+		retired[item.ID] = true
+		stats.NumArchRetired++
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retire archive records: %w", err)
+	}
+	return nil
+}
+
+// Run marks all the archive records referring to the corpus
+// as retired (see cncdb.ArchRecord.Retired) and then reindexes
+// respective query history documents so they are flagged as retired
+// too.
+func (cr *CorpusRetirement) Run(ctx context.Context, corpusID string, chunkSize int) (RetirementStats, error) {
+	stats := RetirementStats{CorpusID: corpusID}
+	if err := cr.retireArchRecords(ctx, corpusID, chunkSize, &stats); err != nil {
+		return stats, err
+	}
+	var err error
+	stats.NumHistoryFound, stats.NumHistoryRetired, err = cr.ftIndexer.RetireCorpus(ctx, corpusID)
+	if err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+func NewCorpusRetirement(
+	concArchDb cncdb.IConcArchOps,
+	ftIndexer *indexer.Indexer,
+) *CorpusRetirement {
+	return &CorpusRetirement{
+		concArchDb: concArchDb,
+		ftIndexer:  ftIndexer,
+	}
+}
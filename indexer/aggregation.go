@@ -0,0 +1,301 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"camus/indexer/documents"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/rs/zerolog/log"
+)
+
+// aggregate represents a stored document collapsing multiple
+// query history items with the same query
+type aggregate struct {
+	id   string
+	name string
+	keys []string
+}
+
+// aggregateLocks serializes read-modify-write updates of aggregates.
+// Locks are per user as all the aggregates a history item may move
+// between (see removeFromAggregate) belong to the same user so there is
+// no need to acquire more locks at once.
+type aggregateLocks struct {
+	mu    sync.Mutex
+	locks map[int]*userAggLock
+}
+
+type userAggLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks aggregates of a user and returns a function to unlock them
+func (al *aggregateLocks) lock(userID int) func() {
+	al.mu.Lock()
+	if al.locks == nil {
+		al.locks = make(map[int]*userAggLock)
+	}
+	ul, ok := al.locks[userID]
+	if !ok {
+		ul = &userAggLock{}
+		al.locks[userID] = ul
+	}
+	ul.refs++
+	al.mu.Unlock()
+	ul.Lock()
+	return func() {
+		ul.Unlock()
+		al.mu.Lock()
+		ul.refs--
+		if ul.refs == 0 {
+			delete(al.locks, userID)
+		}
+		al.mu.Unlock()
+	}
+}
+
+// aggregatedDocID creates an ID of a document representing all the
+// history items of a user with the same (normalized) query.
+func aggregatedDocID(userID int, queryKey string) string {
+	return fmt.Sprintf("%d/agg/%s", userID, queryKey)
+}
+
+// storedStrings converts a stored Bleve field value (which is a string
+// for single values and a slice for multiple ones) into a slice of strings
+func storedStrings(v any) []string {
+	switch tv := v.(type) {
+	case string:
+		return []string{tv}
+	case []any:
+		ans := make([]string, 0, len(tv))
+		for _, item := range tv {
+			if s, ok := item.(string); ok {
+				ans = append(ans, s)
+			}
+		}
+		return ans
+	}
+	return []string{}
+}
+
+func hitToAggregate(hit *search.DocumentMatch) aggregate {
+	ans := aggregate{
+		id:   hit.ID,
+		keys: storedStrings(hit.Fields["history_keys"]),
+	}
+	ans.name, _ = hit.Fields["name"].(string)
+	return ans
+}
+
+// sortedHistoryKeys parses history keys and returns respective
+// records sorted by creation time (oldest first)
+func sortedHistoryKeys(keys []string) ([]cncdb.HistoryRecord, error) {
+	ans := make([]cncdb.HistoryRecord, len(keys))
+	for i, k := range keys {
+		hRec, err := cncdb.ParseIndexID(k)
+		if err != nil {
+			return []cncdb.HistoryRecord{}, err
+		}
+		ans[i] = hRec
+	}
+	sort.SliceStable(ans, func(i, j int) bool {
+		return ans[i].Created < ans[j].Created
+	})
+	return ans, nil
+}
+
+func newAggregationProps(queryKey string, keys []string) (documents.AggregationProps, error) {
+	hRecs, err := sortedHistoryKeys(keys)
+	if err != nil {
+		return documents.AggregationProps{}, fmt.Errorf("failed to create aggregation props: %w", err)
+	}
+	if len(hRecs) == 0 {
+		return documents.AggregationProps{}, fmt.Errorf("failed to create aggregation props: no history keys")
+	}
+	firstUsed := time.Unix(hRecs[0].Created, 0)
+	lastUsed := time.Unix(hRecs[len(hRecs)-1].Created, 0)
+	sortedKeys := make([]string, len(hRecs))
+	for i, hRec := range hRecs {
+		sortedKeys[i] = hRec.CreateIndexID()
+	}
+	return documents.AggregationProps{
+		QueryKey:    queryKey,
+		UseCount:    len(sortedKeys),
+		FirstUsed:   &firstUsed,
+		LastUsed:    &lastUsed,
+		HistoryKeys: sortedKeys,
+	}, nil
+}
+
+func (idx *Indexer) loadAggregate(ctx context.Context, aggID string) (aggregate, bool, error) {
+	search := bleve.NewSearchRequest(bleve.NewDocIDQuery([]string{aggID}))
+	search.Fields = []string{"name", "history_keys"}
+	res, err := idx.bleveIdx.SearchInContext(ctx, search)
+	if err != nil {
+		return aggregate{}, false, fmt.Errorf("failed to load aggregate %s: %w", aggID, err)
+	}
+	if len(res.Hits) == 0 {
+		return aggregate{id: aggID}, false, nil
+	}
+	return hitToAggregate(res.Hits[0]), true, nil
+}
+
+// findAggregates returns all the aggregated documents containing
+// a specified history key.
+func (idx *Indexer) findAggregates(ctx context.Context, histKey string) ([]aggregate, error) {
+	q := bleve.NewMatchQuery(histKey)
+	q.SetField("history_keys")
+	search := bleve.NewSearchRequest(q)
+	search.Fields = []string{"name", "history_keys"}
+	res, err := idx.bleveIdx.SearchInContext(ctx, search)
+	if err != nil {
+		return []aggregate{}, fmt.Errorf("failed to find aggregates for %s: %w", histKey, err)
+	}
+	ans := make([]aggregate, len(res.Hits))
+	for i, hit := range res.Hits {
+		ans[i] = hitToAggregate(hit)
+	}
+	return ans, nil
+}
+
+// storeAggregate writes doc as an aggregate of provided history keys.
+// Any documents specified in replacedIDs are removed within the same batch.
+func (idx *Indexer) storeAggregate(
+	doc documents.IndexableDoc,
+	aggID, queryKey string,
+	keys []string,
+	replacedIDs ...string,
+) error {
+	props, err := newAggregationProps(queryKey, keys)
+	if err != nil {
+		return err
+	}
+	doc.SetAggregation(props)
+	batch := idx.bleveIdx.NewBatch()
+	for _, id := range replacedIDs {
+		if id != aggID {
+			batch.Delete(id)
+		}
+	}
+	if err := batch.Index(aggID, doc); err != nil {
+		return fmt.Errorf("failed to store aggregate %s: %w", aggID, err)
+	}
+	if err := idx.bleveIdx.Batch(batch); err != nil {
+		return fmt.Errorf("failed to store aggregate %s: %w", aggID, err)
+	}
+	return nil
+}
+
+// indexAggregated adds a history record to an aggregated document
+// of the same query (or creates a new one). The document provided
+// becomes the new content of the aggregate.
+func (idx *Indexer) indexAggregated(
+	ctx context.Context, hRec *cncdb.HistoryRecord, doc documents.IndexableDoc) error {
+	queryKey, err := documents.NormalizedQueryKey(doc)
+	if err != nil {
+		return err
+	}
+	aggID := aggregatedDocID(hRec.UserID, queryKey)
+	unlock := idx.aggLocks.lock(hRec.UserID)
+	defer unlock()
+	agg, _, err := idx.loadAggregate(ctx, aggID)
+	if err != nil {
+		return err
+	}
+	if hRec.Name == "" && agg.name != "" {
+		// we must not lose the name of the aggregate so we have
+		// to recreate the document
+		hRec.Name = agg.name
+		doc, err = idx.recToIndexableDoc(ctx, hRec)
+		if err != nil {
+			return err
+		}
+	}
+	histKey := hRec.CreateIndexID()
+	if !slices.Contains(agg.keys, histKey) {
+		agg.keys = append(agg.keys, histKey)
+	}
+	// the history record may have been indexed as a regular document
+	// before collapsing was enabled
+	return idx.storeAggregate(doc, aggID, queryKey, agg.keys, histKey)
+}
+
+// removeFromAggregate removes a history key from an aggregate. The aggregate
+// is then recreated from the latest remaining history item. In case there is
+// no usable history item left, the aggregate is deleted.
+// The caller is expected to hold the lock of the aggregate's user
+// (see aggregateLocks) and to load the aggregate while holding it.
+func (idx *Indexer) removeFromAggregate(ctx context.Context, agg aggregate, histKey string) error {
+	remaining := slices.DeleteFunc(slices.Clone(agg.keys), func(k string) bool { return k == histKey })
+	hRecs, err := sortedHistoryKeys(remaining)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s from aggregate %s: %w", histKey, agg.id, err)
+	}
+	for i := len(hRecs) - 1; i >= 0; i-- {
+		hRec := hRecs[i]
+		hRec.Name = agg.name
+		hRec.Rec, err = idx.GetConcRecord(ctx, hRec.QueryID)
+		if err != nil || hRec.Rec == nil {
+			log.Warn().
+				Err(err).
+				Str("aggregateId", agg.id).
+				Str("historyKey", hRec.CreateIndexID()).
+				Msg("cannot use history item to recreate aggregate, trying an older one")
+			continue
+		}
+		doc, err := idx.recToIndexableDoc(ctx, &hRec)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("aggregateId", agg.id).
+				Str("historyKey", hRec.CreateIndexID()).
+				Msg("cannot use history item to recreate aggregate, trying an older one")
+			continue
+		}
+		queryKey, err := documents.NormalizedQueryKey(doc)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s from aggregate %s: %w", histKey, agg.id, err)
+		}
+		aggID := aggregatedDocID(hRec.UserID, queryKey)
+		if aggID != agg.id {
+			// the document has changed since the aggregate was created
+			// (e.g. due to a subcorpus change) so we must merge it
+			// with a possibly existing other aggregate
+			target, _, err := idx.loadAggregate(ctx, aggID)
+			if err != nil {
+				return fmt.Errorf("failed to remove %s from aggregate %s: %w", histKey, agg.id, err)
+			}
+			for _, k := range target.keys {
+				if !slices.Contains(remaining, k) {
+					remaining = append(remaining, k)
+				}
+			}
+		}
+		return idx.storeAggregate(doc, aggID, queryKey, remaining, agg.id)
+	}
+	return idx.bleveIdx.Delete(agg.id)
+}
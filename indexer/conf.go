@@ -32,7 +32,14 @@ import (
 type Conf struct {
 
 	// IndexDirPath specifies a directory where Bleve stores
-	// its fulltext index data
+	// its fulltext index data.
+	// Please note that Bleve keeps the field mappings the index has
+	// been created with. Once Camus adds or changes a field mapping
+	// (e.g. `is_simple_query`, `subcorpus_id`, `pattern_fragments` or
+	// the word list/keywords form fields), searching by the field is
+	// refused until the index is rebuilt - i.e. the directory is removed
+	// and the index is filled again via `init-query-history`.
+	// Outdated fields are reported in the log on startup.
 	IndexDirPath string `json:"indexDirPath"`
 
 	// QueryHistoryNumPreserve specifies how many items we allow
@@ -56,6 +63,24 @@ type Conf struct {
 	QueryHistoryMarkPendingInterval string `json:"queryHistoryMarkPendingInterval"`
 
 	QueryHistoryMaxNumDeleteAtOnce int `json:"queryHistoryMaxNumDeleteAtOnce"`
+
+	// QueryHistoryTrashRetentionDays specifies how long deleted query
+	// history items are kept in the trash so they can be restored
+	// (see cncdb/histtrash.go for the required table). Zero value
+	// disables the trash.
+	QueryHistoryTrashRetentionDays int `json:"queryHistoryTrashRetentionDays"`
+
+	// CollapseRepeatedQueries specifies whether repeated identical queries
+	// of a user should be indexed as a single aggregate document (carrying
+	// a use count, first/last use time and keys of all the respective
+	// query history items) instead of one document per query history item.
+	// Please note that the index should be rebuilt once the option is changed.
+	CollapseRepeatedQueries bool `json:"collapseRepeatedQueries"`
+
+	// ExcludeRetired specifies whether documents referring to retired
+	// corpora (see the `retire-corpus` action) should be excluded from
+	// search results.
+	ExcludeRetired bool `json:"excludeRetired"`
 }
 
 func (conf *Conf) QueryHistoryCleanupIntervalDur() time.Duration {
@@ -78,6 +103,14 @@ func (conf *Conf) QueryHistoryMarkPendingIntervalDur() time.Duration {
 	return dur
 }
 
+func (conf *Conf) TrashEnabled() bool {
+	return conf.QueryHistoryTrashRetentionDays > 0
+}
+
+func (conf *Conf) QueryHistoryTrashRetention() time.Duration {
+	return time.Duration(conf.QueryHistoryTrashRetentionDays) * 24 * time.Hour
+}
+
 func (conf *Conf) ValidateAndDefaults() error {
 	if conf == nil {
 		return fmt.Errorf("missing `indexer` section")
@@ -113,5 +146,8 @@ func (conf *Conf) ValidateAndDefaults() error {
 	if conf.QueryHistoryMaxNumDeleteAtOnce <= 0 {
 		return fmt.Errorf("queryHistoryMaxNumDeleteAtOnce must be > 0")
 	}
+	if conf.QueryHistoryTrashRetentionDays < 0 {
+		return fmt.Errorf("queryHistoryTrashRetentionDays must be >= 0")
+	}
 	return nil
 }
@@ -20,9 +20,12 @@ package indexer
 import (
 	"camus/cncdb"
 	"camus/indexer/documents"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -31,6 +34,10 @@ import (
 
 var (
 	ErrRecordNotIndexable = errors.New("record is not indexable")
+
+	// ErrFieldNeedsReindex means a searched field is mapped differently
+	// in the existing index (see documents.OutdatedFields)
+	ErrFieldNeedsReindex = errors.New("field cannot be searched until the index is rebuilt")
 )
 
 // IndexableMidDoc is an intermediate format
@@ -53,10 +60,11 @@ type IndexableMidDoc interface {
 // specified ID is not found, the function should
 // return cncdb.ErrRecordNotFound
 type concDB interface {
-	GetConcRecord(id string) (cncdb.ArchRecord, error)
+	GetConcRecord(ctx context.Context, id string) (cncdb.ArchRecord, error)
 }
 
 func importConc(
+	ctx context.Context,
 	rec *cncdb.UntypedQueryRecord,
 	stype cncdb.QuerySupertype,
 	hRec *cncdb.HistoryRecord,
@@ -67,7 +75,7 @@ func importConc(
 	if err := json.Unmarshal([]byte(hRec.Rec.Data), &form); err != nil {
 		return nil, err
 	}
-	subcProps, err := rec.GetSubcorpus(db)
+	subcProps, err := rec.GetSubcorpus(ctx, db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
 	}
@@ -78,13 +86,16 @@ func importConc(
 		UserID:         hRec.UserID,
 		Corpora:        rec.Corpora,
 		Subcorpus:      subcProps.Name,
+		SubcorpusID:    rec.SubcorpusID,
 		QuerySupertype: stype,
 		RawQueries:     make([]cncdb.RawQuery, 0, len(form.LastopForm.CurrQueries)),
 	}
 
-	for corp, query := range form.LastopForm.CurrQueries {
+	// we keep the order of queries stable so that repeated
+	// queries produce the same documents
+	for _, corp := range slices.Sorted(maps.Keys(form.LastopForm.CurrQueries)) {
 		ans.RawQueries = append(ans.RawQueries, cncdb.RawQuery{
-			Value: query,
+			Value: form.LastopForm.CurrQueries[corp],
 			Type:  form.LastopForm.CurrQueryTypes[corp],
 		})
 	}
@@ -134,7 +145,23 @@ func importConc(
 	return ans, nil
 }
 
+// wlistPosAttrs merges the main word list attribute with
+// attributes of a multilevel word list (without duplicates)
+func wlistPosAttrs(wlattr string, sub []string) []string {
+	ans := make([]string, 0, len(sub)+1)
+	if wlattr != "" {
+		ans = append(ans, wlattr)
+	}
+	for _, attr := range sub {
+		if attr != "" && !slices.Contains(ans, attr) {
+			ans = append(ans, attr)
+		}
+	}
+	return ans
+}
+
 func importWlist(
+	ctx context.Context,
 	rec *cncdb.UntypedQueryRecord,
 	stype cncdb.QuerySupertype,
 	hRec *cncdb.HistoryRecord,
@@ -145,27 +172,34 @@ func importWlist(
 		return nil, err
 	}
 
-	subcProps, err := rec.GetSubcorpus(db)
+	subcProps, err := rec.GetSubcorpus(ctx, db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
 	}
 	ans := &documents.MidWordlist{
-		ID:             rec.ID,
-		Name:           hRec.Name,
-		QuerySupertype: stype,
-		Created:        time.Unix(hRec.Created, 0),
-		UserID:         hRec.UserID,
-		Corpora:        rec.Corpora,
-		Subcorpus:      subcProps.Name,
-		RawQuery:       form.Form.WLPattern,
-		PosAttrNames:   []string{form.Form.WLAttr},
-		PFilterWords:   form.Form.PFilterWords,
-		NFilterWords:   form.Form.NFilterWords,
+		ID:               rec.ID,
+		Name:             hRec.Name,
+		QuerySupertype:   stype,
+		Created:          time.Unix(hRec.Created, 0),
+		UserID:           hRec.UserID,
+		Corpora:          rec.Corpora,
+		Subcorpus:        subcProps.Name,
+		SubcorpusID:      rec.SubcorpusID,
+		RawQuery:         form.Form.WLPattern,
+		PatternFragments: documents.DecomposePattern(form.Form.WLPattern),
+		PosAttrNames:     wlistPosAttrs(form.Form.WLAttr, form.Form.WLPosAttrs),
+		PFilterWords:     form.Form.PFilterWords,
+		NFilterWords:     form.Form.NFilterWords,
+		WLType:           form.Form.WLType,
+		MinFreq:          int(form.Form.WLMinFreq),
+		FreqFigure:       form.Form.WLNums,
+		IncludeNonwords:  bool(form.Form.IncludeNonwords),
 	}
 	return ans, nil
 }
 
 func importKwords(
+	ctx context.Context,
 	rec *cncdb.UntypedQueryRecord,
 	stype cncdb.QuerySupertype,
 	hRec *cncdb.HistoryRecord,
@@ -177,37 +211,52 @@ func importKwords(
 	}
 
 	subcorpora := make([]string, 0, 2)
-	subcProps1, err := rec.GetSubcorpus(db)
+	subcorporaIDs := make([]string, 0, 2)
+	subcProps1, err := rec.GetSubcorpus(ctx, db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
 	}
 	if subcProps1.Name != "" {
 		subcorpora = append(subcorpora, subcProps1.Name)
 	}
-	subcProps2, err := db.GetSubcorpusProps(form.Form.RefUsesubcorp)
+	if rec.SubcorpusID != "" {
+		subcorporaIDs = append(subcorporaIDs, rec.SubcorpusID)
+	}
+	subcProps2, err := db.GetSubcorpusProps(ctx, form.Form.RefUsesubcorp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
 	}
 	if subcProps2.Name != "" {
 		subcorpora = append(subcorpora, subcProps2.Name)
 	}
+	if form.Form.RefUsesubcorp != "" {
+		subcorporaIDs = append(subcorporaIDs, form.Form.RefUsesubcorp)
+	}
 	corpora := append(rec.Corpora, form.Form.RefCorpname)
 
 	ans := &documents.MidKwords{
-		ID:             rec.ID,
-		Name:           hRec.Name,
-		QuerySupertype: stype,
-		Created:        time.Unix(hRec.Created, 0),
-		UserID:         hRec.UserID,
-		Corpora:        corpora,
-		Subcorpora:     subcorpora,
-		RawQuery:       form.Form.WLPattern,
-		PosAttrNames:   []string{form.Form.WLAttr},
+		ID:               rec.ID,
+		Name:             hRec.Name,
+		QuerySupertype:   stype,
+		Created:          time.Unix(hRec.Created, 0),
+		UserID:           hRec.UserID,
+		Corpora:          corpora,
+		Subcorpora:       subcorpora,
+		SubcorporaIDs:    subcorporaIDs,
+		RawQuery:         form.Form.WLPattern,
+		PatternFragments: documents.DecomposePattern(form.Form.WLPattern),
+		PosAttrNames:     []string{form.Form.WLAttr},
+		RefCorpus:        form.Form.RefCorpname,
+		ScoreType:        form.Form.ScoreType,
+		MinFreq:          int(form.Form.WLMinFreq),
+		MaxFreq:          int(form.Form.WLMaxFreq),
+		IncludeNonwords:  bool(form.Form.IncludeNonwords),
 	}
 	return ans, nil
 }
 
 func importPquery(
+	ctx context.Context,
 	rec *cncdb.UntypedQueryRecord,
 	stype cncdb.QuerySupertype,
 	hRec *cncdb.HistoryRecord,
@@ -218,7 +267,7 @@ func importPquery(
 	if err := json.Unmarshal([]byte(hRec.Rec.Data), &form); err != nil {
 		return nil, err
 	}
-	subcProps, err := rec.GetSubcorpus(db)
+	subcProps, err := rec.GetSubcorpus(ctx, db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
 	}
@@ -229,7 +278,7 @@ func importPquery(
 	mergedRawQueries := make([]cncdb.RawQuery, 0, len(form.Form.ConcIDs))
 
 	for i, id := range form.Form.ConcIDs {
-		data, err := cdb.GetConcRecord(id)
+		data, err := cdb.GetConcRecord(ctx, id)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch pquery concordance #%d: %w", i, err)
 		}
@@ -251,7 +300,7 @@ func importPquery(
 			Name:    hRec.Name,
 			Rec:     &data,
 		}
-		conc, err := importConc(&crec, cqstype, &h, db)
+		conc, err := importConc(ctx, &crec, cqstype, &h, db)
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to process pquery conc #%d: %w", i, err)
@@ -277,6 +326,7 @@ func importPquery(
 		UserID:         hRec.UserID,
 		Corpora:        rec.Corpora,
 		Subcorpus:      subcProps.Name,
+		SubcorpusID:    rec.SubcorpusID,
 		QuerySupertype: stype,
 		RawQueries:     mergedRawQueries,
 		PosAttrs:       mergedPosAttrs,
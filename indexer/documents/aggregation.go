@@ -0,0 +1,108 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package documents
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AggregationProps describes an aggregate document representing
+// multiple query history items of a user with the same query.
+// For regular (non-aggregated) documents, the values are empty.
+type AggregationProps struct {
+	QueryKey string `json:"query_key"`
+
+	UseCount int `json:"use_count"`
+
+	FirstUsed *time.Time `json:"first_used"`
+
+	LastUsed *time.Time `json:"last_used"`
+
+	// HistoryKeys contains IDs (see cncdb.HistoryRecord.CreateIndexID)
+	// of all the query history items represented by the document
+	HistoryKeys []string `json:"history_keys"`
+}
+
+func (ap *AggregationProps) SetAggregation(props AggregationProps) {
+	*ap = props
+}
+
+// fields which are ignored when comparing queries
+var nonQueryFields = map[string]bool{
+	"id":           true,
+	"name":         true,
+	"created":      true,
+	"subcorpus":    true, // we rely on subcorpus_id which survives renaming
+	"query_key":    true,
+	"use_count":    true,
+	"first_used":   true,
+	"last_used":    true,
+	"history_keys": true,
+	"retired":      true,
+	// derived from raw_query
+	"pattern_fragments": true,
+}
+
+// fields where order of whitespace separated values matters
+var orderedFields = map[string]bool{
+	"raw_query":    true,
+	"simple_query": true,
+}
+
+// NormalizedQueryKey creates a hash of a document's query-related
+// properties. Documents with the same key represent the same query
+// (even if entered at different times and possibly with different
+// whitespace).
+func NormalizedQueryKey(doc IndexableDoc) (string, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to create query key: %w", err)
+	}
+	var props map[string]any
+	if err := json.Unmarshal(data, &props); err != nil {
+		return "", fmt.Errorf("failed to create query key: %w", err)
+	}
+	norm := make(map[string]any)
+	for k, v := range props {
+		if nonQueryFields[k] {
+			continue
+		}
+		sv, ok := v.(string)
+		if !ok {
+			norm[k] = v
+			continue
+		}
+		tokens := strings.Fields(sv)
+		if !orderedFields[k] {
+			sort.Strings(tokens)
+		}
+		norm[k] = strings.Join(tokens, " ")
+	}
+	norm["_type"] = doc.Type()
+	data, err = json.Marshal(norm) // Note: map keys are sorted by the encoder
+	if err != nil {
+		return "", fmt.Errorf("failed to create query key: %w", err)
+	}
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:]), nil
+}
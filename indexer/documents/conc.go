@@ -41,8 +41,14 @@ type Concordance struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpus_id"`
+
 	RawQuery string `json:"raw_query"`
 
+	// SimpleQuery contains only queries entered via KonText's
+	// "simple" query mode (i.e. no CQL)
+	SimpleQuery string `json:"simple_query"`
+
 	Structures string `json:"structures"`
 
 	StructAttrNames string `json:"struct_attr_names"`
@@ -52,6 +58,10 @@ type Concordance struct {
 	PosAttrNames string `json:"pos_attr_names"`
 
 	PosAttrValues string `json:"pos_attr_values"`
+
+	AggregationProps
+
+	RetirementProps
 }
 
 func (bdoc *Concordance) Type() string {
@@ -83,6 +93,11 @@ type MidConc struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	// SubcorpusID is the KonText ID of the subcorpus. Contrary to
+	// the name, it does not change when a user renames the subcorpus
+	// so we can use it to find documents affected by such a change.
+	SubcorpusID string `json:"subcorpusId"`
+
 	// RawQuery is the original query written by a user
 	// (multiple queries = aligned corpora)
 	RawQueries []cncdb.RawQuery `json:"rawQueries"`
@@ -147,6 +162,33 @@ func (doc *MidConc) GetRawQueriesAsString() string {
 	return ans.String()
 }
 
+// GetSimpleQueriesAsString returns space-separated values of all
+// the queries entered in the "simple" query mode.
+func (doc *MidConc) GetSimpleQueriesAsString() string {
+	ans := make([]string, 0, len(doc.RawQueries))
+	for _, v := range doc.RawQueries {
+		if v.Type == "simple" {
+			ans = append(ans, v.Value)
+		}
+	}
+	return strings.Join(ans, " ")
+}
+
+// IsSimpleQuery returns true if all the queries (in case of
+// aligned corpora, there can be more of them) have been entered
+// in the "simple" query mode.
+func (doc *MidConc) IsSimpleQuery() bool {
+	if len(doc.RawQueries) == 0 {
+		return false
+	}
+	for _, v := range doc.RawQueries {
+		if v.Type != "simple" {
+			return false
+		}
+	}
+	return true
+}
+
 // IsValidCQLQuery tests for indexability of a query at position idx
 // (when considering a possible query to aligned corpora; for single-corpus
 // queries, idx==0 is the only option)
@@ -174,9 +216,12 @@ func (doc *MidConc) AsIndexableDoc() IndexableDoc {
 		Created:          doc.Created,
 		QuerySupertype:   string(doc.QuerySupertype),
 		UserID:           strconv.Itoa(doc.UserID),
+		IsSimpleQuery:    doc.IsSimpleQuery(),
 		Corpora:          strings.Join(doc.Corpora, " "),
 		Subcorpus:        doc.Subcorpus,
+		SubcorpusID:      doc.SubcorpusID,
 		RawQuery:         doc.GetRawQueriesAsString(),
+		SimpleQuery:      doc.GetSimpleQueriesAsString(),
 		Structures:       strings.Join(doc.Structures, " "),
 		StructAttrNames:  strings.Join(structAttrNames, " "),
 		StructAttrValues: strings.Join(structAttrValues, " "),
@@ -32,4 +32,11 @@ import "github.com/blevesearch/bleve/v2/mapping"
 type IndexableDoc interface {
 	mapping.Classifier
 	GetID() string
+
+	// SetAggregation turns the document into an aggregate
+	// of multiple query history items
+	SetAggregation(props AggregationProps)
+
+	// SetRetired marks the document as referring to a retired corpus
+	SetRetired(v bool)
 }
@@ -24,6 +24,23 @@ import (
 	"time"
 )
 
+// keynessScoreNames maps KonText keyness score identifiers
+// to names users are likely to search for
+var keynessScoreNames = map[string]string{
+	"logL": "log-likelihood",
+	"chi2": "chi-square",
+	"din":  "difference index",
+}
+
+// scoreTypeAsText produces a searchable representation of a score type
+// containing both the identifier and the name (e.g. `logL log-likelihood`)
+func scoreTypeAsText(scoreType string) string {
+	if name, ok := keynessScoreNames[scoreType]; ok {
+		return scoreType + " " + name
+	}
+	return scoreType
+}
+
 type Kwords struct {
 	ID string `json:"id"`
 
@@ -39,9 +56,33 @@ type Kwords struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	// SubcorpusID contains IDs of both the subcorpus and
+	// the reference subcorpus (if any)
+	SubcorpusID []string `json:"subcorpus_id"`
+
 	RawQuery string `json:"raw_query"`
 
+	// PatternFragments contains literal fragments and character
+	// classes of the RawQuery pattern (see DecomposePattern)
+	PatternFragments string `json:"pattern_fragments"`
+
 	PosAttrNames string `json:"pos_attr_names"`
+
+	RefCorpus string `json:"ref_corpus"`
+
+	// ScoreType contains both the score identifier and its
+	// human readable name (see keynessScoreNames)
+	ScoreType string `json:"score_type"`
+
+	MinFreq int `json:"min_freq"`
+
+	MaxFreq int `json:"max_freq"`
+
+	IncludeNonwords bool `json:"include_nonwords"`
+
+	AggregationProps
+
+	RetirementProps
 }
 
 func (kw *Kwords) Type() string {
@@ -69,9 +110,27 @@ type MidKwords struct {
 
 	Subcorpora []string `json:"subcorpora"`
 
+	// SubcorporaIDs contains IDs of both the focus and
+	// the reference subcorpus (if used)
+	SubcorporaIDs []string `json:"subcorporaIds"`
+
 	RawQuery string `json:"rawQuery"`
 
+	PatternFragments []string `json:"patternFragments"`
+
 	PosAttrNames []string `json:"posAttrNames"`
+
+	RefCorpus string `json:"refCorpus"`
+
+	// ScoreType is a keyness score identifier as used by KonText
+	// (`logL`, `chi2`, `din`)
+	ScoreType string `json:"scoreType"`
+
+	MinFreq int `json:"minFreq"`
+
+	MaxFreq int `json:"maxFreq"`
+
+	IncludeNonwords bool `json:"includeNonwords"`
 }
 
 func (mkw *MidKwords) GetID() string {
@@ -84,14 +143,21 @@ func (mkw *MidKwords) GetQuerySupertype() cncdb.QuerySupertype {
 
 func (mkw *MidKwords) AsIndexableDoc() IndexableDoc {
 	return &Kwords{
-		ID:             mkw.ID,
-		Name:           mkw.Name,
-		Created:        mkw.Created,
-		QuerySupertype: string(mkw.QuerySupertype),
-		UserID:         strconv.Itoa(mkw.UserID),
-		Corpora:        strings.Join(mkw.Corpora, " "),
-		Subcorpus:      strings.Join(mkw.Subcorpora, " "),
-		RawQuery:       mkw.RawQuery,
-		PosAttrNames:   strings.Join(mkw.PosAttrNames, " "),
+		ID:               mkw.ID,
+		Name:             mkw.Name,
+		Created:          mkw.Created,
+		QuerySupertype:   string(mkw.QuerySupertype),
+		UserID:           strconv.Itoa(mkw.UserID),
+		Corpora:          strings.Join(mkw.Corpora, " "),
+		Subcorpus:        strings.Join(mkw.Subcorpora, " "),
+		SubcorpusID:      mkw.SubcorporaIDs,
+		RawQuery:         mkw.RawQuery,
+		PatternFragments: strings.Join(mkw.PatternFragments, " "),
+		PosAttrNames:     strings.Join(mkw.PosAttrNames, " "),
+		RefCorpus:        mkw.RefCorpus,
+		ScoreType:        scoreTypeAsText(mkw.ScoreType),
+		MinFreq:          mkw.MinFreq,
+		MaxFreq:          mkw.MaxFreq,
+		IncludeNonwords:  mkw.IncludeNonwords,
 	}
 }
@@ -20,6 +20,7 @@ package documents
 import (
 	"camus/indexer/lotokenizer"
 	"fmt"
+	"sort"
 
 	"github.com/blevesearch/bleve/v2"
 	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
@@ -70,6 +71,22 @@ func CreateMapping() (mapping.IndexMapping, error) {
 	labelMultiValMapping := bleve.NewTextFieldMapping()
 	labelMultiValMapping.Analyzer = "kontext_label_analyzer"
 	dtMapping := bleve.NewDateTimeFieldMapping()
+	boolMapping := bleve.NewBooleanFieldMapping()
+	numMapping := bleve.NewNumericFieldMapping()
+
+	// fields of aggregated documents (see AggregationProps)
+	addAggregationMappings := func(dm *mapping.DocumentMapping) {
+		dm.AddFieldMappingsAt("query_key", exactStringMapping)
+		dm.AddFieldMappingsAt("use_count", numMapping)
+		dm.AddFieldMappingsAt("first_used", dtMapping)
+		dm.AddFieldMappingsAt("last_used", dtMapping)
+		dm.AddFieldMappingsAt("history_keys", exactStringMapping)
+	}
+
+	// fields of documents referring to retired corpora (see RetirementProps)
+	addRetirementMappings := func(dm *mapping.DocumentMapping) {
+		dm.AddFieldMappingsAt("retired", boolMapping)
+	}
 
 	// conc type
 	concMapping := bleve.NewDocumentMapping()
@@ -77,15 +94,19 @@ func CreateMapping() (mapping.IndexMapping, error) {
 	concMapping.AddFieldMappingsAt("query_supertype", exactStringMapping)
 	concMapping.AddFieldMappingsAt("created", dtMapping)
 	concMapping.AddFieldMappingsAt("user_id", exactStringMapping)
-	concMapping.AddFieldMappingsAt("is_simple_query", exactStringMapping)
+	concMapping.AddFieldMappingsAt("is_simple_query", boolMapping)
 	concMapping.AddFieldMappingsAt("corpora", labelMultiValMapping)
 	concMapping.AddFieldMappingsAt("subcorpus", labelMultiValMapping)
+	concMapping.AddFieldMappingsAt("subcorpus_id", exactStringMapping)
 	concMapping.AddFieldMappingsAt("raw_query", queryMultiValMapping)
+	concMapping.AddFieldMappingsAt("simple_query", labelMultiValMapping)
 	concMapping.AddFieldMappingsAt("structures", labelMultiValMapping)
 	concMapping.AddFieldMappingsAt("struct_attr_names", labelMultiValMapping)
 	concMapping.AddFieldMappingsAt("struct_attr_values", labelMultiValMapping)
 	concMapping.AddFieldMappingsAt("pos_attr_names", labelMultiValMapping)
 	concMapping.AddFieldMappingsAt("pos_attr_values", queryMultiValMapping)
+	addAggregationMappings(concMapping)
+	addRetirementMappings(concMapping)
 
 	indexMapping.AddDocumentMapping("conc", concMapping)
 
@@ -98,10 +119,18 @@ func CreateMapping() (mapping.IndexMapping, error) {
 	wlistMapping.AddFieldMappingsAt("user_id", exactStringMapping)
 	wlistMapping.AddFieldMappingsAt("corpora", labelMultiValMapping)
 	wlistMapping.AddFieldMappingsAt("subcorpus", labelMultiValMapping)
+	wlistMapping.AddFieldMappingsAt("subcorpus_id", exactStringMapping)
 	wlistMapping.AddFieldMappingsAt("raw_query", queryMultiValMapping)
+	wlistMapping.AddFieldMappingsAt("pattern_fragments", queryMultiValMapping)
 	wlistMapping.AddFieldMappingsAt("pos_attr_names", labelMultiValMapping)
 	wlistMapping.AddFieldMappingsAt("pfilter_words", queryMultiValMapping)
 	wlistMapping.AddFieldMappingsAt("nfilter_words", queryMultiValMapping)
+	wlistMapping.AddFieldMappingsAt("wl_type", labelMultiValMapping)
+	wlistMapping.AddFieldMappingsAt("min_freq", numMapping)
+	wlistMapping.AddFieldMappingsAt("freq_figure", labelMultiValMapping)
+	wlistMapping.AddFieldMappingsAt("include_nonwords", boolMapping)
+	addAggregationMappings(wlistMapping)
+	addRetirementMappings(wlistMapping)
 
 	indexMapping.AddDocumentMapping("wlist", wlistMapping)
 
@@ -113,8 +142,17 @@ func CreateMapping() (mapping.IndexMapping, error) {
 	kwordsMapping.AddFieldMappingsAt("user_id", exactStringMapping)
 	kwordsMapping.AddFieldMappingsAt("corpora", labelMultiValMapping)
 	kwordsMapping.AddFieldMappingsAt("subcorpus", labelMultiValMapping)
+	kwordsMapping.AddFieldMappingsAt("subcorpus_id", exactStringMapping)
 	kwordsMapping.AddFieldMappingsAt("raw_query", queryMultiValMapping)
+	kwordsMapping.AddFieldMappingsAt("pattern_fragments", queryMultiValMapping)
 	kwordsMapping.AddFieldMappingsAt("pos_attr_names", labelMultiValMapping)
+	kwordsMapping.AddFieldMappingsAt("ref_corpus", labelMultiValMapping)
+	kwordsMapping.AddFieldMappingsAt("score_type", labelMultiValMapping)
+	kwordsMapping.AddFieldMappingsAt("min_freq", numMapping)
+	kwordsMapping.AddFieldMappingsAt("max_freq", numMapping)
+	kwordsMapping.AddFieldMappingsAt("include_nonwords", boolMapping)
+	addAggregationMappings(kwordsMapping)
+	addRetirementMappings(kwordsMapping)
 
 	indexMapping.AddDocumentMapping("kwords", kwordsMapping)
 
@@ -126,14 +164,67 @@ func CreateMapping() (mapping.IndexMapping, error) {
 	pqueryMapping.AddFieldMappingsAt("user_id", exactStringMapping)
 	pqueryMapping.AddFieldMappingsAt("corpora", labelMultiValMapping)
 	pqueryMapping.AddFieldMappingsAt("subcorpus", labelMultiValMapping)
+	pqueryMapping.AddFieldMappingsAt("subcorpus_id", exactStringMapping)
 	pqueryMapping.AddFieldMappingsAt("raw_query", queryMultiValMapping)
 	pqueryMapping.AddFieldMappingsAt("structures", labelMultiValMapping)
 	pqueryMapping.AddFieldMappingsAt("struct_attr_names", labelMultiValMapping)
 	pqueryMapping.AddFieldMappingsAt("struct_attr_values", queryMultiValMapping)
 	pqueryMapping.AddFieldMappingsAt("pos_attr_names", labelMultiValMapping)
 	pqueryMapping.AddFieldMappingsAt("pos_attr_values", queryMultiValMapping)
+	addAggregationMappings(pqueryMapping)
+	addRetirementMappings(pqueryMapping)
 
 	indexMapping.AddDocumentMapping("pquery", pqueryMapping)
 
 	return indexMapping, nil
 }
+
+// OutdatedFields compares mapping of an existing index with the current
+// mapping (see CreateMapping) and returns names of fields which are missing
+// in the existing mapping or which are mapped differently (e.g. a former
+// keyword field now mapped as a boolean one). Bleve keeps the mapping an index
+// has been created with so such fields cannot be searched reliably until
+// the index is rebuilt (i.e. `indexDirPath` is removed and the index is
+// filled again via `init-query-history`).
+func OutdatedFields(idxMapping mapping.IndexMapping) ([]string, error) {
+	existing, ok := idxMapping.(*mapping.IndexMappingImpl)
+	if !ok {
+		return []string{}, fmt.Errorf("failed to compare mappings: unsupported mapping type")
+	}
+	tmp, err := CreateMapping()
+	if err != nil {
+		return []string{}, fmt.Errorf("failed to compare mappings: %w", err)
+	}
+	current := tmp.(*mapping.IndexMappingImpl)
+	ans := make([]string, 0, 5)
+	seen := make(map[string]bool)
+	for docType, docMapping := range current.TypeMapping {
+		for field, prop := range docMapping.Properties {
+			if seen[field] {
+				continue
+			}
+			var existingProp *mapping.DocumentMapping
+			if existingDoc := existing.TypeMapping[docType]; existingDoc != nil {
+				existingProp = existingDoc.Properties[field]
+			}
+			if existingProp == nil || !sameFieldMappings(prop.Fields, existingProp.Fields) {
+				seen[field] = true
+				ans = append(ans, field)
+			}
+		}
+	}
+	sort.Strings(ans)
+	return ans, nil
+}
+
+func sameFieldMappings(fm1, fm2 []*mapping.FieldMapping) bool {
+	if len(fm1) != len(fm2) {
+		return false
+	}
+	for i := range fm1 {
+		if fm1[i].Type != fm2[i].Type || fm1[i].Analyzer != fm2[i].Analyzer {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package documents
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutdatedFields(t *testing.T) {
+	idxMapping, err := CreateMapping()
+	assert.NoError(t, err)
+	outdated, err := OutdatedFields(idxMapping)
+	assert.NoError(t, err)
+	assert.Empty(t, outdated)
+
+	// simulate an index created before `is_simple_query` became a boolean
+	// field and before `pattern_fragments` existed
+	concMapping := idxMapping.(*mapping.IndexMappingImpl).TypeMapping["conc"]
+	concMapping.AddFieldMappingsAt("is_simple_query", bleve.NewKeywordFieldMapping())
+	delete(idxMapping.(*mapping.IndexMappingImpl).TypeMapping["wlist"].Properties, "pattern_fragments")
+	outdated, err = OutdatedFields(idxMapping)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"is_simple_query", "pattern_fragments"}, outdated)
+}
@@ -39,6 +39,8 @@ type PQuery struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpus_id"`
+
 	RawQuery string `json:"raw_query"`
 
 	Structures string `json:"structures"`
@@ -50,6 +52,10 @@ type PQuery struct {
 	PosAttrNames string `json:"pos_attr_names"`
 
 	PosAttrValues string `json:"pos_attr_values"`
+
+	AggregationProps
+
+	RetirementProps
 }
 
 func (pq *PQuery) Type() string {
@@ -79,6 +85,8 @@ type MidPQuery struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpusId"`
+
 	// RawQuery is the original query written by a user
 	// (multiple queries = aligned corpora)
 	RawQueries []cncdb.RawQuery `json:"rawQueries"`
@@ -162,6 +170,8 @@ func (doc *MidPQuery) AsIndexableDoc() IndexableDoc {
 		Created:          doc.Created,
 		UserID:           strconv.Itoa(doc.UserID),
 		Corpora:          strings.Join(doc.Corpora, " "),
+		Subcorpus:        doc.Subcorpus,
+		SubcorpusID:      doc.SubcorpusID,
 		RawQuery:         doc.getRawQueriesAsString(),
 		Structures:       strings.Join(doc.Structures, " "),
 		PosAttrNames:     strings.Join(posAttrNames, " "),
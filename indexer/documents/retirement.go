@@ -0,0 +1,28 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package documents
+
+// RetirementProps marks documents of queries referring
+// to a retired (withdrawn) corpus. Such queries cannot be
+// opened in KonText anymore.
+type RetirementProps struct {
+	Retired bool `json:"retired"`
+}
+
+func (rp *RetirementProps) SetRetired(v bool) {
+	rp.Retired = v
+}
@@ -39,13 +39,31 @@ type Wordlist struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpus_id"`
+
 	RawQuery string `json:"raw_query"`
 
+	// PatternFragments contains literal fragments and character
+	// classes of the RawQuery pattern (see DecomposePattern)
+	PatternFragments string `json:"pattern_fragments"`
+
 	PosAttrNames string `json:"pos_attr_names"`
 
 	PFilterWords string `json:"pfilter_words"`
 
 	NFilterWords string `json:"nfilter_words"`
+
+	WLType string `json:"wl_type"`
+
+	MinFreq int `json:"min_freq"`
+
+	FreqFigure string `json:"freq_figure"`
+
+	IncludeNonwords bool `json:"include_nonwords"`
+
+	AggregationProps
+
+	RetirementProps
 }
 
 func (wlist *Wordlist) Type() string {
@@ -76,13 +94,28 @@ type MidWordlist struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpusId"`
+
 	RawQuery string `json:"rawQuery"`
 
+	PatternFragments []string `json:"patternFragments"`
+
 	PosAttrNames []string `json:"posAttrNames"`
 
 	PFilterWords []string `json:"pfilterWords"`
 
 	NFilterWords []string `json:"nfilterWords"`
+
+	// WLType is either `simple` or `multilevel`
+	WLType string `json:"wlType"`
+
+	MinFreq int `json:"minFreq"`
+
+	// FreqFigure specifies the frequency type the list
+	// is based on (`frq`, `docf`, `arf`)
+	FreqFigure string `json:"freqFigure"`
+
+	IncludeNonwords bool `json:"includeNonwords"`
 }
 
 func (mwl *MidWordlist) GetID() string {
@@ -95,16 +128,22 @@ func (mwl *MidWordlist) GetQuerySupertype() cncdb.QuerySupertype {
 
 func (mwl *MidWordlist) AsIndexableDoc() IndexableDoc {
 	return &Wordlist{
-		ID:             mwl.ID,
-		Name:           mwl.Name,
-		Created:        mwl.Created,
-		QuerySupertype: string(mwl.QuerySupertype),
-		UserID:         strconv.Itoa(mwl.UserID),
-		Corpora:        strings.Join(mwl.Corpora, " "),
-		Subcorpus:      mwl.Subcorpus,
-		RawQuery:       mwl.RawQuery,
-		PosAttrNames:   strings.Join(mwl.PosAttrNames, " "),
-		PFilterWords:   strings.Join(mwl.PFilterWords, " "),
-		NFilterWords:   strings.Join(mwl.NFilterWords, " "),
+		ID:               mwl.ID,
+		Name:             mwl.Name,
+		Created:          mwl.Created,
+		QuerySupertype:   string(mwl.QuerySupertype),
+		UserID:           strconv.Itoa(mwl.UserID),
+		Corpora:          strings.Join(mwl.Corpora, " "),
+		Subcorpus:        mwl.Subcorpus,
+		SubcorpusID:      mwl.SubcorpusID,
+		RawQuery:         mwl.RawQuery,
+		PatternFragments: strings.Join(mwl.PatternFragments, " "),
+		PosAttrNames:     strings.Join(mwl.PosAttrNames, " "),
+		PFilterWords:     strings.Join(mwl.PFilterWords, " "),
+		NFilterWords:     strings.Join(mwl.NFilterWords, " "),
+		WLType:           mwl.WLType,
+		MinFreq:          mwl.MinFreq,
+		FreqFigure:       mwl.FreqFigure,
+		IncludeNonwords:  mwl.IncludeNonwords,
 	}
 }
@@ -0,0 +1,134 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package documents
+
+import (
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+const (
+	// minPatternLiteralLen specifies a minimum length (in runes)
+	// of a literal fragment to be indexed. Shorter fragments
+	// (typically single characters) would match too many documents.
+	minPatternLiteralLen = 2
+)
+
+type patternDecomposer struct {
+	buff      []rune
+	fragments []string
+	seen      map[string]bool
+}
+
+func (pd *patternDecomposer) add(frag string) {
+	if frag == "" || pd.seen[frag] {
+		return
+	}
+	pd.seen[frag] = true
+	pd.fragments = append(pd.fragments, frag)
+}
+
+func (pd *patternDecomposer) flush() {
+	if len(pd.buff) >= minPatternLiteralLen {
+		pd.add(strings.ToLower(string(pd.buff)))
+	}
+	pd.buff = pd.buff[:0]
+}
+
+// singleFoldedRune tests whether a character class represents
+// just a single character in different letter cases (e.g. [Pp])
+// and if so, returns the character in lower case
+func singleFoldedRune(re *syntax.Regexp) (rune, bool) {
+	var ans rune = -1
+	for i := 0; i+1 < len(re.Rune); i += 2 {
+		for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+			lr := unicode.ToLower(r)
+			if ans == -1 {
+				ans = lr
+
+			} else if ans != lr {
+				return 0, false
+			}
+		}
+	}
+	return ans, ans != -1
+}
+
+func (pd *patternDecomposer) walk(re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		pd.buff = append(pd.buff, re.Rune...)
+	case syntax.OpCharClass:
+		if r, ok := singleFoldedRune(re); ok {
+			pd.buff = append(pd.buff, r)
+			return
+		}
+		pd.flush()
+		pd.add(strings.ToLower(re.String()))
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			pd.walk(sub)
+		}
+	case syntax.OpCapture:
+		pd.walk(re.Sub[0])
+	case syntax.OpAlternate:
+		// the parser factors out common prefixes (e.g. `dům|domy` => `d(?:ům|omy)`)
+		// so we have to prepend the current literal to each of the alternatives
+		prefix := append([]rune{}, pd.buff...)
+		for _, sub := range re.Sub {
+			pd.buff = append(pd.buff[:0], prefix...)
+			pd.walk(sub)
+			pd.flush()
+		}
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		// repeated or optional parts cannot be joined with their
+		// surroundings into a single literal
+		pd.flush()
+		for _, sub := range re.Sub {
+			pd.walk(sub)
+			pd.flush()
+		}
+	default:
+		pd.flush()
+	}
+}
+
+// DecomposePattern splits a word list/keywords regular expression
+// pattern (e.g. `.*ování`) into literal fragments (`ování`) and
+// character classes (`[a-z]`) so they can be searched for as
+// regular words. Literal fragments are lower-cased. In case the pattern
+// is not a valid regular expression, it is returned as a single
+// literal fragment.
+func DecomposePattern(pattern string) []string {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return []string{}
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return []string{strings.ToLower(pattern)}
+	}
+	pd := &patternDecomposer{
+		buff:      make([]rune, 0, len(pattern)),
+		fragments: make([]string, 0, 4),
+		seen:      make(map[string]bool),
+	}
+	pd.walk(re)
+	pd.flush()
+	return pd.fragments
+}
@@ -0,0 +1,33 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package documents
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecomposePattern(t *testing.T) {
+	assert.Equal(t, []string{"ování"}, DecomposePattern(".*ování"))
+	assert.Equal(t, []string{"práce"}, DecomposePattern("[Pp]ráce.*"))
+	assert.Equal(t, []string{"[0-9]", "let"}, DecomposePattern("[0-9]+let"))
+	assert.Equal(t, []string{"dům", "domy"}, DecomposePattern("(dům|domy)"))
+	assert.Equal(t, []string{"pes"}, DecomposePattern("pes"))
+	assert.Equal(t, []string{"[a"}, DecomposePattern("[a"))
+	assert.Equal(t, []string{}, DecomposePattern(" "))
+}
@@ -18,6 +18,7 @@ package indexer
 
 import (
 	"camus/cncdb"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -30,6 +31,8 @@ import (
 
 const (
 	defaultNumRecentRecs = 100
+	defaultNumTrashItems = 50
+	maxNumTrashItems     = 1000
 )
 
 type Actions struct {
@@ -53,7 +56,7 @@ func (a *Actions) IndexLatestRecords(ctx *gin.Context) {
 		return
 	}
 
-	numProc, err := a.idxService.Indexer().IndexRecentRecords(iNumRec)
+	numProc, err := a.idxService.Indexer().IndexRecentRecords(ctx.Request.Context(), iNumRec)
 	if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
 		return
@@ -89,7 +92,7 @@ func (a *Actions) RecordToDoc(ctx *gin.Context) {
 	hRec := cncdb.HistoryRecord{
 		QueryID: ctx.Query("id"),
 	}
-	rec, err := a.idxService.GetRecord(hRec.QueryID)
+	rec, err := a.idxService.GetRecord(ctx.Request.Context(), hRec.QueryID)
 	if err == cncdb.ErrRecordNotFound {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusNotFound)
 		return
@@ -99,7 +102,7 @@ func (a *Actions) RecordToDoc(ctx *gin.Context) {
 		return
 	}
 	hRec.Rec = &rec
-	doc, err := a.idxService.Indexer().RecToDoc(&hRec)
+	doc, err := a.idxService.Indexer().RecToDoc(ctx.Request.Context(), &hRec)
 	if err == ErrRecordNotIndexable {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusUnprocessableEntity)
 		return
@@ -141,8 +144,31 @@ func (a *Actions) Search(ctx *gin.Context) {
 			Requirement: "must",
 		},
 	)
-	rec, err := a.idxService.indexer.Search(queryData, limit, order, fields)
-	if err != nil {
+	// Note: as only concordances can be "simple", filtering by
+	// query type also filters out all the other query supertypes
+	switch ctx.Query("queryType") {
+	case "simple":
+		queryData = append(
+			queryData,
+			searchedTerm{Field: "is_simple_query", Value: "true", Requirement: "must"},
+		)
+	case "advanced":
+		queryData = append(
+			queryData,
+			searchedTerm{Field: "is_simple_query", Value: "false", Requirement: "must"},
+		)
+	case "":
+	default:
+		uniresp.RespondWithErrorJSON(
+			ctx, fmt.Errorf("invalid queryType (must be either simple or advanced)"), http.StatusBadRequest)
+		return
+	}
+	rec, err := a.idxService.indexer.Search(ctx.Request.Context(), queryData, limit, order, fields)
+	if errors.Is(err, ErrFieldNeedsReindex) {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusUnprocessableEntity)
+		return
+
+	} else if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
 		return
 	}
@@ -165,7 +191,7 @@ func (a *Actions) SearchWithQuery(ctx *gin.Context) {
 	}
 
 	srchQuery := fmt.Sprintf("+user_id:%s %s", ctx.Param("userId"), ctx.Query("q"))
-	rec, err := a.idxService.indexer.SearchWithQuery(srchQuery, limit, order, fields)
+	rec, err := a.idxService.indexer.SearchWithQuery(ctx.Request.Context(), srchQuery, limit, order, fields)
 
 	if err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
@@ -180,25 +206,112 @@ func (a *Actions) Update(ctx *gin.Context) {
 		return
 	}
 	hRec.Name = ctx.Query("name")
-	if err := a.idxService.Indexer().Update(hRec); err != nil {
+	if _, err := a.idxService.Indexer().Update(ctx.Request.Context(), hRec); err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
 		return
 	}
 	uniresp.WriteJSONResponse(ctx.Writer, hRec)
 }
 
+// Delete removes a query history item from the index (and moves it to
+// the trash if enabled). The optional `name` URL argument should contain
+// the name of a named item. If omitted, the name stored in the index is used.
 func (a *Actions) Delete(ctx *gin.Context) {
 	hRec := a.getHistoryRecord(ctx)
 	if hRec == nil {
 		return
 	}
-	if err := a.idxService.Indexer().Delete(hRec.CreateIndexID()); err != nil {
+	hRec.Name = ctx.Query("name")
+	if err := a.idxService.Indexer().TrashRecord(ctx.Request.Context(), *hRec); err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	if err := a.idxService.Indexer().Delete(ctx.Request.Context(), hRec.CreateIndexID()); err != nil {
 		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
 		return
 	}
 	uniresp.WriteJSONResponse(ctx.Writer, hRec)
 }
 
+// Trash lists recently deleted query history items of a user
+func (a *Actions) Trash(ctx *gin.Context) {
+	if !a.idxService.Indexer().TrashEnabled() {
+		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("query history trash is disabled"), http.StatusNotImplemented)
+		return
+	}
+	userID, err := strconv.Atoi(ctx.Param("userId"))
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultNumTrashItems)))
+	if err != nil || limit <= 0 || limit > maxNumTrashItems {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid limit (must be between 1 and %d)", maxNumTrashItems),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	items, err := a.idxService.Indexer().UserTrash(ctx.Request.Context(), userID, limit)
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"items": items})
+}
+
+// Restore moves a deleted query history item back from the trash
+// and reindexes it
+func (a *Actions) Restore(ctx *gin.Context) {
+	if !a.idxService.Indexer().TrashEnabled() {
+		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("query history trash is disabled"), http.StatusNotImplemented)
+		return
+	}
+	hRec := a.getHistoryRecord(ctx)
+	if hRec == nil {
+		return
+	}
+	restored, indexed, err := a.idxService.Indexer().RestoreRecord(
+		ctx.Request.Context(), hRec.UserID, hRec.Created, hRec.QueryID)
+	if errors.Is(err, cncdb.ErrRecordNotFound) {
+		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("item not found in trash"), http.StatusNotFound)
+		return
+
+	} else if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		map[string]any{
+			"restored": restored,
+			"indexed":  indexed,
+		},
+	)
+}
+
+// SubcorpusChanged should be called by KonText once a subcorpus is renamed
+// or its text types change. All the documents referring to the subcorpus
+// are reindexed. For documents indexed by older versions of Camus (which did
+// not store subcorpus IDs), the `prevName` URL argument can be used to find
+// them by the former subcorpus name.
+func (a *Actions) SubcorpusChanged(ctx *gin.Context) {
+	subcID := ctx.Param("subcId")
+	numReindexed, err := a.idxService.Indexer().ReindexSubcorpus(ctx.Request.Context(), subcID, ctx.Query("prevName"))
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		map[string]any{
+			"subcorpusId":  subcID,
+			"numReindexed": numReindexed,
+		},
+	)
+}
+
 func (a *Actions) getHistoryRecord(ctx *gin.Context) *cncdb.HistoryRecord {
 	queryID := ctx.Param("queryId")
 	userIDStr := ctx.Param("userId")
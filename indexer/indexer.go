@@ -25,6 +25,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -35,8 +36,27 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	subcReindexPageSize = 100
+	indexRecordTimeout  = 30 * time.Second
+)
+
 type requirement string
 
+var (
+	// boolFields are fields searched by a boolean value
+	boolFields = map[string]bool{
+		"is_simple_query":  true,
+		"include_nonwords": true,
+	}
+
+	// numericFields are fields searched by an exact numeric value
+	numericFields = map[string]bool{
+		"min_freq": true,
+		"max_freq": true,
+	}
+)
+
 type searchedTerm struct {
 	Field       string      `json:"field"`
 	Value       string      `json:"value"`
@@ -48,10 +68,16 @@ type Indexer struct {
 	conf        *Conf
 	concArchDb  cncdb.IConcArchOps
 	queryHistDb cncdb.IQHistArchOps
-	rdb         *archiver.RedisAdapter
+	rdb         concDB
 	bleveIdx    bleve.Index
 	dataPath    string
 	recsToIndex <-chan cncdb.HistoryRecord
+
+	aggLocks aggregateLocks
+
+	// outdatedFields contains fields mapped differently in the opened
+	// index than in the current mapping (see documents.OutdatedFields)
+	outdatedFields map[string]bool
 }
 
 func (idx *Indexer) DocCount() (uint64, error) {
@@ -68,20 +94,20 @@ func (idx *Indexer) DataPath() string {
 // records among the ones fetched for processing (which is a normal
 // - non error thing - e.g. sample, shuffle, filter,...),
 // such records are ignored.
-func (idx *Indexer) IndexRecentRecords(numLatest int) (int, error) {
-	history, err := idx.queryHistDb.LoadRecentNHistory(numLatest)
+func (idx *Indexer) IndexRecentRecords(ctx context.Context, numLatest int) (int, error) {
+	history, err := idx.queryHistDb.LoadRecentNHistory(ctx, numLatest)
 	if err != nil {
 		return 0, fmt.Errorf("failed to index records: %w", err)
 	}
 	var numIndexed int
 	for _, hRec := range history {
-		hRec.Rec, err = idx.GetConcRecord(hRec.QueryID)
+		hRec.Rec, err = idx.GetConcRecord(ctx, hRec.QueryID)
 		if err != nil {
 			log.Error().Err(err).Msgf("failed to get record %s", hRec.QueryID)
 			continue
 		} else if hRec.Rec != nil {
 			log.Debug().Any("item", hRec).Msg("about to store item to Bleve index")
-			indexed, err := idx.IndexRecord(&hRec)
+			indexed, err := idx.IndexRecord(ctx, &hRec)
 			if !indexed && err == nil {
 				continue
 
@@ -98,7 +124,7 @@ func (idx *Indexer) IndexRecentRecords(numLatest int) (int, error) {
 // RecToDoc converts a conc/wlist/... archive record into an indexable
 // document. In case the record is OK but of an unsupported type (e.g. "shuffle"),
 // nil document is returned along with ErrRecordNotIndexable error.
-func (idx *Indexer) RecToDoc(hRec *cncdb.HistoryRecord) (IndexableMidDoc, error) {
+func (idx *Indexer) RecToDoc(ctx context.Context, hRec *cncdb.HistoryRecord) (IndexableMidDoc, error) {
 	var rec cncdb.UntypedQueryRecord
 	if err := json.Unmarshal([]byte(hRec.Rec.Data), &rec); err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
@@ -113,37 +139,55 @@ func (idx *Indexer) RecToDoc(hRec *cncdb.HistoryRecord) (IndexableMidDoc, error)
 	var ans IndexableMidDoc
 	switch qstype {
 	case cncdb.QuerySupertypeConc:
-		ans, err = importConc(&rec, qstype, hRec, idx.concArchDb)
+		ans, err = importConc(ctx, &rec, qstype, hRec, idx.concArchDb)
 	case cncdb.QuerySupertypeWlist:
-		ans, err = importWlist(&rec, qstype, hRec, idx.concArchDb)
+		ans, err = importWlist(ctx, &rec, qstype, hRec, idx.concArchDb)
 	case cncdb.QuerySupertypeKwords:
-		ans, err = importKwords(&rec, qstype, hRec, idx.concArchDb)
+		ans, err = importKwords(ctx, &rec, qstype, hRec, idx.concArchDb)
 	case cncdb.QuerySupertypePquery:
-		ans, err = importPquery(&rec, qstype, hRec, idx.concArchDb, idx.rdb)
+		ans, err = importPquery(ctx, &rec, qstype, hRec, idx.concArchDb, idx.rdb)
 	default:
 		err = ErrRecordNotIndexable
 	}
 	return ans, err
 }
 
+// recToIndexableDoc converts a history record into a document
+// ready to be stored to the Bleve index.
+func (idx *Indexer) recToIndexableDoc(ctx context.Context, hRec *cncdb.HistoryRecord) (documents.IndexableDoc, error) {
+	midDoc, err := idx.RecToDoc(ctx, hRec)
+	if err != nil {
+		return nil, err
+	}
+	doc := midDoc.AsIndexableDoc()
+	doc.SetRetired(hRec.Rec.Retired)
+	return doc, nil
+}
+
 // IndexRecord indexes a provided archive record. The returned bool
 // specifies whether the record was indexed. It is perfectly OK if
 // a provided document is not indexed and without returned error
 // as not all records we deal with are supported for indexing
 // (e.g. additional stages of concordance queries - like shuffle,
 // filter, ...)
-func (idx *Indexer) IndexRecord(hRec *cncdb.HistoryRecord) (bool, error) {
-	doc, err := idx.RecToDoc(hRec)
+func (idx *Indexer) IndexRecord(ctx context.Context, hRec *cncdb.HistoryRecord) (bool, error) {
+	docToIndex, err := idx.recToIndexableDoc(ctx, hRec)
 	if err == ErrRecordNotIndexable {
 		return false, nil
 
 	} else if err != nil {
 		return false, fmt.Errorf("failed to index record: %w", err)
 	}
-	docToIndex := doc.AsIndexableDoc()
 	if zerolog.GlobalLevel() <= zerolog.DebugLevel {
 		spew.Dump(docToIndex)
 	}
+	if idx.conf.CollapseRepeatedQueries {
+		if err := idx.indexAggregated(ctx, hRec, docToIndex); err != nil {
+			return false, fmt.Errorf("failed to index record: %w", err)
+		}
+		log.Debug().Str("id", hRec.QueryID).Msg("indexed record as a part of an aggregate")
+		return true, nil
+	}
 	err = idx.bleveIdx.Index(docToIndex.GetID(), docToIndex)
 	if err != nil {
 		return false, fmt.Errorf("failed to index record: %w", err)
@@ -156,11 +200,24 @@ func (idx *Indexer) Count() (uint64, error) {
 	return idx.bleveIdx.DocCount()
 }
 
+// excludeRetired wraps a search query so it does not match documents
+// referring to retired corpora (in case it is configured).
+func (idx *Indexer) excludeRetired(q query.Query) query.Query {
+	if !idx.conf.ExcludeRetired {
+		return q
+	}
+	retiredQuery := bleve.NewBoolFieldQuery(true)
+	retiredQuery.SetField("retired")
+	ans := bleve.NewBooleanQuery()
+	ans.AddMust(q)
+	ans.AddMustNot(retiredQuery)
+	return ans
+}
+
 // SearchWithQuery is intended for human interface as it exposes Bleve's
 // query language (stuff like `author: "Doe" +type: fiction -subtype: romance`)
-func (idx *Indexer) SearchWithQuery(q string, limit int, order []string, fields []string) (*bleve.SearchResult, error) {
-	query := bleve.NewQueryStringQuery(q)
-	search := bleve.NewSearchRequest(query)
+func (idx *Indexer) SearchWithQuery(ctx context.Context, q string, limit int, order []string, fields []string) (*bleve.SearchResult, error) {
+	search := bleve.NewSearchRequest(idx.excludeRetired(bleve.NewQueryStringQuery(q)))
 	search.Size = limit
 	if len(order) > 0 {
 		search.SortBy(order)
@@ -172,13 +229,27 @@ func (idx *Indexer) SearchWithQuery(q string, limit int, order []string, fields
 	} else {
 		search.Fields = []string{"*"}
 	}
-	return idx.bleveIdx.Search(search)
+	return idx.bleveIdx.SearchInContext(ctx, search)
+}
+
+// withPatternFragments creates a query for a field using the provided
+// factory. For the `raw_query` field, the query is extended so it also matches
+// literal fragments of word list and keywords patterns (e.g. searching for
+// `ování` finds a word list with the pattern `.*ování`).
+func withPatternFragments(field string, mkQuery func(field string) query.Query) query.Query {
+	if field != "raw_query" {
+		return mkQuery(field)
+	}
+	return bleve.NewDisjunctionQuery(mkQuery(field), mkQuery("pattern_fragments"))
 }
 
 // Search provides a search interface for other applications
-func (idx *Indexer) Search(terms []searchedTerm, limit int, order []string, fields []string) (*bleve.SearchResult, error) {
+func (idx *Indexer) Search(ctx context.Context, terms []searchedTerm, limit int, order []string, fields []string) (*bleve.SearchResult, error) {
 	boolQuery := bleve.NewBooleanQuery()
 	for _, term := range terms {
+		if idx.outdatedFields[term.Field] {
+			return nil, fmt.Errorf("failed to search by %s: %w", term.Field, ErrFieldNeedsReindex)
+		}
 		var addQueryFn func(m ...query.Query)
 		switch term.Requirement {
 		case "must":
@@ -197,17 +268,40 @@ func (idx *Indexer) Search(terms []searchedTerm, limit int, order []string, fiel
 			// conversion. Also note that this may cause problem in some edge
 			// cases as the filter's algorithm is not the same as used
 			// in strings.ToLower
-			wc := bleve.NewWildcardQuery("*" + strings.ToLower(term.Value) + "*")
-			wc.SetField(term.Field)
-			addQueryFn(wc)
+			addQueryFn(withPatternFragments(term.Field, func(field string) query.Query {
+				wc := bleve.NewWildcardQuery("*" + strings.ToLower(term.Value) + "*")
+				wc.SetField(field)
+				return wc
+			}))
+
+		} else if boolFields[term.Field] {
+			v, err := strconv.ParseBool(term.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: \"%s\"", term.Field, term.Value)
+			}
+			bq := bleve.NewBoolFieldQuery(v)
+			bq.SetField(term.Field)
+			addQueryFn(bq)
+
+		} else if numericFields[term.Field] {
+			v, err := strconv.ParseFloat(term.Value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: \"%s\"", term.Field, term.Value)
+			}
+			incl := true
+			nq := bleve.NewNumericRangeInclusiveQuery(&v, &v, &incl, &incl)
+			nq.SetField(term.Field)
+			addQueryFn(nq)
 
 		} else {
-			wc := bleve.NewMatchQuery(term.Value)
-			wc.SetField(term.Field)
-			addQueryFn(wc)
+			addQueryFn(withPatternFragments(term.Field, func(field string) query.Query {
+				wc := bleve.NewMatchQuery(term.Value)
+				wc.SetField(field)
+				return wc
+			}))
 		}
 	}
-	search := bleve.NewSearchRequest(boolQuery)
+	search := bleve.NewSearchRequest(idx.excludeRetired(boolQuery))
 	search.Size = limit
 	if len(order) > 0 {
 		search.SortBy(order)
@@ -219,31 +313,314 @@ func (idx *Indexer) Search(terms []searchedTerm, limit int, order []string, fiel
 	} else {
 		search.Fields = []string{"*"}
 	}
-	return idx.bleveIdx.Search(search)
+	return idx.bleveIdx.SearchInContext(ctx, search)
 }
 
-func (idx *Indexer) Update(hRec *cncdb.HistoryRecord) error {
-	rec, err := idx.GetConcRecord(hRec.QueryID)
+// Update loads the archive record of a query history item and indexes
+// the item. The returned bool specifies whether the item has been indexed
+// (not all the records are indexable).
+func (idx *Indexer) Update(ctx context.Context, hRec *cncdb.HistoryRecord) (bool, error) {
+	rec, err := idx.GetConcRecord(ctx, hRec.QueryID)
 	if err != nil {
-		return err
+		return false, err
 	} else if rec == nil {
-		return fmt.Errorf("query not found: %s", hRec.QueryID)
+		return false, fmt.Errorf("query not found: %s", hRec.QueryID)
 	}
 	hRec.Rec = rec
 	log.Debug().Any("item", hRec).Msg("about to store item to Bleve index")
-	_, err = idx.IndexRecord(hRec)
-	return err
+	return idx.IndexRecord(ctx, hRec)
+}
+
+// searchDocs returns all the documents matching a provided query.
+// Regular documents are returned as aggregates with a single history key.
+func (idx *Indexer) searchDocs(ctx context.Context, srchQuery query.Query) ([]aggregate, error) {
+	ans := make([]aggregate, 0, subcReindexPageSize)
+	for from := 0; ; from += subcReindexPageSize {
+		search := bleve.NewSearchRequestOptions(srchQuery, subcReindexPageSize, from, false)
+		search.Fields = []string{"name", "history_keys"}
+		search.SortBy([]string{"_id"})
+		res, err := idx.bleveIdx.SearchInContext(ctx, search)
+		if err != nil {
+			return ans, err
+		}
+		for _, hit := range res.Hits {
+			doc := hitToAggregate(hit)
+			if len(doc.keys) == 0 {
+				doc.keys = []string{hit.ID}
+			}
+			ans = append(ans, doc)
+		}
+		if len(res.Hits) < subcReindexPageSize {
+			break
+		}
+	}
+	return ans, nil
+}
+
+// findDocs returns IDs and stored names of all the documents matching
+// a provided query. For aggregated documents, the latest history item
+// is returned as it determines the content of the aggregate.
+func (idx *Indexer) findDocs(ctx context.Context, srchQuery query.Query) ([]cncdb.HistoryRecord, error) {
+	docs, err := idx.searchDocs(ctx, srchQuery)
+	if err != nil {
+		return []cncdb.HistoryRecord{}, err
+	}
+	ans := make([]cncdb.HistoryRecord, len(docs))
+	for i, doc := range docs {
+		hRecs, err := sortedHistoryKeys(doc.keys)
+		if err != nil {
+			return []cncdb.HistoryRecord{}, err
+		}
+		ans[i] = hRecs[len(hRecs)-1]
+		ans[i].Name = doc.name
+	}
+	return ans, nil
+}
+
+// findSubcorpusDocs returns all the documents referring to a subcorpus
+// (see findDocs). For documents indexed before we started to store
+// subcorpus IDs, a previous name of the subcorpus can be used as a fallback.
+func (idx *Indexer) findSubcorpusDocs(ctx context.Context, subcID, prevName string) ([]cncdb.HistoryRecord, error) {
+	// subcorpus IDs are opaque case-sensitive keys
+	idQuery := bleve.NewTermQuery(subcID)
+	idQuery.SetField("subcorpus_id")
+	var srchQuery query.Query = idQuery
+	if prevName != "" {
+		nameQuery := bleve.NewMatchPhraseQuery(prevName)
+		nameQuery.SetField("subcorpus")
+		srchQuery = bleve.NewDisjunctionQuery(idQuery, nameQuery)
+	}
+	ans, err := idx.findDocs(ctx, srchQuery)
+	if err != nil {
+		return ans, fmt.Errorf("failed to search for subcorpus documents: %w", err)
+	}
+	return ans, nil
+}
+
+// ReindexSubcorpus finds all the documents referring to a subcorpus
+// and indexes them again so they contain the current subcorpus name
+// and text types. The prevName argument is optional, see findSubcorpusDocs.
+// The method returns number of reindexed documents.
+func (idx *Indexer) ReindexSubcorpus(ctx context.Context, subcID, prevName string) (int, error) {
+	hRecs, err := idx.findSubcorpusDocs(ctx, subcID, prevName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reindex subcorpus %s: %w", subcID, err)
+	}
+	var numReindexed int
+	for _, hRec := range hRecs {
+		if _, err := idx.Update(ctx, &hRec); err != nil {
+			log.Error().
+				Err(err).
+				Str("subcorpusId", subcID).
+				Str("docId", hRec.CreateIndexID()).
+				Msg("failed to reindex document affected by subcorpus change, skipping")
+			continue
+		}
+		numReindexed++
+	}
+	log.Info().
+		Str("subcorpusId", subcID).
+		Int("numFound", len(hRecs)).
+		Int("numReindexed", numReindexed).
+		Msg("reindexed documents affected by subcorpus change")
+	return numReindexed, nil
+}
+
+// FindCorpusDocs returns all the documents referring to a corpus (see findDocs)
+func (idx *Indexer) FindCorpusDocs(ctx context.Context, corpusID string) ([]cncdb.HistoryRecord, error) {
+	corpQuery := bleve.NewMatchPhraseQuery(corpusID)
+	corpQuery.SetField("corpora")
+	ans, err := idx.findDocs(ctx, corpQuery)
+	if err != nil {
+		return ans, fmt.Errorf("failed to search for corpus documents: %w", err)
+	}
+	return ans, nil
+}
+
+// ReindexCorpusDocs finds all the documents referring to a corpus and
+// indexes them again with their current archive data. This is intended
+// for situations where the archive records have been changed (e.g. the
+// corpus has been renamed). As the change may affect identity of aggregated
+// documents, aggregates are removed and all their history items are indexed
+// again. The method returns number of found documents and number of reindexed
+// history items.
+func (idx *Indexer) ReindexCorpusDocs(ctx context.Context, corpusID string) (int, int, error) {
+	corpQuery := bleve.NewMatchPhraseQuery(corpusID)
+	corpQuery.SetField("corpora")
+	docs, err := idx.searchDocs(ctx, corpQuery)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to reindex documents of corpus %s: %w", corpusID, err)
+	}
+	var numReindexed int
+	for _, doc := range docs {
+		if len(doc.keys) > 1 || doc.keys[0] != doc.id {
+			if err := idx.bleveIdx.Delete(doc.id); err != nil {
+				return len(docs), numReindexed, fmt.Errorf(
+					"failed to reindex documents of corpus %s: %w", corpusID, err)
+			}
+		}
+		for _, k := range doc.keys {
+			hRec, err := cncdb.ParseIndexID(k)
+			if err != nil {
+				return len(docs), numReindexed, fmt.Errorf(
+					"failed to reindex documents of corpus %s: %w", corpusID, err)
+			}
+			hRec.Name = doc.name
+			if _, err := idx.Update(ctx, &hRec); err != nil {
+				log.Error().
+					Err(err).
+					Str("corpusId", corpusID).
+					Str("docId", k).
+					Msg("failed to reindex document, skipping")
+				continue
+			}
+			numReindexed++
+		}
+	}
+	return len(docs), numReindexed, nil
 }
 
-func (idx *Indexer) Delete(recID string) error {
+// RetireCorpus finds all the documents referring to a corpus and
+// indexes them again with the `retired` flag set. The respective
+// archive records must be already marked as retired
+// (see cncdb.ArchRecord.Retired) as the flag is derived from them.
+// The method returns number of found and reindexed documents.
+func (idx *Indexer) RetireCorpus(ctx context.Context, corpusID string) (int, int, error) {
+	hRecs, err := idx.FindCorpusDocs(ctx, corpusID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to retire corpus %s in index: %w", corpusID, err)
+	}
+	var numReindexed int
+	for _, hRec := range hRecs {
+		// we load the record directly from MySQL as the retired flag
+		// is the only thing we need to update
+		recs, err := idx.concArchDb.LoadRecordsByID(ctx, hRec.QueryID)
+		if err != nil || len(recs) == 0 {
+			log.Error().
+				Err(err).
+				Str("corpusId", corpusID).
+				Str("docId", hRec.CreateIndexID()).
+				Msg("failed to load archive record of a retired document, skipping")
+			continue
+		}
+		hRec.Rec = &recs[0]
+		if _, err := idx.IndexRecord(ctx, &hRec); err != nil {
+			log.Error().
+				Err(err).
+				Str("corpusId", corpusID).
+				Str("docId", hRec.CreateIndexID()).
+				Msg("failed to reindex document of a retired corpus, skipping")
+			continue
+		}
+		numReindexed++
+	}
+	return len(hRecs), numReindexed, nil
+}
+
+// Delete removes a query history item from the index. In case the
+// item is a part of an aggregated document (see Conf.CollapseRepeatedQueries),
+// the aggregate is updated accordingly. Please note that aggregates are
+// searched for even if collapsing is disabled as it might have been enabled
+// before.
+func (idx *Indexer) Delete(ctx context.Context, recID string) error {
+	if hRec, err := cncdb.ParseIndexID(recID); err == nil {
+		unlock := idx.aggLocks.lock(hRec.UserID)
+		defer unlock()
+	}
+	aggs, err := idx.findAggregates(ctx, recID)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", recID, err)
+	}
+	for _, agg := range aggs {
+		if err := idx.removeFromAggregate(ctx, agg, recID); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", recID, err)
+		}
+	}
 	return idx.bleveIdx.Delete(recID)
 }
 
-func (idx *Indexer) GetConcRecord(queryID string) (*cncdb.ArchRecord, error) {
-	rec, err := idx.rdb.GetConcRecord(queryID)
-	if err == cncdb.ErrRecordNotFound {
+// TrashEnabled tells whether deleted query history items
+// are moved to the trash
+func (idx *Indexer) TrashEnabled() bool {
+	return idx.conf.TrashEnabled()
+}
+
+// TrashRecord stores a query history record deleted via API to the trash
+// so it can be restored later. If the trash is disabled, nothing is done.
+// For a record without a name, the name stored in the index (if any)
+// is used so a named record is restored with its name.
+func (idx *Indexer) TrashRecord(ctx context.Context, hRec cncdb.HistoryRecord) error {
+	if !idx.conf.TrashEnabled() {
+		return nil
+	}
+	if hRec.Name == "" {
+		name, err := idx.indexedName(ctx, hRec.CreateIndexID())
+		if err != nil {
+			return fmt.Errorf("failed to trash record: %w", err)
+		}
+		hRec.Name = name
+	}
+	return idx.queryHistDb.TrashRecords(ctx, nil, []cncdb.HistoryRecord{hRec}, cncdb.TrashSourceAPI)
+}
+
+// indexedName returns a name of an indexed query history item. For aggregated
+// items, the name of the aggregate is returned.
+func (idx *Indexer) indexedName(ctx context.Context, recID string) (string, error) {
+	doc, found, err := idx.loadAggregate(ctx, recID)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return doc.name, nil
+	}
+	aggs, err := idx.findAggregates(ctx, recID)
+	if err != nil {
+		return "", err
+	}
+	for _, agg := range aggs {
+		if agg.name != "" {
+			return agg.name, nil
+		}
+	}
+	return "", nil
+}
+
+// UserTrash returns up to `limit` recently deleted query history items of a user
+func (idx *Indexer) UserTrash(ctx context.Context, userID, limit int) ([]cncdb.TrashedHistoryRecord, error) {
+	return idx.queryHistDb.GetUserTrash(ctx, userID, limit)
+}
+
+// RestoreRecord moves a query history item from the trash back to the query
+// history and indexes it again. The returned bool specifies whether the record
+// has been indexed (not all the records are indexable).
+func (idx *Indexer) RestoreRecord(
+	ctx context.Context, userID int, created int64, queryID string) (cncdb.HistoryRecord, bool, error) {
+	hRec, err := idx.queryHistDb.RestoreFromTrash(ctx, userID, created, queryID)
+	if err != nil {
+		return hRec, false, err
+	}
+	indexed, err := idx.Update(ctx, &hRec)
+	if err != nil {
+		return hRec, false, fmt.Errorf("failed to reindex restored record: %w", err)
+	}
+	return hRec, indexed, nil
+}
+
+// GetConcRecord loads an archive record from Redis or (if not found there)
+// from MySQL. As records stored in Redis do not contain the retired flag,
+// the flag is always taken from MySQL so reindexing does not revert
+// a corpus retirement.
+func (idx *Indexer) GetConcRecord(ctx context.Context, queryID string) (*cncdb.ArchRecord, error) {
+	rec, err := idx.rdb.GetConcRecord(ctx, queryID)
+	if err == nil {
+		rec.Retired, err = idx.concArchDb.IsRecordRetired(ctx, queryID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process query %s: %w", queryID, err)
+		}
+
+	} else if err == cncdb.ErrRecordNotFound {
 		log.Info().Str("queryId", queryID).Msg("record not found in Redis, trying MySQL")
-		recs, err := idx.concArchDb.LoadRecordsByID(queryID)
+		recs, err := idx.concArchDb.LoadRecordsByID(ctx, queryID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load query %s from MySQL: %w", queryID, err)
 		}
@@ -268,9 +645,11 @@ func (idx *Indexer) Start(ctx context.Context) {
 				log.Info().Msg("about to close ArchKeeper")
 				return
 			case hRec := <-idx.recsToIndex:
-				if _, err := idx.IndexRecord(&hRec); err != nil {
+				recCtx, cancel := context.WithTimeout(ctx, indexRecordTimeout)
+				if _, err := idx.IndexRecord(recCtx, &hRec); err != nil {
 					log.Error().Err(err).Any("hRec", hRec).Msg("unable to index record")
 				}
+				cancel()
 			}
 		}
 	}()
@@ -302,6 +681,20 @@ func NewIndexer(
 	} else if err != nil {
 		return nil, fmt.Errorf("failed to open index: %w", err)
 	}
+	outdated, err := documents.OutdatedFields(bleveIdx.Mapping())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+	outdatedFields := make(map[string]bool)
+	for _, f := range outdated {
+		outdatedFields[f] = true
+	}
+	if len(outdated) > 0 {
+		log.Warn().
+			Strs("fields", outdated).
+			Str("indexDirPath", conf.IndexDirPath).
+			Msg("index mapping is outdated, searching by the fields is disabled until the index is rebuilt")
+	}
 	return &Indexer{
 		conf:        conf,
 		concArchDb:  concArchDb,
@@ -310,6 +703,8 @@ func NewIndexer(
 		bleveIdx:    bleveIdx,
 		recsToIndex: recsToIndex,
 		dataPath:    conf.IndexDirPath,
+
+		outdatedFields: outdatedFields,
 	}, nil
 }
 
@@ -19,8 +19,13 @@ package indexer
 
 import (
 	"camus/cncdb"
+	"camus/indexer/documents"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -69,7 +74,7 @@ func TestEscaping(t *testing.T) {
 		panic(err)
 	}
 
-	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+	ok, err := idxer.IndexRecord(context.Background(), &cncdb.HistoryRecord{
 		QueryID: "foo",
 		Created: created.Unix(),
 		UserID:  1,
@@ -93,9 +98,409 @@ func TestEscaping(t *testing.T) {
 
 	// perform a query
 
-	result, err := idxer.SearchWithQuery("\\/d.*\\/", 1, []string{"id"}, []string{"id"})
+	result, err := idxer.SearchWithQuery(context.Background(), "\\/d.*\\/", 1, []string{"id"}, []string{"id"})
 	assert.NoError(t, err)
 	assert.Equal(t, 1, result.Hits.Len())
 
 	cleanData(idxer.DataPath())
 }
+
+func TestSimpleQueryFilter(t *testing.T) {
+	idxer := prepareIndexer()
+	created := time.Now()
+
+	forms := map[string]map[string]any{
+		"simple1": {
+			"form_type":           "query",
+			"curr_query_types":    map[string]string{"corp1": "simple"},
+			"curr_queries":        map[string]string{"corp1": "hello world"},
+			"selected_text_types": map[string][]string{},
+		},
+		"advanced1": {
+			"form_type":           "query",
+			"curr_query_types":    map[string]string{"corp1": "advanced"},
+			"curr_queries":        map[string]string{"corp1": "[word=\"hello\"]"},
+			"selected_text_types": map[string][]string{},
+		},
+	}
+	for id, form := range forms {
+		rawForm, err := json.Marshal(unspecifiedQueryRecord{ID: id, LastopForm: form})
+		if err != nil {
+			panic(err)
+		}
+		ok, err := idxer.IndexRecord(context.Background(), &cncdb.HistoryRecord{
+			QueryID: id,
+			Created: created.Unix(),
+			UserID:  1,
+			Rec: &cncdb.ArchRecord{
+				ID:      id,
+				Data:    string(rawForm),
+				Created: created,
+			},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	result, err := idxer.Search(
+		context.Background(),
+		[]searchedTerm{
+			{Field: "user_id", Value: "1", Requirement: "must"},
+			{Field: "is_simple_query", Value: "true", Requirement: "must"},
+		},
+		10, []string{"id"}, []string{"id"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "simple1", result.Hits[0].Fields["id"])
+	}
+
+	result, err = idxer.Search(
+		context.Background(),
+		[]searchedTerm{
+			{Field: "simple_query", Value: "world", Requirement: "must"},
+		},
+		10, []string{"id"}, []string{"id"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+
+	cleanData(idxer.DataPath())
+}
+
+func TestCollapseRepeatedQueries(t *testing.T) {
+	idxer := prepareIndexer()
+	idxer.conf.CollapseRepeatedQueries = true
+	created := time.Now()
+
+	queries := map[string]string{
+		"q1": "[word=\"a\"] [word=\"b\"]",
+		"q2": "[word=\"a\"] [word=\"b\"]",
+		"q3": "[word=\"a\"] [word=\"b\"]",
+		"q4": "[word=\"b\"] [word=\"a\"]",
+	}
+	for i, id := range []string{"q1", "q2", "q3", "q4"} {
+		form := map[string]any{
+			"form_type":           "query",
+			"curr_query_types":    map[string]string{"corp1": "advanced"},
+			"curr_queries":        map[string]string{"corp1": queries[id]},
+			"selected_text_types": map[string][]string{},
+		}
+		rawForm, err := json.Marshal(unspecifiedQueryRecord{ID: id, LastopForm: form})
+		if err != nil {
+			panic(err)
+		}
+		ok, err := idxer.IndexRecord(context.Background(), &cncdb.HistoryRecord{
+			QueryID: id,
+			Created: created.Unix() + int64(i*10),
+			UserID:  1,
+			Rec: &cncdb.ArchRecord{
+				ID:      id,
+				Data:    string(rawForm),
+				Created: created,
+			},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	v, err := idxer.DocCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), v)
+
+	result, err := idxer.Search(
+		context.Background(),
+		[]searchedTerm{
+			{Field: "user_id", Value: "1", Requirement: "must"},
+		},
+		10, []string{"-use_count"}, []string{"id", "use_count", "history_keys"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, result.Hits.Len()) {
+		assert.Equal(t, "q3", result.Hits[0].Fields["id"])
+		assert.Equal(t, float64(3), result.Hits[0].Fields["use_count"])
+		assert.Len(t, result.Hits[0].Fields["history_keys"], 3)
+		assert.Equal(t, "q4", result.Hits[1].Fields["id"])
+		assert.Equal(t, float64(1), result.Hits[1].Fields["use_count"])
+	}
+
+	cleanData(idxer.DataPath())
+}
+
+func TestExcludeRetired(t *testing.T) {
+	idxer := prepareIndexer()
+	idxer.conf.ExcludeRetired = true
+	created := time.Now()
+
+	form := map[string]any{
+		"form_type":           "query",
+		"curr_query_types":    map[string]string{"corp1": "simple"},
+		"curr_queries":        map[string]string{"corp1": "hello world"},
+		"selected_text_types": map[string][]string{},
+	}
+	for i, retired := range []bool{false, true} {
+		id := fmt.Sprintf("rec%d", i)
+		rawForm, err := json.Marshal(unspecifiedQueryRecord{ID: id, LastopForm: form})
+		if err != nil {
+			panic(err)
+		}
+		ok, err := idxer.IndexRecord(context.Background(), &cncdb.HistoryRecord{
+			QueryID: id,
+			Created: created.Unix(),
+			UserID:  1,
+			Rec: &cncdb.ArchRecord{
+				ID:        id,
+				Data:      string(rawForm),
+				Created:   created,
+				Permanent: 1,
+				Retired:   retired,
+			},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	result, err := idxer.Search(
+		context.Background(),
+		[]searchedTerm{
+			{Field: "simple_query", Value: "world", Requirement: "must"},
+		},
+		10, []string{"id"}, []string{"id"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "rec0", result.Hits[0].Fields["id"])
+	}
+
+	idxer.conf.ExcludeRetired = false
+	result, err = idxer.SearchWithQuery(context.Background(), "simple_query:world", 10, []string{}, []string{"id", "retired"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Hits.Len())
+
+	cleanData(idxer.DataPath())
+}
+
+func TestKwordsFormProps(t *testing.T) {
+	idxer := prepareIndexer()
+	created := time.Now()
+
+	form := map[string]any{
+		"form_type":        "kwords",
+		"ref_corpname":     "syn2015",
+		"wlattr":           "lemma",
+		"wlpat":            ".*ování",
+		"score_type":       "logL",
+		"wlminfreq":        "5",
+		"include_nonwords": 0,
+	}
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{ID: "kw1", Corpora: []string{"syn2020"}, Form: form})
+	if err != nil {
+		panic(err)
+	}
+	ok, err := idxer.IndexRecord(context.Background(), &cncdb.HistoryRecord{
+		QueryID: "kw1",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec: &cncdb.ArchRecord{
+			ID:      "kw1",
+			Data:    string(rawForm),
+			Created: created,
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	result, err := idxer.Search(
+		context.Background(),
+		[]searchedTerm{
+			{Field: "score_type", Value: "log-likelihood", Requirement: "must"},
+			{Field: "ref_corpus", Value: "syn2015", Requirement: "must"},
+			{Field: "min_freq", Value: "5", Requirement: "must"},
+			{Field: "include_nonwords", Value: "false", Requirement: "must"},
+			{Field: "raw_query", Value: "ování", Requirement: "must"},
+		},
+		10, []string{"id"}, []string{"id"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "kw1", result.Hits[0].Fields["id"])
+	}
+
+	cleanData(idxer.DataPath())
+}
+
+func TestFindSubcorpusDocs(t *testing.T) {
+	idxer := prepareIndexer()
+	created := time.Now()
+
+	docs := []struct {
+		hRec cncdb.HistoryRecord
+		doc  documents.Concordance
+	}{
+		{
+			hRec: cncdb.HistoryRecord{UserID: 1, Created: created.Unix(), QueryID: "q1"},
+			doc:  documents.Concordance{ID: "q1", UserID: "1", Subcorpus: "My Subc", SubcorpusID: "AbCd"},
+		},
+		{
+			hRec: cncdb.HistoryRecord{UserID: 1, Created: created.Unix(), QueryID: "q2"},
+			doc:  documents.Concordance{ID: "q2", UserID: "1", Subcorpus: "My Subc", SubcorpusID: "abcd"},
+		},
+		{
+			// indexed before subcorpus IDs were stored
+			hRec: cncdb.HistoryRecord{UserID: 1, Created: created.Unix(), QueryID: "q3"},
+			doc:  documents.Concordance{ID: "q3", UserID: "1", Subcorpus: "Old Name"},
+		},
+	}
+	for _, d := range docs {
+		assert.NoError(t, idxer.bleveIdx.Index(d.hRec.CreateIndexID(), &d.doc))
+	}
+
+	found, err := idxer.findSubcorpusDocs(context.Background(), "AbCd", "")
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "q1", found[0].QueryID)
+	}
+
+	found, err = idxer.findSubcorpusDocs(context.Background(), "AbCd", "Old Name")
+	assert.NoError(t, err)
+	foundIDs := make([]string, len(found))
+	for i, hRec := range found {
+		foundIDs[i] = hRec.QueryID
+	}
+	assert.ElementsMatch(t, []string{"q1", "q3"}, foundIDs)
+
+	cleanData(idxer.DataPath())
+}
+
+func TestConcurrentAggregation(t *testing.T) {
+	idxer := prepareIndexer()
+	idxer.conf.CollapseRepeatedQueries = true
+	created := time.Now()
+
+	form := map[string]any{
+		"form_type":           "query",
+		"curr_query_types":    map[string]string{"corp1": "advanced"},
+		"curr_queries":        map[string]string{"corp1": "[word=\"a\"]"},
+		"selected_text_types": map[string][]string{},
+	}
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{ID: "q1", LastopForm: form})
+	if err != nil {
+		panic(err)
+	}
+	const numItems = 20
+	var wg sync.WaitGroup
+	for i := 0; i < numItems; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := idxer.IndexRecord(context.Background(), &cncdb.HistoryRecord{
+				QueryID: "q1",
+				Created: created.Unix() + int64(i),
+				UserID:  1,
+				Rec: &cncdb.ArchRecord{
+					ID:      "q1",
+					Data:    string(rawForm),
+					Created: created,
+				},
+			})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	result, err := idxer.SearchWithQuery(context.Background(), "user_id:1", 10, []string{}, []string{"use_count", "history_keys"})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, float64(numItems), result.Hits[0].Fields["use_count"])
+		assert.Len(t, result.Hits[0].Fields["history_keys"], numItems)
+	}
+
+	cleanData(idxer.DataPath())
+}
+
+type trashingQHist struct {
+	cncdb.DummyQHistSQL
+	trashed []cncdb.HistoryRecord
+}
+
+func (db *trashingQHist) TrashRecords(
+	ctx context.Context, tx *sql.Tx, recs []cncdb.HistoryRecord, source string) error {
+	db.trashed = append(db.trashed, recs...)
+	return nil
+}
+
+func TestTrashRecordKeepsIndexedName(t *testing.T) {
+	idxer := prepareIndexer()
+	idxer.conf.QueryHistoryTrashRetentionDays = 7
+	qHist := &trashingQHist{}
+	idxer.queryHistDb = qHist
+
+	hRec := cncdb.HistoryRecord{UserID: 1, Created: time.Now().Unix(), QueryID: "q1"}
+	doc := documents.Concordance{ID: "q1", UserID: "1", Name: "my query"}
+	assert.NoError(t, idxer.bleveIdx.Index(hRec.CreateIndexID(), &doc))
+
+	assert.NoError(t, idxer.TrashRecord(context.Background(), hRec))
+	if assert.Len(t, qHist.trashed, 1) {
+		assert.Equal(t, "my query", qHist.trashed[0].Name)
+	}
+
+	cleanData(idxer.DataPath())
+}
+
+// redisConcDB mimics Redis which does not store the retired flag
+type redisConcDB struct {
+	recs map[string]cncdb.ArchRecord
+}
+
+func (db *redisConcDB) GetConcRecord(ctx context.Context, id string) (cncdb.ArchRecord, error) {
+	rec, ok := db.recs[id]
+	if !ok {
+		return cncdb.ArchRecord{}, cncdb.ErrRecordNotFound
+	}
+	rec.Retired = false
+	return rec, nil
+}
+
+type retiredConcArch struct {
+	cncdb.DummyConcArchSQL
+	retired map[string]bool
+}
+
+func (db *retiredConcArch) IsRecordRetired(ctx context.Context, id string) (bool, error) {
+	return db.retired[id], nil
+}
+
+func TestReindexKeepsRetired(t *testing.T) {
+	idxer := prepareIndexer()
+	idxer.conf.ExcludeRetired = true
+	created := time.Now()
+
+	form := map[string]any{
+		"form_type":           "query",
+		"curr_query_types":    map[string]string{"corp1": "simple"},
+		"curr_queries":        map[string]string{"corp1": "hello world"},
+		"selected_text_types": map[string][]string{},
+	}
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{ID: "rec1", LastopForm: form})
+	assert.NoError(t, err)
+	rec := cncdb.ArchRecord{ID: "rec1", Data: string(rawForm), Created: created, Retired: true}
+	idxer.rdb = &redisConcDB{recs: map[string]cncdb.ArchRecord{"rec1": rec}}
+	idxer.concArchDb = &retiredConcArch{retired: map[string]bool{"rec1": true}}
+
+	hRec := cncdb.HistoryRecord{QueryID: "rec1", Created: created.Unix(), UserID: 1}
+	indexed, err := idxer.Update(context.Background(), &hRec)
+	assert.NoError(t, err)
+	assert.True(t, indexed)
+
+	result, err := idxer.SearchWithQuery(context.Background(), "simple_query:world", 10, []string{}, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Hits.Len())
+
+	idxer.conf.ExcludeRetired = false
+	result, err = idxer.SearchWithQuery(context.Background(), "simple_query:world", 10, []string{}, []string{"retired"})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, true, result.Hits[0].Fields["retired"])
+	}
+
+	cleanData(idxer.DataPath())
+}
@@ -51,8 +51,8 @@ func (service *Service) Stop(ctx context.Context) error {
 	return nil
 }
 
-func (service *Service) GetRecord(ident string) (cncdb.ArchRecord, error) {
-	return service.redis.GetConcRecord(ident)
+func (service *Service) GetRecord(ctx context.Context, ident string) (cncdb.ArchRecord, error) {
+	return service.redis.GetConcRecord(ctx, ident)
 }
 
 func NewService(
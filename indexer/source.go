@@ -19,6 +19,7 @@ package indexer
 
 import (
 	"camus/cncdb"
+	"context"
 	"fmt"
 	"time"
 )
@@ -59,6 +60,6 @@ func (qr *unspecifiedQueryRecord) GetSupertype() (cncdb.QuerySupertype, error) {
 	return st, nil
 }
 
-func (qr *unspecifiedQueryRecord) GetSubcorpusProps(db cncdb.IConcArchOps) (cncdb.SubcProps, error) {
-	return db.GetSubcorpusProps(qr.SubcorpusID)
+func (qr *unspecifiedQueryRecord) GetSubcorpusProps(ctx context.Context, db cncdb.IConcArchOps) (cncdb.SubcProps, error) {
+	return db.GetSubcorpusProps(ctx, qr.SubcorpusID)
 }
@@ -0,0 +1,140 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integrity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltCheckIntervalSecs       = 60
+	dfltNumProcessItemsPerTick  = 200
+	dfltNightItemsIncrease      = 5
+	maxNumProcessItemsPerTick   = 10000
+	dfltStatusKey               = "camus_integrity_status"
+	dfltReportsKey              = "camus_integrity_reports"
+	dfltMaxStoredReports        = 100
+	dfltMaxReportedMismatches   = 1000
+	minAllowedCheckIntervalSecs = 10
+)
+
+// Conf configures a background verification of archived records'
+// payload checksums (see cncdb/checksum.go). The service goes through
+// the whole archive chunk by chunk and once it reaches the end,
+// it stores a report and starts again.
+type Conf struct {
+	CheckIntervalSecs           int `json:"checkIntervalSecs"`
+	NumProcessItemsPerTick      int `json:"numProcessItemsPerTick"`
+	NumProcessItemsPerTickNight int `json:"numProcessItemsPerTickNight"`
+
+	// FillMissing makes the service calculate and store checksums
+	// for records archived before checksums were enabled
+	FillMissing bool `json:"fillMissing"`
+
+	// StatusKey is a Redis key where the state of the current
+	// verification pass is stored
+	StatusKey string `json:"statusKey"`
+
+	// ReportsKey is a Redis list where reports of finished
+	// verification passes are stored
+	ReportsKey string `json:"reportsKey"`
+
+	// MaxStoredReports specifies how many latest reports are kept
+	MaxStoredReports int `json:"maxStoredReports"`
+
+	// MaxReportedMismatches limits the number of mismatching records
+	// listed in a single report (all of them are logged anyway)
+	MaxReportedMismatches int `json:"maxReportedMismatches"`
+}
+
+func (conf *Conf) CheckInterval() time.Duration {
+	return time.Duration(conf.CheckIntervalSecs) * time.Second
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.CheckIntervalSecs == 0 {
+		conf.CheckIntervalSecs = dfltCheckIntervalSecs
+		log.Warn().
+			Int("value", conf.CheckIntervalSecs).
+			Msg("missing configuration `integrity.checkIntervalSecs`, using default")
+	}
+	if conf.CheckIntervalSecs < minAllowedCheckIntervalSecs {
+		return fmt.Errorf(
+			"invalid value for `integrity.checkIntervalSecs` (must be >= %d)", minAllowedCheckIntervalSecs)
+	}
+	if conf.NumProcessItemsPerTick == 0 {
+		conf.NumProcessItemsPerTick = dfltNumProcessItemsPerTick
+		log.Warn().
+			Int("value", conf.NumProcessItemsPerTick).
+			Msg("missing configuration `integrity.numProcessItemsPerTick`, using default")
+	}
+	if conf.NumProcessItemsPerTick < 1 || conf.NumProcessItemsPerTick > maxNumProcessItemsPerTick {
+		return fmt.Errorf(
+			"invalid value for `integrity.numProcessItemsPerTick` (must be between 1 and %d)",
+			maxNumProcessItemsPerTick,
+		)
+	}
+	if conf.NumProcessItemsPerTickNight == 0 {
+		conf.NumProcessItemsPerTickNight = conf.NumProcessItemsPerTick * dfltNightItemsIncrease
+		log.Warn().
+			Int("value", conf.NumProcessItemsPerTickNight).
+			Msg("missing configuration `integrity.numProcessItemsPerTickNight`, using calculated default")
+	}
+	if conf.NumProcessItemsPerTickNight < 1 || conf.NumProcessItemsPerTickNight > maxNumProcessItemsPerTick {
+		return fmt.Errorf(
+			"invalid value for `integrity.numProcessItemsPerTickNight` (must be between 1 and %d)",
+			maxNumProcessItemsPerTick,
+		)
+	}
+	if conf.StatusKey == "" {
+		conf.StatusKey = dfltStatusKey
+		log.Warn().
+			Str("value", conf.StatusKey).
+			Msg("missing configuration `integrity.statusKey`, using default")
+	}
+	if conf.ReportsKey == "" {
+		conf.ReportsKey = dfltReportsKey
+		log.Warn().
+			Str("value", conf.ReportsKey).
+			Msg("missing configuration `integrity.reportsKey`, using default")
+	}
+	if conf.MaxStoredReports == 0 {
+		conf.MaxStoredReports = dfltMaxStoredReports
+		log.Warn().
+			Int("value", conf.MaxStoredReports).
+			Msg("missing configuration `integrity.maxStoredReports`, using default")
+	}
+	if conf.MaxStoredReports < 0 {
+		return fmt.Errorf("invalid value for `integrity.maxStoredReports` (must be > 0)")
+	}
+	if conf.MaxReportedMismatches == 0 {
+		conf.MaxReportedMismatches = dfltMaxReportedMismatches
+		log.Warn().
+			Int("value", conf.MaxReportedMismatches).
+			Msg("missing configuration `integrity.maxReportedMismatches`, using default")
+	}
+	if conf.MaxReportedMismatches < 0 {
+		return fmt.Errorf("invalid value for `integrity.maxReportedMismatches` (must be > 0)")
+	}
+	return nil
+}
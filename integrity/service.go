@@ -0,0 +1,197 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integrity
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// checksumVerifier verifies payload checksums of a chunk of records
+// (see cncdb.MySQLConcArch.VerifyChecksums)
+type checksumVerifier interface {
+	VerifyChecksums(
+		ctx context.Context,
+		fromCreated time.Time,
+		fromID string,
+		maxItems int,
+		fillMissing bool,
+	) (cncdb.ChecksumVerification, error)
+}
+
+// Report describes a single verification pass over the whole archive.
+// An unfinished pass has a nil Finished.
+type Report struct {
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// LastCreated and LastID identify the last verified record
+	LastCreated time.Time `json:"lastCreated"`
+	LastID      string    `json:"lastId"`
+
+	NumChecked    int `json:"numChecked"`
+	NumMissing    int `json:"numMissing"`
+	NumFilled     int `json:"numFilled"`
+	NumMismatches int `json:"numMismatches"`
+
+	// Mismatches lists (a limited number of) records with payloads
+	// not matching their checksums
+	Mismatches []cncdb.ChecksumMismatch `json:"mismatches"`
+}
+
+func (r *Report) update(chunk cncdb.ChecksumVerification, maxMismatches int) {
+	if chunk.NumChecked > 0 {
+		r.LastCreated = chunk.LastCreated
+		r.LastID = chunk.LastID
+	}
+	r.NumChecked += chunk.NumChecked
+	r.NumMissing += chunk.NumMissing
+	r.NumFilled += chunk.NumFilled
+	r.NumMismatches += len(chunk.Mismatches)
+	for _, m := range chunk.Mismatches {
+		if len(r.Mismatches) >= maxMismatches {
+			break
+		}
+		r.Mismatches = append(r.Mismatches, m)
+	}
+}
+
+// Service continuously verifies payload checksums of archived records
+// in small chunks (so it does not overload the database).
+type Service struct {
+	conf    *Conf
+	db      checksumVerifier
+	rdb     *archiver.RedisAdapter
+	reports *archiver.ReportList[Report]
+	tz      *time.Location
+}
+
+func (job *Service) Start(ctx context.Context) {
+	ticker := time.NewTicker(job.conf.CheckInterval())
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("about to close integrity verification service")
+				return
+			case t := <-ticker.C:
+				numProc := job.conf.NumProcessItemsPerTick
+				if cncdb.TimeIsAtNight(t.In(job.tz)) {
+					numProc = job.conf.NumProcessItemsPerTickNight
+				}
+				tickCtx, cancel := context.WithTimeout(ctx, job.conf.CheckInterval())
+				if err := job.verifyChunk(tickCtx, numProc); err != nil {
+					log.Error().Err(err).Msg("failed to perform integrity verification")
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+func (job *Service) Stop(ctx context.Context) error {
+	log.Warn().Msg("stopping integrity verification service")
+	return nil
+}
+
+func (job *Service) verifyChunk(ctx context.Context, numItems int) error {
+	report, err := job.CurrentState(ctx)
+	if err != nil {
+		return err
+	}
+	chunk, err := job.db.VerifyChecksums(
+		ctx, report.LastCreated, report.LastID, numItems, job.conf.FillMissing)
+	if err != nil {
+		return fmt.Errorf("failed to verify chunk of records: %w", err)
+	}
+	report.update(chunk, job.conf.MaxReportedMismatches)
+	if chunk.NumChecked == numItems {
+		return job.storeState(ctx, report)
+	}
+	// we have reached the end of the archive
+	now := time.Now().In(job.tz)
+	report.Finished = &now
+	if err := job.reports.Store(ctx, report); err != nil {
+		return fmt.Errorf("failed to store integrity report: %w", err)
+	}
+	log.Info().
+		Int("numChecked", report.NumChecked).
+		Int("numMissing", report.NumMissing).
+		Int("numFilled", report.NumFilled).
+		Int("numMismatches", report.NumMismatches).
+		Msg("integrity verification pass finished")
+	if err := job.rdb.Set(ctx, job.conf.StatusKey, ""); err != nil {
+		return fmt.Errorf("failed to reset integrity verification state: %w", err)
+	}
+	return nil
+}
+
+// CurrentState returns the state of the running verification pass
+func (job *Service) CurrentState(ctx context.Context) (Report, error) {
+	raw, err := job.rdb.Get(ctx, job.conf.StatusKey)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to load integrity verification state: %w", err)
+	}
+	if raw == "" {
+		return Report{
+			Started:    time.Now().In(job.tz),
+			Mismatches: []cncdb.ChecksumMismatch{},
+		}, nil
+	}
+	var ans Report
+	if err := json.Unmarshal([]byte(raw), &ans); err != nil {
+		return Report{}, fmt.Errorf("failed to load integrity verification state: %w", err)
+	}
+	return ans, nil
+}
+
+func (job *Service) storeState(ctx context.Context, report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to store integrity verification state: %w", err)
+	}
+	if err := job.rdb.Set(ctx, job.conf.StatusKey, string(data)); err != nil {
+		return fmt.Errorf("failed to store integrity verification state: %w", err)
+	}
+	return nil
+}
+
+// LatestReports returns up to `num` latest reports, the newest first
+func (job *Service) LatestReports(ctx context.Context, num int) ([]Report, error) {
+	return job.reports.Latest(ctx, num)
+}
+
+func NewService(
+	conf *Conf,
+	db checksumVerifier,
+	rdb *archiver.RedisAdapter,
+	tz *time.Location,
+) *Service {
+	return &Service{
+		conf:    conf,
+		db:      db,
+		rdb:     rdb,
+		reports: archiver.NewReportList[Report](rdb, conf.ReportsKey, conf.MaxStoredReports),
+		tz:      tz,
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integrity
+
+import (
+	"camus/cncdb"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportUpdate(t *testing.T) {
+	var report Report
+	t1 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	report.update(
+		cncdb.ChecksumVerification{
+			LastCreated: t1,
+			LastID:      "x1",
+			NumChecked:  100,
+			NumMissing:  3,
+			Mismatches:  []cncdb.ChecksumMismatch{{ID: "m1"}, {ID: "m2"}},
+		},
+		3,
+	)
+	report.update(
+		cncdb.ChecksumVerification{
+			LastCreated: t1.Add(time.Hour),
+			LastID:      "x2",
+			NumChecked:  50,
+			NumFilled:   2,
+			Mismatches:  []cncdb.ChecksumMismatch{{ID: "m3"}, {ID: "m4"}},
+		},
+		3,
+	)
+	// an empty chunk must not reset the position
+	report.update(cncdb.ChecksumVerification{}, 3)
+	assert.Equal(t, t1.Add(time.Hour), report.LastCreated)
+	assert.Equal(t, "x2", report.LastID)
+	assert.Equal(t, 150, report.NumChecked)
+	assert.Equal(t, 3, report.NumMissing)
+	assert.Equal(t, 2, report.NumFilled)
+	assert.Equal(t, 4, report.NumMismatches)
+	assert.Len(t, report.Mismatches, 3)
+}
@@ -0,0 +1,273 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"camus/indexer"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltDefaultAttr = "word"
+)
+
+type UnsafeQuery struct {
+	RecordID string `json:"recordId"`
+	Reason   string `json:"reason"`
+}
+
+type AttrMigrationStats struct {
+	Corpora []string `json:"corpora"`
+	RewriteStats
+	NumUnsafe           int           `json:"numUnsafe"`
+	Unsafe              []UnsafeQuery `json:"unsafe"`
+	NumHistoryFound     int           `json:"numHistoryFound"`
+	NumHistoryReindexed int           `json:"numHistoryReindexed"`
+}
+
+// migratedAttrsRegexp matches expressions using any of the attributes
+// affected by the rules
+func migratedAttrsRegexp(rules *AttrMigrationRules) *regexp.Regexp {
+	attrs := make([]string, 0, len(rules.PosAttrRenames)+len(rules.ValueMappings))
+	for k := range rules.PosAttrRenames {
+		attrs = append(attrs, regexp.QuoteMeta(k))
+	}
+	for k := range rules.StructAttrRenames {
+		attrs = append(attrs, regexp.QuoteMeta(k[strings.Index(k, ".")+1:]))
+	}
+	for k := range rules.ValueMappings {
+		if i := strings.Index(k, "."); i >= 0 {
+			k = k[i+1:]
+		}
+		attrs = append(attrs, regexp.QuoteMeta(k))
+	}
+	return regexp.MustCompile(`\b(` + strings.Join(attrs, "|") + `)\s*!?=`)
+}
+
+// replaceInOps replaces a query in KonText's operation list (the `q` entry)
+func replaceInOps(ops []any, query, newQuery string) bool {
+	var found bool
+	for i, op := range ops {
+		if s, ok := op.(string); ok && strings.Contains(s, query) {
+			ops[i] = strings.Replace(s, query, newQuery, 1)
+			found = true
+		}
+	}
+	return found
+}
+
+// replaceDefaultAttrInOps replaces a default attribute in KonText's
+// operation list where operations are encoded as `[op. code][default attr],[query]`
+func replaceDefaultAttrInOps(ops []any, attr, newAttr string) {
+	for i, op := range ops {
+		if s, ok := op.(string); ok && len(s) > 0 && strings.HasPrefix(s[1:], attr+",") {
+			ops[i] = s[:1] + newAttr + "," + s[1+len(attr)+1:]
+		}
+	}
+}
+
+func migrateConcForm(
+	rec map[string]any, form map[string]any, rules *AttrMigrationRules, migratedAttrs *regexp.Regexp,
+) (bool, error) {
+	queries, _ := form["curr_queries"].(map[string]any)
+	queryTypes, _ := form["curr_query_types"].(map[string]any)
+	defaultAttrs, _ := form["curr_default_attr_values"].(map[string]any)
+	ops, _ := rec["q"].([]any)
+	var changed bool
+	for _, corp := range rules.Corpora {
+		query, ok := queries[corp].(string)
+		if !ok {
+			continue
+		}
+		defaultAttr := dfltDefaultAttr
+		if v, ok := defaultAttrs[corp].(string); ok && v != "" {
+			defaultAttr = v
+		}
+		if queryTypes[corp] == "advanced" {
+			newQuery, qChanged, err := RewriteCQL(query, defaultAttr, rules)
+			if err != nil {
+				return false, fmt.Errorf("query `%s` in %s: %w", query, corp, err)
+			}
+			if qChanged {
+				if !replaceInOps(ops, query, newQuery) {
+					return false, fmt.Errorf(
+						"query `%s` in %s: %w: query not found in operations", query, corp, ErrUnsafeRewrite)
+				}
+				queries[corp] = newQuery
+				changed = true
+			}
+
+		} else {
+			// simple queries are transformed into CQL by KonText and we
+			// are not able to reproduce the process here
+			for _, op := range ops {
+				if s, ok := op.(string); ok && migratedAttrs.MatchString(s) {
+					return false, fmt.Errorf(
+						"query `%s` in %s: %w: simple query operation uses migrated attribute",
+						query, corp, ErrUnsafeRewrite)
+				}
+			}
+		}
+		if newAttr := rules.mapAttr("", defaultAttr); newAttr != defaultAttr {
+			if _, ok := rules.ValueMappings[defaultAttr]; ok && queryTypes[corp] != "advanced" {
+				return false, fmt.Errorf(
+					"query `%s` in %s: %w: values of default attribute %s are migrated",
+					query, corp, ErrUnsafeRewrite, defaultAttr)
+			}
+			if defaultAttrs != nil {
+				defaultAttrs[corp] = newAttr
+			}
+			replaceDefaultAttrInOps(ops, defaultAttr, newAttr)
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+func migratePqueryForm(form map[string]any, rules *AttrMigrationRules) bool {
+	attr, ok := form["attr"].(string)
+	if !ok {
+		return false
+	}
+	if newAttr := rules.mapAttr("", attr); newAttr != attr {
+		form["attr"] = newAttr
+		return true
+	}
+	return false
+}
+
+// MigrateRecordAttrs applies attribute migration rules to a conc or pquery
+// record data. Records of other types or of corpora not specified in the rules
+// are left untouched. In case any of the record's queries cannot be migrated
+// safely, an error wrapping ErrUnsafeRewrite is returned.
+func MigrateRecordAttrs(data string, rules *AttrMigrationRules) (string, bool, error) {
+	rec, err := cncdb.UnmarshalData(data)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to migrate record attributes: %w", err)
+	}
+	corpora, _ := rec["corpora"].([]any)
+	if !slices.ContainsFunc(corpora, func(c any) bool {
+		s, ok := c.(string)
+		return ok && slices.Contains(rules.Corpora, s)
+	}) {
+		return data, false, nil
+	}
+	var changed bool
+	if form, ok := rec["lastop_form"].(map[string]any); ok && form["form_type"] == "query" {
+		changed, err = migrateConcForm(rec, form, rules, migratedAttrsRegexp(rules))
+		if err != nil {
+			return "", false, err
+		}
+
+	} else if form, ok := rec["form"].(map[string]any); ok && form["form_type"] == "pquery" {
+		changed = migratePqueryForm(form, rules)
+	}
+	if !changed {
+		return data, false, nil
+	}
+	ans, err := cncdb.MarshalData(rec)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to migrate record attributes: %w", err)
+	}
+	return ans, true, nil
+}
+
+// AttrMigration applies attribute renames and value mappings
+// to archived conc. and pquery records (both in MySQL and in Redis)
+// and reindexes respective query history documents.
+type AttrMigration struct {
+	rewriter  *recordRewriter
+	ftIndexer *indexer.Indexer
+}
+
+func (job *AttrMigration) Run(
+	ctx context.Context, rules *AttrMigrationRules, chunkSize int) (AttrMigrationStats, error) {
+	stats := AttrMigrationStats{Corpora: rules.Corpora, Unsafe: make([]UnsafeQuery, 0, 100)}
+	reported := make(map[string]bool)
+	err := job.rewriter.rewriteAll(
+		ctx,
+		chunkSize,
+		func(rec cncdb.ArchRecord) (string, bool, error) {
+			newData, changed, err := MigrateRecordAttrs(rec.Data, rules)
+			if errors.Is(err, ErrUnsafeRewrite) {
+				if !reported[rec.ID] {
+					log.Warn().Err(err).Str("recordId", rec.ID).Msg("record cannot be migrated safely, skipping")
+					stats.Unsafe = append(stats.Unsafe, UnsafeQuery{RecordID: rec.ID, Reason: err.Error()})
+					stats.NumUnsafe++
+					reported[rec.ID] = true
+				}
+				return rec.Data, false, nil
+			}
+			return newData, changed, err
+		},
+		&stats.RewriteStats,
+	)
+	if err != nil {
+		return stats, err
+	}
+	for _, corp := range rules.Corpora {
+		if job.rewriter.dryRun {
+			docs, err := job.ftIndexer.FindCorpusDocs(ctx, corp)
+			if err != nil {
+				return stats, err
+			}
+			stats.NumHistoryFound += len(docs)
+			continue
+		}
+		numFound, numReindexed, err := job.ftIndexer.ReindexCorpusDocs(ctx, corp)
+		if err != nil {
+			return stats, err
+		}
+		stats.NumHistoryFound += numFound
+		stats.NumHistoryReindexed += numReindexed
+	}
+	return stats, nil
+}
+
+// NewAttrMigration creates a new attribute migration job. In the dry-run
+// mode, concArchDb is expected to be a dry-run database adapter
+// (see cncdb.NewMySQLDryRun).
+func NewAttrMigration(
+	concArchDb cncdb.IConcArchOps,
+	rdb *archiver.RedisAdapter,
+	ftIndexer *indexer.Indexer,
+	audit *AuditLog,
+	dryRun bool,
+	tz *time.Location,
+) *AttrMigration {
+	return &AttrMigration{
+		rewriter: &recordRewriter{
+			migration:  "migrate-attrs",
+			concArchDb: concArchDb,
+			rdb:        rdb,
+			audit:      audit,
+			dryRun:     dryRun,
+			tz:         tz,
+		},
+		ftIndexer: ftIndexer,
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	StorageMySQL = "mysql"
+	StorageRedis = "redis"
+)
+
+// AuditRecord describes a single change of an archived record
+// performed by a migration. It contains both the original and
+// the new data so the change can be reverted if needed.
+type AuditRecord struct {
+	Time      time.Time  `json:"time"`
+	Migration string     `json:"migration"`
+	DryRun    bool       `json:"dryRun"`
+	Storage   string     `json:"storage"`
+	ID        string     `json:"id"`
+	Created   *time.Time `json:"created,omitempty"`
+	OldData   string     `json:"oldData"`
+	NewData   string     `json:"newData"`
+}
+
+// AuditLog writes audit records to a file in the JSON Lines format.
+// An existing file is appended.
+type AuditLog struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+func (al *AuditLog) Write(rec AuditRecord) error {
+	if err := al.enc.Encode(rec); err != nil {
+		return fmt.Errorf("failed to write audit record for %s: %w", rec.ID, err)
+	}
+	return nil
+}
+
+func (al *AuditLog) Close() error {
+	return al.file.Close()
+}
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	return &AuditLog{file: f, enc: enc}, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"camus/indexer"
+	"context"
+	"time"
+)
+
+type CorpusRenameStats struct {
+	OldID string `json:"oldId"`
+	NewID string `json:"newId"`
+	RewriteStats
+	NumHistoryFound     int `json:"numHistoryFound"`
+	NumHistoryReindexed int `json:"numHistoryReindexed"`
+}
+
+// CorpusRename rewrites corpus ID in archived records (both in MySQL
+// and in Redis) and reindexes respective query history documents.
+type CorpusRename struct {
+	rewriter  *recordRewriter
+	ftIndexer *indexer.Indexer
+}
+
+// Run renames corpus `oldID` to `newID` in MySQL archive, then in Redis
+// and finally it reindexes affected query history documents.
+func (job *CorpusRename) Run(ctx context.Context, oldID, newID string, chunkSize int) (CorpusRenameStats, error) {
+	stats := CorpusRenameStats{OldID: oldID, NewID: newID}
+	err := job.rewriter.rewriteAll(
+		ctx,
+		chunkSize,
+		func(rec cncdb.ArchRecord) (string, bool, error) {
+			return cncdb.RenameCorpusInData(rec.Data, oldID, newID)
+		},
+		&stats.RewriteStats,
+	)
+	if err != nil {
+		return stats, err
+	}
+	if job.rewriter.dryRun {
+		docs, err := job.ftIndexer.FindCorpusDocs(ctx, oldID)
+		if err != nil {
+			return stats, err
+		}
+		stats.NumHistoryFound = len(docs)
+		return stats, nil
+	}
+	stats.NumHistoryFound, stats.NumHistoryReindexed, err = job.ftIndexer.ReindexCorpusDocs(ctx, oldID)
+	if err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// NewCorpusRename creates a new corpus renaming job. In the dry-run
+// mode, concArchDb is expected to be a dry-run database adapter
+// (see cncdb.NewMySQLDryRun).
+func NewCorpusRename(
+	concArchDb cncdb.IConcArchOps,
+	rdb *archiver.RedisAdapter,
+	ftIndexer *indexer.Indexer,
+	audit *AuditLog,
+	dryRun bool,
+	tz *time.Location,
+) *CorpusRename {
+	return &CorpusRename{
+		rewriter: &recordRewriter{
+			migration:  "rename-corpus",
+			concArchDb: concArchDb,
+			rdb:        rdb,
+			audit:      audit,
+			dryRun:     dryRun,
+			tz:         tz,
+		},
+		ftIndexer: ftIndexer,
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/czcorpus/cqlizer/cql"
+)
+
+var (
+	ErrUnsafeRewrite = errors.New("query cannot be rewritten safely")
+)
+
+// AttrMigrationRules specifies how to change attributes and their values
+// in archived queries of a corpus (or multiple corpora sharing the same
+// attributes).
+type AttrMigrationRules struct {
+
+	// Corpora lists corpora the rules apply to
+	Corpora []string `json:"corpora"`
+
+	// PosAttrRenames maps original positional attributes to new ones
+	PosAttrRenames map[string]string `json:"posAttrRenames"`
+
+	// StructAttrRenames maps original structural attributes in the
+	// `structure.attr` form to new attribute names (the structure
+	// stays the same)
+	StructAttrRenames map[string]string `json:"structAttrRenames"`
+
+	// ValueMappings maps values of (original) attributes to new ones.
+	// Positional attributes are specified by their names, structural
+	// ones in the `structure.attr` form. Values must match exactly
+	// (i.e. a regular expression is treated as a plain string). Queries
+	// searching for a value not listed here cannot be rewritten safely.
+	ValueMappings map[string]map[string]string `json:"valueMappings"`
+}
+
+func (rules *AttrMigrationRules) Validate() error {
+	if len(rules.Corpora) == 0 {
+		return fmt.Errorf("no corpora specified")
+	}
+	if len(rules.PosAttrRenames) == 0 && len(rules.StructAttrRenames) == 0 && len(rules.ValueMappings) == 0 {
+		return fmt.Errorf("no renames or value mappings specified")
+	}
+	for k := range rules.StructAttrRenames {
+		if !strings.Contains(k, ".") {
+			return fmt.Errorf("invalid structural attribute %s (expected structure.attr)", k)
+		}
+	}
+	return nil
+}
+
+// attrKey returns a key identifying an attribute in rules
+func attrKey(structure, name string) string {
+	if structure != "" {
+		return structure + "." + name
+	}
+	return name
+}
+
+// mapAttr returns a new name of an attribute
+func (rules *AttrMigrationRules) mapAttr(structure, name string) string {
+	if structure != "" {
+		if v, ok := rules.StructAttrRenames[attrKey(structure, name)]; ok {
+			return v
+		}
+		return name
+	}
+	if v, ok := rules.PosAttrRenames[name]; ok {
+		return v
+	}
+	return name
+}
+
+// mapValue returns a new value of an attribute. In case the attribute
+// has value mappings but the value is not among them, false is returned.
+func (rules *AttrMigrationRules) mapValue(structure, name, value string) (string, bool) {
+	mapping, ok := rules.ValueMappings[attrKey(structure, name)]
+	if !ok {
+		return value, true
+	}
+	v, ok := mapping[value]
+	return v, ok
+}
+
+func (rules *AttrMigrationRules) mapProp(prop cql.QueryProp, defaultAttr string) cql.QueryProp {
+	name := prop.Name
+	if name == "" && prop.Structure == "" {
+		name = defaultAttr
+	}
+	if prop.Value != "" {
+		if v, ok := rules.mapValue(prop.Structure, name, prop.Value); ok {
+			prop.Value = v
+		}
+	}
+	if prop.Name != "" {
+		prop.Name = rules.mapAttr(prop.Structure, prop.Name)
+	}
+	return prop
+}
+
+func LoadAttrMigrationRules(path string) (*AttrMigrationRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migration rules: %w", err)
+	}
+	var ans AttrMigrationRules
+	if err := json.Unmarshal(data, &ans); err != nil {
+		return nil, fmt.Errorf("failed to load migration rules: %w", err)
+	}
+	if err := ans.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid migration rules: %w", err)
+	}
+	return &ans, nil
+}
+
+// ---------------------------------
+
+func propsAsStrings(props []cql.QueryProp) []string {
+	ans := make([]string, len(props))
+	for i, p := range props {
+		ans[i] = fmt.Sprintf("%s|%s|%s", p.Structure, p.Name, p.Value)
+	}
+	slices.Sort(ans)
+	return ans
+}
+
+func enclosingStructure(parents map[cql.ASTNode]cql.ASTNode, node cql.ASTNode) string {
+	for curr := parents[node]; curr != nil; curr = parents[curr] {
+		if st, ok := curr.(*cql.Structure); ok {
+			return st.AttName.Text()
+		}
+	}
+	return ""
+}
+
+// rewriteAttVal creates a new version of an attribute-value expression.
+func rewriteAttVal(av *cql.AttVal, structure string, rules *AttrMigrationRules) (string, error) {
+	text := av.Text()
+	var name, valueText string
+	if av.Variant1 != nil {
+		name = av.Variant1.AttName.Text()
+
+	} else {
+		name = av.Variant2.AttName.Text()
+		valueText = av.Variant2.RegExp.Text()
+	}
+	if !strings.HasPrefix(text, name) {
+		return "", fmt.Errorf("%w: cannot locate attribute %s", ErrUnsafeRewrite, name)
+	}
+	if _, ok := rules.ValueMappings[attrKey(structure, name)]; ok {
+		if av.Variant1 != nil {
+			return "", fmt.Errorf(
+				"%w: value of %s searched using ==", ErrUnsafeRewrite, attrKey(structure, name))
+		}
+		value := strings.Trim(valueText, "\"")
+		newValue, ok := rules.mapValue(structure, name, value)
+		if !ok {
+			return "", fmt.Errorf(
+				"%w: no mapping for value %s of %s", ErrUnsafeRewrite, value, attrKey(structure, name))
+		}
+		if !strings.HasSuffix(text, valueText) {
+			return "", fmt.Errorf("%w: cannot locate value of %s", ErrUnsafeRewrite, name)
+		}
+		text = strings.TrimSuffix(text, valueText) + "\"" + newValue + "\""
+	}
+	return rules.mapAttr(structure, name) + strings.TrimPrefix(text, name), nil
+}
+
+// RewriteCQL applies attribute renames and value mappings to a CQL query.
+// The defaultAttr argument specifies an attribute used for values without
+// explicit attributes (e.g. `"foo"`). The rewritten query is verified by
+// parsing it again and comparing its properties with the expected ones.
+// In case the query cannot be rewritten safely, ErrUnsafeRewrite is returned.
+func RewriteCQL(query, defaultAttr string, rules *AttrMigrationRules) (string, bool, error) {
+	q, err := cql.ParseCQL("query", query)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: failed to parse query: %s", ErrUnsafeRewrite, err)
+	}
+	parents := make(map[cql.ASTNode]cql.ASTNode)
+	var ans strings.Builder
+	var cursor int
+	var changed bool
+	var procErr error
+	q.ForEachElement(func(parent, v cql.ASTNode) {
+		parents[v] = parent
+		if procErr != nil {
+			return
+		}
+		switch tv := v.(type) {
+		case *cql.AttVal:
+			if tv.Variant1 == nil && tv.Variant2 == nil {
+				return
+			}
+			newText, err := rewriteAttVal(tv, enclosingStructure(parents, tv), rules)
+			if err != nil {
+				procErr = err
+				return
+			}
+			pos := strings.Index(query[cursor:], tv.Text())
+			if pos < 0 {
+				procErr = fmt.Errorf("%w: cannot locate expression %s", ErrUnsafeRewrite, tv.Text())
+				return
+			}
+			ans.WriteString(query[cursor : cursor+pos])
+			ans.WriteString(newText)
+			cursor += pos + len(tv.Text())
+			if newText != tv.Text() {
+				changed = true
+			}
+		case *cql.RegExp:
+			_, isPos := parent.(*cql.OnePosition)
+			if isPos {
+				if _, ok := rules.ValueMappings[defaultAttr]; ok {
+					procErr = fmt.Errorf(
+						"%w: value of default attribute %s searched without attribute name",
+						ErrUnsafeRewrite, defaultAttr)
+				}
+			}
+		}
+	})
+	if procErr != nil {
+		return "", false, procErr
+	}
+	if !changed {
+		return query, false, nil
+	}
+	ans.WriteString(query[cursor:])
+	newQuery := ans.String()
+
+	// verification
+	newQ, err := cql.ParseCQL("query", newQuery)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: rewritten query is invalid: %s", ErrUnsafeRewrite, err)
+	}
+	expected := q.ExtractProps()
+	for i, p := range expected {
+		expected[i] = rules.mapProp(p, defaultAttr)
+	}
+	if !slices.Equal(propsAsStrings(expected), propsAsStrings(newQ.ExtractProps())) {
+		return "", false, fmt.Errorf("%w: rewritten query does not match expected properties", ErrUnsafeRewrite)
+	}
+	return newQuery, true, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testingRules() *AttrMigrationRules {
+	return &AttrMigrationRules{
+		Corpora:           []string{"corp1"},
+		PosAttrRenames:    map[string]string{"tag": "xpos"},
+		StructAttrRenames: map[string]string{"doc.txtype": "genre"},
+		ValueMappings: map[string]map[string]string{
+			"pos": {"N": "NOUN", "V": "VERB"},
+		},
+	}
+}
+
+func TestRewriteCQL(t *testing.T) {
+	rules := testingRules()
+
+	ans, changed, err := RewriteCQL(
+		`[lemma="pes" & tag="N.*"] [pos="V"] within <doc txtype="FIC: beletrie" />`, "word", rules)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, `[lemma="pes" & xpos="N.*"] [pos="VERB"] within <doc genre="FIC: beletrie" />`, ans)
+
+	ans, changed, err = RewriteCQL(`[word="tag"] [lemma!="tag"]`, "word", rules)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, `[word="tag"] [lemma!="tag"]`, ans)
+
+	_, _, err = RewriteCQL(`[pos="A"]`, "word", rules)
+	assert.ErrorIs(t, err, ErrUnsafeRewrite)
+
+	_, _, err = RewriteCQL(`"N"`, "pos", rules)
+	assert.ErrorIs(t, err, ErrUnsafeRewrite)
+
+	_, _, err = RewriteCQL(`[tag="N.*"`, "word", rules)
+	assert.ErrorIs(t, err, ErrUnsafeRewrite)
+}
+
+func TestMigrateRecordAttrs(t *testing.T) {
+	rules := testingRules()
+
+	data := `{"corpora":["corp1"],"lastop_form":{"form_type":"query",` +
+		`"curr_queries":{"corp1":"[tag=\"N.*\"]"},"curr_query_types":{"corp1":"advanced"},` +
+		`"curr_default_attr_values":{"corp1":"word"}},"q":["aword,[tag=\"N.*\"]"]}`
+	ans, changed, err := MigrateRecordAttrs(data, rules)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(
+		t,
+		`{"corpora":["corp1"],"lastop_form":{"curr_default_attr_values":{"corp1":"word"},`+
+			`"curr_queries":{"corp1":"[xpos=\"N.*\"]"},"curr_query_types":{"corp1":"advanced"},`+
+			`"form_type":"query"},"q":["aword,[xpos=\"N.*\"]"]}`,
+		ans,
+	)
+
+	data = `{"corpora":["corp1"],"lastop_form":{"form_type":"query",` +
+		`"curr_queries":{"corp1":"pes"},"curr_query_types":{"corp1":"simple"}},"q":["q[tag=\"N.*\"]"]}`
+	_, _, err = MigrateRecordAttrs(data, rules)
+	assert.ErrorIs(t, err, ErrUnsafeRewrite)
+
+	data = `{"corpora":["corp2"],"form":{"form_type":"pquery","attr":"tag"}}`
+	ans, changed, err = MigrateRecordAttrs(data, rules)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, data, ans)
+}
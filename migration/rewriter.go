@@ -0,0 +1,173 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	redisScanChunkSize = 1000
+)
+
+// rewriteFn transforms record data. The returned bool specifies
+// whether the data have been changed.
+type rewriteFn func(rec cncdb.ArchRecord) (string, bool, error)
+
+type RewriteStats struct {
+	DryRun            bool `json:"dryRun"`
+	NumArchScanned    int  `json:"numArchScanned"`
+	NumArchRewritten  int  `json:"numArchRewritten"`
+	NumArchErrors     int  `json:"numArchErrors"`
+	NumRedisScanned   int  `json:"numRedisScanned"`
+	NumRedisRewritten int  `json:"numRedisRewritten"`
+	NumRedisErrors    int  `json:"numRedisErrors"`
+}
+
+// recordRewriter applies a data transformation to all the archived
+// records (both in MySQL and in Redis). Each change is written to
+// an audit log. In the dry-run mode, nothing is changed but the audit
+// log is still written so the changes can be reviewed.
+type recordRewriter struct {
+	migration  string
+	concArchDb cncdb.IConcArchOps
+	rdb        *archiver.RedisAdapter
+	audit      *AuditLog
+	dryRun     bool
+	tz         *time.Location
+}
+
+func (rw *recordRewriter) mkAuditRecord(storage, id, oldData, newData string) AuditRecord {
+	return AuditRecord{
+		Time:      time.Now().In(rw.tz),
+		Migration: rw.migration,
+		DryRun:    rw.dryRun,
+		Storage:   storage,
+		ID:        id,
+		OldData:   oldData,
+		NewData:   newData,
+	}
+}
+
+// rewriteArchive applies fn to all the records in MySQL archive. In the dry-run
+// mode, concArchDb is expected to be a dry-run database adapter
+// (see cncdb.NewMySQLDryRun).
+func (rw *recordRewriter) rewriteArchive(
+	ctx context.Context, chunkSize int, fn rewriteFn, stats *RewriteStats) error {
+	processed := make(map[string]bool)
+	err := cncdb.ScanArchive(ctx, rw.concArchDb, chunkSize, func(item cncdb.ArchRecord) {
+		stats.NumArchScanned++
+		variantKey := fmt.Sprintf("%s/%d", item.ID, item.Created.UnixNano())
+		if processed[variantKey] {
+			return
+		}
+		newData, changed, err := fn(item)
+		if err != nil {
+			log.Warn().Err(err).Str("recordId", item.ID).Msg("failed to rewrite archive record, skipping")
+			stats.NumArchErrors++
+			return
+		}
+		if !changed {
+			return
+		}
+		processed[variantKey] = true
+		auditRec := rw.mkAuditRecord(StorageMySQL, item.ID, item.Data, newData)
+		auditRec.Created = &item.Created
+		if err := rw.audit.Write(auditRec); err != nil {
+			log.Error().Err(err).Str("recordId", item.ID).Msg("failed to rewrite archive record")
+			stats.NumArchErrors++
+			return
+		}
+		if err := rw.concArchDb.UpdateRecordData(ctx, item.ID, item.Created, newData); err != nil {
+			log.Error().Err(err).Str("recordId", item.ID).Msg("failed to rewrite archive record")
+			stats.NumArchErrors++
+			return
+		}
+		stats.NumArchRewritten++
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rewrite archive records: %w", err)
+	}
+	return nil
+}
+
+// rewriteRedis applies fn to all the records stored in Redis
+func (rw *recordRewriter) rewriteRedis(ctx context.Context, fn rewriteFn, stats *RewriteStats) error {
+	var cursor uint64
+	for {
+		ids, next, err := rw.rdb.ScanConcRecordIDs(ctx, cursor, redisScanChunkSize)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite Redis records: %w", err)
+		}
+		for _, id := range ids {
+			stats.NumRedisScanned++
+			rec, err := rw.rdb.GetConcRecord(ctx, id)
+			if err == cncdb.ErrRecordNotFound {
+				continue // expired in the meantime
+
+			} else if err != nil {
+				return fmt.Errorf("failed to rewrite Redis records: %w", err)
+			}
+			newData, changed, err := fn(rec)
+			if err != nil {
+				log.Warn().Err(err).Str("recordId", id).Msg("failed to rewrite Redis record, skipping")
+				stats.NumRedisErrors++
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := rw.audit.Write(rw.mkAuditRecord(StorageRedis, id, rec.Data, newData)); err != nil {
+				log.Error().Err(err).Str("recordId", id).Msg("failed to rewrite Redis record")
+				stats.NumRedisErrors++
+				continue
+			}
+			if !rw.dryRun {
+				if err := rw.rdb.UpdateConcRecord(ctx, id, newData); err != nil {
+					log.Error().Err(err).Str("recordId", id).Msg("failed to rewrite Redis record")
+					stats.NumRedisErrors++
+					continue
+				}
+			}
+			stats.NumRedisRewritten++
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to rewrite Redis records: %w", ctx.Err())
+		default:
+		}
+	}
+}
+
+// rewriteAll applies fn to records in MySQL archive and then in Redis
+func (rw *recordRewriter) rewriteAll(ctx context.Context, chunkSize int, fn rewriteFn, stats *RewriteStats) error {
+	stats.DryRun = rw.dryRun
+	if err := rw.rewriteArchive(ctx, chunkSize, fn, stats); err != nil {
+		return err
+	}
+	return rw.rewriteRedis(ctx, fn, stats)
+}
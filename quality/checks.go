@@ -0,0 +1,142 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quality
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"camus/reporting"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/czcorpus/cqlizer/cql"
+)
+
+const (
+	IssueInvalidJSON          = "invalidJson"
+	IssueUnknownSupertype     = "unknownSupertype"
+	IssueUnparseableCQL       = "unparseableCql"
+	IssueUnresolvedPrevID     = "unresolvedPrevId"
+	IssueInconsistentVariants = "inconsistentVariants"
+)
+
+// recordIssues lists failed checks of a record
+type recordIssues []string
+
+func (ri recordIssues) applyTo(stat *reporting.DataQualityStat) {
+	stat.NumSampled++
+	for _, issue := range ri {
+		switch issue {
+		case IssueInvalidJSON:
+			stat.NumInvalidJSON++
+		case IssueUnknownSupertype:
+			stat.NumUnknownSupertype++
+		case IssueUnparseableCQL:
+			stat.NumUnparseableCQL++
+		case IssueUnresolvedPrevID:
+			stat.NumUnresolvedPrevID++
+		case IssueInconsistentVariants:
+			stat.NumInconsistentVariants++
+		}
+	}
+	if len(ri) > 0 {
+		stat.NumFailed++
+	}
+}
+
+// checkRecordData performs checks which do not require access
+// to the storage. It also returns ID of the previous operation
+// (if any) so it can be further checked.
+func checkRecordData(data string) (recordIssues, string) {
+	issues := make(recordIssues, 0, 5)
+	var rec cncdb.UntypedQueryRecord
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return append(issues, IssueInvalidJSON), ""
+	}
+	var general cncdb.GeneralDataRecord
+	if err := json.Unmarshal([]byte(data), &general); err != nil {
+		return append(issues, IssueInvalidJSON), ""
+	}
+	supertype, err := rec.GetSupertype()
+	if err != nil {
+		issues = append(issues, IssueUnknownSupertype)
+
+	} else if supertype == cncdb.QuerySupertypeConc {
+		queries, _ := rec.LastopForm["curr_queries"].(map[string]any)
+		queryTypes, _ := rec.LastopForm["curr_query_types"].(map[string]any)
+		for corp, q := range queries {
+			tq, ok := q.(string)
+			if !ok || queryTypes[corp] != "advanced" {
+				continue
+			}
+			if _, err := cql.ParseCQL("query", tq); err != nil {
+				issues = append(issues, IssueUnparseableCQL)
+				break
+			}
+		}
+	}
+	return issues, general.GetPrevID()
+}
+
+// Checker performs data quality checks on archive records
+type Checker struct {
+	db  cncdb.IConcArchOps
+	rdb *archiver.RedisAdapter
+}
+
+func (c *Checker) prevIDExists(ctx context.Context, prevID string) (bool, error) {
+	ok, err := c.db.ContainsRecord(ctx, prevID)
+	if err != nil || ok {
+		return ok, err
+	}
+	// the previous operation may have not been archived yet
+	_, err = c.rdb.GetConcRecord(ctx, prevID)
+	if errors.Is(err, cncdb.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CheckRecord runs all the data quality checks on a record.
+// The returned error is related only to the storage access -
+// i.e. it does not represent a failed check.
+func (c *Checker) CheckRecord(ctx context.Context, rec cncdb.ArchRecord) (recordIssues, error) {
+	issues, prevID := checkRecordData(rec.Data)
+	if prevID != "" {
+		ok, err := c.prevIDExists(ctx, prevID)
+		if err != nil {
+			return issues, fmt.Errorf("failed to check record %s: %w", rec.ID, err)
+		}
+		if !ok {
+			issues = append(issues, IssueUnresolvedPrevID)
+		}
+	}
+	variants, err := c.db.LoadRecordsByID(ctx, rec.ID)
+	if err != nil {
+		return issues, fmt.Errorf("failed to check record %s: %w", rec.ID, err)
+	}
+	if err := cncdb.ValidateQueryInstances(variants); err != nil {
+		issues = append(issues, IssueInconsistentVariants)
+	}
+	return issues, nil
+}
+
+func NewChecker(db cncdb.IConcArchOps, rdb *archiver.RedisAdapter) *Checker {
+	return &Checker{db: db, rdb: rdb}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quality
+
+import (
+	"camus/reporting"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRecordData(t *testing.T) {
+	issues, prevID := checkRecordData(
+		`{"prev_id":"abc","lastop_form":{"form_type":"query",` +
+			`"curr_queries":{"corp1":"[word=\"pes\"]"},"curr_query_types":{"corp1":"advanced"}}}`)
+	assert.Empty(t, issues)
+	assert.Equal(t, "abc", prevID)
+
+	issues, _ = checkRecordData(
+		`{"lastop_form":{"form_type":"query",` +
+			`"curr_queries":{"corp1":"[word=\"pes\""},"curr_query_types":{"corp1":"advanced"}}}`)
+	assert.Equal(t, recordIssues{IssueUnparseableCQL}, issues)
+
+	issues, _ = checkRecordData(`{"corpora":["corp1"]}`)
+	assert.Equal(t, recordIssues{IssueUnknownSupertype}, issues)
+
+	issues, _ = checkRecordData(`{"corpora":`)
+	assert.Equal(t, recordIssues{IssueInvalidJSON}, issues)
+
+	var stat reporting.DataQualityStat
+	issues.applyTo(&stat)
+	recordIssues{}.applyTo(&stat)
+	assert.Equal(t, reporting.DataQualityStat{NumSampled: 2, NumInvalidJSON: 1, NumFailed: 1}, stat)
+}
+
+func TestMonthRanges(t *testing.T) {
+	ans := monthRanges(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC), 2)
+	assert.Equal(
+		t,
+		[][2]time.Time{
+			{time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		ans,
+	)
+}
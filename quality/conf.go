@@ -0,0 +1,105 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quality
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltIntervalHours      = 24
+	dfltNumMonths          = 12
+	dfltSampleSizePerMonth = 100
+	maxSampleSizePerMonth  = 10000
+	dfltReportsKey         = "camus_data_quality_reports"
+	dfltMaxStoredReports   = 365
+)
+
+type Conf struct {
+
+	// IntervalHours specifies how often the report is created. Please
+	// note that the sampling itself is performed only at night.
+	IntervalHours int `json:"intervalHours"`
+
+	// NumMonths specifies how many recent months (including the current one)
+	// are sampled
+	NumMonths int `json:"numMonths"`
+
+	SampleSizePerMonth int `json:"sampleSizePerMonth"`
+
+	// ReportsKey is a Redis list where created reports are stored
+	ReportsKey string `json:"reportsKey"`
+
+	// MaxStoredReports specifies how many latest reports are kept
+	MaxStoredReports int `json:"maxStoredReports"`
+}
+
+func (conf *Conf) Interval() time.Duration {
+	return time.Duration(conf.IntervalHours) * time.Hour
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.IntervalHours == 0 {
+		conf.IntervalHours = dfltIntervalHours
+		log.Warn().
+			Int("value", conf.IntervalHours).
+			Msg("missing configuration `dataQuality.intervalHours`, using default")
+	}
+	if conf.IntervalHours < 0 {
+		return fmt.Errorf("invalid value for `dataQuality.intervalHours` (must be > 0)")
+	}
+	if conf.NumMonths == 0 {
+		conf.NumMonths = dfltNumMonths
+		log.Warn().
+			Int("value", conf.NumMonths).
+			Msg("missing configuration `dataQuality.numMonths`, using default")
+	}
+	if conf.NumMonths < 0 {
+		return fmt.Errorf("invalid value for `dataQuality.numMonths` (must be > 0)")
+	}
+	if conf.SampleSizePerMonth == 0 {
+		conf.SampleSizePerMonth = dfltSampleSizePerMonth
+		log.Warn().
+			Int("value", conf.SampleSizePerMonth).
+			Msg("missing configuration `dataQuality.sampleSizePerMonth`, using default")
+	}
+	if conf.SampleSizePerMonth < 0 || conf.SampleSizePerMonth > maxSampleSizePerMonth {
+		return fmt.Errorf(
+			"invalid value for `dataQuality.sampleSizePerMonth` (must be between 1 and %d)",
+			maxSampleSizePerMonth,
+		)
+	}
+	if conf.ReportsKey == "" {
+		conf.ReportsKey = dfltReportsKey
+		log.Warn().
+			Str("value", conf.ReportsKey).
+			Msg("missing configuration `dataQuality.reportsKey`, using default")
+	}
+	if conf.MaxStoredReports == 0 {
+		conf.MaxStoredReports = dfltMaxStoredReports
+	}
+	if conf.MaxStoredReports < 0 {
+		return fmt.Errorf("invalid value for `dataQuality.maxStoredReports` (must be > 0)")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quality
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/czcorpus/cnc-gokit/uniresp"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dfltNumReports = 30
+)
+
+type Actions struct {
+	service *Service
+}
+
+// Reports lists latest data quality reports (the newest first)
+// so a trend can be observed.
+func (a *Actions) Reports(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(dfltNumReports)))
+	if err != nil || limit <= 0 || limit > a.service.conf.MaxStoredReports {
+		uniresp.RespondWithErrorJSON(
+			ctx,
+			fmt.Errorf("invalid limit (must be between 1 and %d)", a.service.conf.MaxStoredReports),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	reports, err := a.service.LatestReports(ctx.Request.Context(), limit)
+	if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"reports": reports})
+}
+
+// Run starts a new data quality check in background. As the sampling
+// is demanding, it is performed only at night unless `forceReload=1`
+// is provided.
+func (a *Actions) Run(ctx *gin.Context) {
+	err := a.service.RunCheckInBackground(ctx.Query("forceReload") == "1")
+	if errors.Is(err, ErrCheckRunning) {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusConflict)
+		return
+
+	} else if err != nil {
+		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"started": true})
+}
+
+func NewActions(service *Service) *Actions {
+	return &Actions{service: service}
+}
@@ -0,0 +1,234 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quality
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"camus/reporting"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	checkInterval    = 10 * time.Minute
+	monthFormat      = "2006-01"
+	maxFailedRecords = 100
+)
+
+var (
+	ErrCheckRunning = errors.New("data quality check already running")
+)
+
+type FailedRecord struct {
+	ID      string       `json:"id"`
+	Created time.Time    `json:"created"`
+	Issues  recordIssues `json:"issues"`
+}
+
+// Report is a result of a single data quality check run
+type Report struct {
+	Created            time.Time                  `json:"created"`
+	SampleSizePerMonth int                        `json:"sampleSizePerMonth"`
+	Months             reporting.DataQualityStats `json:"months"`
+	Total              reporting.DataQualityStat  `json:"total"`
+	NumCheckErrors     int                        `json:"numCheckErrors"`
+
+	// Incomplete is set in case the check had to stop before all
+	// the months were sampled (e.g. when the night ended). Such
+	// a report contains only the months completed so far.
+	Incomplete bool `json:"incomplete"`
+
+	// FailedRecords contains (a limited number of) records
+	// which failed at least one check
+	FailedRecords []FailedRecord `json:"failedRecords"`
+}
+
+// Service periodically samples archive records month by month,
+// checks their quality and stores the results so a trend
+// can be observed.
+type Service struct {
+	conf      *Conf
+	checker   *Checker
+	db        cncdb.IConcArchOps
+	reports   *archiver.ReportList[Report]
+	reporting reporting.IReporting
+	tz        *time.Location
+	running   atomic.Bool
+
+	// ctx is the service context used for checks triggered via API
+	ctx context.Context
+}
+
+func (job *Service) Start(ctx context.Context) {
+	job.ctx = ctx
+	ticker := time.NewTicker(checkInterval)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("about to close data quality service")
+				return
+			case t := <-ticker.C:
+				if !cncdb.TimeIsAtNight(t.In(job.tz)) {
+					continue
+				}
+				latest, err := job.LatestReports(ctx, 1)
+				if err != nil {
+					log.Error().Err(err).Msg("failed to determine time of the latest data quality report")
+					continue
+				}
+				if len(latest) > 0 && !latest[0].Incomplete &&
+					t.Sub(latest[0].Created) < job.conf.Interval() {
+					continue
+				}
+				if _, err := job.RunCheck(ctx, false); err != nil {
+					log.Error().Err(err).Msg("failed to create data quality report")
+				}
+			}
+		}
+	}()
+}
+
+func (job *Service) Stop(ctx context.Context) error {
+	log.Warn().Msg("stopping data quality service")
+	return nil
+}
+
+// monthRanges returns [start, end) ranges of the last numMonths months
+// (including the current one), the oldest first.
+func monthRanges(now time.Time, numMonths int) [][2]time.Time {
+	ans := make([][2]time.Time, numMonths)
+	curr := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	for i := numMonths - 1; i >= 0; i-- {
+		ans[i] = [2]time.Time{curr, curr.AddDate(0, 1, 0)}
+		curr = curr.AddDate(0, -1, 0)
+	}
+	return ans
+}
+
+// RunCheck samples configured months of the archive, checks sampled
+// records and stores the resulting report. Without forceLoad, the sampling
+// is refused outside night hours (see cncdb.ErrTooDemandingQuery).
+func (job *Service) RunCheck(ctx context.Context, forceLoad bool) (Report, error) {
+	if !job.running.CompareAndSwap(false, true) {
+		return Report{}, ErrCheckRunning
+	}
+	defer job.running.Store(false)
+	return job.runCheck(ctx, forceLoad)
+}
+
+// RunCheckInBackground starts a data quality check without waiting
+// for its result. In case a check is already running, ErrCheckRunning
+// is returned.
+func (job *Service) RunCheckInBackground(forceLoad bool) error {
+	if !job.running.CompareAndSwap(false, true) {
+		return ErrCheckRunning
+	}
+	go func() {
+		defer job.running.Store(false)
+		if _, err := job.runCheck(job.ctx, forceLoad); err != nil {
+			log.Error().Err(err).Msg("failed to create data quality report")
+		}
+	}()
+	return nil
+}
+
+func (job *Service) runCheck(ctx context.Context, forceLoad bool) (Report, error) {
+	t0 := time.Now()
+	report := Report{
+		Created:            time.Now().In(job.tz),
+		SampleSizePerMonth: job.conf.SampleSizePerMonth,
+		Months:             make(reporting.DataQualityStats),
+		FailedRecords:      make([]FailedRecord, 0, maxFailedRecords),
+	}
+	// checks can tolerate slightly outdated data
+	checkCtx := cncdb.WithReplicaRead(ctx)
+	for _, rng := range monthRanges(report.Created, job.conf.NumMonths) {
+		recs, err := job.db.SampleRecords(ctx, rng[0], rng[1], job.conf.SampleSizePerMonth, forceLoad)
+		if errors.Is(err, cncdb.ErrTooDemandingQuery) && len(report.Months) > 0 {
+			log.Warn().
+				Str("month", rng[0].Format(monthFormat)).
+				Msg("data quality sampling refused, storing incomplete report")
+			report.Incomplete = true
+			break
+
+		} else if err != nil {
+			return report, fmt.Errorf("failed to run data quality check: %w", err)
+		}
+		var stat reporting.DataQualityStat
+		for _, rec := range recs {
+			issues, err := job.checker.CheckRecord(checkCtx, rec)
+			if err != nil {
+				log.Warn().Err(err).Str("recordId", rec.ID).Msg("failed to check record, skipping")
+				report.NumCheckErrors++
+				continue
+			}
+			issues.applyTo(&stat)
+			if len(issues) > 0 && len(report.FailedRecords) < maxFailedRecords {
+				report.FailedRecords = append(
+					report.FailedRecords,
+					FailedRecord{ID: rec.ID, Created: rec.Created, Issues: issues},
+				)
+			}
+		}
+		report.Months[rng[0].Format(monthFormat)] = stat
+		report.Total.UpdateBy(stat)
+		select {
+		case <-ctx.Done():
+			return report, fmt.Errorf("failed to run data quality check: %w", ctx.Err())
+		default:
+		}
+	}
+	if err := job.reports.Store(ctx, report); err != nil {
+		return report, fmt.Errorf("failed to store data quality report: %w", err)
+	}
+	job.reporting.WriteDataQualityStats(report.Months)
+	log.Info().
+		Any("total", report.Total).
+		Bool("incomplete", report.Incomplete).
+		Float64("procTime", time.Since(t0).Seconds()).
+		Msg("data quality check done")
+	return report, nil
+}
+
+// LatestReports returns up to `num` latest reports, the newest first
+func (job *Service) LatestReports(ctx context.Context, num int) ([]Report, error) {
+	return job.reports.Latest(ctx, num)
+}
+
+func NewService(
+	conf *Conf,
+	db cncdb.IConcArchOps,
+	rdb *archiver.RedisAdapter,
+	reporting reporting.IReporting,
+	tz *time.Location,
+) *Service {
+	return &Service{
+		conf:      conf,
+		checker:   NewChecker(db, rdb),
+		db:        db,
+		reports:   archiver.NewReportList[Report](rdb, conf.ReportsKey, conf.MaxStoredReports),
+		reporting: reporting,
+		tz:        tz,
+	}
+}
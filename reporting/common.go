@@ -58,10 +58,95 @@ type QueryHistoryDelStats struct {
 
 // ------------
 
+type RecordSizeStat struct {
+	NumRecords   int `json:"numRecords"`
+	TotalBytes   int `json:"totalBytes"`
+	MaxBytes     int `json:"maxBytes"`
+	NumOversized int `json:"numOversized"`
+}
+
+func (rss RecordSizeStat) AvgBytes() float64 {
+	if rss.NumRecords == 0 {
+		return 0
+	}
+	return float64(rss.TotalBytes) / float64(rss.NumRecords)
+}
+
+// RecordSizeStats contains data size statistics of archived
+// records by their query supertype
+type RecordSizeStats map[string]RecordSizeStat
+
+func (rss RecordSizeStats) Add(supertype string, size int, oversized bool) {
+	curr := rss[supertype]
+	curr.NumRecords++
+	curr.TotalBytes += size
+	if size > curr.MaxBytes {
+		curr.MaxBytes = size
+	}
+	if oversized {
+		curr.NumOversized++
+	}
+	rss[supertype] = curr
+}
+
+func (rss RecordSizeStats) UpdateBy(other RecordSizeStats) {
+	for supertype, stat := range other {
+		curr := rss[supertype]
+		curr.NumRecords += stat.NumRecords
+		curr.TotalBytes += stat.TotalBytes
+		curr.NumOversized += stat.NumOversized
+		if stat.MaxBytes > curr.MaxBytes {
+			curr.MaxBytes = stat.MaxBytes
+		}
+		rss[supertype] = curr
+	}
+}
+
+// ------------
+
+// DataQualityStat contains results of data quality checks
+// performed on a sample of archived records
+type DataQualityStat struct {
+	NumSampled              int `json:"numSampled"`
+	NumInvalidJSON          int `json:"numInvalidJson"`
+	NumUnknownSupertype     int `json:"numUnknownSupertype"`
+	NumUnparseableCQL       int `json:"numUnparseableCql"`
+	NumUnresolvedPrevID     int `json:"numUnresolvedPrevId"`
+	NumInconsistentVariants int `json:"numInconsistentVariants"`
+
+	// NumFailed is the number of records failing at least one check
+	NumFailed int `json:"numFailed"`
+}
+
+func (dqs DataQualityStat) FailureRatio() float64 {
+	if dqs.NumSampled == 0 {
+		return 0
+	}
+	return float64(dqs.NumFailed) / float64(dqs.NumSampled)
+}
+
+func (dqs *DataQualityStat) UpdateBy(other DataQualityStat) {
+	dqs.NumSampled += other.NumSampled
+	dqs.NumInvalidJSON += other.NumInvalidJSON
+	dqs.NumUnknownSupertype += other.NumUnknownSupertype
+	dqs.NumUnparseableCQL += other.NumUnparseableCQL
+	dqs.NumUnresolvedPrevID += other.NumUnresolvedPrevID
+	dqs.NumInconsistentVariants += other.NumInconsistentVariants
+	dqs.NumFailed += other.NumFailed
+}
+
+// DataQualityStats contains data quality statistics of archived
+// records by their creation month (in the YYYY-MM format)
+type DataQualityStats map[string]DataQualityStat
+
+// ------------
+
 type IReporting interface {
 	Start(ctx context.Context)
 	Stop(ctx context.Context) error
 	WriteOperationsStatus(item OpStats)
 	WriteCleanupStatus(item CleanupStats)
 	WriteQueryHistoryDeletionStatus(item QueryHistoryDelStats)
+	WriteRecordSizeStats(item RecordSizeStats)
+	WriteDataQualityStats(item DataQualityStats)
 }
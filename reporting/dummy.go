@@ -50,3 +50,11 @@ func (job *DummyWriter) WriteCleanupStatus(item CleanupStats) {
 func (job *DummyWriter) WriteQueryHistoryDeletionStatus(item QueryHistoryDelStats) {
 	log.Info().Any("stats", item).Msg("writing dummy query history deletion report")
 }
+
+func (job *DummyWriter) WriteRecordSizeStats(item RecordSizeStats) {
+	log.Info().Any("stats", item).Msg("writing dummy record size report")
+}
+
+func (job *DummyWriter) WriteDataQualityStats(item DataQualityStats) {
+	log.Info().Any("stats", item).Msg("writing dummy data quality report")
+}
@@ -57,15 +57,46 @@ create table camus_query_history_deletion_stats (
 
 select create_hypertable('camus_query_history_deletion_stats', 'time');
 
+create table camus_record_size_stats (
+  "time" timestamp with time zone NOT NULL,
+  query_supertype text,
+  num_records int,
+  total_bytes int,
+  max_bytes int,
+  avg_bytes float,
+  num_oversized int
+);
+
+select create_hypertable('camus_record_size_stats', 'time');
+
+create table camus_data_quality_stats (
+  "time" timestamp with time zone NOT NULL,
+  month text,
+  num_sampled int,
+  num_invalid_json int,
+  num_unknown_supertype int,
+  num_unparseable_cql int,
+  num_unresolved_prev_id int,
+  num_inconsistent_variants int,
+  num_failed int,
+  failure_ratio float
+);
+
+select create_hypertable('camus_data_quality_stats', 'time');
+
 */
 
 type StatusWriter struct {
 	tableWriterOps        *hltscl.TableWriter
 	tableWriterCleanup    *hltscl.TableWriter
 	tableWriterQHDelStats *hltscl.TableWriter
+	tableWriterRecSizes   *hltscl.TableWriter
+	tableWriterQuality    *hltscl.TableWriter
 	opsDataCh             chan<- hltscl.Entry
 	cleanupDataCh         chan<- hltscl.Entry
 	indexInfoDataCh       chan<- hltscl.Entry
+	recSizesDataCh        chan<- hltscl.Entry
+	qualityDataCh         chan<- hltscl.Entry
 	errCh                 <-chan hltscl.WriteError
 	location              *time.Location
 }
@@ -122,6 +153,39 @@ func (ds *StatusWriter) WriteQueryHistoryDeletionStatus(item QueryHistoryDelStat
 	}
 }
 
+func (ds *StatusWriter) WriteRecordSizeStats(item RecordSizeStats) {
+	if ds.tableWriterRecSizes != nil {
+		now := time.Now().In(ds.location)
+		for supertype, stat := range item {
+			ds.recSizesDataCh <- *ds.tableWriterRecSizes.NewEntry(now).
+				Str("query_supertype", supertype).
+				Int("num_records", stat.NumRecords).
+				Int("total_bytes", stat.TotalBytes).
+				Int("max_bytes", stat.MaxBytes).
+				Float("avg_bytes", stat.AvgBytes()).
+				Int("num_oversized", stat.NumOversized)
+		}
+	}
+}
+
+func (ds *StatusWriter) WriteDataQualityStats(item DataQualityStats) {
+	if ds.tableWriterQuality != nil {
+		now := time.Now().In(ds.location)
+		for month, stat := range item {
+			ds.qualityDataCh <- *ds.tableWriterQuality.NewEntry(now).
+				Str("month", month).
+				Int("num_sampled", stat.NumSampled).
+				Int("num_invalid_json", stat.NumInvalidJSON).
+				Int("num_unknown_supertype", stat.NumUnknownSupertype).
+				Int("num_unparseable_cql", stat.NumUnparseableCQL).
+				Int("num_unresolved_prev_id", stat.NumUnresolvedPrevID).
+				Int("num_inconsistent_variants", stat.NumInconsistentVariants).
+				Int("num_failed", stat.NumFailed).
+				Float("failure_ratio", stat.FailureRatio())
+		}
+	}
+}
+
 func NewStatusWriter(conf hltscl.PgConf, tz *time.Location, onError func(err error)) (*StatusWriter, error) {
 
 	conn, err := hltscl.CreatePool(conf)
@@ -134,6 +198,10 @@ func NewStatusWriter(conf hltscl.PgConf, tz *time.Location, onError func(err err
 	cleanupDataCh, errCh2 := twriter2.Activate()
 	twriter3 := hltscl.NewTableWriter(conn, "camus_query_history_deletion_stats", "time", tz)
 	indexInfoDataCh, errCh3 := twriter3.Activate()
+	twriter4 := hltscl.NewTableWriter(conn, "camus_record_size_stats", "time", tz)
+	recSizesDataCh, errCh4 := twriter4.Activate()
+	twriter5 := hltscl.NewTableWriter(conn, "camus_data_quality_stats", "time", tz)
+	qualityDataCh, errCh5 := twriter5.Activate()
 	mergedErr := make(chan hltscl.WriteError)
 	go func() {
 		for err := range errCh1 {
@@ -150,14 +218,28 @@ func NewStatusWriter(conf hltscl.PgConf, tz *time.Location, onError func(err err
 			mergedErr <- err
 		}
 	}()
+	go func() {
+		for err := range errCh4 {
+			mergedErr <- err
+		}
+	}()
+	go func() {
+		for err := range errCh5 {
+			mergedErr <- err
+		}
+	}()
 
 	return &StatusWriter{
 		tableWriterOps:        twriter1,
 		tableWriterCleanup:    twriter2,
 		tableWriterQHDelStats: twriter3,
+		tableWriterRecSizes:   twriter4,
+		tableWriterQuality:    twriter5,
 		opsDataCh:             opsDataCh,
 		cleanupDataCh:         cleanupDataCh,
 		indexInfoDataCh:       indexInfoDataCh,
+		recSizesDataCh:        recSizesDataCh,
+		qualityDataCh:         qualityDataCh,
 		errCh:                 mergedErr,
 		location:              tz,
 	}, nil
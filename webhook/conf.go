@@ -0,0 +1,160 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltOutboxKey          = "camus_webhook_outbox"
+	dfltDeadLetterKey      = "camus_webhook_failed"
+	dfltCheckIntervalSecs  = 5
+	dfltMaxAttempts        = 10
+	dfltInitialBackoffSecs = 10
+	dfltMaxBackoffSecs     = 3600
+	dfltRequestTimeoutSecs = 10
+)
+
+// EndpointConf describes a single receiver of event notifications
+type EndpointConf struct {
+	URL string `json:"url"`
+
+	// Secret is used to sign payloads (HMAC-SHA256). Receivers should
+	// verify the `X-Camus-Signature` header using the same secret.
+	Secret string `json:"secret"`
+
+	// Events specifies which events the endpoint wants to receive.
+	// Empty value means "all events".
+	Events []EventType `json:"events"`
+}
+
+func (ec EndpointConf) Accepts(evt EventType) bool {
+	if len(ec.Events) == 0 {
+		return true
+	}
+	for _, v := range ec.Events {
+		if v == evt {
+			return true
+		}
+	}
+	return false
+}
+
+type Conf struct {
+	Endpoints []EndpointConf `json:"endpoints"`
+
+	// OutboxKey is a Redis list where pending deliveries are stored
+	// so they survive Camus restarts. The key is also used as a prefix
+	// for keys of deliveries being processed and failed deliveries
+	// waiting for another attempt (see ProcessingKey and RetryKey).
+	OutboxKey string `json:"outboxKey"`
+
+	// DeadLetterKey is a Redis list where deliveries are moved once
+	// they exceed MaxAttempts
+	DeadLetterKey string `json:"deadLetterKey"`
+
+	CheckIntervalSecs  int `json:"checkIntervalSecs"`
+	MaxAttempts        int `json:"maxAttempts"`
+	InitialBackoffSecs int `json:"initialBackoffSecs"`
+	MaxBackoffSecs     int `json:"maxBackoffSecs"`
+	RequestTimeoutSecs int `json:"requestTimeoutSecs"`
+}
+
+func (conf *Conf) CheckInterval() time.Duration {
+	return time.Duration(conf.CheckIntervalSecs) * time.Second
+}
+
+// ProcessingKey is a Redis list containing deliveries being sent
+func (conf *Conf) ProcessingKey() string {
+	return conf.OutboxKey + "_processing"
+}
+
+// RetryKey is a Redis sorted set containing failed deliveries
+// scored by the time of their next attempt
+func (conf *Conf) RetryKey() string {
+	return conf.OutboxKey + "_retry"
+}
+
+func (conf *Conf) RequestTimeout() time.Duration {
+	return time.Duration(conf.RequestTimeoutSecs) * time.Second
+}
+
+// Backoff calculates a delay before next delivery attempt
+// (exponential, limited by MaxBackoffSecs)
+func (conf *Conf) Backoff(attempt int) time.Duration {
+	ans := time.Duration(conf.InitialBackoffSecs) * time.Second
+	maxBackoff := time.Duration(conf.MaxBackoffSecs) * time.Second
+	for i := 1; i < attempt && ans < maxBackoff; i++ {
+		ans *= 2
+	}
+	if ans > maxBackoff {
+		return maxBackoff
+	}
+	return ans
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	for i, ep := range conf.Endpoints {
+		if _, err := url.ParseRequestURI(ep.URL); err != nil {
+			return fmt.Errorf("invalid `webhooks.endpoints[%d].url`: %w", i, err)
+		}
+		if ep.Secret == "" {
+			return fmt.Errorf("missing `webhooks.endpoints[%d].secret`", i)
+		}
+		for _, evt := range ep.Events {
+			if !evt.Validate() {
+				return fmt.Errorf("invalid event type `%s` in `webhooks.endpoints[%d]`", evt, i)
+			}
+		}
+	}
+	if conf.OutboxKey == "" {
+		conf.OutboxKey = dfltOutboxKey
+		log.Warn().
+			Str("value", conf.OutboxKey).
+			Msg("missing configuration `webhooks.outboxKey`, using default")
+	}
+	if conf.DeadLetterKey == "" {
+		conf.DeadLetterKey = dfltDeadLetterKey
+		log.Warn().
+			Str("value", conf.DeadLetterKey).
+			Msg("missing configuration `webhooks.deadLetterKey`, using default")
+	}
+	if conf.CheckIntervalSecs == 0 {
+		conf.CheckIntervalSecs = dfltCheckIntervalSecs
+	}
+	if conf.MaxAttempts == 0 {
+		conf.MaxAttempts = dfltMaxAttempts
+	}
+	if conf.InitialBackoffSecs == 0 {
+		conf.InitialBackoffSecs = dfltInitialBackoffSecs
+	}
+	if conf.MaxBackoffSecs == 0 {
+		conf.MaxBackoffSecs = dfltMaxBackoffSecs
+	}
+	if conf.RequestTimeoutSecs == 0 {
+		conf.RequestTimeoutSecs = dfltRequestTimeoutSecs
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DummyNotifier is used in case no webhooks are configured
+type DummyNotifier struct {
+}
+
+func (dn *DummyNotifier) Start(ctx context.Context) {
+}
+
+func (dn *DummyNotifier) Stop(ctx context.Context) error {
+	log.Warn().Msg("stopping DummyNotifier")
+	return nil
+}
+
+func (dn *DummyNotifier) Notify(ctx context.Context, evt Event) {
+	log.Debug().Any("event", evt).Msg("dummy webhook notification")
+}
@@ -0,0 +1,346 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+type EventType string
+
+const (
+	EventArchived EventType = "archived"
+	EventPinned   EventType = "pinned"
+	EventMerged   EventType = "merged"
+	EventDeleted  EventType = "deleted"
+)
+
+func (et EventType) Validate() bool {
+	return et == EventArchived || et == EventPinned || et == EventMerged || et == EventDeleted
+}
+
+// Event describes something that happened to an archived record
+type Event struct {
+	Type    EventType      `json:"type"`
+	ConcID  string         `json:"concId"`
+	Time    time.Time      `json:"time"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// INotifier is an abstract interface for services
+// informing other systems about archive events.
+type INotifier interface {
+	Start(ctx context.Context)
+	Stop(ctx context.Context) error
+
+	// Notify schedules an event for delivery. The method is not
+	// expected to report errors as failing notifications must not
+	// affect archiving itself.
+	Notify(ctx context.Context, evt Event)
+}
+
+// outbox is a persistent storage of pending deliveries
+// (Redis lists and a sorted set in our case):
+//   - the outbox list contains deliveries to be sent as soon as possible,
+//   - the processing list contains deliveries being sent right now
+//     (and in case of a crash, also deliveries we have to try again),
+//   - the retry sorted set contains failed deliveries scored
+//     by the time of their next attempt.
+//
+// Deliveries are removed from the processing list only once they are
+// sent or stored elsewhere so an event is never lost (but it may be
+// delivered more than once - receivers can use the delivery ID to detect it).
+type outbox interface {
+	RPush(ctx context.Context, key string, value string) error
+	LMove(ctx context.Context, src, dst string) (string, error)
+	LRem(ctx context.Context, key string, value string) error
+	LLen(ctx context.Context, key string) (int, error)
+	ZAddWithScore(ctx context.Context, key string, score float64, value string) error
+	ZRangeToScore(ctx context.Context, key string, maxScore float64) ([]string, error)
+	ZRem(ctx context.Context, key string, value string) error
+}
+
+type delivery struct {
+	ID          string    `json:"id"`
+	Endpoint    string    `json:"endpoint"`
+	Event       Event     `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+type payload struct {
+	DeliveryID string `json:"deliveryId"`
+	Event
+}
+
+// Sign creates a signature of a payload as sent in the
+// `X-Camus-Signature` header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher stores events into a persistent outbox and
+// then (in regular intervals) delivers them to configured
+// endpoints. Failed deliveries are retried with an exponential
+// backoff.
+type Dispatcher struct {
+	conf      *Conf
+	outbox    outbox
+	client    *http.Client
+	endpoints map[string]EndpointConf
+	tz        *time.Location
+}
+
+func (d *Dispatcher) Notify(ctx context.Context, evt Event) {
+	if evt.Time.IsZero() {
+		evt.Time = time.Now().In(d.tz)
+	}
+	for _, ep := range d.conf.Endpoints {
+		if !ep.Accepts(evt.Type) {
+			continue
+		}
+		dlv := delivery{
+			ID:          uuid.New().String(),
+			Endpoint:    ep.URL,
+			Event:       evt,
+			NextAttempt: evt.Time,
+		}
+		if err := d.push(ctx, d.conf.OutboxKey, dlv); err != nil {
+			log.Error().
+				Err(err).
+				Str("event", string(evt.Type)).
+				Str("concId", evt.ConcID).
+				Str("endpoint", ep.URL).
+				Msg("failed to store webhook event to outbox")
+		}
+	}
+}
+
+func (d *Dispatcher) push(ctx context.Context, key string, dlv delivery) error {
+	data, err := json.Marshal(dlv)
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery %s: %w", dlv.ID, err)
+	}
+	return d.outbox.RPush(ctx, key, string(data))
+}
+
+func (d *Dispatcher) send(ctx context.Context, ep EndpointConf, dlv delivery) error {
+	body, err := json.Marshal(payload{DeliveryID: dlv.ID, Event: dlv.Event})
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, d.conf.RequestTimeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Camus-Event", string(dlv.Event.Type))
+	req.Header.Set("X-Camus-Delivery", dlv.ID)
+	req.Header.Set("X-Camus-Signature", Sign(ep.Secret, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// requeueDueRetries moves failed deliveries which are due
+// for another attempt back to the outbox
+func (d *Dispatcher) requeueDueRetries(ctx context.Context, now time.Time) error {
+	items, err := d.outbox.ZRangeToScore(ctx, d.conf.RetryKey(), float64(now.Unix()))
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		// Note: in case of a crash between the two operations, the delivery
+		// is sent twice which is better than not sending it at all
+		if err := d.outbox.RPush(ctx, d.conf.OutboxKey, item); err != nil {
+			return err
+		}
+		if err := d.outbox.ZRem(ctx, d.conf.RetryKey(), item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverProcessing moves deliveries left in the processing list
+// (e.g. due to a crash) back to the outbox
+func (d *Dispatcher) recoverProcessing(ctx context.Context) error {
+	var numRecovered int
+	for {
+		raw, err := d.outbox.LMove(ctx, d.conf.ProcessingKey(), d.conf.OutboxKey)
+		if err != nil {
+			return fmt.Errorf("failed to recover webhook deliveries: %w", err)
+		}
+		if raw == "" {
+			break
+		}
+		numRecovered++
+	}
+	if numRecovered > 0 {
+		log.Warn().
+			Int("numDeliveries", numRecovered).
+			Msg("recovered unfinished webhook deliveries")
+	}
+	return nil
+}
+
+// deliver tries to send a delivery. In case of a failure, the delivery
+// is scheduled for another attempt or moved to the dead letter list.
+// An error is returned only if the delivery could not be stored.
+func (d *Dispatcher) deliver(ctx context.Context, raw string, now time.Time) error {
+	var dlv delivery
+	if err := json.Unmarshal([]byte(raw), &dlv); err != nil {
+		log.Error().Err(err).Str("item", raw).Msg("invalid webhook outbox item, removing")
+		return nil
+	}
+	ep, ok := d.endpoints[dlv.Endpoint]
+	if !ok {
+		log.Warn().
+			Str("endpoint", dlv.Endpoint).
+			Str("deliveryId", dlv.ID).
+			Msg("webhook endpoint no longer configured, removing delivery")
+		return nil
+	}
+	err := d.send(ctx, ep, dlv)
+	if err == nil {
+		log.Debug().
+			Str("endpoint", dlv.Endpoint).
+			Str("deliveryId", dlv.ID).
+			Str("event", string(dlv.Event.Type)).
+			Msg("delivered webhook event")
+		return nil
+	}
+	dlv.Attempts++
+	dlv.LastError = err.Error()
+	if dlv.Attempts >= d.conf.MaxAttempts {
+		log.Error().
+			Err(err).
+			Str("endpoint", dlv.Endpoint).
+			Str("deliveryId", dlv.ID).
+			Int("attempts", dlv.Attempts).
+			Msg("giving up webhook delivery, moving to dead letter list")
+		return d.push(ctx, d.conf.DeadLetterKey, dlv)
+	}
+	dlv.NextAttempt = now.Add(d.conf.Backoff(dlv.Attempts))
+	log.Warn().
+		Err(err).
+		Str("endpoint", dlv.Endpoint).
+		Str("deliveryId", dlv.ID).
+		Int("attempts", dlv.Attempts).
+		Time("nextAttempt", dlv.NextAttempt).
+		Msg("failed to deliver webhook event, will retry")
+	data, err := json.Marshal(dlv)
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery %s: %w", dlv.ID, err)
+	}
+	return d.outbox.ZAddWithScore(ctx, d.conf.RetryKey(), float64(dlv.NextAttempt.Unix()), string(data))
+}
+
+// processOutbox sends all the currently stored deliveries
+// (including failed ones due for another attempt)
+func (d *Dispatcher) processOutbox(ctx context.Context) error {
+	now := time.Now().In(d.tz)
+	if err := d.requeueDueRetries(ctx, now); err != nil {
+		return fmt.Errorf("failed to process webhook outbox: %w", err)
+	}
+	size, err := d.outbox.LLen(ctx, d.conf.OutboxKey)
+	if err != nil {
+		return fmt.Errorf("failed to process webhook outbox: %w", err)
+	}
+	for i := 0; i < size; i++ {
+		raw, err := d.outbox.LMove(ctx, d.conf.OutboxKey, d.conf.ProcessingKey())
+		if err != nil {
+			return fmt.Errorf("failed to process webhook outbox: %w", err)
+		}
+		if raw == "" {
+			break
+		}
+		if err := d.deliver(ctx, raw, now); err != nil {
+			// the delivery stays in the processing list
+			// and it will be recovered on the next start
+			return fmt.Errorf("failed to process webhook outbox: %w", err)
+		}
+		if err := d.outbox.LRem(ctx, d.conf.ProcessingKey(), raw); err != nil {
+			return fmt.Errorf("failed to process webhook outbox: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.conf.CheckInterval())
+	log.Info().
+		Int("numEndpoints", len(d.conf.Endpoints)).
+		Msg("starting webhook.Dispatcher task")
+	if err := d.recoverProcessing(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to start webhook Dispatcher properly")
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("about to close webhook Dispatcher")
+				return
+			case <-ticker.C:
+				if err := d.processOutbox(ctx); err != nil {
+					log.Error().Err(err).Msg("failed to deliver webhook events")
+				}
+			}
+		}
+	}()
+}
+
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	log.Warn().Msg("stopping webhook Dispatcher")
+	return nil
+}
+
+func NewDispatcher(conf *Conf, outbox outbox, tz *time.Location) *Dispatcher {
+	endpoints := make(map[string]EndpointConf)
+	for _, ep := range conf.Endpoints {
+		endpoints[ep.URL] = ep
+	}
+	return &Dispatcher{
+		conf:      conf,
+		outbox:    outbox,
+		client:    &http.Client{},
+		endpoints: endpoints,
+		tz:        tz,
+	}
+}
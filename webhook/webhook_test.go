@@ -0,0 +1,179 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memOutbox struct {
+	lists map[string][]string
+	zsets map[string]map[string]float64
+}
+
+func (m *memOutbox) RPush(ctx context.Context, key string, value string) error {
+	m.lists[key] = append(m.lists[key], value)
+	return nil
+}
+
+func (m *memOutbox) LMove(ctx context.Context, src, dst string) (string, error) {
+	if len(m.lists[src]) == 0 {
+		return "", nil
+	}
+	ans := m.lists[src][0]
+	m.lists[src] = m.lists[src][1:]
+	m.lists[dst] = append(m.lists[dst], ans)
+	return ans, nil
+}
+
+func (m *memOutbox) LRem(ctx context.Context, key string, value string) error {
+	for i, v := range m.lists[key] {
+		if v == value {
+			m.lists[key] = append(m.lists[key][:i], m.lists[key][i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memOutbox) LLen(ctx context.Context, key string) (int, error) {
+	return len(m.lists[key]), nil
+}
+
+func (m *memOutbox) ZAddWithScore(ctx context.Context, key string, score float64, value string) error {
+	if m.zsets[key] == nil {
+		m.zsets[key] = make(map[string]float64)
+	}
+	m.zsets[key][value] = score
+	return nil
+}
+
+func (m *memOutbox) ZRangeToScore(ctx context.Context, key string, maxScore float64) ([]string, error) {
+	ans := make([]string, 0, len(m.zsets[key]))
+	for v, score := range m.zsets[key] {
+		if score <= maxScore {
+			ans = append(ans, v)
+		}
+	}
+	return ans, nil
+}
+
+func (m *memOutbox) ZRem(ctx context.Context, key string, value string) error {
+	delete(m.zsets[key], value)
+	return nil
+}
+
+func newTestDispatcher(url string, events []EventType, maxAttempts int) (*Dispatcher, *memOutbox) {
+	conf := &Conf{
+		Endpoints:   []EndpointConf{{URL: url, Secret: "abc", Events: events}},
+		MaxAttempts: maxAttempts,
+	}
+	conf.ValidateAndDefaults()
+	conf.InitialBackoffSecs = 0
+	box := &memOutbox{lists: make(map[string][]string), zsets: make(map[string]map[string]float64)}
+	return NewDispatcher(conf, box, time.UTC), box
+}
+
+func TestDeliverySigned(t *testing.T) {
+	var received payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, Sign("abc", body), r.Header.Get("X-Camus-Signature"))
+		assert.Equal(t, "pinned", r.Header.Get("X-Camus-Event"))
+		json.Unmarshal(body, &received)
+	}))
+	defer srv.Close()
+	d, box := newTestDispatcher(srv.URL, []EventType{EventPinned}, 3)
+	d.Notify(context.Background(), Event{Type: EventArchived, ConcID: "a1"})
+	d.Notify(context.Background(), Event{Type: EventPinned, ConcID: "a2"})
+	assert.Len(t, box.lists[d.conf.OutboxKey], 1)
+
+	assert.NoError(t, d.processOutbox(context.Background()))
+	assert.Equal(t, "a2", received.ConcID)
+	assert.NotEmpty(t, received.DeliveryID)
+	assert.Len(t, box.lists[d.conf.OutboxKey], 0)
+}
+
+func TestDeliveryRetryAndDeadLetter(t *testing.T) {
+	numCalls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numCalls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	d, box := newTestDispatcher(srv.URL, nil, 2)
+	d.Notify(context.Background(), Event{Type: EventDeleted, ConcID: "a1"})
+
+	assert.NoError(t, d.processOutbox(context.Background()))
+	assert.Len(t, box.lists[d.conf.OutboxKey], 0)
+	assert.Len(t, box.lists[d.conf.ProcessingKey()], 0)
+	assert.Len(t, box.zsets[d.conf.RetryKey()], 1)
+	assert.Len(t, box.lists[d.conf.DeadLetterKey], 0)
+
+	assert.NoError(t, d.processOutbox(context.Background()))
+	assert.Len(t, box.lists[d.conf.OutboxKey], 0)
+	assert.Len(t, box.zsets[d.conf.RetryKey()], 0)
+	assert.Len(t, box.lists[d.conf.DeadLetterKey], 1)
+	assert.Equal(t, 2, numCalls)
+}
+
+func TestRetryNotDueStaysScheduled(t *testing.T) {
+	numCalls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numCalls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	d, box := newTestDispatcher(srv.URL, nil, 5)
+	d.conf.InitialBackoffSecs = 3600
+	d.Notify(context.Background(), Event{Type: EventDeleted, ConcID: "a1"})
+
+	assert.NoError(t, d.processOutbox(context.Background()))
+	assert.NoError(t, d.processOutbox(context.Background()))
+	assert.Equal(t, 1, numCalls)
+	assert.Len(t, box.zsets[d.conf.RetryKey()], 1)
+	assert.Len(t, box.lists[d.conf.OutboxKey], 0)
+}
+
+func TestRecoverProcessing(t *testing.T) {
+	d, box := newTestDispatcher("http://localhost", nil, 3)
+	d.Notify(context.Background(), Event{Type: EventDeleted, ConcID: "a1"})
+	// simulate a crash during sending
+	_, err := box.LMove(context.Background(), d.conf.OutboxKey, d.conf.ProcessingKey())
+	assert.NoError(t, err)
+	assert.Len(t, box.lists[d.conf.OutboxKey], 0)
+
+	assert.NoError(t, d.recoverProcessing(context.Background()))
+	assert.Len(t, box.lists[d.conf.OutboxKey], 1)
+	assert.Len(t, box.lists[d.conf.ProcessingKey()], 0)
+}
+
+func TestBackoff(t *testing.T) {
+	conf := &Conf{InitialBackoffSecs: 10, MaxBackoffSecs: 60}
+	assert.Equal(t, 10*time.Second, conf.Backoff(1))
+	assert.Equal(t, 20*time.Second, conf.Backoff(2))
+	assert.Equal(t, 40*time.Second, conf.Backoff(3))
+	assert.Equal(t, 60*time.Second, conf.Backoff(4))
+}